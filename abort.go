@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// cleanupTimeout bounds the cleanup of an aborted run, which cannot use the
+// cancelled context of the run.
+const cleanupTimeout = 2 * time.Minute
+
+// errAborted is the cause of the context of a run aborted by a signal.
+var errAborted = errors.New("aborted")
+
+// signalContext returns a context cancelled on SIGINT or SIGTERM, with
+// errAborted as the cause. A second signal exits right away. stop releases
+// the signals.
+func signalContext(ctx context.Context) (_ context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig, ok := <-ch
+		if !ok {
+			return
+		}
+		warnf("received %v, aborting; send it again to exit right away", sig)
+		cancel(errAborted)
+		if _, ok := <-ch; ok {
+			os.Exit(exitAborted)
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(ch)
+		close(ch)
+		cancel(nil)
+	}
+}
+
+// aborted reports whether ctx was cancelled by a signal.
+func aborted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errAborted)
+}
+
+// cleanupAbortedImage deregisters an image whose creation was aborted and
+// deletes the snapshots CreateImage started for it.
+func cleanupAbortedImage(client *ec2.Client, imageID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	if _, err := client.DeregisterImage(ctx, &ec2.DeregisterImageInput{ImageId: aws.String(imageID)}); err != nil {
+		return nil, fmt.Errorf("error deregistering image %s: %w", imageID, err)
+	}
+	var deleted []string
+	p := ec2.NewDescribeSnapshotsPaginator(client, &ec2.DescribeSnapshotsInput{
+		OwnerIds: []string{"self"},
+		Filters:  []types.Filter{{Name: aws.String("description"), Values: []string{"Created by CreateImage(*) for " + imageID}}},
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return deleted, fmt.Errorf("error describing snapshots: %w", err)
+		}
+		for _, s := range page.Snapshots {
+			if _, err := client.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{SnapshotId: s.SnapshotId}); err != nil {
+				return deleted, fmt.Errorf("error deleting snapshot %s: %w", *s.SnapshotId, err)
+			}
+			deleted = append(deleted, *s.SnapshotId)
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"sigs.k8s.io/yaml"
+)
+
+// accountsFile lists the accounts to fan out to and the role to assume in
+// each of them.
+type accountsFile struct {
+	Accounts []accountRole `json:"accounts"`
+	// Names maps account IDs to friendly names shown in the output.
+	Names map[string]string `json:"names"`
+}
+
+type accountRole struct {
+	// Name is a friendly name for the account, defaulting to its ID.
+	Name    string `json:"name"`
+	RoleArn string `json:"roleArn"`
+	// ExternalId is passed to AssumeRole when the role requires one.
+	ExternalId string `json:"externalId"`
+	// SessionName is the role session name, amimati by default.
+	SessionName string `json:"sessionName"`
+	// Regions overrides the regions of the command for this account.
+	Regions []string `json:"regions"`
+}
+
+func loadAccountsFile(path string) (*accountsFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading accounts file: %w", err)
+	}
+	var f accountsFile
+	if err := yaml.UnmarshalStrict(b, &f); err != nil {
+		return nil, fmt.Errorf("error parsing accounts file %s: %w", path, err)
+	}
+	for i, a := range f.Accounts {
+		if a.RoleArn == "" {
+			return nil, fmt.Errorf("account %d: roleArn is required", i)
+		}
+	}
+	return &f, nil
+}
+
+// assumeRole returns a copy of cfg whose credentials are those of the role,
+// refreshed as they expire. optFns further configure the AssumeRole calls.
+func assumeRole(cfg aws.Config, a accountRole, optFns ...func(*stscreds.AssumeRoleOptions)) aws.Config {
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), a.RoleArn, append([]func(*stscreds.AssumeRoleOptions){func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = "amimati"
+		if a.SessionName != "" {
+			o.RoleSessionName = a.SessionName
+		}
+		if a.ExternalId != "" {
+			o.ExternalID = &a.ExternalId
+		}
+	}}, optFns...)...)
+	cfg = cfg.Copy()
+	cfg.Credentials = aws.NewCredentialsCache(provider)
+	return cfg
+}
+
+// accountNames resolves account IDs to friendly names: from the mapping
+// supplied by the user first, then from the IAM account alias, which can only
+// be read from within the account itself.
+type accountNames struct {
+	mapping map[string]string
+
+	mu      sync.Mutex
+	aliases map[string]string
+}
+
+func newAccountNames(mapping map[string]string) *accountNames {
+	return &accountNames{mapping: mapping, aliases: map[string]string{}}
+}
+
+// name returns the name of the account, looking its alias up with cfg when
+// cfg has credentials for it. It returns an empty string when the account has
+// no known name.
+func (n *accountNames) name(ctx context.Context, cfg aws.Config, accountID string) string {
+	if name, ok := n.mapping[accountID]; ok {
+		return name
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	alias, ok := n.aliases[accountID]
+	if !ok {
+		alias = lookupAccountAlias(ctx, iam.NewFromConfig(cfg))
+		n.aliases[accountID] = alias
+	}
+	return alias
+}
+
+// lookupAccountAlias returns the IAM alias of the account of the client, or an
+// empty string when it has none or the caller is not allowed to list them.
+func lookupAccountAlias(ctx context.Context, client *iam.Client) string {
+	out, err := client.ListAccountAliases(ctx, &iam.ListAccountAliasesInput{})
+	if err != nil || len(out.AccountAliases) == 0 {
+		return ""
+	}
+	return out.AccountAliases[0]
+}
+
+type accountResult struct {
+	Account     string
+	AccountName string `json:",omitempty"`
+	RoleArn     string
+	Region      string
+	Result      *result `json:",omitempty"`
+	Error       string  `json:",omitempty"`
+	Cancelled   bool    `json:",omitempty"`
+}
+
+// accountsReport is the aggregate result of creating the image in several
+// accounts.
+type accountsReport struct {
+	batchReport
+	Results []accountResult
+}
+
+// createImageAccounts assumes the role of each account and runs createImage
+// in each of its regions, or else in the region of cfg, at most concurrency
+// at a time. The results are in the order of the accounts. Runs still going
+// are cancelled once the failures abort the batch under the policy.
+func createImageAccounts(ctx context.Context, cfg aws.Config, accounts *accountsFile, concurrency int, opt options, policy errorPolicy) accountsReport {
+	var results []accountResult
+	var cfgs []aws.Config
+	for _, a := range accounts.Accounts {
+		acfg := assumeRole(cfg, a)
+		r := accountResult{AccountName: a.Name, RoleArn: a.RoleArn}
+		if parsed, err := arn.Parse(a.RoleArn); err == nil {
+			r.Account = parsed.AccountID
+		}
+		if r.AccountName == "" {
+			r.AccountName = accounts.Names[r.Account]
+		}
+		regions := a.Regions
+		if len(regions) == 0 {
+			regions = []string{cfg.Region}
+		}
+		for _, region := range regions {
+			rcfg := acfg.Copy()
+			rcfg.Region = region
+			r.Region = region
+			results = append(results, r)
+			cfgs = append(cfgs, rcfg)
+		}
+	}
+	outcomes := runBatch(ctx, len(results), concurrency, policy, func(ctx context.Context, i int) error {
+		var err error
+		results[i].Result, err = createImage(ctx, cfgs[i], opt)
+		return err
+	})
+	for i, o := range outcomes {
+		if o.err != nil {
+			results[i].Error = o.err.Error()
+		}
+		results[i].Cancelled = o.cancelled
+	}
+	return accountsReport{batchReport: newBatchReport(policy, outcomes), Results: results}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// defaultRestoreDays is how long archived snapshots stay restored when
+// -restore-archived is given without a number of days.
+const defaultRestoreDays = 1
+
+// restoreArchivedFlag is a flag that is either a boolean or the number of days
+// to temporarily restore archived snapshots for, as in -restore-archived or
+// -restore-archived=7.
+type restoreArchivedFlag int32
+
+func (f *restoreArchivedFlag) String() string {
+	return strconv.Itoa(int(*f))
+}
+
+func (f *restoreArchivedFlag) Set(value string) error {
+	if b, err := strconv.ParseBool(value); err == nil {
+		*f = 0
+		if b {
+			*f = defaultRestoreDays
+		}
+		return nil
+	}
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || n < 1 || n > 180 {
+		return fmt.Errorf("invalid number of restore days(1-180): %s", value)
+	}
+	*f = restoreArchivedFlag(n)
+	return nil
+}
+
+func (f *restoreArchivedFlag) IsBoolFlag() bool { return true }
+
+// restoreArchivedImage makes sure none of the snapshots of the image is in the
+// archive tier, where it cannot be copied or launched from. With days zero it
+// fails naming the archived snapshots; otherwise it temporarily restores them
+// for that many days and waits until they are back in the standard tier.
+func restoreArchivedImage(ctx context.Context, client *ec2.Client, image types.Image, days int32, verbose bool) error {
+	snapshots, err := describeImageSnapshots(ctx, client, image)
+	if err != nil {
+		return err
+	}
+	var archived []string
+	for _, ds := range snapshots {
+		if ds.snapshot.StorageTier == types.StorageTierArchive {
+			archived = append(archived, *ds.snapshot.SnapshotId)
+		}
+	}
+	if len(archived) == 0 {
+		return nil
+	}
+	if days == 0 {
+		return fmt.Errorf("snapshots %s of image %s are in the archive tier and must be restored first", strings.Join(archived, ", "), *image.ImageId)
+	}
+
+	statuses, err := describeTierStatus(ctx, client, archived)
+	if err != nil {
+		return err
+	}
+	for _, id := range archived {
+		switch statuses[id].LastTieringOperationStatus {
+		case types.TieringOperationStatusTemporaryRestoreInProgress, types.TieringOperationStatusPermanentRestoreInProgress:
+			if verbose {
+				logf("snapshot %s is already being restored from the archive tier", id)
+			}
+			continue
+		}
+		if _, err := client.RestoreSnapshotTier(ctx, &ec2.RestoreSnapshotTierInput{
+			SnapshotId:           aws.String(id),
+			TemporaryRestoreDays: aws.Int32(days),
+		}); err != nil {
+			return fmt.Errorf("error restoring snapshot %s from the archive tier: %w", id, err)
+		}
+		trace.state(id, "restoring", map[string]any{"days": days})
+		if verbose {
+			logf("restoring snapshot %s from the archive tier for %d days", id, days)
+		}
+	}
+
+	// Restoring from the archive tier takes up to 72 hours.
+	var wait waitLog
+	for {
+		statuses, err := describeTierStatus(ctx, client, archived)
+		if err != nil {
+			return err
+		}
+		var pending []string
+		for _, id := range archived {
+			s := statuses[id]
+			switch s.LastTieringOperationStatus {
+			case types.TieringOperationStatusTemporaryRestoreFailed, types.TieringOperationStatusPermanentRestoreFailed:
+				return fmt.Errorf("restoring snapshot %s from the archive tier failed: %s", id, aws.ToString(s.LastTieringOperationStatusDetail))
+			}
+			if s.StorageTier != types.StorageTierStandard {
+				pending = append(pending, id)
+			}
+		}
+		if len(pending) == 0 {
+			for _, id := range archived {
+				trace.state(id, "restored", nil)
+			}
+			return nil
+		}
+		if verbose {
+			wait.logf("waiting for snapshots %s to be restored from the archive tier", strings.Join(pending, ", "))
+		}
+		time.Sleep(time.Minute)
+	}
+}
+
+// describeTierStatus returns the storage tier status of the snapshots by ID.
+func describeTierStatus(ctx context.Context, client *ec2.Client, ids []string) (map[string]types.SnapshotTierStatus, error) {
+	statuses := map[string]types.SnapshotTierStatus{}
+	p := ec2.NewDescribeSnapshotTierStatusPaginator(client, &ec2.DescribeSnapshotTierStatusInput{
+		Filters: []types.Filter{{Name: aws.String("snapshot-id"), Values: ids}},
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error describing snapshot tier status: %w", err)
+		}
+		for _, s := range page.SnapshotTierStatuses {
+			statuses[aws.ToString(s.SnapshotId)] = s
+		}
+	}
+	return statuses, nil
+}
+
+// archivedSnapshot is a snapshot of an image moved to the archive tier.
+type archivedSnapshot struct {
+	SnapshotId  string
+	StorageTier string
+	// Status is the last tiering operation of the snapshot, such as
+	// archival-in-progress or archival-completed.
+	Status string `json:",omitempty"`
+}
+
+type archiveResult struct {
+	ImageId      string
+	Name         string
+	CreationDate string
+	Snapshots    []archivedSnapshot
+	Error        string `json:",omitempty"`
+}
+
+// archivePollInterval is the time between two polls of the tiering status
+// while waiting for snapshots to be archived, which takes hours.
+const archivePollInterval = time.Minute
+
+// archiveImage moves the snapshots of the image to the archive tier, where
+// they cost less to store but must be restored before the image can be
+// launched or copied. With wait, it waits until they are all archived.
+// Snapshots already archived are left as they are.
+func archiveImage(ctx context.Context, client *ec2.Client, image types.Image, wait, verbose bool) (*archiveResult, error) {
+	snapshots, err := describeImageSnapshots(ctx, client, image)
+	if err != nil {
+		return nil, err
+	}
+	res := &archiveResult{ImageId: *image.ImageId, Name: aws.ToString(image.Name), CreationDate: aws.ToString(image.CreationDate)}
+	var ids []string
+	for _, ds := range snapshots {
+		id := *ds.snapshot.SnapshotId
+		ids = append(ids, id)
+		if ds.snapshot.StorageTier == types.StorageTierArchive {
+			continue
+		}
+		if _, err := client.ModifySnapshotTier(ctx, &ec2.ModifySnapshotTierInput{
+			SnapshotId:  aws.String(id),
+			StorageTier: types.TargetStorageTierArchive,
+		}); err != nil {
+			return nil, fmt.Errorf("error archiving snapshot %s of image %s: %w", id, *image.ImageId, err)
+		}
+		trace.state(id, "archiving", nil)
+		if verbose {
+			logf("archiving snapshot %s of image %s", id, *image.ImageId)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("image %s has no EBS snapshots", *image.ImageId)
+	}
+
+	var w waitLog
+	for {
+		statuses, err := describeTierStatus(ctx, client, ids)
+		if err != nil {
+			return nil, err
+		}
+		res.Snapshots = res.Snapshots[:0]
+		var pending []string
+		for _, id := range ids {
+			s := statuses[id]
+			res.Snapshots = append(res.Snapshots, archivedSnapshot{SnapshotId: id, StorageTier: string(s.StorageTier), Status: string(s.LastTieringOperationStatus)})
+			if s.LastTieringOperationStatus == types.TieringOperationStatusArchivalFailed {
+				return res, fmt.Errorf("archiving snapshot %s failed: %s", id, aws.ToString(s.LastTieringOperationStatusDetail))
+			}
+			if s.StorageTier != types.StorageTierArchive {
+				pending = append(pending, id)
+			}
+		}
+		if !wait || len(pending) == 0 {
+			return res, nil
+		}
+		if verbose {
+			w.logf("waiting for snapshots %s of image %s to be archived", strings.Join(pending, ", "), *image.ImageId)
+		}
+		t := time.NewTimer(archivePollInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return res, fmt.Errorf("snapshots of image %s are not archived: %w", *image.ImageId, ctx.Err())
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+type archiveReport struct {
+	DryRun bool `json:",omitempty"`
+	Images []archiveResult
+}
+
+func runArchive(args []string) {
+	var imageID, namePrefix, olderThan, query string
+	var tagFilters filterTags
+	var wait, dryRun, verbose bool
+	var timeout time.Duration
+	fs := flag.NewFlagSet("archive", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.StringVar(&imageID, "image-id", "", "ID of the image whose snapshots are archived")
+	fs.StringVar(&olderThan, "older-than", "", "instead of -image-id, archive the snapshots of the images owned by the account created longer ago than this(eg. 90d)")
+	fs.StringVar(&namePrefix, "name-prefix", "", "with -older-than, only archive images whose name starts with this prefix")
+	fs.Var(&tagFilters, "tag", "with -older-than, only archive images with these tags(eg. env=prod)")
+	fs.BoolVar(&wait, "wait", false, "wait until the snapshots are in the archive tier, which can take hours")
+	fs.BoolVar(&dryRun, "dry-run", false, "report the images whose snapshots would be archived without archiving them")
+	fs.BoolVar(&verbose, "v", false, "verbose output")
+	fs.DurationVar(&timeout, "timeout", 0, "with -wait, give up and exit with status "+strconv.Itoa(exitTimeout)+" if the snapshots are not archived after this long(eg. 48h; default: no limit)")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. Images[].ImageId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	if (imageID == "") == (olderThan == "") {
+		fatal("either -image-id or -older-than is required")
+	}
+	if imageID != "" && (namePrefix != "" || len(tagFilters) > 0) {
+		fatal("-name-prefix and -tag require -older-than")
+	}
+	var cutoff time.Time
+	if olderThan != "" {
+		d, err := parseDuration(olderThan)
+		if err != nil {
+			fatal(err)
+		}
+		cutoff = time.Now().Add(-d)
+	}
+
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	client := ec2.NewFromConfig(cfg)
+
+	var images []types.Image
+	if imageID != "" {
+		image, err := describeImage(ctx, client, imageID)
+		if err != nil {
+			fatal(err)
+		}
+		images = []types.Image{image}
+	} else {
+		matched, err := matchingImages(ctx, client, nil, namePrefix, tagFilters)
+		if err != nil {
+			fatal(err)
+		}
+		for _, image := range matched {
+			t, err := time.Parse(time.RFC3339, aws.ToString(image.CreationDate))
+			if err == nil && t.Before(cutoff) {
+				images = append(images, image)
+			}
+		}
+	}
+
+	report := archiveReport{DryRun: dryRun, Images: []archiveResult{}}
+	var failed error
+	for _, image := range images {
+		snapshots, err := describeImageSnapshots(ctx, client, image)
+		if err != nil {
+			fatal(err)
+		}
+		archived := true
+		for _, ds := range snapshots {
+			archived = archived && ds.snapshot.StorageTier == types.StorageTierArchive
+		}
+		if archived && imageID == "" {
+			continue
+		}
+		if dryRun {
+			r := archiveResult{ImageId: *image.ImageId, Name: aws.ToString(image.Name), CreationDate: aws.ToString(image.CreationDate)}
+			for _, ds := range snapshots {
+				r.Snapshots = append(r.Snapshots, archivedSnapshot{SnapshotId: *ds.snapshot.SnapshotId, StorageTier: string(ds.snapshot.StorageTier)})
+			}
+			report.Images = append(report.Images, r)
+			continue
+		}
+		r, err := archiveImage(ctx, client, image, wait, verbose)
+		if err != nil {
+			warnf("%v", err)
+			failed = err
+			if r == nil {
+				r = &archiveResult{ImageId: *image.ImageId, Name: aws.ToString(image.Name), CreationDate: aws.ToString(image.CreationDate)}
+			}
+			r.Error = err.Error()
+		}
+		report.Images = append(report.Images, *r)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	printResult(report, query)
+	if failed != nil {
+		os.Exit(exitCode(ctx, failed))
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	astypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+)
+
+// startInstanceRefresh replaces the instances of the group so they pick up
+// its current launch template version.
+func startInstanceRefresh(ctx context.Context, client *autoscaling.Client, group string, minHealthy int32) (string, error) {
+	in := &autoscaling.StartInstanceRefreshInput{AutoScalingGroupName: &group}
+	if minHealthy > 0 {
+		in.Preferences = &astypes.RefreshPreferences{MinHealthyPercentage: &minHealthy}
+	}
+	out, err := client.StartInstanceRefresh(ctx, in)
+	if err != nil {
+		return "", fmt.Errorf("error starting instance refresh of %s: %w", group, err)
+	}
+	return *out.InstanceRefreshId, nil
+}
+
+// instanceRefreshResult is an instance refresh started with -refresh-asg.
+type instanceRefreshResult struct {
+	AutoScalingGroup  string
+	InstanceRefreshId string
+	// Status and PercentageComplete are those of the refresh when it was
+	// last described, its final status with -wait-refresh.
+	Status             string `json:",omitempty"`
+	PercentageComplete int32  `json:",omitempty"`
+}
+
+// waitInstanceRefresh waits until the instance refresh of the group has
+// finished, failing unless it succeeded.
+func waitInstanceRefresh(ctx context.Context, client *autoscaling.Client, res *instanceRefreshResult, verbose bool) error {
+	var wait waitLog
+	for {
+		out, err := client.DescribeInstanceRefreshes(ctx, &autoscaling.DescribeInstanceRefreshesInput{
+			AutoScalingGroupName: &res.AutoScalingGroup,
+			InstanceRefreshIds:   []string{res.InstanceRefreshId},
+		})
+		if err != nil {
+			return fmt.Errorf("error describing instance refresh %s: %w", res.InstanceRefreshId, err)
+		}
+		if len(out.InstanceRefreshes) == 0 {
+			return fmt.Errorf("instance refresh %s of %s not found", res.InstanceRefreshId, res.AutoScalingGroup)
+		}
+		r := out.InstanceRefreshes[0]
+		res.Status = string(r.Status)
+		if r.PercentageComplete != nil {
+			res.PercentageComplete = *r.PercentageComplete
+		}
+		switch r.Status {
+		case astypes.InstanceRefreshStatusSuccessful:
+			return nil
+		case astypes.InstanceRefreshStatusFailed, astypes.InstanceRefreshStatusCancelled,
+			astypes.InstanceRefreshStatusRollbackSuccessful, astypes.InstanceRefreshStatusRollbackFailed:
+			reason := aws.ToString(r.StatusReason)
+			if reason == "" {
+				reason = "no reason given"
+			}
+			return fmt.Errorf("instance refresh %s of %s is %s: %s", res.InstanceRefreshId, res.AutoScalingGroup, r.Status, reason)
+		}
+		if verbose {
+			wait.logf("waiting for instance refresh %s of %s: %s, %d%% complete", res.InstanceRefreshId, res.AutoScalingGroup, r.Status, res.PercentageComplete)
+		}
+		if err := sleepPoll(ctx); err != nil {
+			return fmt.Errorf("instance refresh %s of %s has not finished: %w", res.InstanceRefreshId, res.AutoScalingGroup, err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// apiCall is a call to an AWS API whose SDK module is not a dependency of
+// amimati, such as the EBS direct APIs and Step Functions. It is made through
+// the middleware stack of an SDK client, with the APIOptions and Retryer of
+// the config, so that it is retried, logged, traced and counted as the calls
+// of SDK clients are.
+type apiCall struct {
+	// ServiceID and operation are those of the SDK, as in EBS and
+	// ListChangedBlocks; signingName is the service of the endpoint and of
+	// the signature, as in ebs.
+	serviceID   string
+	signingName string
+	operation   string
+	method      string
+	path        string
+	query       url.Values
+	header      http.Header
+	body        []byte
+}
+
+// invokeAPI makes the call and returns the body of its response.
+func invokeAPI(ctx context.Context, cfg aws.Config, call apiCall) ([]byte, error) {
+	endpoint, err := apiEndpoint(ctx, cfg, call.signingName)
+	if err != nil {
+		return nil, &smithy.OperationError{ServiceID: call.serviceID, OperationName: call.operation, Err: err}
+	}
+	stack := middleware.NewStack(call.operation, smithyhttp.NewStackRequest)
+	if err := call.addMiddlewares(stack, cfg, endpoint); err != nil {
+		return nil, err
+	}
+	for _, fn := range cfg.APIOptions {
+		if err := fn(stack); err != nil {
+			return nil, err
+		}
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = awshttp.NewBuildableClient()
+	}
+	out, _, err := middleware.DecorateHandler(smithyhttp.NewClientHandler(httpClient), stack).Handle(ctx, call)
+	if err != nil {
+		return nil, &smithy.OperationError{ServiceID: call.serviceID, OperationName: call.operation, Err: err}
+	}
+	return out.([]byte), nil
+}
+
+func (call apiCall) addMiddlewares(stack *middleware.Stack, cfg aws.Config, endpoint *url.URL) error {
+	var retryer aws.Retryer = retry.NewStandard()
+	if cfg.Retryer != nil {
+		retryer = cfg.Retryer()
+	}
+	if err := stack.Initialize.Add(&awsmiddleware.RegisterServiceMetadata{ServiceID: call.serviceID, SigningName: call.signingName, Region: cfg.Region, OperationName: call.operation}, middleware.Before); err != nil {
+		return err
+	}
+	if err := stack.Serialize.Add(middleware.SerializeMiddlewareFunc("OperationSerializer", func(ctx context.Context, in middleware.SerializeInput, next middleware.SerializeHandler) (middleware.SerializeOutput, middleware.Metadata, error) {
+		req := in.Request.(*smithyhttp.Request)
+		u := *endpoint
+		u.Path = strings.TrimSuffix(u.Path, "/") + call.path
+		u.RawQuery = call.query.Encode()
+		req.URL = &u
+		req.Method = call.method
+		for k, v := range call.header {
+			req.Header[k] = v
+		}
+		if call.body != nil {
+			var err error
+			if req, err = req.SetStream(bytes.NewReader(call.body)); err != nil {
+				return middleware.SerializeOutput{}, middleware.Metadata{}, err
+			}
+			in.Request = req
+		}
+		return next.HandleSerialize(ctx, in)
+	}), middleware.After); err != nil {
+		return err
+	}
+	if err := smithyhttp.AddComputeContentLengthMiddleware(stack); err != nil {
+		return err
+	}
+	if err := stack.Finalize.Add(&signRequest{credentials: cfg.Credentials, payload: call.body}, middleware.After); err != nil {
+		return err
+	}
+	if err := retry.AddRetryMiddlewares(stack, retry.AddRetryMiddlewaresOptions{Retryer: retryer}); err != nil {
+		return err
+	}
+	if err := stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("OperationDeserializer", deserializeResponse), middleware.After); err != nil {
+		return err
+	}
+	if err := awsmiddleware.AddRequestIDRetrieverMiddleware(stack); err != nil {
+		return err
+	}
+	return awshttp.AddResponseErrorMiddleware(stack)
+}
+
+// signRequest signs each attempt with SigV4, retrieving the credentials
+// again so that credentials refreshed meanwhile are used.
+type signRequest struct {
+	credentials aws.CredentialsProvider
+	payload     []byte
+}
+
+// ID is that of the signing middleware of SDK clients, which the retry
+// middleware is inserted before.
+func (*signRequest) ID() string {
+	return "Signing"
+}
+
+func (s *signRequest) HandleFinalize(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+	if s.credentials == nil {
+		return next.HandleFinalize(ctx, in)
+	}
+	creds, err := s.credentials.Retrieve(ctx)
+	if err != nil {
+		return middleware.FinalizeOutput{}, middleware.Metadata{}, fmt.Errorf("error retrieving credentials: %w", err)
+	}
+	req := in.Request.(*smithyhttp.Request)
+	sum := sha256.Sum256(s.payload)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req.Request, hex.EncodeToString(sum[:]), awsmiddleware.GetSigningName(ctx), awsmiddleware.GetRegion(ctx), time.Now()); err != nil {
+		return middleware.FinalizeOutput{}, middleware.Metadata{}, fmt.Errorf("error signing request: %w", err)
+	}
+	return next.HandleFinalize(ctx, in)
+}
+
+// deserializeResponse returns the body of a successful response, or the
+// error of the service, with its code so that throttling is retried.
+func deserializeResponse(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+	out, md, err := next.HandleDeserialize(ctx, in)
+	if err != nil {
+		return out, md, err
+	}
+	resp := out.RawResponse.(*smithyhttp.Response)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return out, md, &smithy.DeserializationError{Err: err}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return out, md, responseError(resp, body)
+	}
+	out.Result = body
+	return out, md, nil
+}
+
+// responseError parses the error of a JSON or REST JSON response: its code is
+// in the X-Amzn-ErrorType header or the __type or code of the body, possibly
+// after the namespace and a #.
+func responseError(resp *smithyhttp.Response, body []byte) error {
+	var e struct {
+		Type    string `json:"__type"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(body, &e)
+	code, _, _ := strings.Cut(resp.Header.Get("X-Amzn-ErrorType"), ":")
+	for _, c := range []string{e.Type, e.Code} {
+		if code == "" {
+			code = c
+		}
+	}
+	if i := strings.LastIndex(code, "#"); i >= 0 {
+		code = code[i+1:]
+	}
+	if code == "" {
+		code = resp.Status
+	}
+	msg := e.Message
+	if msg == "" && e.Type == "" && e.Code == "" {
+		msg = strings.TrimSpace(string(body))
+	}
+	return &smithy.GenericAPIError{Code: code, Message: msg}
+}
+
+// apiEndpoint returns the endpoint of the service in the region of cfg, or
+// its -endpoint-url. The hosts of a service are named after it as those of
+// EC2 are, in the DNS domain of the partition, which the EC2 endpoint
+// resolver knows for every partition.
+func apiEndpoint(ctx context.Context, cfg aws.Config, service string) (*url.URL, error) {
+	if cfg.BaseEndpoint != nil {
+		return url.Parse(*cfg.BaseEndpoint)
+	}
+	e, err := ec2.NewDefaultEndpointResolverV2().ResolveEndpoint(ctx, ec2.EndpointParameters{Region: aws.String(cfg.Region)})
+	if err != nil {
+		return nil, fmt.Errorf("error resolving the %s endpoint: %w", service, err)
+	}
+	u := e.URI
+	host, ok := strings.CutPrefix(u.Host, "ec2.")
+	if !ok {
+		return nil, fmt.Errorf("error resolving the %s endpoint from %s", service, u.Host)
+	}
+	u.Host = service + "." + host
+	return &u, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+)
+
+// awsOptions are the flags shared by every command that talks to AWS.
+type awsOptions struct {
+	profile     string
+	region      string
+	endpointURL string
+	apiRates    apiRates
+	apiRPS      float64
+	maxRetries  int
+	// maxRetriesSet is whether -max-retries was given, which takes
+	// precedence over AWS_MAX_ATTEMPTS and the profile.
+	maxRetriesSet bool
+	// retryMode is standard or adaptive, or empty for that of
+	// AWS_RETRY_MODE or the profile.
+	retryMode string
+	// role is assumed, if its RoleArn is set, with the credentials of the
+	// profile.
+	role      accountRole
+	mfaSerial string
+	mfaToken  string
+	// imdsRegion falls back to the region of the instance amimati runs on
+	// when no region is configured.
+	imdsRegion bool
+}
+
+func addAWSFlags(fs *flag.FlagSet) *awsOptions {
+	o := &awsOptions{apiRates: defaultAPIRates()}
+	fs.StringVar(&o.profile, "profile", "", "named profile of the shared AWS config files(default: $AWS_PROFILE or default)")
+	fs.StringVar(&o.region, "region", "", "AWS region(default: $AWS_REGION or that of the profile)")
+	fs.StringVar(&o.endpointURL, "endpoint-url", "", "send the AWS requests to this endpoint instead, such as LocalStack(eg. http://localhost:4566)")
+	fs.StringVar(&o.role.RoleArn, "assume-role-arn", "", "assume this role with the credentials of the profile(eg. arn:aws:iam::111122223333:role/ami-builder)")
+	fs.StringVar(&o.role.ExternalId, "external-id", "", "with -assume-role-arn, the external ID the role requires")
+	fs.StringVar(&o.role.SessionName, "session-name", "", "with -assume-role-arn, the role session name(default: amimati)")
+	fs.StringVar(&o.mfaSerial, "mfa-serial", "", "with -assume-role-arn, the MFA device the role requires(eg. arn:aws:iam::444455556666:mfa/alice)")
+	fs.StringVar(&o.mfaToken, "mfa-token", "", "with -mfa-serial, the current MFA code(default: read from stdin)")
+	fs.Var(o.apiRates, "api-rate", "EC2 requests per second per region and API family(describe, image, mutating; 0 for unlimited)")
+	fs.Float64Var(&o.apiRPS, "api-rps", 0, "EC2 requests per second per region across every API family, on top of -api-rate(eg. 20; default: no limit)")
+	o.maxRetries = defaultMaxRetries
+	fs.Var(&retriesFlag{&o.maxRetries, &o.maxRetriesSet}, "max-retries", "times a throttled or transiently failed AWS request is retried with exponential backoff, 0 to never retry(default: $AWS_MAX_ATTEMPTS - 1, max_attempts - 1 of the profile, or 10)")
+	fs.StringVar(&o.retryMode, "retry-mode", "", "standard, or adaptive to also slow down requests client-side once throttled(default: $AWS_RETRY_MODE, retry_mode of the profile, or standard)")
+	return o
+}
+
+// load loads the AWS config of -profile, or the default one, and installs the
+// retryer and the rate limiter.
+func (o *awsOptions) load(ctx context.Context) (aws.Config, error) {
+	return o.loadProfile(ctx, o.profile)
+}
+
+// loadProfile is load for a named profile of the shared config files, or the
+// default one if profile is empty.
+func (o *awsOptions) loadProfile(ctx context.Context, profile string) (aws.Config, error) {
+	var opts []func(*config.LoadOptions) error
+	if profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(profile))
+	}
+	if o.region != "" {
+		opts = append(opts, config.WithRegion(o.region))
+	}
+	if o.endpointURL != "" {
+		opts = append(opts, config.WithBaseEndpoint(o.endpointURL))
+	}
+	if o.imdsRegion {
+		opts = append(opts, config.WithEC2IMDSRegion())
+	}
+	if err := o.validateRole(); err != nil {
+		return aws.Config{}, err
+	}
+	if o.maxRetries < 0 {
+		return aws.Config{}, fmt.Errorf("max retries must not be negative")
+	}
+	if o.apiRPS < 0 {
+		return aws.Config{}, fmt.Errorf("API requests per second must not be negative")
+	}
+	if o.retryMode != "" {
+		if _, err := aws.ParseRetryMode(o.retryMode); err != nil {
+			return aws.Config{}, fmt.Errorf("invalid retry mode %q, want standard or adaptive", o.retryMode)
+		}
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("error loading config: %w", err)
+	}
+	maxRetries, mode := o.retrySettings(cfg)
+	cfg.Retryer = func() aws.Retryer { return newRetryer(maxRetries, mode) }
+	// The clients would otherwise cap the attempts of the retryer at those
+	// of AWS_MAX_ATTEMPTS or the profile, overriding -max-retries.
+	cfg.RetryMaxAttempts = 0
+	if o.role.RoleArn != "" {
+		cfg = assumeRole(cfg, o.role, o.mfa)
+	}
+	l := &rateLimiter{rates: o.apiRates, total: o.apiRPS, buckets: map[string]*tokenBucket{}}
+	l.instrument(&cfg)
+	logRequests(&cfg)
+	validating = false
+	return cfg, nil
+}
+
+// retrySettings returns the retries and the retry mode of the requests: those
+// of the flags, else those of AWS_MAX_ATTEMPTS and AWS_RETRY_MODE or of the
+// profile, as loaded into cfg, else the defaults.
+func (o *awsOptions) retrySettings(cfg aws.Config) (int, aws.RetryMode) {
+	maxRetries := o.maxRetries
+	if !o.maxRetriesSet && cfg.RetryMaxAttempts > 0 {
+		maxRetries = cfg.RetryMaxAttempts - 1
+	}
+	mode := cfg.RetryMode
+	if o.retryMode != "" {
+		mode, _ = aws.ParseRetryMode(o.retryMode)
+	}
+	if mode == "" {
+		mode = aws.RetryModeStandard
+	}
+	return maxRetries, mode
+}
+
+// retriesFlag is the -max-retries flag, recording whether it was given.
+type retriesFlag struct {
+	n   *int
+	set *bool
+}
+
+func (f *retriesFlag) String() string {
+	if f.n == nil {
+		return ""
+	}
+	return strconv.Itoa(*f.n)
+}
+
+func (f *retriesFlag) Set(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid number of retries: %s", value)
+	}
+	*f.n, *f.set = n, true
+	return nil
+}
+
+func (o *awsOptions) validateRole() error {
+	if o.role.RoleArn == "" && (o.role.ExternalId != "" || o.role.SessionName != "" || o.mfaSerial != "") {
+		return errors.New("-external-id, -session-name and -mfa-serial require -assume-role-arn")
+	}
+	if o.mfaToken != "" && o.mfaSerial == "" {
+		return errors.New("-mfa-token requires -mfa-serial")
+	}
+	return nil
+}
+
+// mfa sets up the MFA device of the role, if any, with the code given by
+// -mfa-token or read from stdin when the role is first assumed.
+func (o *awsOptions) mfa(ro *stscreds.AssumeRoleOptions) {
+	if o.mfaSerial == "" {
+		return
+	}
+	ro.SerialNumber = aws.String(o.mfaSerial)
+	ro.TokenProvider = stdinMFAToken
+	if o.mfaToken != "" {
+		token := o.mfaToken
+		ro.TokenProvider = func() (string, error) { return token, nil }
+	}
+}
+
+// stdinMFAToken prompts for an MFA code on stderr, keeping stdout for the
+// result, and reads it from stdin.
+func stdinMFAToken() (string, error) {
+	fmt.Fprint(os.Stderr, "MFA code: ")
+	var code string
+	if _, err := fmt.Scanln(&code); err != nil {
+		return "", fmt.Errorf("error reading MFA code: %w", err)
+	}
+	return code, nil
+}
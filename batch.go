@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// batchOutcome is how one run of a batch ended.
+type batchOutcome struct {
+	err error
+	// cancelled is whether the run was cancelled, or never started, because
+	// the batch was aborted.
+	cancelled bool
+}
+
+// runBatch calls run once for each of n items, with at most concurrency runs
+// at a time, or all of them at once if concurrency is zero, and returns how
+// each run ended in the order of the items. Runs still going or yet to start
+// are cancelled once the failures abort the batch under the policy.
+func runBatch(ctx context.Context, n, concurrency int, policy errorPolicy, run func(ctx context.Context, i int) error) []batchOutcome {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if concurrency <= 0 {
+		concurrency = n
+	}
+	sem := make(chan struct{}, concurrency)
+	outcomes := make([]batchOutcome, n)
+	var mu sync.Mutex
+	failed := 0
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			outcomes[i] = batchOutcome{err: ctx.Err(), cancelled: true}
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := run(ctx, i)
+			if err == nil {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			outcomes[i].err = err
+			if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+				outcomes[i].cancelled = true
+				return
+			}
+			failed++
+			if policy.abort(failed, n) {
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return outcomes
+}
+
+// newBatchReport counts the outcomes of a batch run under the policy.
+func newBatchReport(policy errorPolicy, outcomes []batchOutcome) batchReport {
+	report := batchReport{OnError: policy.String(), Total: len(outcomes)}
+	for _, o := range outcomes {
+		switch {
+		case o.cancelled:
+			report.Cancelled++
+		case o.err != nil:
+			report.Failed++
+		default:
+			report.Succeeded++
+		}
+	}
+	// Cancelled runs count as failed: they did not produce an image.
+	report.Passed = policy.passed(report.Failed+report.Cancelled, report.Total)
+	return report
+}
+
+// exitStatus is the exit status of the batch: 0 if it passed, exitPartial if
+// it did not but some runs succeeded, and 1 otherwise.
+func (r batchReport) exitStatus() int {
+	switch {
+	case r.Passed:
+		return 0
+	case r.Succeeded > 0:
+		return exitPartial
+	}
+	return 1
+}
+
+type instanceResult struct {
+	InstanceId string
+	Result     *result `json:",omitempty"`
+	Error      string  `json:",omitempty"`
+	Cancelled  bool    `json:",omitempty"`
+}
+
+// createImageBatch runs createImage once per instance, at most concurrency at
+// a time, and returns the results in the order of the instances along with
+// the report of the batch.
+func createImageBatch(ctx context.Context, cfg aws.Config, instanceIDs []string, concurrency int, opt options, policy errorPolicy) ([]instanceResult, batchReport) {
+	results := make([]instanceResult, len(instanceIDs))
+	if concurrency > 1 {
+		cfg = batchDescribes(cfg)
+	}
+	outcomes := runBatch(ctx, len(instanceIDs), concurrency, policy, func(ctx context.Context, i int) error {
+		o := opt
+		o.instanceID = instanceIDs[i]
+		o.instance = instanceSelector{}
+		var err error
+		results[i].Result, err = createImage(ctx, cfg, o)
+		return err
+	})
+	for i, o := range outcomes {
+		results[i].InstanceId = instanceIDs[i]
+		if o.err != nil {
+			results[i].Error = o.err.Error()
+		}
+		results[i].Cancelled = o.cancelled
+	}
+	return results, newBatchReport(policy, outcomes)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func parseBootMode(s string) (types.BootModeValues, error) {
+	for _, v := range types.BootModeValues("").Values() {
+		if string(v) == s {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("invalid boot mode: %s", s)
+}
+
+func parseTpmSupport(s string) (types.TpmSupportValues, error) {
+	for _, v := range types.TpmSupportValues("").Values() {
+		if string(v) == s {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("invalid TPM support: %s", s)
+}
+
+// instanceBootMode returns the boot mode the instance is actually running
+// with.
+func instanceBootMode(instance types.Instance) string {
+	if instance.CurrentInstanceBootMode != "" {
+		return string(instance.CurrentInstanceBootMode)
+	}
+	if instance.BootMode != "" && instance.BootMode != types.BootModeValuesUefiPreferred {
+		return string(instance.BootMode)
+	}
+	return defaultBootMode(instance.Architecture)
+}
+
+// imageBootMode returns the boot mode instances launched from the image will
+// use. Images without an explicit boot mode use the default of their
+// architecture.
+func imageBootMode(mode types.BootModeValues, arch types.ArchitectureValues) string {
+	if mode != "" {
+		return string(mode)
+	}
+	return defaultBootMode(arch)
+}
+
+func defaultBootMode(arch types.ArchitectureValues) string {
+	if arch == types.ArchitectureValuesArm64 || arch == types.ArchitectureValuesArm64Mac {
+		return string(types.BootModeValuesUefi)
+	}
+	return string(types.BootModeValuesLegacyBios)
+}
+
+// checkBootMode warns when instances launched with the given boot mode would
+// not boot the same way as the source instance.
+func checkBootMode(instance types.Instance, mode types.BootModeValues, arch types.ArchitectureValues) {
+	want := instanceBootMode(instance)
+	got := imageBootMode(mode, arch)
+	if got == string(types.BootModeValuesUefiPreferred) || got == want {
+		return
+	}
+	warnf("instance %s boots with %s but the image implies %s; instances launched from it may fail to boot", *instance.InstanceId, want, got)
+}
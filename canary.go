@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	astypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+)
+
+const canaryTagKey = "amimati:canary"
+
+type canaryOptions struct {
+	asg     string
+	count   int
+	timeout time.Duration
+}
+
+type canaryResult struct {
+	AutoScalingGroup string
+	Instances        []string
+	Replaced         []string `json:",omitempty"`
+	Promotable       bool
+	Reason           string `json:",omitempty"`
+}
+
+// runCanary replaces count instances of the auto scaling group with instances
+// launched from the image. Canaries are launched from the group's launch
+// template in the subnet of the instance they replace and attached to the
+// group; the replaced instances are only terminated once every canary is
+// healthy in the group and its target groups. Unhealthy canaries are removed
+// and the image is reported as not promotable.
+func runCanary(ctx context.Context, cfg aws.Config, imageID string, opt canaryOptions, verbose bool) (*canaryResult, error) {
+	client := ec2.NewFromConfig(cfg)
+	asClient := autoscaling.NewFromConfig(cfg)
+
+	out, err := asClient.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{AutoScalingGroupNames: []string{opt.asg}})
+	if err != nil {
+		return nil, fmt.Errorf("error describing auto scaling group: %w", err)
+	}
+	if len(out.AutoScalingGroups) == 0 {
+		return nil, fmt.Errorf("auto scaling group %s not found", opt.asg)
+	}
+	group := out.AutoScalingGroups[0]
+
+	lt := group.LaunchTemplate
+	if lt == nil && group.MixedInstancesPolicy != nil && group.MixedInstancesPolicy.LaunchTemplate != nil {
+		lt = group.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification
+	}
+	if lt == nil {
+		return nil, fmt.Errorf("auto scaling group %s has no launch template", opt.asg)
+	}
+
+	var victims []string
+	for _, i := range group.Instances {
+		if i.LifecycleState == astypes.LifecycleStateInService && len(victims) < opt.count {
+			victims = append(victims, *i.InstanceId)
+		}
+	}
+	if len(victims) < opt.count {
+		return nil, fmt.Errorf("auto scaling group %s has %d instances in service, %d needed", opt.asg, len(victims), opt.count)
+	}
+
+	res := &canaryResult{AutoScalingGroup: opt.asg}
+	for _, victim := range victims {
+		instance, err := describeInstance(ctx, client, victim)
+		if err != nil {
+			return res, err
+		}
+		run, err := client.RunInstances(ctx, &ec2.RunInstancesInput{
+			ImageId:  &imageID,
+			MinCount: aws.Int32(1),
+			MaxCount: aws.Int32(1),
+			SubnetId: instance.SubnetId,
+			LaunchTemplate: &types.LaunchTemplateSpecification{
+				LaunchTemplateId:   lt.LaunchTemplateId,
+				LaunchTemplateName: lt.LaunchTemplateName,
+				Version:            lt.Version,
+			},
+			TagSpecifications: []types.TagSpecification{{
+				ResourceType: types.ResourceTypeInstance,
+				Tags:         []types.Tag{{Key: aws.String(canaryTagKey), Value: &imageID}},
+			}},
+		})
+		if err != nil {
+			return res, fmt.Errorf("error launching canary instance: %w", err)
+		}
+		res.Instances = append(res.Instances, *run.Instances[0].InstanceId)
+	}
+	if verbose {
+		logf("launched canary instances %v in %s", res.Instances, opt.asg)
+	}
+
+	waiter := ec2.NewInstanceRunningWaiter(client)
+	if err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{InstanceIds: res.Instances}, opt.timeout); err != nil {
+		return res, errors.Join(fmt.Errorf("error waiting for canary instances: %w", err), removeCanaries(ctx, client, asClient, res, false))
+	}
+	if _, err := asClient.AttachInstances(ctx, &autoscaling.AttachInstancesInput{AutoScalingGroupName: &opt.asg, InstanceIds: res.Instances}); err != nil {
+		return res, errors.Join(fmt.Errorf("error attaching canary instances: %w", err), removeCanaries(ctx, client, asClient, res, false))
+	}
+
+	if err := waitCanaryHealthy(ctx, asClient, elbv2.NewFromConfig(cfg), group.TargetGroupARNs, res.Instances, opt.timeout, verbose); err != nil {
+		res.Reason = err.Error()
+		return res, removeCanaries(ctx, client, asClient, res, true)
+	}
+
+	for _, victim := range victims {
+		if _, err := asClient.TerminateInstanceInAutoScalingGroup(ctx, &autoscaling.TerminateInstanceInAutoScalingGroupInput{
+			InstanceId:                     &victim,
+			ShouldDecrementDesiredCapacity: aws.Bool(true),
+		}); err != nil {
+			return res, fmt.Errorf("error terminating replaced instance %s: %w", victim, err)
+		}
+		res.Replaced = append(res.Replaced, victim)
+	}
+	res.Promotable = true
+	return res, nil
+}
+
+// waitCanaryHealthy waits until the instances are healthy and in service in
+// the auto scaling group and healthy in each of its target groups.
+func waitCanaryHealthy(ctx context.Context, asClient *autoscaling.Client, elbClient *elbv2.Client, targetGroups, instances []string, timeout time.Duration, verbose bool) error {
+	deadline := time.Now().Add(timeout)
+	var wait waitLog
+	for {
+		unhealthy, err := unhealthyCanaries(ctx, asClient, elbClient, targetGroups, instances)
+		if err != nil {
+			return err
+		}
+		if len(unhealthy) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("canary instances not healthy after %s: %v", timeout, unhealthy)
+		}
+		if verbose {
+			wait.logf("waiting for canary instances to be healthy: %v", unhealthy)
+		}
+		time.Sleep(15 * time.Second)
+	}
+}
+
+func unhealthyCanaries(ctx context.Context, asClient *autoscaling.Client, elbClient *elbv2.Client, targetGroups, instances []string) ([]string, error) {
+	healthy := map[string]bool{}
+	out, err := asClient.DescribeAutoScalingInstances(ctx, &autoscaling.DescribeAutoScalingInstancesInput{InstanceIds: instances})
+	if err != nil {
+		return nil, fmt.Errorf("error describing auto scaling instances: %w", err)
+	}
+	for _, i := range out.AutoScalingInstances {
+		healthy[*i.InstanceId] = aws.ToString(i.HealthStatus) == "HEALTHY" && aws.ToString(i.LifecycleState) == string(astypes.LifecycleStateInService)
+	}
+
+	for _, tg := range targetGroups {
+		var targets []elbv2types.TargetDescription
+		for _, id := range instances {
+			targets = append(targets, elbv2types.TargetDescription{Id: aws.String(id)})
+		}
+		out, err := elbClient.DescribeTargetHealth(ctx, &elbv2.DescribeTargetHealthInput{TargetGroupArn: aws.String(tg), Targets: targets})
+		if err != nil {
+			return nil, fmt.Errorf("error describing target health: %w", err)
+		}
+		for _, d := range out.TargetHealthDescriptions {
+			if d.TargetHealth == nil || d.TargetHealth.State != elbv2types.TargetHealthStateEnumHealthy {
+				healthy[aws.ToString(d.Target.Id)] = false
+			}
+		}
+	}
+
+	var unhealthy []string
+	for _, id := range instances {
+		if !healthy[id] {
+			unhealthy = append(unhealthy, id)
+		}
+	}
+	return unhealthy, nil
+}
+
+// removeCanaries terminates the canary instances, detaching them from the
+// group first when they were attached.
+func removeCanaries(ctx context.Context, client *ec2.Client, asClient *autoscaling.Client, res *canaryResult, attached bool) error {
+	if attached {
+		if _, err := asClient.DetachInstances(ctx, &autoscaling.DetachInstancesInput{
+			AutoScalingGroupName:           &res.AutoScalingGroup,
+			InstanceIds:                    res.Instances,
+			ShouldDecrementDesiredCapacity: aws.Bool(true),
+		}); err != nil {
+			return fmt.Errorf("error detaching canary instances: %w", err)
+		}
+	}
+	if _, err := client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{InstanceIds: res.Instances}); err != nil {
+		return fmt.Errorf("error terminating canary instances: %w", err)
+	}
+	return nil
+}
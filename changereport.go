@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// snapshotChange reports the blocks of a snapshot of the image that changed
+// since the previous snapshot of the same volume, with -change-report.
+type snapshotChange struct {
+	DeviceName string
+	SnapshotId string
+	// PreviousSnapshotId is empty for the first snapshot of the volume, of
+	// which every written block counts as changed.
+	PreviousSnapshotId string `json:",omitempty"`
+	ChangedBlocks      int
+	BlockSize          int32
+	ChangedBytes       int64
+	VolumeSizeGiB      int32
+	// ChangedPercent is the share of the volume that changed.
+	ChangedPercent float64
+	Error          string `json:",omitempty"`
+}
+
+// reportChanges compares each snapshot with the previous completed snapshot
+// of its volume with ListChangedBlocks of the EBS direct APIs. Failures are
+// reported per snapshot and warned about without failing the run.
+func reportChanges(ctx context.Context, cfg aws.Config, snapshots []deviceSnapshot, verbose bool) []snapshotChange {
+	client := ec2.NewFromConfig(cfg)
+	changes := make([]snapshotChange, 0, len(snapshots))
+	for _, ds := range snapshots {
+		c := snapshotChange{DeviceName: ds.deviceName, SnapshotId: aws.ToString(ds.snapshot.SnapshotId), VolumeSizeGiB: aws.ToInt32(ds.snapshot.VolumeSize)}
+		err := func() error {
+			previous, err := previousSnapshot(ctx, client, ds.snapshot)
+			if err != nil {
+				return err
+			}
+			c.PreviousSnapshotId = previous
+			c.ChangedBlocks, c.BlockSize, err = listChangedBlocks(ctx, cfg, previous, c.SnapshotId)
+			return err
+		}()
+		if err != nil {
+			warnf("error reporting the changes of snapshot %s: %v", c.SnapshotId, err)
+			c.Error = err.Error()
+		}
+		c.ChangedBytes = int64(c.ChangedBlocks) * int64(c.BlockSize)
+		if c.VolumeSizeGiB > 0 {
+			c.ChangedPercent = float64(c.ChangedBytes) / float64(int64(c.VolumeSizeGiB)<<30) * 100
+		}
+		if verbose && err == nil {
+			logf("snapshot %s of %s: %d blocks (%.1f MiB) changed since %s", c.SnapshotId, c.DeviceName, c.ChangedBlocks, float64(c.ChangedBytes)/(1<<20), c.previous())
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+func (c snapshotChange) previous() string {
+	if c.PreviousSnapshotId == "" {
+		return "the volume was created"
+	}
+	return c.PreviousSnapshotId
+}
+
+// previousSnapshot returns the newest completed snapshot of the volume of s
+// started before it, or an empty string if there is none.
+func previousSnapshot(ctx context.Context, client *ec2.Client, s types.Snapshot) (string, error) {
+	if s.VolumeId == nil || s.StartTime == nil {
+		return "", nil
+	}
+	var older []types.Snapshot
+	p := ec2.NewDescribeSnapshotsPaginator(client, &ec2.DescribeSnapshotsInput{
+		OwnerIds: []string{"self"},
+		Filters: []types.Filter{
+			{Name: aws.String("volume-id"), Values: []string{*s.VolumeId}},
+			{Name: aws.String("status"), Values: []string{string(types.SnapshotStateCompleted)}},
+		},
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return "", fmt.Errorf("error describing the snapshots of volume %s: %w", *s.VolumeId, err)
+		}
+		for _, o := range page.Snapshots {
+			if o.StartTime != nil && o.StartTime.Before(*s.StartTime) && aws.ToString(o.SnapshotId) != aws.ToString(s.SnapshotId) {
+				older = append(older, o)
+			}
+		}
+	}
+	if len(older) == 0 {
+		return "", nil
+	}
+	sort.Slice(older, func(i, j int) bool { return older[i].StartTime.After(*older[j].StartTime) })
+	return *older[0].SnapshotId, nil
+}
+
+// changedBlocksPage is a page of the ListChangedBlocks response.
+type changedBlocksPage struct {
+	BlockSize     int32
+	ChangedBlocks []struct {
+		BlockIndex int32
+	}
+	NextToken string
+}
+
+// listChangedBlocks counts the blocks that differ between the snapshots with
+// ListChangedBlocks, or the written blocks of second if first is empty.
+func listChangedBlocks(ctx context.Context, cfg aws.Config, first, second string) (int, int32, error) {
+	var blocks int
+	var blockSize int32
+	token := ""
+	for {
+		q := url.Values{"maxResults": {"10000"}}
+		if first != "" {
+			q.Set("firstSnapshotId", first)
+		}
+		if token != "" {
+			q.Set("pageToken", token)
+		}
+		body, err := invokeAPI(ctx, cfg, apiCall{
+			serviceID:   "EBS",
+			signingName: "ebs",
+			operation:   "ListChangedBlocks",
+			method:      http.MethodGet,
+			path:        "/snapshots/" + url.PathEscape(second) + "/changedblocks",
+			query:       q,
+		})
+		if err != nil {
+			return 0, 0, fmt.Errorf("error listing changed blocks: %w", err)
+		}
+		var page changedBlocksPage
+		if err := json.Unmarshal(body, &page); err != nil {
+			return 0, 0, fmt.Errorf("error parsing changed blocks: %w", err)
+		}
+		blocks += len(page.ChangedBlocks)
+		if page.BlockSize > 0 {
+			blockSize = page.BlockSize
+		}
+		if page.NextToken == "" {
+			return blocks, blockSize, nil
+		}
+		token = page.NextToken
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/smithy-go/middleware"
+)
+
+func TestListChangedBlocks(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/snapshots/snap-2/changedblocks" || r.URL.Query().Get("firstSnapshotId") != "snap-1" {
+			t.Errorf("got request %s", r.URL)
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") || !strings.Contains(r.Header.Get("Authorization"), "/ebs/aws4_request") {
+			t.Errorf("request is not signed for ebs: %q", r.Header.Get("Authorization"))
+		}
+		switch r.URL.Query().Get("pageToken") {
+		case "":
+			fmt.Fprint(w, `{"BlockSize":524288,"ChangedBlocks":[{"BlockIndex":1},{"BlockIndex":2}],"NextToken":"p2"}`)
+		case "p2":
+			fmt.Fprint(w, `{"BlockSize":524288,"ChangedBlocks":[{"BlockIndex":9}]}`)
+		default:
+			t.Errorf("unexpected page token %q", r.URL.Query().Get("pageToken"))
+		}
+	}))
+	defer srv.Close()
+
+	cfg := aws.Config{Region: "us-east-1", BaseEndpoint: aws.String(srv.URL), Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", "")}
+	blocks, size, err := listChangedBlocks(context.Background(), cfg, "snap-1", "snap-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks != 3 || size != 524288 || requests != 2 {
+		t.Errorf("got %d blocks of %d bytes in %d requests, want 3 of 524288 in 2", blocks, size, requests)
+	}
+}
+
+func TestListChangedBlocksError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-amzn-RequestId", "req-1")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"message":"denied"}`)
+	}))
+	defer srv.Close()
+
+	cfg := aws.Config{Region: "us-east-1", BaseEndpoint: aws.String(srv.URL), Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", "")}
+	_, _, err := listChangedBlocks(context.Background(), cfg, "", "snap-2")
+	if err == nil || !strings.Contains(err.Error(), "denied") || !strings.Contains(err.Error(), "req-1") {
+		t.Fatalf("got error %v, want the denial with its request ID", err)
+	}
+}
+
+func TestListChangedBlocksRetried(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("X-Amzn-ErrorType", "RequestThrottledException")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"message":"slow down"}`)
+			return
+		}
+		fmt.Fprint(w, `{"BlockSize":524288,"ChangedBlocks":[{"BlockIndex":1}]}`)
+	}))
+	defer srv.Close()
+
+	var calls []string
+	cfg := aws.Config{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(srv.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		Retryer: func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+			})
+		},
+		APIOptions: []func(*middleware.Stack) error{func(stack *middleware.Stack) error {
+			return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("record", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				calls = append(calls, awsmiddleware.GetServiceID(ctx)+"."+awsmiddleware.GetOperationName(ctx))
+				return next.HandleInitialize(ctx, in)
+			}), middleware.After)
+		}},
+	}
+	blocks, _, err := listChangedBlocks(context.Background(), cfg, "", "snap-2")
+	if err != nil || blocks != 1 || requests != 2 {
+		t.Fatalf("got %d blocks in %d requests (%v), want 1 after a retry", blocks, requests, err)
+	}
+	if want := []string{"EBS.ListChangedBlocks"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("APIOptions saw %v, want %v", calls, want)
+	}
+}
+
+func TestAPIEndpoint(t *testing.T) {
+	for region, want := range map[string]string{
+		"us-east-1":     "https://ebs.us-east-1.amazonaws.com",
+		"us-gov-west-1": "https://ebs.us-gov-west-1.amazonaws.com",
+		"cn-north-1":    "https://ebs.cn-north-1.amazonaws.com.cn",
+		"us-iso-east-1": "https://ebs.us-iso-east-1.c2s.ic.gov",
+	} {
+		got, err := apiEndpoint(context.Background(), aws.Config{Region: region}, "ebs")
+		if err != nil || got.String() != want {
+			t.Errorf("apiEndpoint(%s, ebs) = %v, %v, want %s", region, got, err, want)
+		}
+	}
+}
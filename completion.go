@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// completionCacheTTL is how long live values offered for completion are
+// reused before AWS is asked again.
+const completionCacheTTL = time.Minute
+
+// subcommands returns the commands offered when completing the first word, in
+// order.
+func subcommands() []string {
+	var names []string
+	for name := range commands {
+		if !strings.HasPrefix(name, "__") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+const bashCompletion = `_amimati() {
+	local IFS=$'\n'
+	COMPREPLY=($(amimati __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null | cut -f1))
+	if [ ${#COMPREPLY[@]} -eq 0 ]; then
+		COMPREPLY=($(compgen -f -- "${COMP_WORDS[COMP_CWORD]}"))
+	fi
+}
+complete -F _amimati amimati
+`
+
+const zshCompletion = `_amimati() {
+	local -a values
+	values=(${(f)"$(amimati __complete "${(@)words[2,CURRENT]}" 2>/dev/null | sed 's/:/\\:/g; s/	/:/')"})
+	if (( ${#values} )); then
+		_describe 'value' values
+	else
+		_files
+	fi
+}
+compdef _amimati amimati
+`
+
+const fishCompletion = `function __amimati_complete
+	set -l values (amimati __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)
+	if test (count $values) -gt 0
+		string join \n -- $values
+	else
+		__fish_complete_path (commandline -ct)
+	end
+end
+complete -c amimati -f -a '(__amimati_complete)'
+`
+
+// flagValues are offered for the flags taking one of a few values.
+var flagValues = map[string][]string{
+	"o":            {outputJSON, outputID, outputTable, outputYAML},
+	"log-level":    {"debug", "info", "warn", "error"},
+	"log-format":   {"text", "json", "human"},
+	"on-error":     {onErrorContinue, onErrorFailFast, "threshold=20%"},
+	"if-exists":    {ifExistsFail, ifExistsSkip, ifExistsReplace, ifExistsSuffix},
+	"boot-mode":    {"uefi", "legacy-bios", "uefi-preferred"},
+	"format":       {"vmdk", "raw", "vhd", "vhdx"},
+	"architecture": {"x86_64", "arm64", "i386", "x86_64_mac", "arm64_mac"},
+	"retry-mode":   {"standard", "adaptive"},
+}
+
+func runCompletion(args []string) {
+	if len(args) != 1 {
+		fatal("usage: amimati completion bash|zsh|fish")
+	}
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		fmt.Print(fishCompletion)
+	default:
+		fatalf("unsupported shell: %s", args[0])
+	}
+}
+
+// completionValue is a candidate with an optional description.
+type completionValue struct {
+	Value       string
+	Description string
+}
+
+// runComplete prints the candidates for the last of the words, one per line
+// with a tab before their description. It is called by the completion
+// scripts and prints nothing it cannot complete, leaving that to the shell.
+func runComplete(args []string) {
+	if len(args) == 0 {
+		return
+	}
+	cur := args[len(args)-1]
+	var values []completionValue
+	switch {
+	case strings.HasPrefix(cur, "-"):
+		// Without a command, the flags are those of create.
+		command := "create"
+		if len(args) > 1 && !strings.HasPrefix(args[0], "-") {
+			command = args[0]
+		}
+		values = commandFlags(command)
+	case len(args) == 1:
+		for _, c := range subcommands() {
+			values = append(values, completionValue{Value: c})
+		}
+	default:
+		name := strings.TrimLeft(args[len(args)-2], "-")
+		if v, ok := flagValues[name]; ok {
+			for _, s := range v {
+				values = append(values, completionValue{Value: s})
+			}
+			break
+		}
+		kind := ""
+		switch name {
+		case "instance-id", "source-instance-id":
+			kind = "instances"
+		case "image-id", "source-image-id":
+			kind = "images"
+		case "launch-template", "update-launch-template":
+			kind = "launch-templates"
+		}
+		if kind == "" {
+			return
+		}
+		var err error
+		if values, err = liveValues(context.Background(), kind); err != nil {
+			return
+		}
+	}
+	for _, v := range values {
+		if !strings.HasPrefix(v.Value, cur) {
+			continue
+		}
+		if v.Description != "" {
+			fmt.Printf("%s\t%s\n", v.Value, v.Description)
+		} else {
+			fmt.Println(v.Value)
+		}
+	}
+}
+
+// commandFlags returns the flags of the command with the first line of their
+// help, read from the usage amimati prints for "-h".
+func commandFlags(command string) []completionValue {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil
+	}
+	var usage bytes.Buffer
+	cmd := exec.Command(exe, command, "-h")
+	cmd.Stderr = &usage
+	// The usage is printed before -h exits with a status of 0 or 2.
+	cmd.Run()
+
+	var values []completionValue
+	var last *completionValue
+	for _, line := range strings.Split(usage.String(), "\n") {
+		switch {
+		case strings.HasPrefix(line, "  -"):
+			name, desc, _ := strings.Cut(strings.TrimPrefix(line, "  "), "\t")
+			name, _, _ = strings.Cut(name, " ")
+			values = append(values, completionValue{Value: name, Description: desc})
+			last = &values[len(values)-1]
+		case strings.HasPrefix(line, "    \t") && last != nil:
+			if last.Description == "" {
+				last.Description = strings.TrimSpace(line)
+			}
+			last = nil
+		}
+	}
+	return values
+}
+
+// completionCache is the cached content of a kind of live values.
+type completionCache struct {
+	Fetched time.Time
+	Values  []completionValue
+}
+
+// liveValues returns the instances, images or launch templates of the current
+// region, from a cache file when it is recent enough.
+func liveValues(ctx context.Context, kind string) ([]completionValue, error) {
+	awsOpt := &awsOptions{apiRates: defaultAPIRates()}
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var path string
+	if dir, err := os.UserCacheDir(); err == nil {
+		path = filepath.Join(dir, "amimati", "completion", cfg.Region+"-"+kind+".json")
+		if b, err := os.ReadFile(path); err == nil {
+			var c completionCache
+			if json.Unmarshal(b, &c) == nil && time.Since(c.Fetched) < completionCacheTTL {
+				return c.Values, nil
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	client := ec2.NewFromConfig(cfg)
+	var values []completionValue
+	switch kind {
+	case "instances":
+		p := ec2.NewDescribeInstancesPaginator(client, &ec2.DescribeInstancesInput{
+			Filters: []types.Filter{{Name: aws.String("instance-state-name"), Values: []string{"running", "stopped"}}},
+		})
+		for p.HasMorePages() {
+			page, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range page.Reservations {
+				for _, i := range r.Instances {
+					values = append(values, completionValue{Value: *i.InstanceId, Description: tagValue(i.Tags, "Name")})
+				}
+			}
+		}
+	case "images":
+		p := ec2.NewDescribeImagesPaginator(client, &ec2.DescribeImagesInput{Owners: []string{"self"}})
+		for p.HasMorePages() {
+			page, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, i := range page.Images {
+				values = append(values, completionValue{Value: *i.ImageId, Description: aws.ToString(i.Name)})
+			}
+		}
+	case "launch-templates":
+		p := ec2.NewDescribeLaunchTemplatesPaginator(client, &ec2.DescribeLaunchTemplatesInput{})
+		for p.HasMorePages() {
+			page, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, lt := range page.LaunchTemplates {
+				values = append(values,
+					completionValue{Value: *lt.LaunchTemplateId, Description: aws.ToString(lt.LaunchTemplateName)},
+					completionValue{Value: aws.ToString(lt.LaunchTemplateName), Description: *lt.LaunchTemplateId})
+			}
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Value < values[j].Value })
+
+	if path != "" {
+		if b, err := json.Marshal(completionCache{Fetched: time.Now(), Values: values}); err == nil {
+			if os.MkdirAll(filepath.Dir(path), 0o700) == nil {
+				os.WriteFile(path, b, 0o600)
+			}
+		}
+	}
+	return values, nil
+}
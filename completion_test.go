@@ -0,0 +1,24 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSubcommands(t *testing.T) {
+	got := subcommands()
+	if !slices.IsSorted(got) {
+		t.Errorf("subcommands are not sorted: %v", got)
+	}
+	for _, name := range []string{"create", "list", "copy", "delete", "wait", "completion"} {
+		if !slices.Contains(got, name) {
+			t.Errorf("subcommands lack %s", name)
+		}
+	}
+	if slices.Contains(got, "__complete") {
+		t.Error("subcommands offer the internal __complete")
+	}
+	if len(got) != len(commands)-1 {
+		t.Errorf("got %d subcommands of %d commands", len(got), len(commands))
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"sigs.k8s.io/yaml"
+)
+
+// fileConfig is the content of the amimati configuration file.
+type fileConfig struct {
+	Pipeline *pipelineConfig `json:"pipeline"`
+	Naming   *namingConfig   `json:"naming"`
+	// TagPolicy is checked against the tags of the images created.
+	TagPolicy *tagPolicyConfig `json:"tagPolicy"`
+	// Schedule restricts when the daemon may start runs.
+	Schedule *scheduleConfig `json:"schedule"`
+	// Profiles are the named defaults of create and prune.
+	Profiles map[string]*configProfile `json:"profiles"`
+}
+
+func loadConfigFile(path string) (*fileConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening config file: %w", err)
+	}
+	defer f.Close()
+	return decodeConfig(f, path)
+}
+
+// decodeConfig parses the configuration read from r, in YAML or JSON, named
+// by source in errors.
+func decodeConfig(r io.Reader, source string) (*fileConfig, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config %s: %w", source, err)
+	}
+	if b, err = yaml.YAMLToJSON(b); err != nil {
+		return nil, fmt.Errorf("error parsing config %s: %w", source, err)
+	}
+	var c fileConfig
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("error parsing config %s: %w", source, err)
+	}
+	return &c, nil
+}
+
+// tagMap converts a key/value map from a config file to tags, ordered by key.
+func tagMap(m map[string]string) tags {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	t := make(tags, 0, len(m))
+	for _, k := range keys {
+		key, val := k, m[k]
+		t = append(t, types.Tag{Key: &key, Value: &val})
+	}
+	return t
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// defaultConfigFiles are looked for, in order, when create or prune is given
+// no -config.
+var defaultConfigFiles = []string{"amimati.yaml", "amimati.yml", "amimati.json"}
+
+// defaultConfigFile returns the first of defaultConfigFiles that exists, or
+// an empty string if none does.
+func defaultConfigFile() string {
+	for _, path := range defaultConfigFiles {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// configProfile holds defaults for the flags of create and prune, selected
+// with -profile-name. Flags given on the command line take precedence.
+type configProfile struct {
+	Name          string            `json:"name"`
+	Description   string            `json:"description"`
+	Tags          map[string]string `json:"tags"`
+	ImageTags     map[string]string `json:"imageTags"`
+	SnapshotTags  map[string]string `json:"snapshotTags"`
+	ExpireAfter   string            `json:"expireAfter"`
+	Region        string            `json:"region"`
+	CopyToRegions []string          `json:"copyToRegions"`
+	ShareWith     []string          `json:"shareWith"`
+	// Notify are -notify targets such as "slack:https://hooks.slack.com/...".
+	Notify []string `json:"notify"`
+	// Retention is the retention policy applied by prune.
+	Retention *retentionConfig `json:"retention"`
+}
+
+type retentionConfig struct {
+	NamePrefix string            `json:"namePrefix"`
+	Tags       map[string]string `json:"tags"`
+	KeepLast   int               `json:"keepLast"`
+	OlderThan  string            `json:"olderThan"`
+}
+
+// profileFlag is a value a profile gives to a flag.
+type profileFlag struct {
+	name, value string
+}
+
+// flags returns the flag values of the profile for the command.
+func (p *configProfile) flags(command string) []profileFlag {
+	var f []profileFlag
+	add := func(name, value string) {
+		if value != "" {
+			f = append(f, profileFlag{name, value})
+		}
+	}
+	add("region", p.Region)
+	switch command {
+	case "create":
+		add("name", p.Name)
+		add("description", p.Description)
+		for _, t := range tagMap(p.Tags) {
+			add("tag", formatTags([]types.Tag{t}))
+		}
+		for _, t := range tagMap(p.ImageTags) {
+			add("image-tag", formatTags([]types.Tag{t}))
+		}
+		for _, t := range tagMap(p.SnapshotTags) {
+			add("snapshot-tag", formatTags([]types.Tag{t}))
+		}
+		add("expire-after", p.ExpireAfter)
+		for _, r := range p.CopyToRegions {
+			add("copy-to-region", r)
+		}
+		for _, a := range p.ShareWith {
+			add("share-with", a)
+		}
+		for _, n := range p.Notify {
+			add("notify", n)
+		}
+	case "prune":
+		if r := p.Retention; r != nil {
+			add("name-prefix", r.NamePrefix)
+			for _, t := range tagMap(r.Tags) {
+				add("tag", formatTags([]types.Tag{t}))
+			}
+			if r.KeepLast > 0 {
+				add("keep-last", strconv.Itoa(r.KeepLast))
+			}
+			add("older-than", r.OlderThan)
+		}
+	}
+	return f
+}
+
+// applyConfigProfile sets the flags of fs that were not given on the command
+// line to the values of the named profile of the config.
+func applyConfigProfile(fs *flag.FlagSet, c *fileConfig, name string) error {
+	if name == "" {
+		return nil
+	}
+	if c == nil {
+		return errors.New("-profile-name requires a config file")
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		return fmt.Errorf("profile %s not found in the config file", name)
+	}
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, f := range p.flags(fs.Name()) {
+		if given[f.name] {
+			continue
+		}
+		if err := fs.Set(f.name, f.value); err != nil {
+			return fmt.Errorf("profile %s: invalid %s: %w", name, f.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// profileTags are tag values that the key:value form of earlier versions
+// would split or reject.
+var profileTags = map[string]string{
+	"url":        "https://example.com/?a=b",
+	"team:owner": "alice",
+	"note":       "a, b",
+	"quoted":     `"x"`,
+}
+
+func TestConfigProfileTagsRoundTrip(t *testing.T) {
+	c := &fileConfig{Profiles: map[string]*configProfile{"prod": {
+		Tags:         profileTags,
+		ImageTags:    profileTags,
+		SnapshotTags: profileTags,
+		Retention:    &retentionConfig{NamePrefix: "web-", Tags: profileTags, KeepLast: 1},
+	}}}
+
+	var commonTags, imageTags, snapshotTags tags
+	create := flag.NewFlagSet("create", flag.ContinueOnError)
+	create.Var(&commonTags, "tag", "")
+	create.Var(&imageTags, "image-tag", "")
+	create.Var(&snapshotTags, "snapshot-tag", "")
+	if err := applyConfigProfile(create, c, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	var retentionTags filterTags
+	var namePrefix string
+	var keepLast int
+	prune := flag.NewFlagSet("prune", flag.ContinueOnError)
+	prune.Var(&retentionTags, "tag", "")
+	prune.StringVar(&namePrefix, "name-prefix", "", "")
+	prune.IntVar(&keepLast, "keep-last", 0, "")
+	if err := applyConfigProfile(prune, c, "prod"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, got := range map[string][]types.Tag{"create -tag": commonTags, "-image-tag": imageTags, "-snapshot-tag": snapshotTags, "prune -tag": retentionTags} {
+		m := map[string]string{}
+		for _, tag := range got {
+			m[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+		if !reflect.DeepEqual(m, profileTags) {
+			t.Errorf("%s: got %v, want %v", name, m, profileTags)
+		}
+	}
+}
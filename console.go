@@ -0,0 +1,40 @@
+package main
+
+import "fmt"
+
+// consoleDomains maps partitions to the domain of their AWS console. The
+// isolated partitions have no public console and get no links.
+var consoleDomains = map[string]string{
+	"aws":        "console.aws.amazon.com",
+	"aws-cn":     "console.amazonaws.cn",
+	"aws-us-gov": "console.amazonaws-us-gov.com",
+}
+
+// consoleURL returns the EC2 console URL of the page in the region, or an
+// empty string when the partition of the region has no known console.
+func consoleURL(region, page string) string {
+	domain, ok := consoleDomains[partitionFor(region)]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("https://%s.%s/ec2/home?region=%s#%s", region, domain, region, page)
+}
+
+func imageConsoleURL(region, imageID string) string {
+	return consoleURL(region, "ImageDetails:imageId="+imageID)
+}
+
+func snapshotConsoleURL(region, snapshotID string) string {
+	return consoleURL(region, "SnapshotDetails:snapshotId="+snapshotID)
+}
+
+// imageConsoleURLs returns the console URLs of the images keyed by region.
+func imageConsoleURLs(images map[string]string) map[string]string {
+	urls := map[string]string{}
+	for region, id := range images {
+		if u := imageConsoleURL(region, id); u != "" {
+			urls[region] = u
+		}
+	}
+	return urls
+}
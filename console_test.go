@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestImageConsoleURL(t *testing.T) {
+	for _, tt := range []struct {
+		region, want string
+	}{
+		{"us-east-1", "https://us-east-1.console.aws.amazon.com/ec2/home?region=us-east-1#ImageDetails:imageId=ami-1"},
+		{"us-gov-west-1", "https://us-gov-west-1.console.amazonaws-us-gov.com/ec2/home?region=us-gov-west-1#ImageDetails:imageId=ami-1"},
+		{"cn-north-1", "https://cn-north-1.console.amazonaws.cn/ec2/home?region=cn-north-1#ImageDetails:imageId=ami-1"},
+		// The isolated partitions have no public console.
+		{"us-iso-east-1", ""},
+		{"us-isob-east-1", ""},
+		{"eu-isoe-west-1", ""},
+		{"us-isof-south-1", ""},
+	} {
+		if got := imageConsoleURL(tt.region, "ami-1"); got != tt.want {
+			t.Errorf("imageConsoleURL(%q) = %q, want %q", tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestImageConsoleURLs(t *testing.T) {
+	got := imageConsoleURLs(map[string]string{"eu-west-1": "ami-1", "us-iso-east-1": "ami-2"})
+	if len(got) != 1 || got["eu-west-1"] == "" {
+		t.Errorf("imageConsoleURLs = %v, want only the eu-west-1 link", got)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+type copyRegion struct {
+	Region     string
+	ImageId    string `json:",omitempty"`
+	ConsoleUrl string `json:",omitempty"`
+	Error      string `json:",omitempty"`
+}
+
+type copyReport struct {
+	SourceImageId string
+	Copies        []copyRegion
+}
+
+func runCopy(args []string) {
+	var imageID, tpmSupport, query string
+	var regions list
+	var verbose, dryRun, noProgress, failFast bool
+	var maxParallel int
+	var restoreDays restoreArchivedFlag
+	fs := flag.NewFlagSet("copy", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.StringVar(&imageID, "image-id", "", "source image ID")
+	fs.Var(&regions, "regions", "regions to copy the image into(eg. us-west-2,eu-west-1)")
+	fs.StringVar(&tpmSupport, "tpm-support", "", "re-register the copies that lack NitroTPM support(v2.0)")
+	fs.Var(&restoreDays, "restore-archived", "temporarily restore snapshots of the image in the archive tier before copying, for 1 day or the given number of days(eg. -restore-archived=7)")
+	fs.IntVar(&maxParallel, "max-parallel-copies", 0, "copy into at most this many regions at a time(default: all of them)")
+	fs.BoolVar(&failFast, "fail-fast", false, "cancel the other copies once a region has failed, instead of carrying on with them")
+	fs.BoolVar(&noProgress, "no-progress", false, "do not draw the live status of the copies on an interactive terminal")
+	fs.BoolVar(&dryRun, "dry-run", false, "only check that CopyImage is permitted in each region")
+	fs.BoolVar(&verbose, "v", false, "verbose output")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. Copies[].ImageId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	if imageID == "" {
+		fatal("image ID is required")
+	}
+	if len(regions) == 0 {
+		fatal("regions are required")
+	}
+	if maxParallel < 0 {
+		fatal("max parallel copies must not be negative")
+	}
+
+	ctx := context.Background()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+
+	client := ec2.NewFromConfig(cfg)
+	if dryRun {
+		report, err := dryRunCopy(ctx, cfg, imageID, regions)
+		if err != nil {
+			fatal(err)
+		}
+		printResult(report, query)
+		if !report.Permitted {
+			os.Exit(1)
+		}
+		return
+	}
+	source, err := waitImageAvailable(ctx, client, imageID, verbose)
+	if err != nil {
+		fatal(err)
+	}
+	if err := restoreArchivedImage(ctx, client, source, int32(restoreDays), verbose); err != nil {
+		fatal(err)
+	}
+	copies, err := copyImage(ctx, cfg, source, regions, copyOptions{attrs: imageAttributes{tpmSupport: types.TpmSupportValues(tpmSupport)}, verbose: verbose, maxParallel: maxParallel, failFast: failFast, bars: drawProgressBars(noProgress)})
+	var errs regionErrors
+	if err != nil && !errors.As(err, &errs) {
+		fatal(err)
+	}
+
+	report := copyReport{SourceImageId: imageID}
+	for _, region := range regions {
+		if id, ok := copies[region]; ok {
+			report.Copies = append(report.Copies, copyRegion{Region: region, ImageId: id, ConsoleUrl: imageConsoleURL(region, id)})
+		} else {
+			report.Copies = append(report.Copies, copyRegion{Region: region, Error: errs[region].Error()})
+		}
+	}
+	printResult(report, query)
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
+
+// regionalClient returns an EC2 client for the given region.
+func regionalClient(cfg aws.Config, region string) *ec2.Client {
+	return ec2.NewFromConfig(cfg, func(o *ec2.Options) { o.Region = region })
+}
+
+// copyAttempts is how many times the copy into a region is attempted before
+// the region is given up on.
+const copyAttempts = 3
+
+// copyOptions are the options of copyImage.
+type copyOptions struct {
+	// attrs are the attributes the copies are given, defaulting to those of
+	// the image.
+	attrs   imageAttributes
+	verbose bool
+	// maxParallel bounds the copies in flight, all of them at once if 0.
+	maxParallel int
+	// failFast cancels the other copies once one has failed.
+	failFast bool
+	// bars draws the live status of the copies.
+	bars bool
+}
+
+// copyImage copies the image into each region concurrently and waits for the
+// copies to become available. A failed copy is deregistered and attempted
+// again on its own, up to copyAttempts times. Copies are given the boot mode,
+// TPM, ENA and IMDS support of the attributes of opt, or else of the image,
+// when they lack them. As CopyImage keeps neither tags nor sharing, the tags
+// of the image and of its snapshots, and its launch and create volume
+// permissions, are then applied to each copy. It returns the IDs of the
+// copies that succeeded keyed by region, along with a regionErrors for those
+// that did not.
+func copyImage(ctx context.Context, cfg aws.Config, image types.Image, regions []string, opt copyOptions) (map[string]string, error) {
+	if err := checkSamePartition(cfg.Region, regions); err != nil {
+		return nil, err
+	}
+	attrs := opt.attrs.withDefaults(imageAttributesOf(image))
+	sourceClient := ec2.NewFromConfig(cfg)
+	sourceSnapshots, err := describeImageSnapshots(ctx, sourceClient, image)
+	if err != nil {
+		return nil, err
+	}
+	// Only the owner of the image can describe who it is shared with.
+	sharing, err := describeSharing(ctx, sourceClient, *image.ImageId, sourceSnapshots)
+	if err != nil {
+		warnf("copying image %s without its sharing: %v", *image.ImageId, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var status *copyStatus
+	if opt.bars {
+		status = newCopyStatus(regions)
+	}
+	sem := make(chan struct{}, len(regions))
+	if opt.maxParallel > 0 {
+		sem = make(chan struct{}, opt.maxParallel)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := regionErrors{}
+	copies := map[string]string{}
+	for _, region := range regions {
+		wg.Add(1)
+		go func(region string) {
+			defer wg.Done()
+			var copied types.Image
+			var err error
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				client := regionalClient(cfg, region)
+				copied, err = copyIntoRegion(ctx, client, cfg.Region, region, image, status, opt.verbose)
+				if err == nil {
+					copied, err = applyImageAttributes(ctx, client, copied, attrs, opt.verbose)
+				}
+				if err == nil {
+					err = retagCopy(ctx, client, image, sourceSnapshots, *copied.ImageId)
+				}
+				if err == nil {
+					err = sharing.apply(ctx, client, copied)
+				}
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				status.set(region, copyFailed, err.Error())
+				errs[region] = fmt.Errorf("error copying image to %s: %w", region, err)
+				if opt.failFast {
+					cancel()
+				}
+				return
+			}
+			status.set(region, copyAvailable, *copied.ImageId)
+			copies[region] = *copied.ImageId
+		}(region)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return copies, errs
+	}
+	return copies, nil
+}
+
+// copyAPI is the part of the EC2 client used to copy an image into a region
+// and wait for the copy. *ec2.Client implements it, and so does the fake of
+// package amimatitest.
+type copyAPI interface {
+	CopyImage(ctx context.Context, params *ec2.CopyImageInput, optFns ...func(*ec2.Options)) (*ec2.CopyImageOutput, error)
+	DeregisterImage(ctx context.Context, params *ec2.DeregisterImageInput, optFns ...func(*ec2.Options)) (*ec2.DeregisterImageOutput, error)
+	ec2.DescribeImagesAPIClient
+	ec2.DescribeSnapshotsAPIClient
+}
+
+// copyIntoRegion copies the image from the source region into the region of
+// the client and waits for the copy to become available, attempting it again
+// when it fails.
+func copyIntoRegion(ctx context.Context, client copyAPI, sourceRegion, region string, image types.Image, status *copyStatus, verbose bool) (types.Image, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		status.set(region, copyCopying, "")
+		var out *ec2.CopyImageOutput
+		out, err = client.CopyImage(ctx, &ec2.CopyImageInput{
+			Name:          image.Name,
+			Description:   image.Description,
+			SourceImageId: image.ImageId,
+			SourceRegion:  aws.String(sourceRegion),
+		})
+		if err == nil {
+			var copied types.Image
+			if copied, err = waitCopyAvailable(ctx, client, region, *out.ImageId, status, verbose); err == nil {
+				return copied, nil
+			}
+			// A failed copy would keep the name from being copied again.
+			if _, derr := client.DeregisterImage(ctx, &ec2.DeregisterImageInput{ImageId: out.ImageId}); derr != nil && ctx.Err() == nil {
+				warnf("error deregistering failed copy %s in %s: %v", *out.ImageId, region, derr)
+			}
+		}
+		if ctx.Err() != nil || attempt == copyAttempts {
+			return types.Image{}, err
+		}
+		status.set(region, copyRetrying, fmt.Sprintf("attempt %d of %d: %v", attempt+1, copyAttempts, err))
+		warnf("copy of image %s to %s failed, retrying: %v", *image.ImageId, region, err)
+		if err := sleepPoll(ctx); err != nil {
+			return types.Image{}, err
+		}
+	}
+}
+
+// regionErrors holds the errors of an operation run in several regions.
+type regionErrors map[string]error
+
+func (e regionErrors) Error() string {
+	regions := make([]string, 0, len(e))
+	for r := range e {
+		regions = append(regions, r)
+	}
+	sort.Strings(regions)
+	msgs := make([]string, 0, len(e))
+	for _, r := range regions {
+		msgs = append(msgs, e[r].Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// sourceImageTagKey records the image a copy was made from.
+const sourceImageTagKey = "amimati:source-image-id"
+
+// findCopy returns the image in the client's region that is a copy of the
+// source image, matched by its source image ID, lineage tag or name.
+func findCopy(ctx context.Context, client *ec2.Client, source types.Image) (*types.Image, error) {
+	for _, f := range []types.Filter{
+		{Name: aws.String("tag:" + sourceImageTagKey), Values: []string{*source.ImageId}},
+		{Name: aws.String("name"), Values: []string{aws.ToString(source.Name)}},
+	} {
+		out, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{Owners: []string{"self"}, Filters: []types.Filter{f}})
+		if err != nil {
+			return nil, fmt.Errorf("error describing images: %w", err)
+		}
+		for i, image := range out.Images {
+			switch image.State {
+			case types.ImageStatePending, types.ImageStateAvailable:
+				return &out.Images[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// retagCopy applies the tags of the source image and of its snapshots to the
+// copy and the snapshots of the same devices.
+func retagCopy(ctx context.Context, client *ec2.Client, source types.Image, sourceSnapshots []deviceSnapshot, copyID string) error {
+	imageTags := append(userTags(source.Tags), types.Tag{Key: aws.String(sourceImageTagKey), Value: source.ImageId})
+	if _, err := client.CreateTags(ctx, &ec2.CreateTagsInput{Resources: []string{copyID}, Tags: imageTags}); err != nil {
+		return fmt.Errorf("error tagging image %s: %w", copyID, err)
+	}
+
+	out, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: []string{copyID}})
+	if err != nil {
+		return fmt.Errorf("error describing image %s: %w", copyID, err)
+	}
+	if len(out.Images) == 0 {
+		return fmt.Errorf("image %s not found", copyID)
+	}
+	snapshots := map[string]string{}
+	for _, bdm := range out.Images[0].BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+			snapshots[aws.ToString(bdm.DeviceName)] = *bdm.Ebs.SnapshotId
+		}
+	}
+	for _, ds := range sourceSnapshots {
+		id, ok := snapshots[ds.deviceName]
+		t := userTags(ds.snapshot.Tags)
+		if !ok || len(t) == 0 {
+			continue
+		}
+		if _, err := client.CreateTags(ctx, &ec2.CreateTagsInput{Resources: []string{id}, Tags: t}); err != nil {
+			return fmt.Errorf("error tagging snapshot %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// userTags drops the tags with the reserved aws: prefix, which cannot be set.
+func userTags(ts []types.Tag) []types.Tag {
+	var u []types.Tag
+	for _, t := range ts {
+		if !strings.HasPrefix(aws.ToString(t.Key), "aws:") {
+			u = append(u, t)
+		}
+	}
+	return u
+}
+
+// tagValue returns the value of the tag with the key, or an empty string.
+func tagValue(ts []types.Tag, key string) string {
+	for _, t := range ts {
+		if aws.ToString(t.Key) == key {
+			return aws.ToString(t.Value)
+		}
+	}
+	return ""
+}
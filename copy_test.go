@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/otama-jaccy/amimati/pkg/amimati/amimatitest"
+)
+
+func testContext(t *testing.T) context.Context {
+	old := pollInterval
+	pollInterval = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(func() {
+		cancel()
+		pollInterval = old
+	})
+	return ctx
+}
+
+// availableImage creates an image on the fake and waits for it.
+func availableImage(t *testing.T, ctx context.Context, fake *amimatitest.FakeEC2) types.Image {
+	t.Helper()
+	out, err := fake.CreateImage(ctx, &ec2.CreateImageInput{InstanceId: aws.String("i-1"), Name: aws.String("web")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := waitImageAvailable(ctx, fake, *out.ImageId, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image
+}
+
+func TestWaitImageAvailable(t *testing.T) {
+	ctx := testContext(t)
+	fake := &amimatitest.FakeEC2{SnapshotPolls: 2, CompletePolls: 3}
+	image := availableImage(t, ctx, fake)
+	if image.State != types.ImageStateAvailable {
+		t.Fatalf("image is %s, want available", image.State)
+	}
+}
+
+func TestWaitImageAvailableFailed(t *testing.T) {
+	ctx := testContext(t)
+	fake := &amimatitest.FakeEC2{CompletePolls: 1, FailSnapshots: true}
+	out, err := fake.CreateImage(ctx, &ec2.CreateImageInput{InstanceId: aws.String("i-1"), Name: aws.String("web")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := waitImageAvailable(ctx, fake, *out.ImageId, false); err == nil {
+		t.Fatal("got no error for a failed image")
+	}
+}
+
+func TestCopyIntoRegion(t *testing.T) {
+	ctx := testContext(t)
+	fake := &amimatitest.FakeEC2{CompletePolls: 2}
+	image := availableImage(t, ctx, fake)
+	status := newCopyStatus([]string{"eu-west-1"})
+	copied, err := copyIntoRegion(ctx, fake, "us-east-1", "eu-west-1", image, status, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied.State != types.ImageStateAvailable || *copied.ImageId == *image.ImageId {
+		t.Fatalf("got copy %s in state %s, want a new available image", *copied.ImageId, copied.State)
+	}
+}
+
+func TestCopyIntoRegionRetries(t *testing.T) {
+	ctx := testContext(t)
+	fake := &amimatitest.FakeEC2{Errors: map[string][]error{"CopyImage": {errors.New("throttled")}}}
+	image := availableImage(t, ctx, fake)
+	if _, err := copyIntoRegion(ctx, fake, "us-east-1", "eu-west-1", image, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.Calls("CopyImage"); n != 2 {
+		t.Errorf("got %d CopyImage calls, want 2", n)
+	}
+}
+
+func TestCopyIntoRegionDeregistersFailedCopies(t *testing.T) {
+	ctx := testContext(t)
+	fake := &amimatitest.FakeEC2{}
+	image := availableImage(t, ctx, fake)
+	fake.FailSnapshots = true
+	if _, err := copyIntoRegion(ctx, fake, "us-east-1", "eu-west-1", image, nil, false); err == nil {
+		t.Fatal("got no error for failing copies")
+	}
+	if n := fake.Calls("CopyImage"); n != copyAttempts {
+		t.Errorf("got %d CopyImage calls, want %d", n, copyAttempts)
+	}
+	if n := fake.Calls("DeregisterImage"); n != copyAttempts {
+		t.Errorf("got %d failed copies deregistered, want %d", n, copyAttempts)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Copy states shown by copyStatus.
+const (
+	copyQueued    = "queued"
+	copyCopying   = "copying"
+	copyRetrying  = "retrying"
+	copyAvailable = "available"
+	copyFailed    = "failed"
+)
+
+// copyStatus draws a live line per region of a copy on stderr with its state,
+// the progress of its snapshots and its elapsed time, redrawn in place as the
+// copies are polled. A nil copyStatus draws nothing.
+type copyStatus struct {
+	bars *progressBars
+
+	mu   sync.Mutex
+	rows map[string]*copyRow
+}
+
+type copyRow struct {
+	state   string
+	detail  string
+	pct     float64
+	started time.Time
+	elapsed time.Duration
+}
+
+// newCopyStatus starts drawing the copies into the regions, all queued.
+func newCopyStatus(regions []string) *copyStatus {
+	s := &copyStatus{bars: newProgressBars(), rows: map[string]*copyRow{}}
+	for _, region := range regions {
+		s.rows[region] = &copyRow{state: copyQueued}
+	}
+	for _, region := range regions {
+		s.update(region, func(*copyRow) {})
+	}
+	return s
+}
+
+// set sets the state of the copy into the region, with a detail such as the
+// ID of the copy or the error it failed with.
+func (s *copyStatus) set(region, state, detail string) {
+	s.update(region, func(r *copyRow) {
+		if r.started.IsZero() && state != copyQueued {
+			r.started = time.Now()
+		}
+		r.state, r.detail = state, detail
+		if state == copyAvailable {
+			r.pct = 100
+		}
+	})
+}
+
+// progress sets the progress of the snapshots of the copy into the region.
+func (s *copyStatus) progress(region string, pct float64) {
+	s.update(region, func(r *copyRow) { r.pct = pct })
+}
+
+func (s *copyStatus) update(region string, f func(*copyRow)) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.rows[region]
+	f(r)
+	if !r.started.IsZero() && r.state != copyAvailable && r.state != copyFailed {
+		r.elapsed = time.Since(r.started)
+	}
+	done := true
+	for _, r := range s.rows {
+		if r.state != copyAvailable && r.state != copyFailed {
+			done = false
+		}
+	}
+	line := fmt.Sprintf("%-15s %s  %-9s", region, progressBar(r.pct), r.state)
+	if r.elapsed > 0 {
+		line += fmt.Sprintf("  %s elapsed", r.elapsed.Round(time.Second))
+	}
+	if r.detail != "" {
+		line += "  " + r.detail
+	}
+	s.bars.setLine(region, line, done)
+}
+
+// waitCopyAvailable is waitImageAvailable for a copy into the region,
+// reporting the progress of its snapshots to the status.
+func waitCopyAvailable(ctx context.Context, client copyAPI, region, imageID string, status *copyStatus, verbose bool) (types.Image, error) {
+	if status == nil {
+		return waitImageAvailable(ctx, client, imageID, verbose)
+	}
+	for {
+		out, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: []string{imageID}})
+		if err != nil {
+			return types.Image{}, fmt.Errorf("error describing image %s: %w", imageID, err)
+		}
+		if len(out.Images) == 0 {
+			return types.Image{}, fmt.Errorf("image %s not found", imageID)
+		}
+		image := out.Images[0]
+		if image.State != types.ImageStatePending {
+			// Reports the state of an image that did not become available.
+			return waitImageAvailable(ctx, client, imageID, verbose)
+		}
+		if pct, ok := copyProgress(ctx, client, image); ok {
+			status.progress(region, pct)
+		}
+		if err := sleepPoll(ctx); err != nil {
+			return types.Image{}, err
+		}
+	}
+}
+
+// copyProgress returns the mean progress of the snapshots of the pending
+// copy, once they are known.
+func copyProgress(ctx context.Context, client ec2.DescribeSnapshotsAPIClient, image types.Image) (float64, bool) {
+	var ids []string
+	for _, bdm := range image.BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+			ids = append(ids, *bdm.Ebs.SnapshotId)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, false
+	}
+	out, err := client.DescribeSnapshots(ctx, &ec2.DescribeSnapshotsInput{SnapshotIds: ids})
+	if err != nil || len(out.Snapshots) == 0 {
+		return 0, false
+	}
+	var total float64
+	for _, s := range out.Snapshots {
+		pct, _ := strconv.ParseFloat(strings.TrimSuffix(aws.ToString(s.Progress), "%"), 64)
+		if s.State == types.SnapshotStateCompleted {
+			pct = 100
+		}
+		total += pct
+	}
+	return total / float64(len(out.Snapshots)), true
+}
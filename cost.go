@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// snapshotPrices are the list prices of standard EBS snapshot storage, in
+// USD per GiB-month, by region. Regions missing from the table are estimated
+// at defaultSnapshotPrice.
+var snapshotPrices = map[string]float64{
+	"us-east-1":      0.05,
+	"us-east-2":      0.05,
+	"us-west-1":      0.055,
+	"us-west-2":      0.05,
+	"ca-central-1":   0.055,
+	"sa-east-1":      0.068,
+	"eu-west-1":      0.05,
+	"eu-west-2":      0.053,
+	"eu-west-3":      0.053,
+	"eu-central-1":   0.054,
+	"eu-north-1":     0.0475,
+	"eu-south-1":     0.053,
+	"ap-south-1":     0.05,
+	"ap-northeast-1": 0.05,
+	"ap-northeast-2": 0.05,
+	"ap-northeast-3": 0.05,
+	"ap-southeast-1": 0.05,
+	"ap-southeast-2": 0.055,
+	"me-south-1":     0.055,
+	"af-south-1":     0.06,
+}
+
+const (
+	defaultSnapshotPrice = 0.05
+	// archiveSnapshotPriceRatio is the price of the archive tier relative to
+	// the standard tier.
+	archiveSnapshotPriceRatio = 0.25
+)
+
+// costEstimator estimates the monthly cost of storing the snapshots of
+// images.
+type costEstimator struct {
+	// price overrides the price per GiB-month of the standard tier in every
+	// region, unless zero.
+	price float64
+}
+
+// monthly estimates the monthly cost in USD of the snapshots of the image in
+// the region, with the storage tiers of the snapshots by ID. Snapshots are
+// priced at the size of their volume: as they are incremental, the actual
+// cost is usually lower, and it is an upper bound for an image sharing no
+// blocks with other snapshots.
+func (c *costEstimator) monthly(region string, image types.Image, tiers map[string]types.StorageTier) float64 {
+	var cost float64
+	for _, bdm := range image.BlockDeviceMappings {
+		if bdm.Ebs == nil || bdm.Ebs.SnapshotId == nil {
+			continue
+		}
+		cost += float64(aws.ToInt32(bdm.Ebs.VolumeSize)) * c.perGiB(region, tiers[*bdm.Ebs.SnapshotId])
+	}
+	return math.Round(cost*100) / 100
+}
+
+// perGiB returns the price in USD per GiB-month of snapshots in the region
+// and storage tier.
+func (c *costEstimator) perGiB(region string, tier types.StorageTier) float64 {
+	price := c.price
+	if price == 0 {
+		var ok bool
+		if price, ok = snapshotPrices[region]; !ok {
+			price = defaultSnapshotPrice
+		}
+	}
+	if tier == types.StorageTierArchive {
+		price *= archiveSnapshotPriceRatio
+	}
+	return price
+}
+
+// snapshotTiers returns the storage tier of each snapshot owned by the
+// account in the client's region.
+func snapshotTiers(ctx context.Context, client *ec2.Client) (map[string]types.StorageTier, error) {
+	tiers := map[string]types.StorageTier{}
+	p := ec2.NewDescribeSnapshotsPaginator(client, &ec2.DescribeSnapshotsInput{OwnerIds: []string{"self"}})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error describing snapshots: %w", err)
+		}
+		for _, s := range out.Snapshots {
+			tiers[*s.SnapshotId] = s.StorageTier
+		}
+	}
+	return tiers, nil
+}
@@ -0,0 +1,789 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+
+	"github.com/otama-jaccy/amimati/pkg/amimati"
+)
+
+type result struct {
+	types.Image
+	RunId              string `json:",omitempty"`
+	ImageArn           string
+	ConsoleUrl         string `json:",omitempty"`
+	SnapshotEncryption []snapshotEncryption
+	SourceInstance     *sourceInstance
+	Canary             *canaryResult     `json:",omitempty"`
+	Inventory          *inventory        `json:",omitempty"`
+	Verify             *verifyResult     `json:",omitempty"`
+	Inspector          *inspectorResult  `json:",omitempty"`
+	Gate               *gateResult       `json:",omitempty"`
+	FastLaunch         *fastLaunchResult `json:",omitempty"`
+	// FastSnapshotRestore reports -enable-fsr.
+	FastSnapshotRestore *fsrResult   `json:",omitempty"`
+	SnapshotLocks       []lockResult `json:",omitempty"`
+	// ChangeReport reports the blocks changed since the previous snapshots
+	// of the volumes with -change-report.
+	ChangeReport []snapshotChange `json:",omitempty"`
+	// PendingSnapshotIds are the snapshots of the image with -wait=false,
+	// which are yet to complete.
+	PendingSnapshotIds []string `json:",omitempty"`
+	// UnencryptedImageId is the image of the instance encrypted with
+	// -kms-key-id, unless deleted with -delete-unencrypted.
+	UnencryptedImageId string `json:",omitempty"`
+	// Copies holds the IDs of the copies made with -copy-to-region by
+	// region.
+	Copies map[string]string `json:",omitempty"`
+	// SharedWith are the accounts, organizations and organizational units
+	// granted launch permission with -share-with and -share-with-org.
+	SharedWith []string `json:",omitempty"`
+	// SSMParameter is the parameter written with -ssm-parameter.
+	SSMParameter *ssmParameterResult `json:",omitempty"`
+	// LaunchTemplate is the launch template version created with
+	// -update-launch-template.
+	LaunchTemplate *launchTemplateUpdateResult `json:",omitempty"`
+	// Promotion reports the images -promote-tag was moved from.
+	Promotion *promotionResult `json:",omitempty"`
+	// InstanceRefresh is the instance refresh started with -refresh-asg.
+	InstanceRefresh *instanceRefreshResult `json:",omitempty"`
+	// Archive reports the snapshots moved to the archive tier with -archive.
+	Archive *archiveResult `json:",omitempty"`
+	// Hooks are the commands run on the instance with -pre-command and
+	// -post-command.
+	Hooks []hookResult `json:",omitempty"`
+
+	started, finished time.Time
+	timings           []deviceTiming
+}
+
+type options struct {
+	verbose bool
+	// progressBars draws live progress bars of the snapshots on stderr.
+	progressBars bool
+	instanceID   string
+	imageName    string
+	imageTags    tags
+	snapshotTags tags
+	expireAfter  string
+	bootMode     types.BootModeValues
+	// requireIMDSv2 makes instances launched from the image require IMDSv2.
+	requireIMDSv2 bool
+	tpmSupport    types.TpmSupportValues
+	// targetInstanceTypes are checked for the enhanced networking they need.
+	targetInstanceTypes []string
+	// allowMarketplace skips the check for AWS Marketplace product codes.
+	allowMarketplace bool
+	// adoptExisting waits for an existing image of the same name instead of
+	// failing on the duplicate name.
+	adoptExisting bool
+	// ssmInventory captures the SSM inventory of the instance before imaging.
+	ssmInventory bool
+	// naming is applied to imageName.
+	naming namingConfig
+	// tagPolicy is checked against the tags of the image before it is
+	// created.
+	tagPolicy tagPolicy
+	// snapshotLock, if set, is applied to the snapshots once completed.
+	snapshotLock snapshotLock
+	// changeReport compares the completed snapshots with the previous ones
+	// of their volumes.
+	changeReport bool
+	// mirrorImageTags applies the final tags of the image to its snapshots.
+	mirrorImageTags bool
+	// noReboot images the instance without shutting it down first.
+	noReboot bool
+	// instance selects the instance when instanceID is empty.
+	instance instanceSelector
+	// kmsKeyID, if set, makes the image an encrypted copy of the image of
+	// the instance.
+	kmsKeyID string
+	// deleteUnencrypted deletes the unencrypted image once copied.
+	deleteUnencrypted bool
+	// ifExists is the behavior when an image of the same name exists:
+	// ifExistsFail (the default), ifExistsSkip, ifExistsReplace or
+	// ifExistsSuffix.
+	ifExists string
+	// copyInstanceTags merges the tags of the instance starting with
+	// copyTagPrefix into the image and snapshot tags.
+	copyInstanceTags bool
+	copyTagPrefix    string
+	// lineage tags the image and snapshots with what they were built from;
+	// see lineageTags.
+	lineage bool
+	// purgeReplaced deletes the snapshots of an image replaced
+	// with ifExistsReplace.
+	purgeReplaced bool
+	// cleanupOnAbort deregisters the image being created and deletes its
+	// snapshots when the run is aborted by a signal.
+	cleanupOnAbort bool
+	// dryRun, if set, makes createImage only check whether CreateImage is
+	// permitted and fill dryRun in with the image it would create, returning
+	// a nil result.
+	dryRun *dryRunReport
+	// description is the description of the image; autoDescribe
+	// generates one from the source instance instead.
+	description  string
+	autoDescribe bool
+	// excludeDevices are left out of the image, and deviceMappings change
+	// the EBS mappings of the image.
+	excludeDevices []string
+	deviceMappings deviceMappings
+	// deviceSnapshotTags are the tags of the snapshots of single devices,
+	// applied once their IDs are known.
+	deviceSnapshotTags deviceTags
+	// deprecateAfter, if set, is a duration or timestamp after which the
+	// image is deprecated.
+	deprecateAfter string
+	// noWait returns the image, still pending, as soon as its snapshots have
+	// been created, for the caller to wait for them.
+	noWait bool
+	// preCommand and postCommand are run on the instance with SSM before
+	// the image is created and once its snapshots have been, within
+	// hookTimeout each.
+	preCommand  string
+	postCommand string
+	hookTimeout time.Duration
+	// vss creates the image of a Windows instance with vssDocument, for an
+	// application-consistent image, instead of CreateImage.
+	vss bool
+}
+
+// createImage creates an image of the instance and waits until its snapshot
+// has completed.
+func createImage(ctx context.Context, cfg aws.Config, opt options) (_ *result, err error) {
+	started := time.Now()
+	client := ec2.NewFromConfig(cfg)
+
+	// ownedID is the image this run created while its snapshots are yet to
+	// complete, cleaned up if the run is aborted with cleanupOnAbort.
+	var ownedID string
+	defer func() {
+		if err == nil || !opt.cleanupOnAbort || ownedID == "" || !aborted(ctx) {
+			return
+		}
+		deleted, cerr := cleanupAbortedImage(client, ownedID)
+		if cerr != nil {
+			warnf("cleaning up aborted image %s: %v", ownedID, cerr)
+			return
+		}
+		logf("deregistered aborted image %s and deleted its snapshots %v", ownedID, deleted)
+	}()
+
+	setPhase("describing instance")
+	if opt.instanceID == "" {
+		id, err := opt.instance.resolve(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		if opt.verbose {
+			logf("selected instance %s by %s", id, opt.instance)
+		}
+		opt.instanceID = id
+	}
+	instance, err := describeInstance(ctx, client, opt.instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if !opt.allowMarketplace {
+		if err := checkProductCodes(ctx, client, instance); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkEBSBacked(instance); err != nil {
+		return nil, err
+	}
+	if err := checkVolumes(ctx, client, instance); err != nil {
+		return nil, err
+	}
+	if opt.vss {
+		if err := checkVSS(instance, opt); err != nil {
+			return nil, err
+		}
+	}
+	mappings, err := imageMappings(instance, opt.excludeDevices, opt.deviceMappings)
+	if err != nil {
+		return nil, err
+	}
+	data := newTemplateData(instance, cfg.Region, started)
+	data.caller = lookupCaller(ctx, cfg)
+	if opt.imageName, err = data.expand(opt.imageName); err != nil {
+		return nil, err
+	}
+	if opt.description, err = data.expand(opt.description); err != nil {
+		return nil, err
+	}
+	if opt.autoDescribe {
+		opt.description = autoDescription(opt.instanceID, cfg.Region, started)
+	}
+	if opt.imageTags, err = data.expandTags(opt.imageTags); err != nil {
+		return nil, err
+	}
+	if opt.snapshotTags, err = data.expandTags(opt.snapshotTags); err != nil {
+		return nil, err
+	}
+	if err := opt.deviceSnapshotTags.check(instance, opt.excludeDevices); err != nil {
+		return nil, err
+	}
+	if opt.deviceSnapshotTags, err = opt.deviceSnapshotTags.expand(data); err != nil {
+		return nil, err
+	}
+	if opt.copyInstanceTags {
+		copied := instanceTags(instance, opt.copyTagPrefix)
+		opt.imageTags = mergeTags(copied, opt.imageTags)
+		opt.snapshotTags = mergeTags(copied, opt.snapshotTags)
+	}
+	if opt.imageName, err = opt.naming.apply(opt.imageName, cfg.Region, instance.Architecture); err != nil {
+		return nil, err
+	}
+
+	var inv *inventory
+	if opt.ssmInventory {
+		if inv, err = captureInventory(ctx, ssm.NewFromConfig(cfg), opt.instanceID); err != nil {
+			return nil, err
+		}
+		if inv == nil {
+			warnf("instance %s is not an online SSM managed node, no inventory captured", opt.instanceID)
+		}
+	}
+
+	imageTags := append(append(tags{}, opt.imageTags...), runIDTags()...)
+	snapshotTags := append(append(tags{}, opt.snapshotTags...), runIDTags()...)
+	if opt.lineage {
+		imageTags = mergeTags(imageTags, lineageTags(instance, started))
+		snapshotTags = mergeTags(snapshotTags, lineageTags(instance, started))
+	}
+	if opt.expireAfter != "" {
+		d, err := parseDuration(opt.expireAfter)
+		if err != nil {
+			return nil, err
+		}
+		key, val := expireAtTagKey, time.Now().Add(d).UTC().Format(time.RFC3339)
+		imageTags = append(imageTags, types.Tag{Key: &key, Value: &val})
+		snapshotTags = append(snapshotTags, types.Tag{Key: &key, Value: &val})
+	}
+
+	if err := opt.tagPolicy.check(imageTags); err != nil {
+		return nil, err
+	}
+
+	ts := make([]types.TagSpecification, 0, 2)
+	if len(imageTags) > 0 {
+		ts = append(ts, types.TagSpecification{ResourceType: types.ResourceTypeImage, Tags: imageTags})
+	}
+	if len(snapshotTags) > 0 {
+		ts = append(ts, types.TagSpecification{ResourceType: types.ResourceTypeSnapshot, Tags: snapshotTags})
+	}
+
+	// The image of an instance is encrypted by copying it, under the name,
+	// from an intermediate image named with a suffix.
+	createName := opt.imageName
+	if opt.kmsKeyID != "" {
+		createName += unencryptedSuffix
+	}
+
+	if opt.dryRun != nil {
+		var description *string
+		if opt.description != "" {
+			description = aws.String(opt.description)
+		}
+		_, err := client.CreateImage(ctx, &ec2.CreateImageInput{
+			DryRun:              aws.Bool(true),
+			Name:                aws.String(createName),
+			InstanceId:          aws.String(opt.instanceID),
+			NoReboot:            aws.Bool(opt.noReboot),
+			TagSpecifications:   ts,
+			Description:         description,
+			BlockDeviceMappings: mappings,
+		})
+		if err := opt.dryRun.check("CreateImage", opt.instanceID, err); err != nil {
+			return nil, err
+		}
+		opt.dryRun.InstanceId = opt.instanceID
+		opt.dryRun.Name = createName
+		opt.dryRun.Description = opt.description
+		opt.dryRun.ImageTags = imageTags
+		opt.dryRun.SnapshotTags = snapshotTags
+		return nil, nil
+	}
+
+	var imageID string
+	if opt.adoptExisting || opt.ifExists != "" && opt.ifExists != ifExistsFail {
+		existing, err := findImageByName(ctx, client, createName)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case existing == nil:
+		case opt.adoptExisting:
+			if err := checkAdoptable(*existing, opt.instanceID); err != nil {
+				return nil, err
+			}
+			imageID = *existing.ImageId
+			if opt.verbose {
+				logf("adopting existing image %s", imageID)
+			}
+			trace.state(imageID, "adopted", map[string]any{"instanceId": opt.instanceID})
+		case opt.ifExists == ifExistsSkip:
+			if err := checkSkippable(*existing); err != nil {
+				return nil, err
+			}
+			imageID = *existing.ImageId
+			if opt.verbose {
+				logf("image %s named %s exists, skipping creation", imageID, createName)
+			}
+			trace.state(imageID, "skipped", nil)
+		case opt.ifExists == ifExistsReplace:
+			if err := replaceImage(ctx, client, *existing, opt.purgeReplaced); err != nil {
+				return nil, err
+			}
+			if opt.verbose {
+				logf("deregistered image %s named %s to replace it", *existing.ImageId, createName)
+			}
+			trace.state(*existing.ImageId, "replaced", nil)
+		case opt.ifExists == ifExistsSuffix:
+			opt.imageName = suffixedName(opt.imageName, time.Now())
+			createName = opt.imageName
+			if opt.kmsKeyID != "" {
+				createName += unencryptedSuffix
+			}
+			if opt.verbose {
+				logf("an image named %s exists, naming the image %s", *existing.Name, opt.imageName)
+			}
+		}
+	}
+
+	// The post-command runs once, as soon as the snapshots have been created
+	// or creating the image failed, so the instance is not held in the state
+	// of the pre-command while the snapshots complete. It runs even when the
+	// run was aborted or timed out, to undo the pre-command.
+	var hooks []hookResult
+	var postErr error
+	postDone := opt.postCommand == "" || imageID != ""
+	runPost := func() {
+		if postDone {
+			return
+		}
+		postDone = true
+		h, err := runInstanceCommand(context.WithoutCancel(ctx), ssm.NewFromConfig(cfg), instance, "post-command", opt.postCommand, opt.hookTimeout)
+		if h != nil {
+			hooks = append(hooks, *h)
+		}
+		if err == nil && opt.verbose {
+			logf("ran post-command %s on %s", h.CommandId, opt.instanceID)
+		}
+		postErr = err
+	}
+
+	// The creator reports the progress of the snapshots on every poll.
+	progress := newSnapshotProgress(opt.verbose, opt.progressBars)
+	creator := &amimati.Creator{Client: client, PollInterval: pollInterval, Progress: func(image types.Image, snapshots []types.Snapshot) {
+		if imageID == "" {
+			imageID = *image.ImageId
+			ownedID = imageID
+			trace.state(imageID, "created", map[string]any{"instanceId": opt.instanceID})
+			runStatus.image(imageID)
+			runState.image(imageID)
+			runNotifier.image(imageID)
+		}
+		if snapshots != nil {
+			runPost()
+		}
+		progress.report(image, snapshots)
+	}}
+
+	if imageID == "" && opt.preCommand != "" {
+		setPhase("running pre-command")
+		h, err := runInstanceCommand(ctx, ssm.NewFromConfig(cfg), instance, "pre-command", opt.preCommand, opt.hookTimeout)
+		if h != nil {
+			hooks = append(hooks, *h)
+		}
+		if err != nil {
+			// The post-command undoes whatever part of the pre-command
+			// took effect.
+			runPost()
+			return nil, err
+		}
+		if opt.verbose {
+			logf("ran pre-command %s on %s", h.CommandId, opt.instanceID)
+		}
+	}
+
+	vssCreated := false
+	if opt.vss && imageID == "" {
+		setPhase("creating VSS image")
+		id, h, err := createVSSImage(ctx, cfg, client, opt.instanceID, createName, opt.description, imageTags)
+		if h != nil {
+			hooks = append(hooks, *h)
+		}
+		ownedID = id
+		if err != nil {
+			runPost()
+			return nil, err
+		}
+		if opt.verbose {
+			logf("ran %s %s on %s, creating image %s", vssDocument, h.CommandId, opt.instanceID, id)
+		}
+		trace.state(id, "created", map[string]any{"instanceId": opt.instanceID, "vss": true})
+		imageID = id
+		vssCreated = true
+	}
+
+	if opt.noWait && imageID == "" {
+		setPhase("creating image")
+		image, err := creator.Start(ctx, amimati.CreateRequest{
+			InstanceID:          opt.instanceID,
+			Name:                createName,
+			NoReboot:            opt.noReboot,
+			TagSpecifications:   ts,
+			Description:         opt.description,
+			BlockDeviceMappings: mappings,
+		})
+		runPost()
+		if err != nil {
+			return nil, err
+		}
+		if postErr != nil {
+			return nil, postErr
+		}
+		runStatus.image(*image.ImageId)
+		runState.image(*image.ImageId)
+		runNotifier.image(*image.ImageId)
+		if err := opt.deviceSnapshotTags.apply(ctx, client, image); err != nil {
+			return nil, err
+		}
+		pending, _ := amimati.MappedSnapshots(image)
+		return &result{
+			Image:              image,
+			RunId:              runID,
+			ImageArn:           ec2ARN(cfg.Region, "image/"+*image.ImageId),
+			ConsoleUrl:         imageConsoleURL(cfg.Region, *image.ImageId),
+			SourceInstance:     newSourceInstance(instance),
+			Inventory:          inv,
+			PendingSnapshotIds: pending,
+			Hooks:              hooks,
+			started:            started,
+			finished:           time.Now(),
+		}, nil
+	}
+
+	setPhase("waiting for snapshots")
+	var created *amimati.Result
+	if imageID == "" {
+		if opt.noReboot && opt.verbose {
+			logf("not rebooting %s: the image is only crash-consistent, writes still buffered in the instance may be missing", opt.instanceID)
+		}
+		created, err = creator.Create(ctx, amimati.CreateRequest{
+			InstanceID:          opt.instanceID,
+			Name:                createName,
+			NoReboot:            opt.noReboot,
+			TagSpecifications:   ts,
+			Description:         opt.description,
+			BlockDeviceMappings: mappings,
+		})
+	} else {
+		runStatus.image(imageID)
+		runState.image(imageID)
+		runNotifier.image(imageID)
+		created, err = creator.Wait(ctx, imageID)
+	}
+	runPost()
+	if err != nil {
+		return nil, err
+	}
+	if postErr != nil {
+		return nil, postErr
+	}
+	createdImage := created.Image
+	// The snapshots have completed: an abort from here on leaves the image
+	// in place.
+	ownedID = ""
+	if vssCreated {
+		if err := tagVSSSnapshots(ctx, client, createdImage, snapshotTags); err != nil {
+			return nil, err
+		}
+	}
+
+	setPhase("registering image")
+	if opt.bootMode != "" {
+		checkBootMode(instance, opt.bootMode, createdImage.Architecture)
+	} else {
+		checkBootMode(instance, createdImage.BootMode, createdImage.Architecture)
+	}
+	attrs := imageAttributes{bootMode: opt.bootMode, tpmSupport: opt.tpmSupport}.missingFrom(createdImage)
+	requireIMDSv2 := opt.requireIMDSv2 && createdImage.ImdsSupport != types.ImdsSupportValuesV20
+
+	if attrs != (imageAttributes{}) {
+		if requireIMDSv2 {
+			attrs.imdsSupport = types.ImdsSupportValuesV20
+		}
+		if createdImage, err = reregisterImage(ctx, client, createdImage, attrs, opt.verbose); err != nil {
+			return nil, err
+		}
+	} else if requireIMDSv2 {
+		if createdImage, err = setIMDSv2Required(ctx, client, *createdImage.ImageId, opt.verbose); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkNetworking(ctx, client, instance, createdImage, opt.targetInstanceTypes); err != nil {
+		return nil, err
+	}
+
+	var unencryptedID string
+	if opt.kmsKeyID != "" {
+		setPhase("encrypting image")
+		unencrypted, err := waitImageAvailable(ctx, client, *createdImage.ImageId, opt.verbose)
+		if err != nil {
+			return nil, err
+		}
+		if createdImage, err = encryptImage(ctx, client, cfg.Region, unencrypted, opt.imageName, opt.kmsKeyID, snapshotTags, opt.verbose); err != nil {
+			return nil, err
+		}
+		unencryptedID = *unencrypted.ImageId
+		if opt.deleteUnencrypted {
+			if _, err := pruneImage(ctx, client, unencrypted); err != nil {
+				return nil, err
+			}
+			if opt.verbose {
+				logf("deleted unencrypted image %s", unencryptedID)
+			}
+			unencryptedID = ""
+		}
+	}
+
+	// The snapshots completing does not make the image available yet, and
+	// launch templates cannot use a pending image.
+	setPhase("waiting for image")
+	if createdImage.State != types.ImageStateAvailable {
+		if createdImage, err = waitImageAvailable(ctx, client, *createdImage.ImageId, opt.verbose); err != nil {
+			return nil, err
+		}
+	}
+	if opt.deprecateAfter != "" {
+		at, err := deprecationTime(opt.deprecateAfter, started)
+		if err != nil {
+			return nil, err
+		}
+		if err := deprecateImage(ctx, client, *createdImage.ImageId, at.UTC().Truncate(time.Minute)); err != nil {
+			return nil, err
+		}
+		if createdImage, err = describeImage(ctx, client, *createdImage.ImageId); err != nil {
+			return nil, err
+		}
+		if opt.verbose {
+			logf("image %s is deprecated at %s", *createdImage.ImageId, aws.ToString(createdImage.DeprecationTime))
+		}
+	}
+	snapshots, err := describeImageSnapshots(ctx, client, createdImage)
+	if err != nil {
+		return nil, err
+	}
+	if opt.mirrorImageTags {
+		if err := mirrorImageTags(ctx, client, createdImage, snapshots); err != nil {
+			return nil, err
+		}
+	}
+	if err := opt.deviceSnapshotTags.apply(ctx, client, createdImage); err != nil {
+		return nil, err
+	}
+	var locks []lockResult
+	if opt.snapshotLock.mode != "" {
+		setPhase("locking snapshots")
+		if locks, err = lockSnapshots(ctx, client, snapshots, opt.snapshotLock); err != nil {
+			return nil, err
+		}
+	}
+	var changes []snapshotChange
+	if opt.changeReport {
+		setPhase("reporting changed blocks")
+		changes = reportChanges(ctx, cfg, snapshots, opt.verbose)
+	}
+	res := newResult(ctx, cfg, createdImage, snapshots, started)
+	res.SourceInstance = newSourceInstance(instance)
+	res.Inventory = inv
+	res.SnapshotLocks = locks
+	res.ChangeReport = changes
+	res.UnencryptedImageId = unencryptedID
+	res.Hooks = hooks
+	return res, nil
+}
+
+// newResult describes the available image whose snapshots have completed,
+// finishing the run started at started.
+func newResult(ctx context.Context, cfg aws.Config, image types.Image, snapshots []deviceSnapshot, started time.Time) *result {
+	finished := time.Now()
+	encryption := describeEncryption(ctx, kms.NewFromConfig(cfg), snapshots)
+	for i := range encryption {
+		encryption[i].ConsoleUrl = snapshotConsoleURL(cfg.Region, encryption[i].SnapshotId)
+	}
+	return &result{
+		Image:              image,
+		RunId:              runID,
+		ImageArn:           ec2ARN(cfg.Region, "image/"+*image.ImageId),
+		ConsoleUrl:         imageConsoleURL(cfg.Region, *image.ImageId),
+		SnapshotEncryption: encryption,
+		started:            started,
+		finished:           finished,
+		timings:            deviceTimings(snapshots, finished),
+	}
+}
+
+// snapshotProgress traces the changes in the progress of the snapshots of an
+// image, writes them to the status file and, with progress bars, draws them,
+// or if verbose logs them.
+type snapshotProgress struct {
+	verbose bool
+	bars    *progressBars
+	last    map[string]string
+	waits   map[string]*waitLog
+	wait    waitLog
+}
+
+func newSnapshotProgress(verbose, bars bool) *snapshotProgress {
+	p := &snapshotProgress{verbose: verbose, last: map[string]string{}, waits: map[string]*waitLog{}}
+	if bars {
+		p.bars = newProgressBars()
+	}
+	return p
+}
+
+// report is an amimati.Creator Progress function.
+func (p *snapshotProgress) report(image types.Image, snapshots []types.Snapshot) {
+	if snapshots == nil {
+		if p.verbose {
+			p.wait.logf("waiting for snapshots to be created")
+		}
+		return
+	}
+	for _, snapshot := range snapshots {
+		snapshotId := *snapshot.SnapshotId
+		if _, ok := p.last[snapshotId]; !ok {
+			trace.state(snapshotId, "started", map[string]any{"imageId": aws.ToString(image.ImageId)})
+		}
+		if progress := string(snapshot.State) + " " + aws.ToString(snapshot.Progress); progress != p.last[snapshotId] {
+			trace.state(snapshotId, string(snapshot.State), map[string]any{"progress": aws.ToString(snapshot.Progress)})
+			runStatus.snapshot(snapshotId, string(snapshot.State), aws.ToString(snapshot.Progress), aws.ToTime(snapshot.StartTime))
+			p.last[snapshotId] = progress
+		}
+		if p.verbose && p.bars == nil && snapshot.State == types.SnapshotStatePending {
+			if p.waits[snapshotId] == nil {
+				p.waits[snapshotId] = &waitLog{}
+			}
+			p.waits[snapshotId].logf("snapshot %s state: %v, progress: %s, image state: %v", snapshotId, snapshot.State, aws.ToString(snapshot.Progress), image.State)
+		}
+	}
+	if p.bars != nil {
+		p.bars.update(snapshots)
+	}
+}
+
+func describeInstance(ctx context.Context, client *ec2.Client, instanceID string) (types.Instance, error) {
+	out, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instanceID}})
+	if err != nil {
+		return types.Instance{}, fmt.Errorf("error describing instance: %w", err)
+	}
+	if len(out.Reservations) == 0 || len(out.Reservations[0].Instances) == 0 {
+		return types.Instance{}, fmt.Errorf("instance %s not found", instanceID)
+	}
+	return out.Reservations[0].Instances[0], nil
+}
+
+// autoDescription describes an image of the instance created at t.
+func autoDescription(instanceID, region string, t time.Time) string {
+	return fmt.Sprintf("Created by amimati %s from %s in %s at %s", toolVersion(), instanceID, region, t.UTC().Format(time.RFC3339))
+}
+
+func describeImage(ctx context.Context, client *ec2.Client, imageID string) (types.Image, error) {
+	out, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: []string{imageID}})
+	if err != nil {
+		return types.Image{}, fmt.Errorf("error describing image %s: %w", imageID, err)
+	}
+	if len(out.Images) == 0 {
+		return types.Image{}, fmt.Errorf("image %s not found", imageID)
+	}
+	return out.Images[0], nil
+}
+
+// findImageByName returns the image owned by the caller with the given name,
+// or nil if there is none.
+func findImageByName(ctx context.Context, client *ec2.Client, name string) (*types.Image, error) {
+	out, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		Owners:  []string{"self"},
+		Filters: []types.Filter{{Name: aws.String("name"), Values: []string{name}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing images: %w", err)
+	}
+	if len(out.Images) == 0 {
+		return nil, nil
+	}
+	return &out.Images[0], nil
+}
+
+// checkAdoptable reports whether an existing image can be waited on in place
+// of creating a new one from the instance.
+func checkAdoptable(image types.Image, instanceID string) error {
+	if image.SourceInstanceId != nil && *image.SourceInstanceId != instanceID {
+		return fmt.Errorf("image %s named %s was created from %s, not %s", *image.ImageId, aws.ToString(image.Name), *image.SourceInstanceId, instanceID)
+	}
+	switch image.State {
+	case types.ImageStatePending, types.ImageStateAvailable:
+		return nil
+	}
+	return fmt.Errorf("image %s named %s cannot be adopted in state %v", *image.ImageId, aws.ToString(image.Name), image.State)
+}
+
+// waitImageAvailable polls the image with the ImageAvailable waiter of the SDK
+// until it leaves the pending state.
+func waitImageAvailable(ctx context.Context, client ec2.DescribeImagesAPIClient, imageID string, verbose bool) (types.Image, error) {
+	var image types.Image
+	var lastState types.ImageState
+	var wait waitLog
+	waiter := ec2.NewImageAvailableWaiter(client, func(o *ec2.ImageAvailableWaiterOptions) {
+		o.MinDelay = pollInterval
+		o.MaxDelay = pollInterval
+		o.Retryable = func(ctx context.Context, in *ec2.DescribeImagesInput, out *ec2.DescribeImagesOutput, err error) (bool, error) {
+			if err != nil {
+				return false, fmt.Errorf("error describing image %s: %w", imageID, err)
+			}
+			if len(out.Images) == 0 {
+				return false, fmt.Errorf("image %s not found", imageID)
+			}
+			image = out.Images[0]
+			if image.State != lastState {
+				trace.state(imageID, string(image.State), nil)
+				lastState = image.State
+			}
+			switch image.State {
+			case types.ImageStateAvailable:
+				return false, nil
+			case types.ImageStatePending:
+			default:
+				if image.StateReason != nil && image.StateReason.Message != nil {
+					return false, fmt.Errorf("image %s state: %v: %s", imageID, image.State, *image.StateReason.Message)
+				}
+				return false, fmt.Errorf("image %s state: %v", imageID, image.State)
+			}
+			if verbose {
+				wait.logf("waiting for image %s to be available, state: %v", imageID, image.State)
+			}
+			return true, nil
+		}
+	})
+	if err := waiter.Wait(ctx, &ec2.DescribeImagesInput{ImageIds: []string{imageID}}, unlimitedWait); err != nil {
+		// The image is returned along with the state it failed in.
+		if image.State == "" || image.State == types.ImageStatePending {
+			return types.Image{}, err
+		}
+		return image, err
+	}
+	return image, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwltypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// cwLogger ships the log lines of a run as JSON events to a CloudWatch Logs
+// stream named by the run ID. Events are buffered and sent every few seconds
+// and on close. A nil cwLogger ships nothing.
+type cwLogger struct {
+	client *cloudwatchlogs.Client
+	group  string
+	stream string
+
+	mu      sync.Mutex
+	pending []cwltypes.InputLogEvent
+	failed  bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+type cwLogEvent struct {
+	Level   string `json:"level"`
+	RunId   string `json:"runId,omitempty"`
+	Message string `json:"message"`
+}
+
+var runLog *cwLogger
+
+// newCWLogger creates the log stream of the run, and the log group if it does
+// not exist yet, and starts shipping events to it.
+func newCWLogger(ctx context.Context, cfg aws.Config, group string) (*cwLogger, error) {
+	client := cloudwatchlogs.NewFromConfig(cfg)
+	_, err := client.CreateLogStream(ctx, &cloudwatchlogs.CreateLogStreamInput{LogGroupName: &group, LogStreamName: &runID})
+	var notFound *cwltypes.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		if _, err := client.CreateLogGroup(ctx, &cloudwatchlogs.CreateLogGroupInput{LogGroupName: &group}); err != nil {
+			return nil, fmt.Errorf("error creating log group %s: %w", group, err)
+		}
+		_, err = client.CreateLogStream(ctx, &cloudwatchlogs.CreateLogStreamInput{LogGroupName: &group, LogStreamName: &runID})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating log stream %s: %w", runID, err)
+	}
+
+	l := &cwLogger{client: client, group: group, stream: runID, done: make(chan struct{})}
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		t := time.NewTicker(5 * time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				l.flush()
+			case <-l.done:
+				return
+			}
+		}
+	}()
+	return l, nil
+}
+
+func (l *cwLogger) event(level, msg string) {
+	if l == nil {
+		return
+	}
+	b, _ := json.Marshal(cwLogEvent{Level: level, RunId: runID, Message: msg})
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = append(l.pending, cwltypes.InputLogEvent{Message: aws.String(string(b)), Timestamp: aws.Int64(time.Now().UnixMilli())})
+}
+
+// flush sends the pending events. Failures are warned about once on stderr and
+// the events are dropped, so logging never stops the run.
+func (l *cwLogger) flush() {
+	l.mu.Lock()
+	events := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+
+	for len(events) > 0 {
+		n := min(len(events), 10000)
+		_, err := l.client.PutLogEvents(context.Background(), &cloudwatchlogs.PutLogEventsInput{
+			LogGroupName:  &l.group,
+			LogStreamName: &l.stream,
+			LogEvents:     events[:n],
+		})
+		if err != nil {
+			l.mu.Lock()
+			if !l.failed {
+				l.failed = true
+				l.mu.Unlock()
+				warnf("error shipping logs to CloudWatch Logs: %v", err)
+			} else {
+				l.mu.Unlock()
+			}
+			return
+		}
+		events = events[n:]
+	}
+}
+
+// close stops the background shipping and sends the remaining events.
+func (l *cwLogger) close() {
+	if l == nil {
+		return
+	}
+	close(l.done)
+	l.wg.Wait()
+	l.flush()
+}
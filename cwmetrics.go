@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// cwMetrics publishes the outcome of a run as CloudWatch custom metrics with
+// PutMetricData once the run ends, dimensioned by the command. A nil
+// cwMetrics publishes nothing.
+type cwMetrics struct {
+	client    *cloudwatch.Client
+	namespace string
+	command   string
+
+	mu            sync.Mutex
+	snapshotBytes float64
+	published     bool
+}
+
+var cloudMetrics *cwMetrics
+
+func newCWMetrics(cfg aws.Config, namespace, command string) *cwMetrics {
+	return &cwMetrics{client: cloudwatch.NewFromConfig(cfg), namespace: namespace, command: command}
+}
+
+// image records the size of the snapshots of the image created by the run.
+func (m *cwMetrics) image(sizeGiB int32) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshotBytes = float64(sizeGiB) * (1 << 30)
+}
+
+// finish publishes BackupSuccess and BackupFailure, one of them 1 and the
+// other 0 so that a missing run can be alarmed on, BackupDuration and, once
+// an image was created, SnapshotBytes. Only the first call publishes, and a
+// failure to publish is warned about without failing the run.
+func (m *cwMetrics) finish(succeeded bool, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.published {
+		return
+	}
+	m.published = true
+
+	success, failure := 1.0, 0.0
+	if !succeeded {
+		success, failure = 0, 1
+	}
+	dims := []cwtypes.Dimension{{Name: aws.String("Command"), Value: &m.command}}
+	now := time.Now()
+	datum := func(name string, v float64, unit cwtypes.StandardUnit) cwtypes.MetricDatum {
+		return cwtypes.MetricDatum{MetricName: aws.String(name), Dimensions: dims, Timestamp: &now, Value: aws.Float64(v), Unit: unit}
+	}
+	data := []cwtypes.MetricDatum{
+		datum("BackupSuccess", success, cwtypes.StandardUnitCount),
+		datum("BackupFailure", failure, cwtypes.StandardUnitCount),
+		datum("BackupDuration", elapsed.Seconds(), cwtypes.StandardUnitSeconds),
+	}
+	if m.snapshotBytes > 0 {
+		data = append(data, datum("SnapshotBytes", m.snapshotBytes, cwtypes.StandardUnitBytes))
+	}
+	// The run may have been aborted: its context is not used.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := m.client.PutMetricData(ctx, &cloudwatch.PutMetricDataInput{Namespace: &m.namespace, MetricData: data}); err != nil {
+		warnf("error publishing metrics to CloudWatch namespace %s: %v", m.namespace, err)
+	}
+}
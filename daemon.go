@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// daemonState is written to the -state-file of the daemon whenever it
+// schedules a run.
+type daemonState struct {
+	LastRun *daemonRun `json:",omitempty"`
+	NextRun time.Time
+}
+
+type daemonRun struct {
+	RunId     string
+	Started   time.Time
+	Finished  time.Time
+	Succeeded bool
+	ImageId   string `json:",omitempty"`
+	Error     string `json:",omitempty"`
+	// Skipped is the number of runs due while this one was running.
+	Skipped int `json:",omitempty"`
+}
+
+func runDaemon(args []string) {
+	var path, cronExpr, stateFile, metricsAddr string
+	var interval time.Duration
+	var verbose bool
+	var requiredTags list
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	timeOpt := addTimeFlags(fs)
+	fs.StringVar(&path, "config", "amimati.json", "config file")
+	remote := addRemoteConfigFlags(fs)
+	fs.DurationVar(&interval, "interval", 24*time.Hour, "time between the starts of two runs of the pipeline")
+	fs.StringVar(&cronExpr, "schedule", "", "start the runs of the pipeline on this cron schedule, in the timezone of the schedule config, in place of -interval(eg. \"0 3 * * *\" or @daily)")
+	fs.StringVar(&stateFile, "state-file", "", "keep the outcome of the last run and the time of the next one in this JSON file(eg. /var/lib/amimati/state.json)")
+	fs.Var(&requiredTags, "required-tags", "fail before creating the image unless it is tagged with these keys, whose value must match the regular expression after =, if any(eg. owner,cost-center=^CC-[0-9]+$)")
+	fs.StringVar(&metricsAddr, "metrics-addr", "", "serve Prometheus metrics of the runs on /metrics at this address(eg. :9090)")
+	fs.BoolVar(&verbose, "v", false, "verbose output")
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := timeOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if interval <= 0 {
+		fatal("interval must be positive")
+	}
+	var cron *cronSchedule
+	if cronExpr != "" {
+		intervalGiven := false
+		fs.Visit(func(f *flag.Flag) { intervalGiven = intervalGiven || f.Name == "interval" })
+		if intervalGiven {
+			fatal("-schedule cannot be combined with -interval")
+		}
+		var err error
+		if cron, err = parseCron(cronExpr); err != nil {
+			fatal(err)
+		}
+	}
+
+	if err := remote.validate(); err != nil {
+		fatal(err)
+	}
+
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+
+	c, naming, policy, schedule, err := loadDaemonConfig(ctx, cfg, path, remote, requiredTags)
+	if err != nil {
+		fatal(err)
+	}
+
+	var prom *promMetrics
+	if metricsAddr != "" {
+		prom = newPromMetrics()
+		if err := prom.serve(metricsAddr); err != nil {
+			fatal(err)
+		}
+	}
+
+	// next returns when the run after t is due, and how many runs due up to
+	// now it skips.
+	next := func(t time.Time) (time.Time, int) {
+		skipped := -1
+		for !t.After(time.Now()) {
+			if cron != nil {
+				t = cron.next(t.In(schedule.loc))
+			} else {
+				t = t.Add(interval)
+			}
+			if t.IsZero() {
+				fatal("the cron schedule matches no time")
+			}
+			skipped++
+		}
+		return t, max(skipped, 0)
+	}
+	due := time.Now()
+	if cron != nil {
+		due, _ = next(due)
+	}
+	var last *daemonRun
+	for first := true; ; first = false {
+		// A remote config is fetched again before each run, keeping the
+		// previous one if that fails.
+		if remote.set() && !first {
+			if nc, nn, np, ns, err := loadDaemonConfig(ctx, cfg, path, remote, requiredTags); err != nil {
+				warnf("keeping the previous config: %v", err)
+			} else {
+				c, naming, policy, schedule = nc, nn, np, ns
+			}
+		}
+		start, reason := schedule.nextAllowed(due)
+		if start.IsZero() {
+			fatal("the schedule allows no run")
+		}
+		if reason != "" {
+			logf("deferring the run due at %s to %s: %s", formatTime(due), formatTime(start), reason)
+		}
+		if stateFile != "" {
+			if err := writeFileAtomic(stateFile, daemonState{LastRun: last, NextRun: start.UTC()}); err != nil {
+				warnf("error writing state file: %v", err)
+			}
+		}
+		// A signal while waiting for the next run stops the daemon cleanly.
+		select {
+		case <-time.After(time.Until(start)):
+		case <-ctx.Done():
+			logf("stopping")
+			return
+		}
+
+		setRunID("")
+		started := time.Now()
+		logf("starting run %s", runID)
+		st := &pipelineState{cfg: cfg, verbose: verbose, naming: naming, tagPolicy: policy, images: map[string]string{}}
+		report := c.Pipeline.run(ctx, st)
+		printResult(report, "")
+		last = &daemonRun{RunId: runID, Started: started.UTC(), Finished: time.Now().UTC(), Succeeded: report.Succeeded, ImageId: report.ImageId}
+		prom.run(c.Pipeline.target(), report.Succeeded, last.Finished.Sub(started), last.Finished)
+		if err := report.err(); err != nil {
+			last.Error = err.Error()
+		}
+		if aborted(ctx) {
+			if stateFile != "" {
+				if err := writeFileAtomic(stateFile, daemonState{LastRun: last}); err != nil {
+					warnf("error writing state file: %v", err)
+				}
+			}
+			exitWith(exitAborted, errors.New("aborted"))
+		}
+		if report.Succeeded {
+			logf("run %s succeeded after %s", runID, time.Since(started).Round(time.Second))
+		} else {
+			logf("run %s failed after %s", runID, time.Since(started).Round(time.Second))
+		}
+
+		// Runs due while the previous one was running are skipped, so runs
+		// never overlap.
+		due, last.Skipped = next(due)
+		if last.Skipped > 0 {
+			logf("skipped %d runs due while run %s was running", last.Skipped, last.RunId)
+		}
+	}
+}
+
+// loadDaemonConfig loads the pipeline config along with its naming convention,
+// tag policy, with the keys of -required-tags added, and schedule.
+func loadDaemonConfig(ctx context.Context, cfg aws.Config, path string, remote *remoteConfig, requiredTags []string) (*fileConfig, namingConfig, tagPolicy, *scheduleConfig, error) {
+	c, err := loadPipelineConfig(ctx, cfg, path, remote)
+	if err != nil {
+		return nil, namingConfig{}, tagPolicy{}, nil, err
+	}
+	naming, err := loadNaming(c)
+	if err != nil {
+		return nil, namingConfig{}, tagPolicy{}, nil, err
+	}
+	policy, err := loadTagPolicy(c, requiredTags)
+	if err != nil {
+		return nil, namingConfig{}, tagPolicy{}, nil, err
+	}
+	schedule := c.Schedule
+	if schedule == nil {
+		schedule = &scheduleConfig{}
+	}
+	if err := schedule.validate(); err != nil {
+		return nil, namingConfig{}, tagPolicy{}, nil, err
+	}
+	return c, naming, policy, schedule, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// deleteResult is an image deregistered by delete.
+type deleteResult struct {
+	pruneResult
+	// KeptSnapshotIds are the snapshots of the image left in place, with
+	// -keep-snapshots or because other images are registered from them.
+	KeptSnapshotIds []string `json:",omitempty"`
+}
+
+func runDelete(args []string) {
+	var imageID, query string
+	var filter listFilter
+	var dryRun, keepSnapshots, allMatching, plan, autoApprove bool
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.StringVar(&imageID, "image-id", "", "ID of the image to deregister along with its snapshots")
+	fs.StringVar(&filter.name, "name", "", "select the image owned by the account by its name, a glob with * and ? wildcards, in place of -image-id(eg. web-20260101)")
+	fs.Var(&filter.tags, "tag", "select the image owned by the account by these tags, in place of -image-id(eg. env=staging)")
+	fs.BoolVar(&allMatching, "all-matching", false, "delete every image matched by -name and -tag instead of requiring exactly one")
+	fs.BoolVar(&keepSnapshots, "keep-snapshots", false, "only deregister the image, keeping its snapshots")
+	fs.BoolVar(&dryRun, "dry-run", false, "only check that the image can be deregistered and its snapshots deleted")
+	fs.BoolVar(&plan, "plan", false, "print the plan of the images and snapshots that would be deleted, with their sizes, ages and the rule matching them, without deleting them")
+	fs.BoolVar(&autoApprove, "auto-approve", false, "delete without showing the plan and asking to confirm it on the terminal")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. SnapshotIds)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	selected := filter.name != "" || len(filter.tags) > 0
+	if imageID == "" && !selected {
+		fatal("image ID, name or tag is required")
+	}
+	if imageID != "" && selected {
+		fatal("-image-id cannot be combined with -name or -tag")
+	}
+	if allMatching && !selected {
+		fatal("-all-matching requires -name or -tag")
+	}
+	if plan && dryRun {
+		fatal("-plan cannot be combined with -dry-run")
+	}
+
+	ctx := context.Background()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	client := ec2.NewFromConfig(cfg)
+
+	var images []types.Image
+	if imageID != "" {
+		image, err := describeImage(ctx, client, imageID)
+		if err != nil {
+			fatal(err)
+		}
+		images = []types.Image{image}
+	} else {
+		if images, err = describeOwnImages(ctx, client, filter.filters()); err != nil {
+			fatal(err)
+		}
+		if len(images) == 0 {
+			fatal("no image matches")
+		}
+		if len(images) > 1 && !allMatching {
+			var ids []string
+			for _, image := range images {
+				ids = append(ids, *image.ImageId+" ("+aws.ToString(image.Name)+")")
+			}
+			fatalf("%d images match, use -all-matching to delete them all: %s", len(images), strings.Join(ids, ", "))
+		}
+	}
+
+	if dryRun {
+		report := newDryRunReport()
+		for _, image := range images {
+			if err := dryRunDelete(ctx, client, report, image, keepSnapshots); err != nil {
+				fatal(err)
+			}
+		}
+		printResult(report, query)
+		if !report.Permitted {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if plan || !autoApprove {
+		var p deletionPlan
+		now := time.Now()
+		rule := "matches -image-id"
+		if selected {
+			var match []string
+			if filter.name != "" {
+				match = append(match, "name "+filter.name)
+			}
+			if len(filter.tags) > 0 {
+				match = append(match, "tags "+filter.tags.String())
+			}
+			rule = "matches " + strings.Join(match, " and ")
+		}
+		for _, image := range images {
+			_, kept, _, err := deletableSnapshots(ctx, client, image, keepSnapshots)
+			if err != nil {
+				fatal(err)
+			}
+			p.add(image, rule, kept, now)
+		}
+		if plan {
+			printResult(p, query)
+			return
+		}
+		if err := confirmPlan(p); err != nil {
+			fatal(err)
+		}
+	}
+
+	results := []deleteResult{}
+	for _, image := range images {
+		res, err := deleteImageKeeping(ctx, client, image, keepSnapshots)
+		results = append(results, res)
+		if err != nil {
+			printResult(results, query)
+			fatal(err)
+		}
+	}
+	if allMatching {
+		printResult(results, query)
+	} else {
+		printResult(results[0], query)
+	}
+}
+
+// deleteImageKeeping deregisters the image and deletes its snapshots, unless
+// keepSnapshots, except those other images are registered from: deleting
+// them would fail once the image is gone, leaving the deletion half done.
+func deleteImageKeeping(ctx context.Context, client *ec2.Client, image types.Image, keepSnapshots bool) (deleteResult, error) {
+	r := deleteResult{pruneResult: pruneResult{ImageId: *image.ImageId, Name: aws.ToString(image.Name)}}
+	remove, kept, users, err := deletableSnapshots(ctx, client, image, keepSnapshots)
+	if err != nil {
+		return r, err
+	}
+	r.KeptSnapshotIds = kept
+	for _, id := range kept {
+		if len(users[id]) > 0 {
+			warnf("keeping snapshot %s, other images are registered from it: %v", id, users[id])
+		}
+	}
+
+	if _, err := client.DeregisterImage(ctx, &ec2.DeregisterImageInput{ImageId: image.ImageId}); err != nil {
+		return r, fmt.Errorf("error deregistering image %s: %w", *image.ImageId, err)
+	}
+	for _, id := range remove {
+		if _, err := client.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{SnapshotId: aws.String(id)}); err != nil {
+			return r, fmt.Errorf("error deleting snapshot %s: %w", id, err)
+		}
+		r.SnapshotIds = append(r.SnapshotIds, id)
+	}
+	r.RecycleBin = recycleBinStatus(ctx, client, *image.ImageId, r.SnapshotIds)
+	return r, nil
+}
+
+// deletableSnapshots splits the snapshots of the image into those to delete
+// and those to keep: all of them with keepSnapshots, or else those other
+// images are registered from, whose images are returned by snapshot ID.
+func deletableSnapshots(ctx context.Context, client *ec2.Client, image types.Image, keepSnapshots bool) (remove, kept []string, users map[string][]string, err error) {
+	if keepSnapshots {
+		return nil, imageSnapshotIDs(image), nil, nil
+	}
+	users = map[string][]string{}
+	for _, id := range imageSnapshotIDs(image) {
+		u, err := snapshotImages(ctx, client, id)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if len(u) > 1 {
+			users[id] = u
+			kept = append(kept, id)
+			continue
+		}
+		remove = append(remove, id)
+	}
+	return remove, kept, users, nil
+}
+
+// snapshotImages returns the IDs of the images owned by the caller that are
+// registered from the snapshot.
+func snapshotImages(ctx context.Context, client *ec2.Client, snapshotID string) ([]string, error) {
+	images, err := describeOwnImages(ctx, client, []types.Filter{{Name: aws.String("block-device-mapping.snapshot-id"), Values: []string{snapshotID}}})
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, image := range images {
+		ids = append(ids, *image.ImageId)
+	}
+	return ids, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+type deprecateResult struct {
+	ImageId         string
+	DeprecationTime *time.Time `json:",omitempty"`
+}
+
+func runDeprecate(args []string) {
+	var imageID, after string
+	var cancel bool
+	var query string
+	fs := flag.NewFlagSet("deprecate", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.StringVar(&imageID, "image-id", "", "image ID")
+	fs.StringVar(&after, "after", "", "deprecate the image after this duration, or at this RFC 3339 time(eg. 90d, 2w, 36h or 2027-01-01T00:00:00Z)")
+	fs.BoolVar(&cancel, "cancel", false, "cancel a scheduled deprecation")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. ImageId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+
+	if imageID == "" {
+		fatal("image ID is required")
+	}
+	if cancel == (after != "") {
+		fatal("exactly one of -after or -cancel is required")
+	}
+
+	ctx := context.Background()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	client := ec2.NewFromConfig(cfg)
+
+	res := deprecateResult{ImageId: imageID}
+	if cancel {
+		if _, err := client.DisableImageDeprecation(ctx, &ec2.DisableImageDeprecationInput{ImageId: &imageID}); err != nil {
+			fatalf("error disabling image deprecation: %v", err)
+		}
+	} else {
+		at, err := deprecationTime(after, time.Now())
+		if err != nil {
+			fatal(err)
+		}
+		at = at.UTC().Truncate(time.Minute)
+		if err := deprecateImage(ctx, client, imageID, at); err != nil {
+			fatal(err)
+		}
+		res.DeprecationTime = &at
+	}
+
+	printResult(res, query)
+}
+
+// deprecationTime returns the time given by a deprecation delay: a duration
+// after now such as "90d", or an RFC 3339 time in the future.
+func deprecationTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		if !t.After(now) {
+			return time.Time{}, fmt.Errorf("deprecation time %s is not in the future", s)
+		}
+		return t, nil
+	}
+	d, err := parseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid deprecation time: %s", s)
+	}
+	return now.Add(d), nil
+}
+
+func deprecateImage(ctx context.Context, client *ec2.Client, imageID string, at time.Time) error {
+	if _, err := client.EnableImageDeprecation(ctx, &ec2.EnableImageDeprecationInput{ImageId: &imageID, DeprecateAt: &at}); err != nil {
+		return fmt.Errorf("error enabling image deprecation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// describeBatchSize is the most IDs a coalesced describe asks for at once.
+const describeBatchSize = 200
+
+// describeBatcher coalesces the DescribeImages and DescribeSnapshots calls by
+// ID that the concurrent waits of a batch make, such as one per snapshot per
+// poll, into a single call per region and tick for all the pending IDs. The
+// first call of a tick waits for the others for window before the describe
+// is made; waits served together stay in step afterwards. A coalesced call
+// that fails, for instance because one of the IDs does not exist, is made
+// again by each of its callers on its own, so errors are those of the call.
+type describeBatcher struct {
+	cfg    aws.Config
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*describeBatch
+}
+
+// describeBatch is the describe of a tick for an operation in a region.
+type describeBatch struct {
+	ids  []string
+	done chan struct{}
+	// images and snapshots are set by ID once done, unless err is.
+	images    map[string]types.Image
+	snapshots map[string]types.Snapshot
+	err       error
+}
+
+// batchDescribes returns cfg with the describes by ID of the clients created
+// from it coalesced.
+func batchDescribes(cfg aws.Config) aws.Config {
+	b := &describeBatcher{cfg: cfg, window: max(pollInterval/5, 10*time.Millisecond), pending: map[string]*describeBatch{}}
+	cfg = cfg.Copy()
+	cfg.APIOptions = append(cfg.APIOptions, func(stack *middleware.Stack) error {
+		// After the service and region are known, but before the rate
+		// limiter and the tracer, which the coalesced call goes through
+		// once instead.
+		return stack.Initialize.Insert(middleware.InitializeMiddlewareFunc("amimatiDescribeBatch", b.handle), "RegisterServiceMetadata", middleware.After)
+	})
+	return cfg
+}
+
+func (b *describeBatcher) handle(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	if awsmiddleware.GetServiceID(ctx) != "EC2" {
+		return next.HandleInitialize(ctx, in)
+	}
+	region := awsmiddleware.GetRegion(ctx)
+	switch p := in.Parameters.(type) {
+	case *ec2.DescribeImagesInput:
+		if !imagesByID(p) {
+			break
+		}
+		batch, err := b.join(ctx, region, "DescribeImages", p.ImageIds)
+		if err != nil {
+			return middleware.InitializeOutput{}, middleware.Metadata{}, err
+		}
+		if batch.err != nil {
+			break
+		}
+		out := &ec2.DescribeImagesOutput{}
+		for _, id := range p.ImageIds {
+			if image, ok := batch.images[id]; ok {
+				out.Images = append(out.Images, image)
+			}
+		}
+		return middleware.InitializeOutput{Result: out}, middleware.Metadata{}, nil
+	case *ec2.DescribeSnapshotsInput:
+		if !snapshotsByID(p) {
+			break
+		}
+		batch, err := b.join(ctx, region, "DescribeSnapshots", p.SnapshotIds)
+		if err != nil {
+			return middleware.InitializeOutput{}, middleware.Metadata{}, err
+		}
+		if batch.err != nil {
+			break
+		}
+		out := &ec2.DescribeSnapshotsOutput{}
+		for _, id := range p.SnapshotIds {
+			if s, ok := batch.snapshots[id]; ok {
+				out.Snapshots = append(out.Snapshots, s)
+			}
+		}
+		return middleware.InitializeOutput{Result: out}, middleware.Metadata{}, nil
+	}
+	return next.HandleInitialize(ctx, in)
+}
+
+// imagesByID reports whether the input asks for images by ID only, without
+// the filters or owners a coalesced describe would not honour.
+func imagesByID(in *ec2.DescribeImagesInput) bool {
+	return len(in.ImageIds) > 0 && len(in.Filters) == 0 && len(in.Owners) == 0 && len(in.ExecutableUsers) == 0 &&
+		in.DryRun == nil && in.IncludeDeprecated == nil && in.IncludeDisabled == nil && in.MaxResults == nil && in.NextToken == nil
+}
+
+// snapshotsByID is imagesByID for snapshots.
+func snapshotsByID(in *ec2.DescribeSnapshotsInput) bool {
+	return len(in.SnapshotIds) > 0 && len(in.Filters) == 0 && len(in.OwnerIds) == 0 && len(in.RestorableByUserIds) == 0 &&
+		in.DryRun == nil && in.MaxResults == nil && in.NextToken == nil
+}
+
+// join adds the IDs to the describe of the tick for the operation in the
+// region, and waits for it, or for ctx to be done.
+func (b *describeBatcher) join(ctx context.Context, region, op string, ids []string) (*describeBatch, error) {
+	key := region + "/" + op
+	b.mu.Lock()
+	batch, ok := b.pending[key]
+	if !ok {
+		batch = &describeBatch{done: make(chan struct{})}
+		b.pending[key] = batch
+		// The describe outlives the call starting it, which may be
+		// cancelled while others wait for it.
+		go b.flush(context.WithoutCancel(ctx), key, region, op, batch)
+	}
+	for _, id := range ids {
+		if !slices.Contains(batch.ids, id) {
+			batch.ids = append(batch.ids, id)
+		}
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-batch.done:
+		return batch, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// flush makes the describe of the batch once its window has passed.
+func (b *describeBatcher) flush(ctx context.Context, key, region, op string, batch *describeBatch) {
+	time.Sleep(b.window)
+	b.mu.Lock()
+	delete(b.pending, key)
+	ids := batch.ids
+	b.mu.Unlock()
+	defer close(batch.done)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	client := ec2.NewFromConfig(b.cfg, func(o *ec2.Options) { o.Region = region })
+	switch op {
+	case "DescribeImages":
+		batch.images = map[string]types.Image{}
+	case "DescribeSnapshots":
+		batch.snapshots = map[string]types.Snapshot{}
+	}
+	for start := 0; start < len(ids); start += describeBatchSize {
+		chunk := ids[start:min(start+describeBatchSize, len(ids))]
+		switch op {
+		case "DescribeImages":
+			out, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: chunk})
+			if err != nil {
+				batch.err = err
+				return
+			}
+			for _, image := range out.Images {
+				batch.images[aws.ToString(image.ImageId)] = image
+			}
+		case "DescribeSnapshots":
+			p := ec2.NewDescribeSnapshotsPaginator(client, &ec2.DescribeSnapshotsInput{SnapshotIds: chunk})
+			for p.HasMorePages() {
+				out, err := p.NextPage(ctx)
+				if err != nil {
+					batch.err = err
+					return
+				}
+				for _, s := range out.Snapshots {
+					batch.snapshots[aws.ToString(s.SnapshotId)] = s
+				}
+			}
+		}
+	}
+}
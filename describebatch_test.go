@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// snapshotServer answers DescribeSnapshots with a completed snapshot for each
+// of the IDs asked for, failing as InvalidSnapshot.NotFound for snap-gone.
+func snapshotServer(t *testing.T, calls *atomic.Int32) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("Action") != "DescribeSnapshots" {
+			t.Errorf("got request %v", r.Form)
+		}
+		calls.Add(1)
+		var items strings.Builder
+		for k, v := range r.Form {
+			if !strings.HasPrefix(k, "SnapshotId.") {
+				continue
+			}
+			if v[0] == "snap-gone" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `<Response><Errors><Error><Code>InvalidSnapshot.NotFound</Code><Message>gone</Message></Error></Errors></Response>`)
+				return
+			}
+			fmt.Fprintf(&items, "<item><snapshotId>%s</snapshotId><status>completed</status></item>", v[0])
+		}
+		fmt.Fprintf(w, `<DescribeSnapshotsResponse><snapshotSet>%s</snapshotSet></DescribeSnapshotsResponse>`, items.String())
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// describeSnapshots describes each of the snapshots on its own, concurrently,
+// as the waits of a batch do, and returns the IDs described or the errors.
+func describeSnapshots(ctx context.Context, cfg aws.Config, ids ...string) []string {
+	client := ec2.NewFromConfig(cfg)
+	got := make([]string, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		i, id := i, id
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := client.DescribeSnapshots(ctx, &ec2.DescribeSnapshotsInput{SnapshotIds: []string{id}})
+			switch {
+			case err != nil:
+				got[i] = "error"
+			case len(out.Snapshots) != 1:
+				got[i] = fmt.Sprintf("%d snapshots", len(out.Snapshots))
+			default:
+				got[i] = aws.ToString(out.Snapshots[0].SnapshotId)
+			}
+		}()
+	}
+	wg.Wait()
+	return got
+}
+
+func TestBatchDescribes(t *testing.T) {
+	ctx := testContext(t)
+	var calls atomic.Int32
+	srv := snapshotServer(t, &calls)
+	cfg := aws.Config{Region: "us-east-1", BaseEndpoint: aws.String(srv.URL), Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", "")}
+	got := describeSnapshots(ctx, batchDescribes(cfg), "snap-1", "snap-2", "snap-3", "snap-4")
+	if strings.Join(got, " ") != "snap-1 snap-2 snap-3 snap-4" {
+		t.Errorf("got %v, want each snapshot described", got)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("made %d DescribeSnapshots calls, want 1", calls.Load())
+	}
+}
+
+func TestBatchDescribesFallback(t *testing.T) {
+	ctx := testContext(t)
+	var calls atomic.Int32
+	srv := snapshotServer(t, &calls)
+	cfg := aws.Config{Region: "us-east-1", BaseEndpoint: aws.String(srv.URL), Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""), RetryMaxAttempts: 1}
+	got := describeSnapshots(ctx, batchDescribes(cfg), "snap-1", "snap-gone")
+	if strings.Join(got, " ") != "snap-1 error" {
+		t.Errorf("got %v, want only the missing snapshot to fail", got)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("made %d DescribeSnapshots calls, want the coalesced one and one per snapshot", calls.Load())
+	}
+}
+
+func TestBatchDescribesFilters(t *testing.T) {
+	for _, in := range []*ec2.DescribeSnapshotsInput{
+		{},
+		{SnapshotIds: []string{"snap-1"}, OwnerIds: []string{"self"}},
+		{SnapshotIds: []string{"snap-1"}, MaxResults: aws.Int32(5)},
+	} {
+		if snapshotsByID(in) {
+			t.Errorf("%+v is coalesced", in)
+		}
+	}
+	if !snapshotsByID(&ec2.DescribeSnapshotsInput{SnapshotIds: []string{"snap-1"}}) {
+		t.Error("a describe by ID is not coalesced")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// deviceMappings is a repeatable flag changing the EBS mappings of the
+// image, as in
+// -device-mapping device=/dev/xvdb,type=gp3,size=100,delete-on-termination=false.
+type deviceMappings []types.BlockDeviceMapping
+
+func (m *deviceMappings) String() string {
+	s := make([]string, 0, len(*m))
+	for _, bdm := range *m {
+		s = append(s, aws.ToString(bdm.DeviceName))
+	}
+	return strings.Join(s, " ")
+}
+
+func (m *deviceMappings) Set(value string) error {
+	bdm := types.BlockDeviceMapping{Ebs: &types.EbsBlockDevice{}}
+	for _, kv := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("invalid device mapping: %s", kv)
+		}
+		switch k {
+		case "device":
+			bdm.DeviceName = aws.String(v)
+		case "type":
+			bdm.Ebs.VolumeType = types.VolumeType(v)
+		case "size", "iops", "throughput":
+			n, err := strconv.ParseInt(v, 10, 32)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid device mapping %s: %s", k, v)
+			}
+			switch k {
+			case "size":
+				bdm.Ebs.VolumeSize = aws.Int32(int32(n))
+			case "iops":
+				bdm.Ebs.Iops = aws.Int32(int32(n))
+			case "throughput":
+				bdm.Ebs.Throughput = aws.Int32(int32(n))
+			}
+		case "delete-on-termination":
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid device mapping %s: %s", k, v)
+			}
+			bdm.Ebs.DeleteOnTermination = aws.Bool(b)
+		default:
+			return fmt.Errorf("invalid device mapping: %s", kv)
+		}
+	}
+	if bdm.DeviceName == nil {
+		return fmt.Errorf("device mapping requires device: %s", value)
+	}
+	*m = append(*m, bdm)
+	return nil
+}
+
+// imageMappings returns the block device mappings to create the image of the
+// instance with: the excluded devices mapped to no device, and the
+// mappings changing those of the instance. The devices must be EBS volumes
+// of the instance, and the root device cannot be excluded.
+func imageMappings(instance types.Instance, exclude []string, mappings deviceMappings) ([]types.BlockDeviceMapping, error) {
+	attached := map[string]bool{}
+	for _, bdm := range instance.BlockDeviceMappings {
+		// Instance store volumes are not in the image, whatever the mappings.
+		if bdm.Ebs != nil {
+			attached[aws.ToString(bdm.DeviceName)] = true
+		}
+	}
+
+	var result []types.BlockDeviceMapping
+	for _, device := range exclude {
+		if !attached[device] {
+			return nil, fmt.Errorf("cannot exclude %s: instance %s has no such EBS volume", device, *instance.InstanceId)
+		}
+		if device == aws.ToString(instance.RootDeviceName) {
+			return nil, fmt.Errorf("cannot exclude the root device %s", device)
+		}
+		result = append(result, types.BlockDeviceMapping{DeviceName: aws.String(device), NoDevice: aws.String("")})
+	}
+	for _, bdm := range mappings {
+		device := aws.ToString(bdm.DeviceName)
+		if !attached[device] {
+			return nil, fmt.Errorf("cannot map %s: instance %s has no such EBS volume", device, *instance.InstanceId)
+		}
+		for _, e := range exclude {
+			if e == device {
+				return nil, fmt.Errorf("device %s is both excluded and mapped", device)
+			}
+		}
+		result = append(result, bdm)
+	}
+	return result, nil
+}
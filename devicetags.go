@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// devicePrefix matches the device name prefixing the tags of a single
+// snapshot, as in /dev/xvdb:role=data.
+var devicePrefix = regexp.MustCompile(`^(/dev/[a-z0-9]+|xvd[a-z]+[0-9]*):`)
+
+// deviceTags are the tags of the snapshots of single devices, by device name.
+type deviceTags map[string]tags
+
+// snapshotTagFlag is -snapshot-tag: tags for every snapshot of the image, or,
+// prefixed with a device name, for the snapshot of that device only.
+type snapshotTagFlag struct {
+	all     *tags
+	devices *deviceTags
+}
+
+func (f snapshotTagFlag) String() string {
+	if f.all == nil {
+		return ""
+	}
+	var devices []string
+	for device, t := range *f.devices {
+		devices = append(devices, device+":"+t.String())
+	}
+	sort.Strings(devices)
+	if len(*f.all) > 0 {
+		devices = append([]string{f.all.String()}, devices...)
+	}
+	return strings.Join(devices, " ")
+}
+
+func (f snapshotTagFlag) Set(value string) error {
+	m := devicePrefix.FindStringSubmatch(value)
+	if m == nil {
+		return f.all.Set(value)
+	}
+	if *f.devices == nil {
+		*f.devices = deviceTags{}
+	}
+	t := (*f.devices)[m[1]]
+	if err := t.Set(value[len(m[0]):]); err != nil {
+		return fmt.Errorf("invalid tags of device %s: %w", m[1], err)
+	}
+	(*f.devices)[m[1]] = t
+	return nil
+}
+
+// deviceTagMap converts the tags of each device from a config file.
+func deviceTagMap(m map[string]map[string]string) deviceTags {
+	d := deviceTags{}
+	for device, t := range m {
+		d[device] = tagMap(t)
+	}
+	return d
+}
+
+// check fails when a device is not among those of the instance imaged.
+func (d deviceTags) check(instance types.Instance, excludeDevices []string) error {
+	var devices []string
+	for _, bdm := range instance.BlockDeviceMappings {
+		if name := aws.ToString(bdm.DeviceName); !slices.Contains(excludeDevices, name) {
+			devices = append(devices, name)
+		}
+	}
+	for device := range d {
+		if !slices.Contains(devices, device) {
+			return fmt.Errorf("no such device to tag the snapshot of: %s, the image has %s", device, strings.Join(devices, ", "))
+		}
+	}
+	return nil
+}
+
+// expand expands the values of the tags of each device with the data.
+func (d deviceTags) expand(data templateData) (deviceTags, error) {
+	expanded := deviceTags{}
+	for device, t := range d {
+		e, err := data.expandTags(t)
+		if err != nil {
+			return nil, err
+		}
+		expanded[device] = e
+	}
+	return expanded, nil
+}
+
+// apply tags the snapshot of each device of the image with its tags, once
+// CreateImage has assigned the snapshot IDs.
+func (d deviceTags) apply(ctx context.Context, client *ec2.Client, image types.Image) error {
+	for _, bdm := range image.BlockDeviceMappings {
+		t := d[aws.ToString(bdm.DeviceName)]
+		if len(t) == 0 || bdm.Ebs == nil || bdm.Ebs.SnapshotId == nil {
+			continue
+		}
+		if _, err := client.CreateTags(ctx, &ec2.CreateTagsInput{Resources: []string{*bdm.Ebs.SnapshotId}, Tags: t}); err != nil {
+			return fmt.Errorf("error tagging snapshot %s of %s: %w", *bdm.Ebs.SnapshotId, aws.ToString(bdm.DeviceName), err)
+		}
+	}
+	return nil
+}
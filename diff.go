@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Operations of an imageChange, named as in JSON Patch.
+const (
+	diffAdd     = "add"
+	diffRemove  = "remove"
+	diffReplace = "replace"
+)
+
+// imageChange is a difference between two images, from the first to the
+// second. Path is a JSON pointer into the fields of the images, such as
+// /BlockDeviceMappings/~1dev~1xvda/VolumeSize.
+type imageChange struct {
+	Op   string
+	Path string
+	Old  any `json:",omitempty"`
+	New  any `json:",omitempty"`
+}
+
+func runDiff(args []string) {
+	var query string
+	var withSnapshots bool
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.BoolVar(&withSnapshots, "snapshots", false, "also report the snapshot IDs of the block devices, which differ between any two images")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. [?starts_with(Path, '/Tags/')])")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	if fs.NArg() != 2 {
+		fatal("usage: amimati diff [flags] <image ID> <image ID>")
+	}
+
+	ctx := context.Background()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	client := ec2.NewFromConfig(cfg)
+
+	a, err := describeImage(ctx, client, fs.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	b, err := describeImage(ctx, client, fs.Arg(1))
+	if err != nil {
+		fatal(err)
+	}
+	printResult(diffImages(a, b, withSnapshots), query)
+}
+
+// diffImages returns the changes from image a to image b: of their
+// attributes, of the EBS mappings of their block devices by device name and
+// of their tags. Snapshot IDs are left out unless withSnapshots.
+func diffImages(a, b types.Image, withSnapshots bool) []imageChange {
+	changes := []imageChange{}
+	diffFields(&changes, "", imageFields(a), imageFields(b))
+
+	devicesA, devicesB := deviceFields(a, withSnapshots), deviceFields(b, withSnapshots)
+	for _, device := range unionKeys(devicesA, devicesB) {
+		path := "/BlockDeviceMappings/" + jsonPointerEscape(device)
+		fa, okA := devicesA[device]
+		fb, okB := devicesB[device]
+		switch {
+		case !okA:
+			changes = append(changes, imageChange{Op: diffAdd, Path: path, New: fb})
+		case !okB:
+			changes = append(changes, imageChange{Op: diffRemove, Path: path, Old: fa})
+		default:
+			diffFields(&changes, path, fa, fb)
+		}
+	}
+
+	diffFields(&changes, "/Tags", tagFields(a.Tags), tagFields(b.Tags))
+	return changes
+}
+
+// diffFields appends the changes from fields a to fields b under the path.
+func diffFields(changes *[]imageChange, path string, a, b map[string]any) {
+	for _, k := range unionKeys(a, b) {
+		va, okA := a[k]
+		vb, okB := b[k]
+		p := path + "/" + jsonPointerEscape(k)
+		switch {
+		case !okA:
+			*changes = append(*changes, imageChange{Op: diffAdd, Path: p, New: vb})
+		case !okB:
+			*changes = append(*changes, imageChange{Op: diffRemove, Path: p, Old: va})
+		case va != vb:
+			*changes = append(*changes, imageChange{Op: diffReplace, Path: p, Old: va, New: vb})
+		}
+	}
+}
+
+// imageFields returns the attributes of the image that are set.
+func imageFields(image types.Image) map[string]any {
+	f := map[string]any{}
+	set := func(k, v string) {
+		if v != "" {
+			f[k] = v
+		}
+	}
+	set("Name", aws.ToString(image.Name))
+	set("Description", aws.ToString(image.Description))
+	set("CreationDate", aws.ToString(image.CreationDate))
+	set("Architecture", string(image.Architecture))
+	set("BootMode", string(image.BootMode))
+	set("TpmSupport", string(image.TpmSupport))
+	set("ImdsSupport", string(image.ImdsSupport))
+	set("SriovNetSupport", aws.ToString(image.SriovNetSupport))
+	set("VirtualizationType", string(image.VirtualizationType))
+	set("RootDeviceName", aws.ToString(image.RootDeviceName))
+	set("PlatformDetails", aws.ToString(image.PlatformDetails))
+	if image.EnaSupport != nil {
+		f["EnaSupport"] = *image.EnaSupport
+	}
+	return f
+}
+
+// deviceFields returns the mappings of the block devices of the image by
+// device name.
+func deviceFields(image types.Image, withSnapshots bool) map[string]map[string]any {
+	devices := map[string]map[string]any{}
+	for _, bdm := range image.BlockDeviceMappings {
+		f := map[string]any{}
+		if bdm.VirtualName != nil {
+			f["VirtualName"] = *bdm.VirtualName
+		}
+		if bdm.NoDevice != nil {
+			f["NoDevice"] = true
+		}
+		if ebs := bdm.Ebs; ebs != nil {
+			if withSnapshots && ebs.SnapshotId != nil {
+				f["SnapshotId"] = *ebs.SnapshotId
+			}
+			if ebs.VolumeSize != nil {
+				f["VolumeSize"] = *ebs.VolumeSize
+			}
+			if ebs.VolumeType != "" {
+				f["VolumeType"] = string(ebs.VolumeType)
+			}
+			if ebs.Iops != nil {
+				f["Iops"] = *ebs.Iops
+			}
+			if ebs.Throughput != nil {
+				f["Throughput"] = *ebs.Throughput
+			}
+			if ebs.Encrypted != nil {
+				f["Encrypted"] = *ebs.Encrypted
+			}
+			if ebs.KmsKeyId != nil {
+				f["KmsKeyId"] = *ebs.KmsKeyId
+			}
+			if ebs.DeleteOnTermination != nil {
+				f["DeleteOnTermination"] = *ebs.DeleteOnTermination
+			}
+		}
+		devices[aws.ToString(bdm.DeviceName)] = f
+	}
+	return devices
+}
+
+func tagFields(ts []types.Tag) map[string]any {
+	f := map[string]any{}
+	for _, t := range ts {
+		f[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return f
+}
+
+// unionKeys returns the keys of a and b, sorted.
+func unionKeys[V any](a, b map[string]V) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range []map[string]V{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonPointerEscape escapes a key as a reference token of a JSON pointer.
+func jsonPointerEscape(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// dryRunCheck is the outcome of an API call made with DryRun set.
+type dryRunCheck struct {
+	Operation string
+	// Resource is the instance, image, snapshot or region the call is for.
+	Resource  string `json:",omitempty"`
+	Permitted bool
+	// Reason is the error the call would fail with.
+	Reason string `json:",omitempty"`
+}
+
+// dryRunReport is what a command run with -dry-run would have done, and
+// whether the caller is allowed to.
+type dryRunReport struct {
+	DryRun bool
+	// Permitted is whether every check is permitted.
+	Permitted bool
+	// InstanceId, Name, Description, ImageTags and SnapshotTags are those of
+	// the image create would have created.
+	InstanceId   string `json:",omitempty"`
+	Name         string `json:",omitempty"`
+	Description  string `json:",omitempty"`
+	ImageTags    tags   `json:",omitempty"`
+	SnapshotTags tags   `json:",omitempty"`
+	Checks       []dryRunCheck
+}
+
+func newDryRunReport() *dryRunReport {
+	return &dryRunReport{DryRun: true, Permitted: true, Checks: []dryRunCheck{}}
+}
+
+// check records the outcome of a call made with DryRun set. The
+// DryRunOperation error means the call would have succeeded, and any other
+// API error, such as UnauthorizedOperation, the reason it would not. Errors
+// not returned by the API, such as network errors, are returned.
+func (r *dryRunReport) check(operation, resource string, err error) error {
+	c := dryRunCheck{Operation: operation, Resource: resource}
+	var apiErr smithy.APIError
+	switch {
+	case err == nil:
+		c.Permitted = true
+	case !errors.As(err, &apiErr):
+		return err
+	case apiErr.ErrorCode() == "DryRunOperation":
+		c.Permitted = true
+	default:
+		c.Reason = apiErr.ErrorCode() + ": " + apiErr.ErrorMessage()
+	}
+	r.Checks = append(r.Checks, c)
+	r.Permitted = r.Permitted && c.Permitted
+	return nil
+}
+
+// dryRunCopy checks whether the image can be copied into each region.
+func dryRunCopy(ctx context.Context, cfg aws.Config, imageID string, regions []string) (*dryRunReport, error) {
+	if err := checkSamePartition(cfg.Region, regions); err != nil {
+		return nil, err
+	}
+	image, err := describeImage(ctx, ec2.NewFromConfig(cfg), imageID)
+	if err != nil {
+		return nil, err
+	}
+	r := newDryRunReport()
+	for _, region := range regions {
+		_, err := regionalClient(cfg, region).CopyImage(ctx, &ec2.CopyImageInput{
+			DryRun:        aws.Bool(true),
+			Name:          image.Name,
+			SourceImageId: image.ImageId,
+			SourceRegion:  aws.String(cfg.Region),
+		})
+		if err := r.check("CopyImage", region, err); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// dryRunDelete records in r whether the image can be deregistered and, unless
+// keepSnapshots, its snapshots deleted.
+func dryRunDelete(ctx context.Context, client *ec2.Client, r *dryRunReport, image types.Image, keepSnapshots bool) error {
+	_, err := client.DeregisterImage(ctx, &ec2.DeregisterImageInput{DryRun: aws.Bool(true), ImageId: image.ImageId})
+	if err := r.check("DeregisterImage", *image.ImageId, err); err != nil {
+		return err
+	}
+	if keepSnapshots {
+		return nil
+	}
+	for _, bdm := range image.BlockDeviceMappings {
+		if bdm.Ebs == nil || bdm.Ebs.SnapshotId == nil {
+			continue
+		}
+		_, err := client.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{DryRun: aws.Bool(true), SnapshotId: bdm.Ebs.SnapshotId})
+		if err := r.check("DeleteSnapshot", *bdm.Ebs.SnapshotId, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
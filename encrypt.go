@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// unencryptedSuffix is appended to the name of the intermediate image created
+// from the instance when the image is encrypted with -kms-key-id, as names
+// are unique in a region.
+const unencryptedSuffix = "-unencrypted"
+
+// encryptImage copies the image in its region under the name, encrypting its
+// snapshots with the KMS key, and waits for the copy to be available. The
+// user tags and the attributes of the image are kept; snapshotTags are
+// applied to the snapshots of the copy.
+func encryptImage(ctx context.Context, client *ec2.Client, region string, image types.Image, name, keyID string, snapshotTags tags, verbose bool) (types.Image, error) {
+	var ts []types.TagSpecification
+	if len(snapshotTags) > 0 {
+		ts = append(ts, types.TagSpecification{ResourceType: types.ResourceTypeSnapshot, Tags: snapshotTags})
+	}
+	out, err := client.CopyImage(ctx, &ec2.CopyImageInput{
+		Name:              aws.String(name),
+		Description:       image.Description,
+		SourceImageId:     image.ImageId,
+		SourceRegion:      aws.String(region),
+		Encrypted:         aws.Bool(true),
+		KmsKeyId:          aws.String(keyID),
+		CopyImageTags:     aws.Bool(true),
+		TagSpecifications: ts,
+	})
+	if err != nil {
+		return types.Image{}, fmt.Errorf("error encrypting image %s: %w", *image.ImageId, err)
+	}
+	trace.state(*out.ImageId, "encrypting", map[string]any{"sourceImageId": *image.ImageId, "kmsKeyId": keyID})
+	if verbose {
+		logf("encrypting image %s with %s as %s", *image.ImageId, keyID, *out.ImageId)
+	}
+	runStatus.image(*out.ImageId)
+	runState.image(*out.ImageId)
+	encrypted, err := waitImageAvailable(ctx, client, *out.ImageId, verbose)
+	if err != nil {
+		return types.Image{}, err
+	}
+	return applyImageAttributes(ctx, client, encrypted, imageAttributesOf(image), verbose)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+type snapshotEncryption struct {
+	DeviceName  string
+	SnapshotId  string
+	Encrypted   bool
+	KmsKeyId    string `json:",omitempty"`
+	KmsKeyAlias string `json:",omitempty"`
+	ConsoleUrl  string `json:",omitempty"`
+}
+
+// describeEncryption reports, per block device, whether its snapshot is
+// encrypted and with which KMS key.
+func describeEncryption(ctx context.Context, kmsClient *kms.Client, snapshots []deviceSnapshot) []snapshotEncryption {
+	aliases := map[string]string{}
+	var result []snapshotEncryption
+	for _, ds := range snapshots {
+		s := ds.snapshot
+		e := snapshotEncryption{DeviceName: ds.deviceName, SnapshotId: *s.SnapshotId, Encrypted: aws.ToBool(s.Encrypted)}
+		if s.KmsKeyId != nil {
+			e.KmsKeyId = *s.KmsKeyId
+			alias, ok := aliases[e.KmsKeyId]
+			if !ok {
+				alias = lookupKeyAlias(ctx, kmsClient, e.KmsKeyId)
+				aliases[e.KmsKeyId] = alias
+			}
+			e.KmsKeyAlias = alias
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// lookupKeyAlias returns the first alias of the key, or an empty string when
+// the key has none or the caller is not allowed to list them.
+func lookupKeyAlias(ctx context.Context, kmsClient *kms.Client, keyID string) string {
+	out, err := kmsClient.ListAliases(ctx, &kms.ListAliasesInput{KeyId: &keyID})
+	if err != nil || len(out.Aliases) == 0 || out.Aliases[0].AliasName == nil {
+		return ""
+	}
+	return *out.Aliases[0].AliasName
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+
+	"github.com/otama-jaccy/amimati/pkg/amimati"
+)
+
+// Exit statuses, documented so that wrapper scripts can branch on the kind of
+// failure.
+const (
+	exitFailure = 1
+	// exitValidation is a command line that is invalid, found before any
+	// AWS request is made.
+	exitValidation = 2
+	// exitAWS is a request rejected by AWS, such as a denied or throttled
+	// call.
+	exitAWS = 3
+	// exitTimeout is a run that did not finish within its -timeout.
+	exitTimeout = 4
+	// exitSnapshot is a snapshot of the image that failed.
+	exitSnapshot = 5
+	// exitPartial is a batch in which some, but not all, of the runs failed
+	// under the error policy.
+	exitPartial = 6
+	// exitAborted is a run aborted by SIGINT or SIGTERM, the status shells
+	// report for a process killed by SIGINT.
+	exitAborted = 130
+)
+
+// validating is set until the AWS config of the command is loaded: failures
+// until then are errors of the command line.
+var validating = true
+
+// errorExitCode is the exit status of a failure with err.
+func errorExitCode(err error) int {
+	var snapshotErr *amimati.SnapshotError
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &snapshotErr):
+		return exitSnapshot
+	case errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout
+	case errors.As(err, &apiErr):
+		return exitAWS
+	case validating:
+		return exitValidation
+	}
+	return exitFailure
+}
+
+// failure describes the error that ended a command, for the json output
+// format.
+type failure struct {
+	Message    string
+	ExitStatus int
+	// Phase is the phase the run was in when it failed, if it got that far.
+	Phase string `json:",omitempty"`
+	// ErrorCode and Fault are those of the AWS error, if the failure is a
+	// request rejected by AWS.
+	ErrorCode string `json:",omitempty"`
+	Fault     string `json:",omitempty"`
+	// RequestIds are the IDs of the failed AWS requests, for AWS support.
+	RequestIds []string `json:",omitempty"`
+}
+
+func failureOf(code int, err error) *failure {
+	f := &failure{Message: err.Error(), ExitStatus: code, Phase: lastPhase()}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		f.ErrorCode = apiErr.ErrorCode()
+		f.Fault = apiErr.ErrorFault().String()
+	}
+	f.RequestIds = requestIDs(err)
+	return f
+}
+
+// requestIDs returns the IDs of the AWS requests that failed with err, in
+// order.
+func requestIDs(err error) []string {
+	var ids []string
+	walkErrors(err, func(e error) {
+		if re, ok := e.(*awshttp.ResponseError); ok && re.ServiceRequestID() != "" && !slices.Contains(ids, re.ServiceRequestID()) {
+			ids = append(ids, re.ServiceRequestID())
+		}
+	})
+	return ids
+}
+
+// walkErrors calls fn with err and every error it wraps, including each of
+// the errors joined with errors.Join.
+func walkErrors(err error, fn func(error)) {
+	if err == nil {
+		return
+	}
+	fn(err)
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		walkErrors(e.Unwrap(), fn)
+	case interface{ Unwrap() []error }:
+		for _, err := range e.Unwrap() {
+			walkErrors(err, fn)
+		}
+	}
+}
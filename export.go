@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// exportResult is the result of export.
+type exportResult struct {
+	ExportImageTaskId string
+	ImageId           string
+	Format            string
+	S3Bucket          string
+	S3Key             string
+	// S3Uri is the s3:// URI of the exported disk image.
+	S3Uri    string
+	Status   string
+	Started  time.Time
+	Finished time.Time `json:",omitempty"`
+	Duration string    `json:",omitempty"`
+}
+
+func runExport(args []string) {
+	var imageID, bucket, prefix, format, roleName, query string
+	var noWait, verbose bool
+	var timeout time.Duration
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.StringVar(&imageID, "image-id", "", "ID of the image to export")
+	fs.StringVar(&bucket, "s3-bucket", "", "S3 bucket to export the disk image to")
+	fs.StringVar(&prefix, "s3-prefix", "", "prefix of the key of the exported disk image(eg. exports/)")
+	fs.StringVar(&format, "format", "vmdk", "disk image format: vmdk, raw or vhd")
+	fs.StringVar(&roleName, "role-name", "", "IAM role VM Import/Export assumes to write to the bucket(default: vmimport)")
+	fs.BoolVar(&noWait, "no-wait", false, "print the export task once started instead of waiting for it to complete")
+	fs.BoolVar(&verbose, "v", false, "verbose output")
+	fs.DurationVar(&timeout, "timeout", 0, "give up and exit with status "+strconv.Itoa(exitTimeout)+" if the export has not completed after this long, leaving it running(eg. 6h; default: no limit)")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. S3Uri)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	if imageID == "" {
+		fatal("image ID is required")
+	}
+	if bucket == "" {
+		fatal("S3 bucket is required")
+	}
+	diskFormat, err := parseDiskImageFormat(format)
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	res, err := exportImage(ctx, ec2.NewFromConfig(cfg), imageID, diskFormat, bucket, prefix, roleName, !noWait, verbose)
+	if err != nil {
+		exitWith(exitCode(ctx, err), err)
+	}
+	printResult(res, query)
+}
+
+func parseDiskImageFormat(s string) (types.DiskImageFormat, error) {
+	for _, f := range types.DiskImageFormat("").Values() {
+		if strings.EqualFold(s, string(f)) {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("invalid disk image format: %s", s)
+}
+
+// exportImage exports the image to the S3 bucket as a disk image in the
+// format with ExportImage and, if wait, polls the export task until it has
+// completed. The task is tagged with the image ID and the run ID.
+func exportImage(ctx context.Context, client *ec2.Client, imageID string, format types.DiskImageFormat, bucket, prefix, roleName string, wait, verbose bool) (*exportResult, error) {
+	in := &ec2.ExportImageInput{
+		ImageId:         &imageID,
+		DiskImageFormat: format,
+		S3ExportLocation: &types.ExportTaskS3LocationRequest{
+			S3Bucket: &bucket,
+		},
+		Description: aws.String(fmt.Sprintf("Exported by amimati %s from %s", toolVersion(), imageID)),
+		TagSpecifications: []types.TagSpecification{{
+			ResourceType: types.ResourceTypeExportImageTask,
+			Tags:         append([]types.Tag{{Key: aws.String(sourceImageTagKey), Value: &imageID}}, runIDTags()...),
+		}},
+	}
+	if prefix != "" {
+		in.S3ExportLocation.S3Prefix = &prefix
+	}
+	if roleName != "" {
+		in.RoleName = &roleName
+	}
+	started := time.Now()
+	out, err := client.ExportImage(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("error exporting image %s: %w", imageID, err)
+	}
+	id := aws.ToString(out.ExportImageTaskId)
+	// The disk image is written to the prefix, named after the task.
+	key := prefix + id + "." + strings.ToLower(string(format))
+	res := &exportResult{
+		ExportImageTaskId: id,
+		ImageId:           imageID,
+		Format:            string(format),
+		S3Bucket:          bucket,
+		S3Key:             key,
+		S3Uri:             "s3://" + bucket + "/" + key,
+		Status:            aws.ToString(out.Status),
+		Started:           started,
+	}
+	if verbose {
+		logf("exporting image %s to %s as task %s", imageID, res.S3Uri, id)
+	}
+	if !wait {
+		return res, nil
+	}
+
+	setPhase("waiting for export")
+	var w waitLog
+	for {
+		out, err := client.DescribeExportImageTasks(ctx, &ec2.DescribeExportImageTasksInput{ExportImageTaskIds: []string{id}})
+		if err != nil {
+			return nil, fmt.Errorf("error describing export image task %s: %w", id, err)
+		}
+		if len(out.ExportImageTasks) == 0 {
+			return nil, fmt.Errorf("export image task %s not found", id)
+		}
+		task := out.ExportImageTasks[0]
+		res.Status = aws.ToString(task.Status)
+		switch res.Status {
+		case "completed":
+			res.Finished = time.Now()
+			res.Duration = humanDuration(res.Finished.Sub(started))
+			return res, nil
+		case "deleting", "deleted":
+			return nil, fmt.Errorf("export image task %s of image %s failed: %s", id, imageID, aws.ToString(task.StatusMessage))
+		}
+		if verbose {
+			w.logf("waiting for export image task %s: %s, progress: %s%%", id, aws.ToString(task.StatusMessage), aws.ToString(task.Progress))
+		}
+		if err := sleepPoll(ctx); err != nil {
+			return nil, fmt.Errorf("export image task %s of image %s has not completed: %w", id, imageID, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// defaultFastLaunchResources is the number of pre-provisioned snapshots kept
+// ready when -fast-launch is given without a count.
+const defaultFastLaunchResources = 5
+
+// fastLaunchFlag is a flag that is either a boolean or a target resource
+// count, as in -fast-launch or -fast-launch=10.
+type fastLaunchFlag int32
+
+func (f *fastLaunchFlag) String() string {
+	return strconv.Itoa(int(*f))
+}
+
+func (f *fastLaunchFlag) Set(value string) error {
+	if b, err := strconv.ParseBool(value); err == nil {
+		*f = 0
+		if b {
+			*f = defaultFastLaunchResources
+		}
+		return nil
+	}
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid target resource count: %s", value)
+	}
+	*f = fastLaunchFlag(n)
+	return nil
+}
+
+func (f *fastLaunchFlag) IsBoolFlag() bool { return true }
+
+type fastLaunchResult struct {
+	TargetResourceCount int32
+	State               string
+}
+
+// enableFastLaunch enables Fast Launch on the Windows image with the target
+// number of pre-provisioned snapshots and waits until it is enabled.
+func enableFastLaunch(ctx context.Context, client *ec2.Client, image types.Image, target int32, verbose bool) (*fastLaunchResult, error) {
+	if image.Platform != types.PlatformValuesWindows {
+		return nil, fmt.Errorf("fast launch requires a Windows image, %s is %s", *image.ImageId, aws.ToString(image.PlatformDetails))
+	}
+	if _, err := client.EnableFastLaunch(ctx, &ec2.EnableFastLaunchInput{
+		ImageId:               image.ImageId,
+		ResourceType:          aws.String(string(types.FastLaunchResourceTypeSnapshot)),
+		SnapshotConfiguration: &types.FastLaunchSnapshotConfigurationRequest{TargetResourceCount: &target},
+	}); err != nil {
+		return nil, fmt.Errorf("error enabling fast launch on image %s: %w", *image.ImageId, err)
+	}
+
+	var lastState types.FastLaunchStateCode
+	for {
+		out, err := client.DescribeFastLaunchImages(ctx, &ec2.DescribeFastLaunchImagesInput{ImageIds: []string{*image.ImageId}})
+		if err != nil {
+			return nil, fmt.Errorf("error describing fast launch of image %s: %w", *image.ImageId, err)
+		}
+		if len(out.FastLaunchImages) == 0 {
+			return nil, fmt.Errorf("fast launch of image %s not found", *image.ImageId)
+		}
+		fl := out.FastLaunchImages[0]
+		if fl.State != lastState {
+			trace.state(*image.ImageId, "fast-launch "+string(fl.State), nil)
+			lastState = fl.State
+			if verbose {
+				logf("fast launch of image %s: %s", *image.ImageId, fl.State)
+			}
+		}
+		switch fl.State {
+		case types.FastLaunchStateCodeEnabled:
+			return &fastLaunchResult{TargetResourceCount: target, State: string(fl.State)}, nil
+		case types.FastLaunchStateCodeEnabling:
+		default:
+			return nil, fmt.Errorf("fast launch of image %s is %s: %s", *image.ImageId, fl.State, aws.ToString(fl.StateTransitionReason))
+		}
+		time.Sleep(15 * time.Second)
+	}
+}
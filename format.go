@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	outputJSON  = "json"
+	outputID    = "id"
+	outputTable = "table"
+	outputYAML  = "yaml"
+)
+
+// outputFormat is the format printResult writes the result in.
+var outputFormat = outputJSON
+
+// quiet is set by -quiet: only the image IDs of the result are written, and
+// only errors are logged.
+var quiet bool
+
+// addOutputFlag adds the -o and -quiet flags to the flag set.
+func addOutputFlag(fs *flag.FlagSet) {
+	fs.StringVar(&outputFormat, "o", outputJSON, "output format of the result(json, id, table or yaml)")
+	fs.BoolVar(&quiet, "quiet", false, "write only the image IDs of the result, as -o id, and log only errors; the exit status tells the kind of failure")
+}
+
+func validateFormat(format string) error {
+	switch format {
+	case outputJSON, outputID, outputTable, outputYAML:
+		return nil
+	}
+	return fmt.Errorf("invalid output format: %s", format)
+}
+
+// writeResult writes the decoded JSON document doc to w in the format.
+func writeResult(w io.Writer, doc any, format string) error {
+	switch format {
+	case outputID:
+		ids := resultIDs(doc)
+		if len(ids) == 0 {
+			return fmt.Errorf("no image ID in the result, select the IDs with -query")
+		}
+		for _, id := range ids {
+			fmt.Fprintln(w, id)
+		}
+		return nil
+	case outputTable:
+		return writeTable(w, doc)
+	case outputYAML:
+		o, err := yaml.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("error marshalling result: %w", err)
+		}
+		_, err = w.Write(o)
+		return err
+	}
+	if s, ok := doc.(string); ok {
+		_, err := fmt.Fprintln(w, s)
+		return err
+	}
+	o, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("error marshalling result: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "%s\n", o)
+	return err
+}
+
+// resultIDs returns the IDs of the document: a string is an ID itself, an
+// array has the IDs of its elements, and an object has its ImageId, or else
+// the IDs of its Result.
+func resultIDs(doc any) []string {
+	switch d := doc.(type) {
+	case string:
+		return []string{d}
+	case []any:
+		var ids []string
+		for _, e := range d {
+			ids = append(ids, resultIDs(e)...)
+		}
+		return ids
+	case map[string]any:
+		if id, ok := d["ImageId"].(string); ok {
+			return []string{id}
+		}
+		if r, ok := d["Result"]; ok {
+			return resultIDs(r)
+		}
+	}
+	return nil
+}
+
+// writeTable writes an array of objects as a table with a column per key, an
+// object as a table of its keys and values, and anything else one value per
+// line. Nested values are written as JSON.
+func writeTable(w io.Writer, doc any) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	switch d := doc.(type) {
+	case map[string]any:
+		for _, k := range sortedKeys(d) {
+			fmt.Fprintf(tw, "%s\t%s\n", k, tableCell(d[k]))
+		}
+	case []any:
+		rows := make([]map[string]any, 0, len(d))
+		columns := map[string]any{}
+		for _, e := range d {
+			row, ok := e.(map[string]any)
+			if !ok {
+				rows = nil
+				break
+			}
+			for k := range row {
+				columns[k] = nil
+			}
+			rows = append(rows, row)
+		}
+		if rows == nil {
+			for _, e := range d {
+				fmt.Fprintln(tw, tableCell(e))
+			}
+			break
+		}
+		keys := sortedKeys(columns)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(keys, "\t")))
+		for _, row := range rows {
+			cells := make([]string, len(keys))
+			for i, k := range keys {
+				cells[i] = tableCell(row[k])
+			}
+			fmt.Fprintln(tw, strings.Join(cells, "\t"))
+		}
+	default:
+		fmt.Fprintln(tw, tableCell(d))
+	}
+	return tw.Flush()
+}
+
+func tableCell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	o, _ := json.Marshal(v)
+	return string(o)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// fsrResult reports Fast Snapshot Restore on the snapshots of the image.
+type fsrResult struct {
+	AvailabilityZones []string
+	// States holds the state of each snapshot by availability zone, as last
+	// described: enabled once waited for, usually enabling otherwise.
+	States map[string]map[string]string
+}
+
+// enableFastSnapshotRestores enables Fast Snapshot Restore on every snapshot
+// of the image in the availability zones and, if wait, waits until it is
+// enabled everywhere. Fast Snapshot Restore is billed per snapshot and zone
+// while enabled.
+func enableFastSnapshotRestores(ctx context.Context, client *ec2.Client, image types.Image, zones []string, wait, verbose bool) (*fsrResult, error) {
+	ids := imageSnapshotIDs(image)
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("image %s has no EBS snapshots", *image.ImageId)
+	}
+	out, err := client.EnableFastSnapshotRestores(ctx, &ec2.EnableFastSnapshotRestoresInput{
+		AvailabilityZones: zones,
+		SourceSnapshotIds: ids,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error enabling fast snapshot restores of image %s: %w", *image.ImageId, err)
+	}
+	var errs []string
+	for _, e := range out.Unsuccessful {
+		for _, fe := range e.FastSnapshotRestoreStateErrors {
+			if fe.Error != nil {
+				errs = append(errs, fmt.Sprintf("%s in %s: %s", aws.ToString(e.SnapshotId), aws.ToString(fe.AvailabilityZone), aws.ToString(fe.Error.Message)))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("error enabling fast snapshot restores of image %s: %v", *image.ImageId, errs)
+	}
+
+	res := &fsrResult{AvailabilityZones: zones, States: map[string]map[string]string{}}
+	for _, s := range out.Successful {
+		res.state(s.SnapshotId, s.AvailabilityZone, s.State)
+	}
+	if !wait {
+		return res, nil
+	}
+
+	var w waitLog
+	for {
+		out, err := client.DescribeFastSnapshotRestores(ctx, &ec2.DescribeFastSnapshotRestoresInput{
+			Filters: []types.Filter{{Name: aws.String("snapshot-id"), Values: ids}, {Name: aws.String("availability-zone"), Values: zones}},
+		})
+		if err != nil {
+			return res, fmt.Errorf("error describing fast snapshot restores of image %s: %w", *image.ImageId, err)
+		}
+		pending := 0
+		for _, s := range out.FastSnapshotRestores {
+			res.state(s.SnapshotId, s.AvailabilityZone, s.State)
+			switch s.State {
+			case types.FastSnapshotRestoreStateCodeEnabled:
+			case types.FastSnapshotRestoreStateCodeEnabling, types.FastSnapshotRestoreStateCodeOptimizing:
+				pending++
+			default:
+				return res, fmt.Errorf("fast snapshot restore of %s in %s is %s: %s", aws.ToString(s.SnapshotId), aws.ToString(s.AvailabilityZone), s.State, aws.ToString(s.StateTransitionReason))
+			}
+		}
+		if pending == 0 && len(out.FastSnapshotRestores) >= len(ids)*len(zones) {
+			return res, nil
+		}
+		if verbose {
+			w.logf("waiting for fast snapshot restores of image %s: %d of %d enabled", *image.ImageId, len(out.FastSnapshotRestores)-pending, len(ids)*len(zones))
+		}
+		if err := sleepPoll(ctx); err != nil {
+			return res, fmt.Errorf("fast snapshot restores of image %s are not enabled: %w", *image.ImageId, err)
+		}
+	}
+}
+
+func (r *fsrResult) state(snapshotID, zone *string, state types.FastSnapshotRestoreStateCode) {
+	id := aws.ToString(snapshotID)
+	if r.States[id] == nil {
+		r.States[id] = map[string]string{}
+	}
+	r.States[id][aws.ToString(zone)] = string(state)
+}
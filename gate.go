@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	approvalTagKey = "amimati:approval"
+
+	approvalApproved = "approved"
+	approvalPending  = "pending-approval"
+)
+
+type gateResult struct {
+	Command  string
+	ExitCode int
+	Approved bool
+}
+
+// runGate runs the gate command through the shell with the JSON payload on
+// its stdin and AMIMATI_IMAGE_ID and AMIMATI_RUN_ID set. The command approves the image by
+// exiting 0; its output goes to stderr to keep stdout for the result.
+func runGate(ctx context.Context, command, imageID string, payload any) (*gateResult, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding gate payload: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "AMIMATI_IMAGE_ID="+imageID, "AMIMATI_RUN_ID="+runID)
+
+	res := &gateResult{Command: command}
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		res.Approved = true
+	case errors.As(err, &exitErr):
+		res.ExitCode = exitErr.ExitCode()
+	default:
+		return nil, fmt.Errorf("error running gate command: %w", err)
+	}
+	return res, nil
+}
+
+// tagApproval tags the images with approved or pending-approval.
+func tagApproval(ctx context.Context, client *ec2.Client, imageIDs []string, approved bool) error {
+	val := approvalPending
+	if approved {
+		val = approvalApproved
+	}
+	if _, err := client.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: imageIDs,
+		Tags:      []types.Tag{{Key: aws.String(approvalTagKey), Value: aws.String(val)}},
+	}); err != nil {
+		return fmt.Errorf("error tagging images %v: %w", imageIDs, err)
+	}
+	return nil
+}
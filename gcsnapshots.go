@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// snapshotImagePattern matches the description EC2 gives the snapshots it
+// creates for an image, by CreateImage or CopyImage, capturing the image ID.
+var snapshotImagePattern = regexp.MustCompile(`^(?:Created by CreateImage\([^)]*\) for|Copied for DestinationAmi) (ami-[0-9a-f]+)`)
+
+// snapshotImageID returns the ID of the image the snapshot was created for,
+// or "" when its description names none.
+func snapshotImageID(snapshot types.Snapshot) string {
+	m := snapshotImagePattern.FindStringSubmatch(aws.ToString(snapshot.Description))
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+type gcReport struct {
+	DryRun bool `json:",omitempty"`
+	// Scanned is the number of snapshots created for an image, of which
+	// Orphaned have lost it and Deleted were deleted.
+	Scanned  int
+	Orphaned int
+	Deleted  int
+	Failed   int `json:",omitempty"`
+	// SizeGiB and MonthlyCost total the orphaned snapshots.
+	SizeGiB     int32
+	MonthlyCost float64
+	Snapshots   []gcSnapshot
+}
+
+type gcSnapshot struct {
+	SnapshotId string
+	// ImageId is the image the snapshot was created for, which no longer
+	// exists.
+	ImageId     string
+	SizeGiB     int32
+	StorageTier string
+	StartTime   time.Time
+	Age         string
+	MonthlyCost float64
+	// RecycleBinExitTime is when the deleted snapshot leaves the Recycle Bin
+	// for good, if a rule retains it.
+	RecycleBinExitTime *time.Time `json:",omitempty"`
+	Error              string     `json:",omitempty"`
+}
+
+// write prints the orphaned snapshots for a person to review.
+func (r gcReport) write(w io.Writer) {
+	for _, s := range r.Snapshots {
+		fmt.Fprintf(w, "- delete %s (%d GiB, %s), started %s ago: image %s no longer exists\n", s.SnapshotId, s.SizeGiB, s.StorageTier, s.Age, s.ImageId)
+	}
+	fmt.Fprintf(w, "%d snapshots (%d GiB, about $%.2f a month) to delete\n", len(r.Snapshots), r.SizeGiB, r.MonthlyCost)
+}
+
+func runGCSnapshots(args []string) {
+	var dryRun, autoApprove, includeUntagged bool
+	var olderThan, query string
+	var snapshotPrice float64
+	var tagFilters filterTags
+	fs := flag.NewFlagSet("gc-snapshots", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.Var(&tagFilters, "tag", "only consider snapshots with these tags(eg. env=prod)")
+	fs.BoolVar(&includeUntagged, "include-untagged", false, "also consider the snapshots of images not created by amimati, which lack the "+runIDTagKey+" tag")
+	fs.StringVar(&olderThan, "older-than", "", "only delete orphaned snapshots started longer ago than this(eg. 30d)")
+	fs.BoolVar(&dryRun, "dry-run", false, "report the orphaned snapshots without deleting them")
+	fs.BoolVar(&autoApprove, "auto-approve", false, "delete without showing the orphaned snapshots and asking to confirm it on the terminal")
+	fs.Float64Var(&snapshotPrice, "snapshot-price", 0, "USD per GiB-month of standard snapshot storage, to estimate MonthlyCost(eg. 0.05; default: the list price of the region)")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. Snapshots[].SnapshotId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+
+	if snapshotPrice < 0 {
+		fatal("snapshot price must not be negative")
+	}
+	var cutoff time.Time
+	if olderThan != "" {
+		d, err := parseDuration(olderThan)
+		if err != nil {
+			fatal(err)
+		}
+		cutoff = time.Now().Add(-d)
+	}
+
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	client := ec2.NewFromConfig(cfg)
+
+	// The snapshots are listed before the images, so that the image of a
+	// snapshot created meanwhile is listed too.
+	snapshots, err := imageSnapshots(ctx, client, tagFilters, includeUntagged)
+	if err != nil {
+		fatal(err)
+	}
+	images, err := describeOwnImagesIncludingDisabled(ctx, client)
+	if err != nil {
+		fatal(err)
+	}
+	recycled, err := imagesInRecycleBin(ctx, client)
+	if err != nil {
+		fatal(err)
+	}
+
+	now := time.Now()
+	cost := costEstimator{price: snapshotPrice}
+	report := gcReport{DryRun: dryRun, Scanned: len(snapshots), Snapshots: []gcSnapshot{}}
+	for _, s := range orphanedSnapshots(snapshots, images, recycled, cutoff) {
+		r := gcSnapshot{
+			SnapshotId:  *s.SnapshotId,
+			ImageId:     snapshotImageID(s),
+			SizeGiB:     aws.ToInt32(s.VolumeSize),
+			StorageTier: string(s.StorageTier),
+			StartTime:   aws.ToTime(s.StartTime),
+			Age:         humanDuration(now.Sub(aws.ToTime(s.StartTime))),
+		}
+		r.MonthlyCost = math.Round(float64(r.SizeGiB)*cost.perGiB(cfg.Region, s.StorageTier)*100) / 100
+		report.SizeGiB += r.SizeGiB
+		report.MonthlyCost += r.MonthlyCost
+		report.Snapshots = append(report.Snapshots, r)
+	}
+	report.Orphaned = len(report.Snapshots)
+	report.MonthlyCost = math.Round(report.MonthlyCost*100) / 100
+	logf("%d of %d snapshots have lost their image: %d GiB, about $%.2f a month", report.Orphaned, report.Scanned, report.SizeGiB, report.MonthlyCost)
+
+	if !dryRun && !autoApprove && report.Orphaned > 0 {
+		if err := confirmPlan(report); err != nil {
+			fatal(err)
+		}
+	}
+
+	var failed error
+	if !dryRun {
+		var deleted []string
+		for i := range report.Snapshots {
+			r := &report.Snapshots[i]
+			if _, err := client.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{SnapshotId: &r.SnapshotId}); err != nil {
+				err = fmt.Errorf("error deleting snapshot %s: %w", r.SnapshotId, err)
+				warnf("%v", err)
+				failed = err
+				r.Error = err.Error()
+				report.Failed++
+				if ctx.Err() != nil {
+					break
+				}
+				continue
+			}
+			report.Deleted++
+			deleted = append(deleted, r.SnapshotId)
+		}
+		exits := snapshotsInRecycleBin(ctx, client, deleted)
+		for i := range report.Snapshots {
+			report.Snapshots[i].RecycleBinExitTime = exits[report.Snapshots[i].SnapshotId]
+		}
+	}
+
+	printResult(report, query)
+	if failed != nil {
+		os.Exit(exitCode(ctx, failed))
+	}
+}
+
+// imageSnapshots returns the snapshots owned by the account that were created
+// for an image and carry the tags, tagged by amimati unless includeUntagged.
+func imageSnapshots(ctx context.Context, client *ec2.Client, tagFilters filterTags, includeUntagged bool) ([]types.Snapshot, error) {
+	var filters []types.Filter
+	if !includeUntagged {
+		filters = append(filters, types.Filter{Name: aws.String("tag-key"), Values: []string{runIDTagKey}})
+	}
+	for _, t := range tagFilters {
+		filters = append(filters, types.Filter{Name: aws.String("tag:" + aws.ToString(t.Key)), Values: []string{aws.ToString(t.Value)}})
+	}
+	var snapshots []types.Snapshot
+	p := ec2.NewDescribeSnapshotsPaginator(client, &ec2.DescribeSnapshotsInput{OwnerIds: []string{"self"}, Filters: filters})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error describing snapshots: %w", err)
+		}
+		for _, s := range out.Snapshots {
+			if snapshotImageID(s) != "" {
+				snapshots = append(snapshots, s)
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+// describeOwnImagesIncludingDisabled returns the images owned by the caller,
+// with the deprecated and disabled ones, which still use their snapshots.
+func describeOwnImagesIncludingDisabled(ctx context.Context, client *ec2.Client) ([]types.Image, error) {
+	in := &ec2.DescribeImagesInput{Owners: []string{"self"}, IncludeDeprecated: aws.Bool(true), IncludeDisabled: aws.Bool(true)}
+	var images []types.Image
+	p := ec2.NewDescribeImagesPaginator(client, in)
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error describing images: %w", err)
+		}
+		images = append(images, out.Images...)
+	}
+	return images, nil
+}
+
+// imagesInRecycleBin returns the IDs of the deregistered images retained by
+// a Recycle Bin rule, which need their snapshots to be restored.
+func imagesInRecycleBin(ctx context.Context, client *ec2.Client) (map[string]bool, error) {
+	ids := map[string]bool{}
+	p := ec2.NewListImagesInRecycleBinPaginator(client, &ec2.ListImagesInRecycleBinInput{})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing images in the Recycle Bin: %w", err)
+		}
+		for _, image := range out.Images {
+			ids[aws.ToString(image.ImageId)] = true
+		}
+	}
+	return ids, nil
+}
+
+// snapshotsInRecycleBin returns when each deleted snapshot retained by a
+// Recycle Bin rule leaves it. It returns what it could list when the caller
+// is not allowed to list the Recycle Bin.
+func snapshotsInRecycleBin(ctx context.Context, client *ec2.Client, snapshotIds []string) map[string]*time.Time {
+	exits := map[string]*time.Time{}
+	if len(snapshotIds) == 0 {
+		return exits
+	}
+	p := ec2.NewListSnapshotsInRecycleBinPaginator(client, &ec2.ListSnapshotsInRecycleBinInput{SnapshotIds: snapshotIds})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return exits
+		}
+		for _, s := range out.Snapshots {
+			exits[aws.ToString(s.SnapshotId)] = s.RecycleBinExitTime
+		}
+	}
+	return exits
+}
+
+// orphanedSnapshots returns the snapshots whose image no longer exists and
+// that no other image is registered from, started before the cutoff unless
+// it is zero. Snapshots still being created are left alone, as are those of
+// images in the Recycle Bin.
+func orphanedSnapshots(snapshots []types.Snapshot, images []types.Image, recycled map[string]bool, cutoff time.Time) []types.Snapshot {
+	existing := map[string]bool{}
+	used := map[string]bool{}
+	for _, image := range images {
+		existing[aws.ToString(image.ImageId)] = true
+		for _, id := range imageSnapshotIDs(image) {
+			used[id] = true
+		}
+	}
+	var orphaned []types.Snapshot
+	for _, s := range snapshots {
+		imageID := snapshotImageID(s)
+		switch {
+		case existing[imageID] || used[*s.SnapshotId]:
+		case s.State == types.SnapshotStatePending:
+		case !cutoff.IsZero() && !aws.ToTime(s.StartTime).Before(cutoff):
+		case recycled[imageID]:
+			warnf("keeping snapshot %s, its image %s is in the Recycle Bin", *s.SnapshotId, imageID)
+		default:
+			orphaned = append(orphaned, s)
+		}
+	}
+	return orphaned
+}
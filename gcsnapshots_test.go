@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestSnapshotImageID(t *testing.T) {
+	for _, tc := range []struct {
+		description, want string
+	}{
+		{"Created by CreateImage(i-0123456789abcdef0) for ami-0123456789abcdef0", "ami-0123456789abcdef0"},
+		{"Created by CreateImage(i-0123456789abcdef0) for ami-0123456789abcdef0 from vol-0123456789abcdef0", "ami-0123456789abcdef0"},
+		{"Copied for DestinationAmi ami-0aaaaaaaaaaaaaaaa from SourceAmi ami-0bbbbbbbbbbbbbbbb for SourceSnapshot snap-0123456789abcdef0. Task created on 1,700,000,000,000.", "ami-0aaaaaaaaaaaaaaaa"},
+		{"amimati snapshot of i-0123456789abcdef0", ""},
+		{"nightly backup for ami-0123456789abcdef0", ""},
+		{"", ""},
+	} {
+		if got := snapshotImageID(types.Snapshot{Description: aws.String(tc.description)}); got != tc.want {
+			t.Errorf("snapshotImageID(%q) = %q, want %q", tc.description, got, tc.want)
+		}
+	}
+}
+
+func TestOrphanedSnapshots(t *testing.T) {
+	now := time.Now()
+	snapshot := func(id, imageID string, age time.Duration, state types.SnapshotState) types.Snapshot {
+		return types.Snapshot{
+			SnapshotId:  aws.String(id),
+			Description: aws.String("Created by CreateImage(i-1) for " + imageID),
+			StartTime:   aws.Time(now.Add(-age)),
+			State:       state,
+		}
+	}
+	day := 24 * time.Hour
+	snapshots := []types.Snapshot{
+		snapshot("snap-live", "ami-0000000000000000a", 40*day, types.SnapshotStateCompleted),
+		snapshot("snap-gone", "ami-0000000000000000b", 40*day, types.SnapshotStateCompleted),
+		snapshot("snap-failed", "ami-0000000000000000c", 40*day, types.SnapshotStateError),
+		snapshot("snap-young", "ami-0000000000000000d", day, types.SnapshotStateCompleted),
+		snapshot("snap-pending", "ami-0000000000000000e", 40*day, types.SnapshotStatePending),
+		snapshot("snap-registered", "ami-0000000000000000f", 40*day, types.SnapshotStateCompleted),
+		snapshot("snap-recycled", "ami-00000000000000011", 40*day, types.SnapshotStateCompleted),
+	}
+	images := []types.Image{
+		{ImageId: aws.String("ami-0000000000000000a"), BlockDeviceMappings: []types.BlockDeviceMapping{{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-live")}}}},
+		{ImageId: aws.String("ami-00000000000000010"), BlockDeviceMappings: []types.BlockDeviceMapping{{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-registered")}}}},
+	}
+	recycled := map[string]bool{"ami-00000000000000011": true}
+
+	ids := func(snapshots []types.Snapshot) []string {
+		var ids []string
+		for _, s := range snapshots {
+			ids = append(ids, *s.SnapshotId)
+		}
+		return ids
+	}
+	if got, want := ids(orphanedSnapshots(snapshots, images, recycled, now.Add(-30*day))), []string{"snap-gone", "snap-failed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("older than 30 days: got %v, want %v", got, want)
+	}
+	if got, want := ids(orphanedSnapshots(snapshots, images, recycled, time.Time{})), []string{"snap-gone", "snap-failed", "snap-young"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("without a cutoff: got %v, want %v", got, want)
+	}
+}
+
+func TestCostPerGiB(t *testing.T) {
+	c := costEstimator{}
+	if got := c.perGiB("us-west-1", types.StorageTierStandard); got != 0.055 {
+		t.Errorf("standard tier in us-west-1: got %v, want 0.055", got)
+	}
+	if got := c.perGiB("xx-nowhere-1", types.StorageTierArchive); got != defaultSnapshotPrice*archiveSnapshotPriceRatio {
+		t.Errorf("archive tier in an unknown region: got %v", got)
+	}
+	if got := (&costEstimator{price: 0.1}).perGiB("us-east-1", types.StorageTierStandard); got != 0.1 {
+		t.Errorf("overridden price: got %v, want 0.1", got)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ANSI escapes of the human log format.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// useColor reports whether the human log format colors its lines: when
+// stderr is a terminal, unless disabled by -no-color or by setting NO_COLOR
+// (https://no-color.org).
+func useColor(noColor bool) bool {
+	return !noColor && os.Getenv("NO_COLOR") == "" && stderrIsTerminal()
+}
+
+// humanHandler writes the log lines for a person watching the run rather
+// than for a log pipeline: the time and message, then the attributes, with
+// warnings and errors marked, and each phase of the run as a header that is
+// checked off with its duration once the next one starts.
+type humanHandler struct {
+	*humanOutput
+	level slog.Leveler
+	attrs []slog.Attr
+}
+
+// humanOutput is the state shared by a humanHandler and those derived from
+// it with WithAttrs.
+type humanOutput struct {
+	w     io.Writer
+	color bool
+
+	mu           sync.Mutex
+	phase        string
+	phaseStarted time.Time
+}
+
+func newHumanHandler(w io.Writer, level slog.Leveler, color bool) *humanHandler {
+	return &humanHandler{humanOutput: &humanOutput{w: w, color: color}, level: level}
+}
+
+func (h *humanHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *humanHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(h.paint(ansiDim, r.Time.Format("15:04:05")))
+	b.WriteByte(' ')
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString(h.paint(ansiRed+ansiBold, "✗ error: "+r.Message))
+	case r.Level >= slog.LevelWarn:
+		b.WriteString(h.paint(ansiYellow, "! warning: "+r.Message))
+	case r.Level < slog.LevelInfo:
+		b.WriteString(h.paint(ansiDim, r.Message))
+	default:
+		b.WriteString(r.Message)
+	}
+	var attrs []string
+	add := func(a slog.Attr) bool {
+		// The run ID is in the first header; it would repeat on every line.
+		if a.Key != "run_id" && !a.Equal(slog.Attr{}) {
+			attrs = append(attrs, a.Key+"="+a.Value.String())
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+	if len(attrs) > 0 {
+		b.WriteByte(' ')
+		b.WriteString(h.paint(ansiDim, strings.Join(attrs, " ")))
+	}
+	b.WriteByte('\n')
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *humanHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &humanHandler{humanOutput: h.humanOutput, level: h.level, attrs: append(append([]slog.Attr{}, h.attrs...), attrs...)}
+}
+
+// WithGroup is not used by amimati, whose attributes are flat; the group is
+// dropped.
+func (h *humanHandler) WithGroup(string) slog.Handler { return h }
+
+// enter checks off the phase the run was in and writes the header of the one
+// it entered, or, for done, failed and rejected, the outcome of the run.
+func (o *humanOutput) enter(name string, now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var b strings.Builder
+	if o.phase != "" {
+		d := now.Sub(o.phaseStarted).Round(100 * time.Millisecond)
+		if name == "failed" || name == "rejected" {
+			fmt.Fprintf(&b, "%s %s\n", o.paint(ansiRed, "✗ "+o.phase), o.paint(ansiDim, d.String()))
+		} else {
+			fmt.Fprintf(&b, "%s %s\n", o.paint(ansiGreen, "✓ "+o.phase), o.paint(ansiDim, d.String()))
+		}
+	}
+	switch name {
+	case "done":
+		fmt.Fprintf(&b, "%s\n", o.paint(ansiGreen+ansiBold, "✓ done in "+now.Sub(runStarted).Round(time.Second).String()))
+	case "failed", "rejected":
+		fmt.Fprintf(&b, "%s\n", o.paint(ansiRed+ansiBold, "✗ "+name+" after "+now.Sub(runStarted).Round(time.Second).String()))
+	default:
+		header := "▸ " + name
+		if o.phase == "" && runID != "" {
+			header += o.paint(ansiDim, " (run "+runID+")")
+		}
+		fmt.Fprintf(&b, "%s\n", o.paint(ansiCyan+ansiBold, header))
+	}
+	o.phase, o.phaseStarted = name, now
+	if terminalPhases[name] {
+		o.phase = ""
+	}
+	io.WriteString(o.w, b.String())
+}
+
+// paint colors s with the escape, if the output is colored.
+func (o *humanOutput) paint(escape, s string) string {
+	if !o.color {
+		return s
+	}
+	return escape + s + ansiReset
+}
+
+// humanPhase writes the header of the phase with the human log format.
+func humanPhase(name string) {
+	if h, ok := logger.Handler().(*humanHandler); ok {
+		h.enter(name, time.Now())
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHumanHandler(t *testing.T) {
+	var b strings.Builder
+	h := newHumanHandler(&b, slog.LevelInfo, false)
+	l := slog.New(h).With("run_id", "r-1")
+	l.Info("created image", "image_id", "ami-1")
+	l.Warn("slow snapshot")
+	l.Error("timed out")
+	l.Debug("hidden")
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	want := []string{" created image image_id=ami-1", " ! warning: slow snapshot", " ✗ error: timed out"}
+	if len(lines) != len(want) {
+		t.Fatalf("got lines %q, want %d", lines, len(want))
+	}
+	for i, line := range lines {
+		// The lines start with the time of day.
+		if len(line) < 8 || line[8:] != want[i] {
+			t.Errorf("line %d is %q, want the time then %q", i, line, want[i])
+		}
+	}
+}
+
+func TestHumanPhases(t *testing.T) {
+	var b strings.Builder
+	h := newHumanHandler(&b, slog.LevelInfo, false)
+	now := time.Now()
+	h.enter("describing instance", now)
+	h.enter("waiting for snapshots", now.Add(1500*time.Millisecond))
+	h.enter("done", now.Add(3*time.Second))
+	got := b.String()
+	for _, want := range []string{"▸ describing instance\n", "✓ describing instance 1.5s\n", "▸ waiting for snapshots\n", "✓ waiting for snapshots 1.5s\n", "✓ done in "} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output %q lacks %q", got, want)
+		}
+	}
+
+	b.Reset()
+	h.enter("verifying image", now)
+	h.enter("rejected", now.Add(time.Second))
+	if got := b.String(); !strings.Contains(got, "✗ verifying image 1s\n") || !strings.Contains(got, "✗ rejected after ") {
+		t.Errorf("output %q does not mark the rejection", got)
+	}
+}
+
+func TestHumanColor(t *testing.T) {
+	var b strings.Builder
+	h := newHumanHandler(&b, slog.LevelInfo, true)
+	if err := h.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelError, "boom", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), ansiRed) {
+		t.Errorf("error line %q is not red", b.String())
+	}
+	t.Setenv("NO_COLOR", "1")
+	if useColor(false) {
+		t.Error("NO_COLOR does not turn the colors off")
+	}
+}
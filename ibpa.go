@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+type ibpaRegion struct {
+	Region string
+	State  string `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+func runIBPA(args []string) {
+	if len(args) == 0 || (args[0] != "status" && args[0] != "enable") {
+		fatal("usage: amimati ibpa status|enable [-regions ...]")
+	}
+	action := args[0]
+
+	var regions []string
+	var query string
+	fs := flag.NewFlagSet("ibpa "+action, flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.Var((*list)(&regions), "regions", "regions to "+action+" image block public access in(eg. us-east-1,us-west-2; default: the configured region)")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. [].State)")
+	addOutputFlag(fs)
+	fs.Parse(args[1:])
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+
+	ctx := context.Background()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	if len(regions) == 0 {
+		regions = []string{cfg.Region}
+	}
+
+	result := make([]ibpaRegion, len(regions))
+	var wg sync.WaitGroup
+	for i, region := range regions {
+		wg.Add(1)
+		go func(i int, region string) {
+			defer wg.Done()
+			client := regionalClient(cfg, region)
+			var state string
+			var err error
+			if action == "enable" {
+				state, err = enableIBPA(ctx, client)
+			} else {
+				state, err = ibpaState(ctx, client)
+			}
+			result[i] = ibpaRegion{Region: region, State: state}
+			if err != nil {
+				result[i].Error = err.Error()
+			}
+		}(i, region)
+	}
+	wg.Wait()
+
+	printResult(result, query)
+	for _, r := range result {
+		if r.Error != "" {
+			os.Exit(1)
+		}
+	}
+}
+
+// ibpaState returns the image block public access state of the region of the
+// client: "block-new-sharing" or "unblocked".
+func ibpaState(ctx context.Context, client *ec2.Client) (string, error) {
+	out, err := client.GetImageBlockPublicAccessState(ctx, &ec2.GetImageBlockPublicAccessStateInput{})
+	if err != nil {
+		return "", fmt.Errorf("error getting image block public access state: %w", err)
+	}
+	return aws.ToString(out.ImageBlockPublicAccessState), nil
+}
+
+func enableIBPA(ctx context.Context, client *ec2.Client) (string, error) {
+	out, err := client.EnableImageBlockPublicAccess(ctx, &ec2.EnableImageBlockPublicAccessInput{
+		ImageBlockPublicAccessState: types.ImageBlockPublicAccessEnabledStateBlockNewSharing,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error enabling image block public access: %w", err)
+	}
+	return string(out.ImageBlockPublicAccessState), nil
+}
+
+// checkPublicSharing fails when image block public access prevents making
+// images public in the region of the client.
+func checkPublicSharing(ctx context.Context, client *ec2.Client) error {
+	state, err := ibpaState(ctx, client)
+	if err != nil {
+		return err
+	}
+	if state == string(types.ImageBlockPublicAccessEnabledStateBlockNewSharing) {
+		return fmt.Errorf("image block public access is enabled in the region, images cannot be made public")
+	}
+	return nil
+}
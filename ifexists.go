@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// The -if-exists behaviors when an image of the same name already exists.
+const (
+	ifExistsFail    = "fail"
+	ifExistsSkip    = "skip"
+	ifExistsReplace = "replace"
+	ifExistsSuffix  = "suffix"
+)
+
+// nameSuffixLayout is the layout of the timestamp appended to the name with
+// -if-exists suffix.
+const nameSuffixLayout = "20060102-150405"
+
+func validateIfExists(v string) error {
+	switch v {
+	case "", ifExistsFail, ifExistsSkip, ifExistsReplace, ifExistsSuffix:
+		return nil
+	}
+	return fmt.Errorf("invalid if-exists behavior: %s", v)
+}
+
+// checkSkippable fails when the existing image cannot be returned in place of
+// a new one.
+func checkSkippable(image types.Image) error {
+	switch image.State {
+	case types.ImageStatePending, types.ImageStateAvailable:
+		return nil
+	}
+	return fmt.Errorf("existing image %s named %s cannot be used in state %v", *image.ImageId, *image.Name, image.State)
+}
+
+// replaceImage deregisters the existing image so that its name can be reused,
+// deleting its snapshots too if deleteSnapshots is set.
+func replaceImage(ctx context.Context, client *ec2.Client, image types.Image, deleteSnapshots bool) error {
+	if deleteSnapshots {
+		_, err := pruneImage(ctx, client, image)
+		return err
+	}
+	if _, err := client.DeregisterImage(ctx, &ec2.DeregisterImageInput{ImageId: image.ImageId}); err != nil {
+		return fmt.Errorf("error deregistering image %s: %w", *image.ImageId, err)
+	}
+	return nil
+}
+
+// suffixedName appends the time to the name.
+func suffixedName(name string, t time.Time) string {
+	return name + "-" + t.UTC().Format(nameSuffixLayout)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// describeImagesPageSize is the page size DescribeImages is paged through
+// with, as it only pages when asked for a maximum number of results.
+const describeImagesPageSize = 1000
+
+// imageScope is which images a command looks at: those of the owners and,
+// with includeShared, those other accounts share with the caller. A nil
+// imageScope looks at the images owned by the caller.
+type imageScope struct {
+	owners        list
+	includeShared bool
+}
+
+func addImageScopeFlags(fs *flag.FlagSet, verb string) *imageScope {
+	s := &imageScope{}
+	fs.Var(&s.owners, "owner", "only "+verb+" images owned by these owners: self, amazon, aws-marketplace or account IDs(eg. self,123456789012; default: self)")
+	fs.BoolVar(&s.includeShared, "include-shared", false, "also "+verb+" the images other accounts have granted this one launch permission on")
+	return s
+}
+
+// ownOnly reports whether the scope is limited to the images of the caller.
+func (s *imageScope) ownOnly() bool {
+	return s == nil || !s.includeShared && (len(s.owners) == 0 || slices.Equal([]string(s.owners), []string{"self"}))
+}
+
+// describe pages through the images of the scope matching the filters, each
+// image once.
+func (s *imageScope) describe(ctx context.Context, client *ec2.Client, filters []types.Filter) ([]types.Image, error) {
+	owners := []string{"self"}
+	if s != nil && len(s.owners) > 0 {
+		owners = s.owners
+	}
+	queries := []*ec2.DescribeImagesInput{{Owners: owners, Filters: filters}}
+	if s != nil && s.includeShared {
+		queries = append(queries, &ec2.DescribeImagesInput{ExecutableUsers: []string{"self"}, Filters: filters})
+	}
+	var images []types.Image
+	seen := map[string]bool{}
+	for _, in := range queries {
+		p := ec2.NewDescribeImagesPaginator(client, in, func(o *ec2.DescribeImagesPaginatorOptions) { o.Limit = describeImagesPageSize })
+		for p.HasMorePages() {
+			out, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("error describing images: %w", err)
+			}
+			for _, image := range out.Images {
+				if !seen[*image.ImageId] {
+					seen[*image.ImageId] = true
+					images = append(images, image)
+				}
+			}
+		}
+	}
+	return images, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+)
+
+// imdsTimeout bounds the lookup, which hangs until it times out when not
+// running on EC2.
+const imdsTimeout = 5 * time.Second
+
+// selfInstanceID returns the ID of the EC2 instance amimati runs on, from
+// IMDSv2.
+func selfInstanceID(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, imdsTimeout)
+	defer cancel()
+	out, err := imds.New(imds.Options{}).GetMetadata(ctx, &imds.GetMetadataInput{Path: "instance-id"})
+	if err != nil {
+		return "", fmt.Errorf("error getting the instance ID from the instance metadata service, is amimati running on EC2?: %w", err)
+	}
+	defer out.Content.Close()
+	b, err := io.ReadAll(out.Content)
+	if err != nil {
+		return "", fmt.Errorf("error reading the instance ID from the instance metadata service: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// importTaskTagKey records the import image task an image was imported by.
+const importTaskTagKey = "amimati:import-task-id"
+
+// importOptions describe an image imported with import.
+type importOptions struct {
+	bucket, key  string
+	format       string
+	description  string
+	roleName     string
+	bootMode     string
+	kmsKeyID     string
+	imageTags    tags
+	snapshotTags tags
+}
+
+func runImport(args []string) {
+	var opt importOptions
+	var commonTags tags
+	var s3URI, query string
+	var verbose bool
+	var timeout time.Duration
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.StringVar(&s3URI, "s3-uri", "", "S3 URI of the disk image to import(eg. s3://my-bucket/images/disk.vhd)")
+	fs.StringVar(&opt.format, "format", "", "disk image format: vmdk, raw, vhd or vhdx(default: told from the extension of the key)")
+	fs.StringVar(&opt.description, "description", "", "description of the imported image(default: one naming the S3 URI)")
+	fs.StringVar(&opt.roleName, "role-name", "", "IAM role VM Import/Export assumes to read the bucket(default: vmimport)")
+	fs.StringVar(&opt.bootMode, "boot-mode", "", "boot mode of the imported image: uefi or legacy-bios(default: told from the disk image)")
+	fs.StringVar(&opt.kmsKeyID, "kms-key-id", "", "encrypt the snapshots of the imported image with this KMS key(eg. alias/ebs)")
+	fs.Var(&commonTags, "tag", "tags of both the image and its snapshots, repeatable; -image-tag and -snapshot-tag override them by key(eg. env=prod)")
+	fs.Var(&opt.imageTags, "image-tag", "image tags, repeatable(eg. env=prod)")
+	fs.Var(&opt.snapshotTags, "snapshot-tag", "snapshot tags, repeatable(eg. env=prod)")
+	fs.BoolVar(&verbose, "v", false, "verbose output")
+	fs.DurationVar(&timeout, "timeout", 0, "give up and exit with status "+strconv.Itoa(exitTimeout)+" if the import has not completed after this long, leaving it running(eg. 6h; default: no limit)")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. ImageId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+	opt.imageTags = mergeTags(commonTags, opt.imageTags)
+	opt.snapshotTags = mergeTags(commonTags, opt.snapshotTags)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	var err error
+	if opt.bucket, opt.key, err = parseS3URI(s3URI); err != nil {
+		fatal(err)
+	}
+	if opt.format == "" {
+		opt.format = strings.TrimPrefix(path.Ext(opt.key), ".")
+	}
+	switch opt.format = strings.ToLower(opt.format); opt.format {
+	case "vmdk", "raw", "vhd", "vhdx":
+	case "img":
+		opt.format = "raw"
+	default:
+		fatalf("invalid disk image format, use -format: %s", opt.format)
+	}
+	switch opt.bootMode {
+	case "", string(types.BootModeValuesUefi), string(types.BootModeValuesLegacyBios):
+	default:
+		fatalf("invalid boot mode: %s", opt.bootMode)
+	}
+
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	res, err := importImage(ctx, cfg, opt, verbose)
+	if err != nil {
+		exitWith(exitCode(ctx, err), err)
+	}
+	printResult(res, query)
+}
+
+// parseS3URI splits an s3://bucket/key URI.
+func parseS3URI(s string) (bucket, key string, err error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "s3" || u.Host == "" || strings.TrimPrefix(u.Path, "/") == "" {
+		return "", "", fmt.Errorf("invalid S3 URI, want s3://bucket/key: %s", s)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// importImage imports the disk image in S3 as an image with ImportImage,
+// waits for the import task and then for the image to be available, and tags
+// the image and its snapshots. The result is that of create.
+func importImage(ctx context.Context, cfg aws.Config, opt importOptions, verbose bool) (*result, error) {
+	started := time.Now()
+	client := ec2.NewFromConfig(cfg)
+	uri := "s3://" + opt.bucket + "/" + opt.key
+	description := opt.description
+	if description == "" {
+		description = fmt.Sprintf("Imported by amimati %s from %s", toolVersion(), uri)
+	}
+	in := &ec2.ImportImageInput{
+		Description: &description,
+		DiskContainers: []types.ImageDiskContainer{{
+			Format:     &opt.format,
+			UserBucket: &types.UserBucket{S3Bucket: &opt.bucket, S3Key: &opt.key},
+		}},
+	}
+	if len(runIDTags()) > 0 {
+		in.TagSpecifications = []types.TagSpecification{{ResourceType: types.ResourceTypeImportImageTask, Tags: runIDTags()}}
+	}
+	if opt.roleName != "" {
+		in.RoleName = &opt.roleName
+	}
+	if opt.bootMode != "" {
+		in.BootMode = types.BootModeValues(opt.bootMode)
+	}
+	if opt.kmsKeyID != "" {
+		in.Encrypted = aws.Bool(true)
+		in.KmsKeyId = &opt.kmsKeyID
+	}
+	setPhase("importing image")
+	out, err := client.ImportImage(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("error importing %s: %w", uri, err)
+	}
+	id := aws.ToString(out.ImportTaskId)
+	if verbose {
+		logf("importing %s as task %s", uri, id)
+	}
+
+	var imageID string
+	var w waitLog
+	for imageID == "" {
+		out, err := client.DescribeImportImageTasks(ctx, &ec2.DescribeImportImageTasksInput{ImportTaskIds: []string{id}})
+		if err != nil {
+			return nil, fmt.Errorf("error describing import image task %s: %w", id, err)
+		}
+		if len(out.ImportImageTasks) == 0 {
+			return nil, fmt.Errorf("import image task %s not found", id)
+		}
+		task := out.ImportImageTasks[0]
+		switch aws.ToString(task.Status) {
+		case "completed":
+			imageID = aws.ToString(task.ImageId)
+			continue
+		case "deleting", "deleted":
+			return nil, fmt.Errorf("import image task %s of %s failed: %s", id, uri, aws.ToString(task.StatusMessage))
+		}
+		if verbose {
+			w.logf("waiting for import image task %s: %s, progress: %s%%", id, aws.ToString(task.StatusMessage), aws.ToString(task.Progress))
+		}
+		if err := sleepPoll(ctx); err != nil {
+			return nil, fmt.Errorf("import image task %s of %s has not completed: %w", id, uri, err)
+		}
+	}
+	runStatus.image(imageID)
+
+	setPhase("waiting for image")
+	image, err := waitImageAvailable(ctx, client, imageID, verbose)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := describeImageSnapshots(ctx, client, image)
+	if err != nil {
+		return nil, err
+	}
+	t := mergeTags(append(tags{{Key: aws.String(importTaskTagKey), Value: &id}}, runIDTags()...), opt.imageTags)
+	if _, err := client.CreateTags(ctx, &ec2.CreateTagsInput{Resources: []string{imageID}, Tags: t}); err != nil {
+		return nil, fmt.Errorf("error tagging image %s: %w", imageID, err)
+	}
+	image.Tags = mergeTags(image.Tags, t)
+	if len(snapshots) > 0 && len(opt.snapshotTags) > 0 {
+		ids := make([]string, 0, len(snapshots))
+		for _, ds := range snapshots {
+			ids = append(ids, *ds.snapshot.SnapshotId)
+		}
+		if _, err := client.CreateTags(ctx, &ec2.CreateTagsInput{Resources: ids, Tags: opt.snapshotTags}); err != nil {
+			return nil, fmt.Errorf("error tagging snapshots of image %s: %w", imageID, err)
+		}
+	}
+	return newResult(ctx, cfg, image, snapshots, started), nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// batchJob is an image to create, read from the -input of create. The fields
+// left empty are those of the command line.
+type batchJob struct {
+	instanceID   string
+	name         string
+	description  string
+	imageTags    tags
+	snapshotTags tags
+	expireAfter  string
+	noReboot     *bool
+	// excludeDevices, if set, replace the -exclude-device values, so that
+	// jobs of the same instance can image different subsets of its volumes.
+	excludeDevices []string
+}
+
+// jsonBatchJob is a job of a JSON -input.
+type jsonBatchJob struct {
+	InstanceId     string            `json:"instanceId"`
+	Name           string            `json:"name"`
+	Description    string            `json:"description"`
+	ImageTags      map[string]string `json:"imageTags"`
+	SnapshotTags   map[string]string `json:"snapshotTags"`
+	ExpireAfter    string            `json:"expireAfter"`
+	NoReboot       *bool             `json:"noReboot"`
+	ExcludeDevices []string          `json:"excludeDevices"`
+}
+
+// jobResult is the NDJSON line written once a job of the -input has ended.
+type jobResult struct {
+	// Index is the position of the job in the input, from 0.
+	Index int
+	instanceResult
+}
+
+// readBatchJobs reads the jobs of the file, or of stdin if path is "-": a
+// JSON array of objects, or CSV whose header names the columns instanceId,
+// name, description, imageTags, snapshotTags, expireAfter, noReboot and
+// excludeDevices. CSV tags are written as -image-tag values, and the devices
+// of excludeDevices separated by semicolons.
+func readBatchJobs(path string) ([]batchJob, error) {
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading input: %w", err)
+	}
+	var jobs []batchJob
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '[' {
+		jobs, err = parseJSONJobs(trimmed)
+	} else {
+		jobs, err = parseCSVJobs(b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, errors.New("input has no jobs")
+	}
+	for i, j := range jobs {
+		if j.instanceID == "" {
+			return nil, fmt.Errorf("invalid input job %d: instance ID is required", i)
+		}
+		if j.expireAfter != "" {
+			if _, err := parseDuration(j.expireAfter); err != nil {
+				return nil, fmt.Errorf("invalid input job %d: %w", i, err)
+			}
+		}
+	}
+	return jobs, nil
+}
+
+func parseJSONJobs(b []byte) ([]batchJob, error) {
+	var raw []jsonBatchJob
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+	jobs := make([]batchJob, 0, len(raw))
+	for i, r := range raw {
+		j := batchJob{instanceID: r.InstanceId, name: r.Name, description: r.Description, expireAfter: r.ExpireAfter, noReboot: r.NoReboot, excludeDevices: r.ExcludeDevices}
+		if err := setTagMap(&j.imageTags, r.ImageTags); err != nil {
+			return nil, fmt.Errorf("invalid input job %d: %w", i, err)
+		}
+		if err := setTagMap(&j.snapshotTags, r.SnapshotTags); err != nil {
+			return nil, fmt.Errorf("invalid input job %d: %w", i, err)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// setTagMap sets the tags of the map, in the order of their keys, checking
+// them as the tags of a flag.
+func setTagMap(t *tags, m map[string]string) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := t.Set(formatTags([]types.Tag{{Key: aws.String(k), Value: aws.String(m[k])}})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseCSVJobs(b []byte) ([]batchJob, error) {
+	r := csv.NewReader(bytes.NewReader(b))
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing input: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	header := rows[0]
+	for _, c := range header {
+		switch c {
+		case "instanceId", "name", "description", "imageTags", "snapshotTags", "expireAfter", "noReboot", "excludeDevices":
+		default:
+			return nil, fmt.Errorf("invalid input column: %s", c)
+		}
+	}
+	jobs := make([]batchJob, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		var j batchJob
+		for k, v := range row {
+			if v == "" {
+				continue
+			}
+			switch header[k] {
+			case "instanceId":
+				j.instanceID = v
+			case "name":
+				j.name = v
+			case "description":
+				j.description = v
+			case "imageTags":
+				err = j.imageTags.Set(v)
+			case "snapshotTags":
+				err = j.snapshotTags.Set(v)
+			case "expireAfter":
+				j.expireAfter = v
+			case "noReboot":
+				var b bool
+				b, err = strconv.ParseBool(v)
+				j.noReboot = &b
+			case "excludeDevices":
+				j.excludeDevices = strings.Split(v, ";")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid input job %d: %s: %w", i, header[k], err)
+			}
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// options returns the options of the command line with the fields of the
+// job.
+func (j batchJob) options(opt options) (options, error) {
+	opt.instanceID = j.instanceID
+	opt.instance = instanceSelector{}
+	if j.name != "" {
+		opt.imageName = j.name
+	}
+	if opt.imageName == "" {
+		return opt, errors.New("image name is required")
+	}
+	if j.description != "" {
+		opt.description = j.description
+		opt.autoDescribe = false
+	}
+	opt.imageTags = mergeTags(append(tags{}, opt.imageTags...), j.imageTags)
+	opt.snapshotTags = mergeTags(append(tags{}, opt.snapshotTags...), j.snapshotTags)
+	if j.expireAfter != "" {
+		opt.expireAfter = j.expireAfter
+	}
+	if j.noReboot != nil {
+		opt.noReboot = *j.noReboot
+	}
+	if j.excludeDevices != nil {
+		opt.excludeDevices = j.excludeDevices
+	}
+	return opt, nil
+}
+
+// checkSharedInstances checks the jobs imaging the same instance, such as an
+// image of its root volume and another of all its volumes: with jobs run
+// concurrently, none of them may reboot the instance, which the CreateImage
+// of the others would find stopping.
+func checkSharedInstances(jobs []batchJob, noReboot bool, concurrency int) error {
+	if concurrency <= 1 {
+		return nil
+	}
+	first := map[string]int{}
+	for i, j := range jobs {
+		k, ok := first[j.instanceID]
+		if !ok {
+			first[j.instanceID] = i
+			continue
+		}
+		if reboots(jobs[k], noReboot) || reboots(j, noReboot) {
+			return fmt.Errorf("invalid input jobs %d and %d: both image %s concurrently, which cannot reboot it: set noReboot, or -concurrency 1", k, i, j.instanceID)
+		}
+	}
+	return nil
+}
+
+// reboots reports whether the job reboots its instance, with noReboot the
+// value of -no-reboot.
+func reboots(j batchJob, noReboot bool) bool {
+	if j.noReboot != nil {
+		return !*j.noReboot
+	}
+	return !noReboot
+}
+
+// createImageJobs runs createImage once per job, at most concurrency at a
+// time, writing a jobResult line to stdout as each job ends, and returns the
+// report of the batch.
+func createImageJobs(ctx context.Context, cfg aws.Config, jobs []batchJob, concurrency int, opt options, policy errorPolicy) batchReport {
+	var mu sync.Mutex
+	emit := func(r jobResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		o, err := json.Marshal(r)
+		if err != nil {
+			warnf("error marshalling the result of input job %d: %v", r.Index, err)
+			return
+		}
+		fmt.Printf("%s\n", o)
+	}
+	started := make([]bool, len(jobs))
+	if concurrency > 1 {
+		cfg = batchDescribes(cfg)
+	}
+	outcomes := runBatch(ctx, len(jobs), concurrency, policy, func(ctx context.Context, i int) error {
+		started[i] = true
+		r := jobResult{Index: i, instanceResult: instanceResult{InstanceId: jobs[i].instanceID}}
+		o, err := jobs[i].options(opt)
+		if err == nil {
+			r.Result, err = createImage(ctx, cfg, o)
+		}
+		if err != nil {
+			r.Error = err.Error()
+			r.Cancelled = errors.Is(err, context.Canceled) && ctx.Err() != nil
+		}
+		emit(r)
+		return err
+	})
+	for i, o := range outcomes {
+		if !started[i] {
+			emit(jobResult{Index: i, instanceResult: instanceResult{InstanceId: jobs[i].instanceID, Error: o.err.Error(), Cancelled: true}})
+		}
+	}
+	return newBatchReport(policy, outcomes)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseJobsExcludeDevices(t *testing.T) {
+	jsonJobs, err := parseJSONJobs([]byte(`[{"instanceId": "i-1", "name": "web-os", "excludeDevices": ["/dev/xvdb", "/dev/xvdc"]}, {"instanceId": "i-1", "name": "web-full"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	csvJobs, err := parseCSVJobs([]byte("instanceId,name,excludeDevices\ni-1,web-os,/dev/xvdb;/dev/xvdc\ni-1,web-full,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, jobs := range [][]batchJob{jsonJobs, csvJobs} {
+		if want := []string{"/dev/xvdb", "/dev/xvdc"}; !reflect.DeepEqual(jobs[0].excludeDevices, want) {
+			t.Errorf("first job excludes %v, want %v", jobs[0].excludeDevices, want)
+		}
+		if jobs[1].excludeDevices != nil {
+			t.Errorf("second job excludes %v, want the devices of the command line", jobs[1].excludeDevices)
+		}
+	}
+
+	opt := options{imageName: "default", excludeDevices: []string{"/dev/xvdz"}}
+	o, err := jsonJobs[0].options(opt)
+	if err != nil || !reflect.DeepEqual(o.excludeDevices, []string{"/dev/xvdb", "/dev/xvdc"}) {
+		t.Errorf("got %v, %v, want the devices of the job", o.excludeDevices, err)
+	}
+	if o, _ := jsonJobs[1].options(opt); !reflect.DeepEqual(o.excludeDevices, []string{"/dev/xvdz"}) {
+		t.Errorf("got %v, want the devices of the command line", o.excludeDevices)
+	}
+}
+
+func TestCheckSharedInstances(t *testing.T) {
+	yes, no := true, false
+	split := []batchJob{{instanceID: "i-1", noReboot: &yes}, {instanceID: "i-2"}, {instanceID: "i-1", noReboot: &yes}}
+	for _, tc := range []struct {
+		name        string
+		jobs        []batchJob
+		noReboot    bool
+		concurrency int
+		ok          bool
+	}{
+		{"no reboot", split, false, 4, true},
+		{"sequential", []batchJob{{instanceID: "i-1"}, {instanceID: "i-1"}}, false, 1, true},
+		{"reboot", []batchJob{{instanceID: "i-1"}, {instanceID: "i-1"}}, false, 4, false},
+		{"-no-reboot", []batchJob{{instanceID: "i-1"}, {instanceID: "i-1"}}, true, 4, true},
+		{"one job reboots", []batchJob{{instanceID: "i-1"}, {instanceID: "i-1"}, {instanceID: "i-1", noReboot: &no}}, true, 4, false},
+	} {
+		if err := checkSharedInstances(tc.jobs, tc.noReboot, tc.concurrency); (err == nil) != tc.ok {
+			t.Errorf("%s: got error %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+}
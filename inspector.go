@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/inspector2"
+	inspectortypes "github.com/aws/aws-sdk-go-v2/service/inspector2/types"
+)
+
+const inspectorTagKey = "amimati:inspector"
+
+// severities in increasing order.
+var severities = []inspectortypes.Severity{
+	inspectortypes.SeverityInformational,
+	inspectortypes.SeverityLow,
+	inspectortypes.SeverityMedium,
+	inspectortypes.SeverityHigh,
+	inspectortypes.SeverityCritical,
+}
+
+func parseSeverity(s string) (inspectortypes.Severity, error) {
+	for _, v := range severities {
+		if string(v) == s {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("invalid severity: %s", s)
+}
+
+func severityRank(s inspectortypes.Severity) int {
+	for i, v := range severities {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+type inspectorOptions struct {
+	throwawayOptions
+	threshold inspectortypes.Severity
+	timeout   time.Duration
+}
+
+type inspectorResult struct {
+	InstanceId string
+	Findings   map[string]int
+	Threshold  string
+	Passed     bool
+}
+
+// inspectImage launches a short-lived instance from the image, waits for
+// Amazon Inspector to scan it and counts its active findings by severity. The
+// image fails when it has any finding at or above the threshold; it is tagged
+// with the outcome either way.
+func inspectImage(ctx context.Context, cfg aws.Config, imageID string, opt inspectorOptions, verbose bool) (*inspectorResult, error) {
+	client := ec2.NewFromConfig(cfg)
+	instanceID, err := launchThrowaway(ctx, client, imageID, "inspector", opt.throwawayOptions, opt.timeout)
+	if instanceID != "" {
+		defer func() {
+			if terr := terminateThrowaway(ctx, client, instanceID); terr != nil {
+				warnf("%v", terr)
+			}
+		}()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	insp := inspector2.NewFromConfig(cfg)
+	if err := waitInspectorScan(ctx, insp, instanceID, opt.timeout, verbose); err != nil {
+		return nil, err
+	}
+
+	res := &inspectorResult{InstanceId: instanceID, Findings: map[string]int{}, Threshold: string(opt.threshold), Passed: true}
+	p := inspector2.NewListFindingsPaginator(insp, &inspector2.ListFindingsInput{FilterCriteria: &inspectortypes.FilterCriteria{
+		ResourceId:    []inspectortypes.StringFilter{{Comparison: inspectortypes.StringComparisonEquals, Value: &instanceID}},
+		FindingStatus: []inspectortypes.StringFilter{{Comparison: inspectortypes.StringComparisonEquals, Value: aws.String(string(inspectortypes.FindingStatusActive))}},
+	}})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing findings: %w", err)
+		}
+		for _, f := range out.Findings {
+			res.Findings[string(f.Severity)]++
+			if severityRank(f.Severity) >= severityRank(opt.threshold) {
+				res.Passed = false
+			}
+		}
+	}
+
+	outcome := "passed"
+	if !res.Passed {
+		outcome = "failed"
+	}
+	if _, err := client.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{imageID},
+		Tags:      []types.Tag{{Key: aws.String(inspectorTagKey), Value: aws.String(outcome)}},
+	}); err != nil {
+		return res, fmt.Errorf("error tagging image %s: %w", imageID, err)
+	}
+	return res, nil
+}
+
+// waitInspectorScan waits until Inspector covers the instance and has scanned
+// it at least once.
+func waitInspectorScan(ctx context.Context, client *inspector2.Client, instanceID string, timeout time.Duration, verbose bool) error {
+	deadline := time.Now().Add(timeout)
+	var wait waitLog
+	for {
+		out, err := client.ListCoverage(ctx, &inspector2.ListCoverageInput{FilterCriteria: &inspectortypes.CoverageFilterCriteria{
+			ResourceId: []inspectortypes.CoverageStringFilter{{Comparison: inspectortypes.CoverageStringComparisonEquals, Value: &instanceID}},
+		}})
+		if err != nil {
+			return fmt.Errorf("error listing coverage: %w", err)
+		}
+		if len(out.CoveredResources) > 0 {
+			r := out.CoveredResources[0]
+			if r.LastScannedAt != nil {
+				return nil
+			}
+			if r.ScanStatus != nil && r.ScanStatus.StatusCode == inspectortypes.ScanStatusCodeInactive && r.ScanStatus.Reason != inspectortypes.ScanStatusReasonPendingInitialScan {
+				return fmt.Errorf("inspector cannot scan instance %s: %s", instanceID, r.ScanStatus.Reason)
+			}
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the inspector scan of instance " + instanceID)
+		}
+		if verbose {
+			wait.logf("waiting for inspector to scan instance %s", instanceID)
+		}
+		time.Sleep(30 * time.Second)
+	}
+}
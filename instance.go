@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// sourceInstance records the instance an image was created from, so the
+// result stands on its own without looking the instance up again.
+type sourceInstance struct {
+	InstanceId       string
+	Name             string `json:",omitempty"`
+	InstanceType     string
+	AvailabilityZone string `json:",omitempty"`
+	PlatformDetails  string `json:",omitempty"`
+	Architecture     string `json:",omitempty"`
+	PrivateIpAddress string `json:",omitempty"`
+	SubnetId         string `json:",omitempty"`
+	VpcId            string `json:",omitempty"`
+	KeyName          string `json:",omitempty"`
+	LaunchTime       *time.Time
+	EnaSupport       bool
+	SriovNetSupport  string            `json:",omitempty"`
+	Tags             map[string]string `json:",omitempty"`
+}
+
+func newSourceInstance(instance types.Instance) *sourceInstance {
+	s := &sourceInstance{
+		InstanceId:       aws.ToString(instance.InstanceId),
+		InstanceType:     string(instance.InstanceType),
+		PlatformDetails:  aws.ToString(instance.PlatformDetails),
+		Architecture:     string(instance.Architecture),
+		PrivateIpAddress: aws.ToString(instance.PrivateIpAddress),
+		SubnetId:         aws.ToString(instance.SubnetId),
+		VpcId:            aws.ToString(instance.VpcId),
+		KeyName:          aws.ToString(instance.KeyName),
+		LaunchTime:       instance.LaunchTime,
+		EnaSupport:       aws.ToBool(instance.EnaSupport),
+		SriovNetSupport:  aws.ToString(instance.SriovNetSupport),
+	}
+	if instance.Placement != nil {
+		s.AvailabilityZone = aws.ToString(instance.Placement.AvailabilityZone)
+	}
+	for _, t := range userTags(instance.Tags) {
+		if s.Tags == nil {
+			s.Tags = map[string]string{}
+		}
+		s.Tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+		if aws.ToString(t.Key) == "Name" {
+			s.Name = aws.ToString(t.Value)
+		}
+	}
+	return s
+}
+
+// instanceTags returns the user tags of the instance whose key starts with
+// prefix.
+func instanceTags(instance types.Instance, prefix string) tags {
+	var t tags
+	for _, tt := range userTags(instance.Tags) {
+		if strings.HasPrefix(aws.ToString(tt.Key), prefix) {
+			t = append(t, tt)
+		}
+	}
+	return t
+}
+
+// mergeTags returns the base tags with those of the same key replaced by the
+// overrides.
+func mergeTags(base, overrides tags) tags {
+	keys := map[string]bool{}
+	for _, t := range overrides {
+		keys[aws.ToString(t.Key)] = true
+	}
+	var merged tags
+	for _, t := range base {
+		if !keys[aws.ToString(t.Key)] {
+			merged = append(merged, t)
+		}
+	}
+	return append(merged, overrides...)
+}
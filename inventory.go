@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+type inventory struct {
+	AgentVersion    string
+	PlatformName    string
+	PlatformVersion string
+	CaptureTime     string              `json:",omitempty"`
+	Applications    []map[string]string `json:",omitempty"`
+}
+
+// captureInventory returns the SSM inventory of the instance, or nil when the
+// instance is not an online managed node.
+func captureInventory(ctx context.Context, client *ssm.Client, instanceID string) (*inventory, error) {
+	out, err := client.DescribeInstanceInformation(ctx, &ssm.DescribeInstanceInformationInput{
+		Filters: []ssmtypes.InstanceInformationStringFilter{{Key: aws.String("InstanceIds"), Values: []string{instanceID}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing instance information: %w", err)
+	}
+	if len(out.InstanceInformationList) == 0 || out.InstanceInformationList[0].PingStatus != ssmtypes.PingStatusOnline {
+		return nil, nil
+	}
+	info := out.InstanceInformationList[0]
+	inv := &inventory{
+		AgentVersion:    aws.ToString(info.AgentVersion),
+		PlatformName:    aws.ToString(info.PlatformName),
+		PlatformVersion: aws.ToString(info.PlatformVersion),
+	}
+
+	in := &ssm.ListInventoryEntriesInput{InstanceId: &instanceID, TypeName: aws.String("AWS:Application")}
+	for {
+		out, err := client.ListInventoryEntries(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("error listing inventory entries: %w", err)
+		}
+		inv.CaptureTime = aws.ToString(out.CaptureTime)
+		inv.Applications = append(inv.Applications, out.Entries...)
+		if out.NextToken == nil {
+			return inv, nil
+		}
+		in.NextToken = out.NextToken
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// recordInventory writes an item describing the run that created the image
+// to the DynamoDB table, keyed by the ImageId string attribute. images holds
+// the image and its copies by region, and promotable whether the image passed
+// the checks of the run. The operator is the ARN of the caller, left out if
+// it cannot be told.
+func recordInventory(ctx context.Context, cfg aws.Config, table string, res *result, images map[string]string, promotable bool) error {
+	s := func(v string) ddbtypes.AttributeValue { return &ddbtypes.AttributeValueMemberS{Value: v} }
+	item := map[string]ddbtypes.AttributeValue{
+		"ImageId":   s(*res.ImageId),
+		"ImageArn":  s(res.ImageArn),
+		"Name":      s(aws.ToString(res.Name)),
+		"Region":    s(cfg.Region),
+		"AccountId": s(aws.ToString(res.OwnerId)),
+		"CreatedAt": s(res.finished.UTC().Format(time.RFC3339)),
+		"DurationSeconds": &ddbtypes.AttributeValueMemberN{
+			Value: strconv.FormatFloat(res.finished.Sub(res.started).Seconds(), 'f', 0, 64),
+		},
+	}
+	if runID != "" {
+		item["RunId"] = s(runID)
+	}
+	if res.SourceInstance != nil {
+		item["SourceInstanceId"] = s(res.SourceInstance.InstanceId)
+		if res.SourceInstance.Name != "" {
+			item["SourceInstanceName"] = s(res.SourceInstance.Name)
+		}
+	}
+	// String sets cannot be empty, so an image without snapshots has none.
+	if ids := imageSnapshotIDs(res.Image); len(ids) > 0 {
+		item["SnapshotIds"] = &ddbtypes.AttributeValueMemberSS{Value: ids}
+	}
+	tagMap := map[string]ddbtypes.AttributeValue{}
+	for _, t := range res.Tags {
+		tagMap[aws.ToString(t.Key)] = s(aws.ToString(t.Value))
+	}
+	item["Tags"] = &ddbtypes.AttributeValueMemberM{Value: tagMap}
+	copies := map[string]ddbtypes.AttributeValue{}
+	for region, id := range images {
+		copies[region] = s(id)
+	}
+	item["Images"] = &ddbtypes.AttributeValueMemberM{Value: copies}
+	item["Promotable"] = &ddbtypes.AttributeValueMemberBOOL{Value: promotable}
+	if out, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{}); err != nil {
+		warnf("recording the inventory without the operator: %v", err)
+	} else {
+		item["Operator"] = s(aws.ToString(out.Arn))
+	}
+
+	if _, err := dynamodb.NewFromConfig(cfg).PutItem(ctx, &dynamodb.PutItemInput{TableName: &table, Item: item}); err != nil {
+		return fmt.Errorf("error writing image %s to inventory table %s: %w", *res.ImageId, table, err)
+	}
+	return nil
+}
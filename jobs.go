@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	amimativ1 "github.com/otama-jaccy/amimati/api/amimati/v1"
+)
+
+// jobServer implements the gRPC JobService. Jobs run in the background of the
+// server and are kept in memory.
+type jobServer struct {
+	amimativ1.UnimplementedJobServiceServer
+
+	cfg aws.Config
+
+	mu   sync.Mutex
+	next int
+	jobs map[string]*job
+}
+
+type job struct {
+	msg    *amimativ1.Job
+	events []*amimativ1.JobEvent
+	// changed is closed and replaced whenever an event is recorded.
+	changed chan struct{}
+}
+
+func newJobServer(cfg aws.Config) *jobServer {
+	return &jobServer{cfg: cfg, jobs: map[string]*job{}}
+}
+
+func (s *jobServer) SubmitJob(ctx context.Context, req *amimativ1.SubmitJobRequest) (*amimativ1.Job, error) {
+	run, err := s.jobFunc(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	s.mu.Lock()
+	s.next++
+	id := "job-" + strconv.Itoa(s.next)
+	j := &job{
+		msg:     &amimativ1.Job{Id: id, State: amimativ1.JobState_JOB_STATE_PENDING, SubmitTime: timestamppb.Now()},
+		changed: make(chan struct{}),
+	}
+	s.jobs[id] = j
+	s.record(j, amimativ1.JobState_JOB_STATE_PENDING, "submitted")
+	msg := cloneJob(j.msg)
+	s.mu.Unlock()
+
+	go s.run(j, run)
+	return msg, nil
+}
+
+func (s *jobServer) GetJob(ctx context.Context, req *amimativ1.GetJobRequest) (*amimativ1.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[req.Id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "job %s not found", req.Id)
+	}
+	return cloneJob(j.msg), nil
+}
+
+func (s *jobServer) WatchJob(req *amimativ1.WatchJobRequest, stream amimativ1.JobService_WatchJobServer) error {
+	s.mu.Lock()
+	j, ok := s.jobs[req.Id]
+	s.mu.Unlock()
+	if !ok {
+		return status.Errorf(codes.NotFound, "job %s not found", req.Id)
+	}
+
+	sent := 0
+	for {
+		s.mu.Lock()
+		events := j.events[sent:]
+		changed := j.changed
+		done := jobDone(j.msg.State)
+		s.mu.Unlock()
+
+		for _, e := range events {
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+		sent += len(events)
+		if done {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// jobFunc validates the request and returns the function running the job.
+// The function returns the result of the job, reporting progress to the
+// event callback.
+func (s *jobServer) jobFunc(req *amimativ1.SubmitJobRequest) (func(ctx context.Context, event func(string)) (any, error), error) {
+	switch j := req.Job.(type) {
+	case *amimativ1.SubmitJobRequest_Create:
+		c := j.Create
+		if c.InstanceId == "" || c.Name == "" {
+			return nil, errors.New("create requires instance_id and name")
+		}
+		if c.ExpireAfter != "" {
+			if _, err := parseDuration(c.ExpireAfter); err != nil {
+				return nil, err
+			}
+		}
+		opt := options{
+			instanceID:   c.InstanceId,
+			imageName:    c.Name,
+			imageTags:    tagMap(c.ImageTags),
+			snapshotTags: tagMap(c.SnapshotTags),
+			expireAfter:  c.ExpireAfter,
+		}
+		return func(ctx context.Context, event func(string)) (any, error) {
+			event(fmt.Sprintf("creating image %s of instance %s", opt.imageName, opt.instanceID))
+			return createImage(ctx, s.cfg, opt)
+		}, nil
+
+	case *amimativ1.SubmitJobRequest_Copy:
+		c := j.Copy
+		if c.ImageId == "" || len(c.Regions) == 0 {
+			return nil, errors.New("copy requires image_id and regions")
+		}
+		return func(ctx context.Context, event func(string)) (any, error) {
+			image, err := waitImageAvailable(ctx, ec2.NewFromConfig(s.cfg), c.ImageId, false)
+			if err != nil {
+				return nil, err
+			}
+			if err := restoreArchivedImage(ctx, ec2.NewFromConfig(s.cfg), image, 0, false); err != nil {
+				return nil, err
+			}
+			event(fmt.Sprintf("copying image %s to %v", c.ImageId, c.Regions))
+			return copyImage(ctx, s.cfg, image, c.Regions, copyOptions{})
+		}, nil
+
+	case *amimativ1.SubmitJobRequest_Prune:
+		p := j.Prune
+		if p.Expired == (p.NamePrefix != "") {
+			return nil, errors.New("prune requires either expired or name_prefix")
+		}
+		if p.NamePrefix != "" && p.KeepLast < 1 {
+			return nil, errors.New("prune requires a positive keep_last with name_prefix")
+		}
+		return func(ctx context.Context, event func(string)) (any, error) {
+			client := ec2.NewFromConfig(s.cfg)
+			var images []types.Image
+			var err error
+			if p.Expired {
+				images, err = expiredImages(ctx, client, nil, time.Now())
+			} else {
+				images, err = retainedImages(ctx, client, p.NamePrefix, int(p.KeepLast))
+			}
+			if err != nil {
+				return nil, err
+			}
+			pruned := []pruneResult{}
+			for _, image := range images {
+				event("deleting image " + *image.ImageId)
+				r, err := pruneImage(ctx, client, image)
+				if err != nil {
+					return pruned, err
+				}
+				pruned = append(pruned, r)
+			}
+			return pruned, nil
+		}, nil
+	}
+	return nil, errors.New("job is required")
+}
+
+func (s *jobServer) run(j *job, run func(ctx context.Context, event func(string)) (any, error)) {
+	s.mu.Lock()
+	s.record(j, amimativ1.JobState_JOB_STATE_RUNNING, "started")
+	s.mu.Unlock()
+
+	res, err := run(context.Background(), func(msg string) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.record(j, amimativ1.JobState_JOB_STATE_RUNNING, msg)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if res != nil {
+		if b, merr := json.Marshal(res); merr == nil {
+			j.msg.Result = string(b)
+		}
+	}
+	j.msg.FinishTime = timestamppb.Now()
+	if err != nil {
+		j.msg.Error = err.Error()
+		s.record(j, amimativ1.JobState_JOB_STATE_FAILED, err.Error())
+		return
+	}
+	s.record(j, amimativ1.JobState_JOB_STATE_SUCCEEDED, "succeeded")
+}
+
+// record sets the state of the job and appends an event; s.mu must be held.
+func (s *jobServer) record(j *job, state amimativ1.JobState, msg string) {
+	j.msg.State = state
+	j.events = append(j.events, &amimativ1.JobEvent{JobId: j.msg.Id, Time: timestamppb.Now(), State: state, Message: msg})
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+func jobDone(state amimativ1.JobState) bool {
+	return state == amimativ1.JobState_JOB_STATE_SUCCEEDED || state == amimativ1.JobState_JOB_STATE_FAILED
+}
+
+func cloneJob(j *amimativ1.Job) *amimativ1.Job {
+	return &amimativ1.Job{Id: j.Id, State: j.State, SubmitTime: j.SubmitTime, FinishTime: j.FinishTime, Result: j.Result, Error: j.Error}
+}
@@ -0,0 +1,73 @@
+//go:build lambda
+
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// lambdaEvent is the input of a Lambda invocation: the pipeline section of
+// the config file, and its naming convention. Without stages, the pipeline
+// only creates the image. TaskToken, as passed by a Step Functions "wait for
+// callback" state, is answered with the report as -task-token is.
+type lambdaEvent struct {
+	pipelineConfig
+	Naming    *namingConfig `json:"naming"`
+	TaskToken string        `json:"taskToken"`
+}
+
+func init() {
+	// The same binary keeps its command line outside of Lambda.
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		lambdaMain = func() { lambda.Start(handleLambda) }
+	}
+}
+
+// handleLambda runs the pipeline of the event and returns its report. A
+// failed pipeline fails the invocation, after logging the report, so that
+// EventBridge and Lambda retry and alarm on it.
+func handleLambda(ctx context.Context, event lambdaEvent) (*pipelineReport, error) {
+	awsOpt := &awsOptions{apiRates: defaultAPIRates(), maxRetries: defaultMaxRetries}
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The callback is of the invocation: a warm runtime serves others.
+	var callback *taskCallback
+	if event.TaskToken != "" {
+		callback = newTaskCallback(cfg, event.TaskToken)
+	}
+	p := &event.pipelineConfig
+	if len(p.Stages) == 0 {
+		p.Stages = []pipelineStage{{Type: stageCreate}}
+	}
+	if err := p.validate(); err != nil {
+		callback.fail(exitValidation, err)
+		return nil, err
+	}
+	naming, err := loadNaming(&fileConfig{Naming: event.Naming})
+	if err != nil {
+		callback.fail(exitValidation, err)
+		return nil, err
+	}
+
+	// The request ID correlates the tags of the image with the invocation.
+	var id string
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		id = lc.AwsRequestID
+	}
+	setRunID(id)
+	st := &pipelineState{cfg: cfg, naming: naming, images: map[string]string{}}
+	report := p.run(ctx, st)
+	if !report.Succeeded {
+		logger.Error("pipeline failed", append(logAttrs(), "report", report)...)
+		callback.fail(exitCode(ctx, report.err()), report.err())
+		return nil, report.err()
+	}
+	callback.succeed(report)
+	return &report, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// launchTemplateRef identifies a launch template by ID (lt-...) or name.
+type launchTemplateRef string
+
+func (r launchTemplateRef) apply(id, name **string) {
+	if strings.HasPrefix(string(r), "lt-") {
+		*id = aws.String(string(r))
+	} else {
+		*name = aws.String(string(r))
+	}
+}
+
+// launchTemplateVersions returns every version of the launch template, newest
+// first.
+func launchTemplateVersions(ctx context.Context, client *ec2.Client, lt launchTemplateRef) ([]types.LaunchTemplateVersion, error) {
+	in := &ec2.DescribeLaunchTemplateVersionsInput{}
+	lt.apply(&in.LaunchTemplateId, &in.LaunchTemplateName)
+
+	var versions []types.LaunchTemplateVersion
+	p := ec2.NewDescribeLaunchTemplateVersionsPaginator(client, in)
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error describing launch template versions: %w", err)
+		}
+		versions = append(versions, out.LaunchTemplateVersions...)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return aws.ToInt64(versions[i].VersionNumber) > aws.ToInt64(versions[j].VersionNumber)
+	})
+	return versions, nil
+}
+
+func launchTemplateImage(v types.LaunchTemplateVersion) string {
+	if v.LaunchTemplateData == nil {
+		return ""
+	}
+	return aws.ToString(v.LaunchTemplateData.ImageId)
+}
+
+// createLaunchTemplateVersion creates a version based on sourceVersion using
+// the image, optionally making it the default version.
+func createLaunchTemplateVersion(ctx context.Context, client *ec2.Client, lt launchTemplateRef, sourceVersion int64, imageID, description string, setDefault bool) (types.LaunchTemplateVersion, error) {
+	in := &ec2.CreateLaunchTemplateVersionInput{
+		SourceVersion:      aws.String(strconv.FormatInt(sourceVersion, 10)),
+		VersionDescription: aws.String(description),
+		LaunchTemplateData: &types.RequestLaunchTemplateData{ImageId: &imageID},
+	}
+	lt.apply(&in.LaunchTemplateId, &in.LaunchTemplateName)
+	out, err := client.CreateLaunchTemplateVersion(ctx, in)
+	if err != nil {
+		return types.LaunchTemplateVersion{}, fmt.Errorf("error creating launch template version: %w", err)
+	}
+	v := *out.LaunchTemplateVersion
+
+	if setDefault {
+		in := &ec2.ModifyLaunchTemplateInput{DefaultVersion: aws.String(strconv.FormatInt(*v.VersionNumber, 10))}
+		lt.apply(&in.LaunchTemplateId, &in.LaunchTemplateName)
+		if _, err := client.ModifyLaunchTemplate(ctx, in); err != nil {
+			return v, fmt.Errorf("error setting default launch template version: %w", err)
+		}
+		v.DefaultVersion = aws.Bool(true)
+	}
+	return v, nil
+}
+
+// launchTemplateUpdate is an -update-launch-template value: the launch
+// template and the version the new version is based on.
+type launchTemplateUpdate struct {
+	ref launchTemplateRef
+	// source is "latest", "default" or a version number.
+	source string
+}
+
+// parseLaunchTemplateUpdate parses "<ID or name>[:<latest|default|version>]",
+// based on the latest version when none is given.
+func parseLaunchTemplateUpdate(s string) (launchTemplateUpdate, error) {
+	ref, source, ok := strings.Cut(s, ":")
+	if !ok {
+		source = "latest"
+	}
+	if ref == "" {
+		return launchTemplateUpdate{}, fmt.Errorf("invalid launch template, want <ID or name>[:latest|default|<version>]: %s", s)
+	}
+	if source != "latest" && source != "default" {
+		if n, err := strconv.ParseInt(source, 10, 64); err != nil || n < 1 {
+			return launchTemplateUpdate{}, fmt.Errorf("invalid launch template version, want latest, default or a version number: %s", s)
+		}
+	}
+	return launchTemplateUpdate{ref: launchTemplateRef(ref), source: source}, nil
+}
+
+func (u launchTemplateUpdate) String() string {
+	return string(u.ref) + ":" + u.source
+}
+
+// launchTemplateUpdateResult is a version created by -update-launch-template.
+type launchTemplateUpdateResult struct {
+	LaunchTemplateId string
+	SourceVersion    int64
+	// SourceImageId is the image of the source version, replaced by the new
+	// image in the new version.
+	SourceImageId string `json:",omitempty"`
+	NewVersion    int64
+	Default       bool
+}
+
+// updateLaunchTemplate creates a version of the launch template using the
+// image, based on the source version of u, optionally making it the default
+// version.
+func updateLaunchTemplate(ctx context.Context, client *ec2.Client, u launchTemplateUpdate, imageID string, setDefault bool) (*launchTemplateUpdateResult, error) {
+	versions, err := launchTemplateVersions(ctx, client, u.ref)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("launch template %s has no versions", u.ref)
+	}
+	source := versions[0]
+	if u.source != "latest" {
+		found := false
+		for _, v := range versions {
+			if u.source == "default" && aws.ToBool(v.DefaultVersion) || strconv.FormatInt(aws.ToInt64(v.VersionNumber), 10) == u.source {
+				source, found = v, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("launch template %s has no %s version", u.ref, u.source)
+		}
+	}
+
+	v, err := createLaunchTemplateVersion(ctx, client, u.ref, *source.VersionNumber, imageID,
+		fmt.Sprintf("amimati %s from version %d", imageID, *source.VersionNumber), setDefault)
+	if err != nil {
+		return nil, err
+	}
+	return &launchTemplateUpdateResult{
+		LaunchTemplateId: aws.ToString(v.LaunchTemplateId),
+		SourceVersion:    *source.VersionNumber,
+		SourceImageId:    launchTemplateImage(source),
+		NewVersion:       *v.VersionNumber,
+		Default:          aws.ToBool(v.DefaultVersion),
+	}, nil
+}
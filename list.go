@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	listSortAccount = "account"
+	listSortNewest  = "newest"
+	listSortOldest  = "oldest"
+	listSortSize    = "size"
+)
+
+type listedImage struct {
+	Account      string
+	AccountName  string `json:",omitempty"`
+	Region       string
+	ImageId      string
+	Name         string
+	State        string
+	CreationDate string
+	SizeGiB      int32
+	ExpireAt     string `json:",omitempty"`
+	// MonthlyCost is the estimated monthly cost in USD of storing the
+	// snapshots, with -show-cost.
+	MonthlyCost *float64 `json:",omitempty"`
+}
+
+// listFilter selects the images to list.
+type listFilter struct {
+	namePrefix string
+	// name is a glob on the name, with * and ? wildcards.
+	name string
+	tags filterTags
+	// createdAfter and createdBefore bound the creation time, unless zero.
+	createdAfter, createdBefore time.Time
+	// scope is which images are listed, those owned by the caller if nil.
+	scope *imageScope
+}
+
+// filters returns the DescribeImages filters of the name and tags.
+func (f listFilter) filters() []types.Filter {
+	var filters []types.Filter
+	if f.namePrefix != "" {
+		filters = append(filters, types.Filter{Name: aws.String("name"), Values: []string{f.namePrefix + "*"}})
+	}
+	if f.name != "" {
+		filters = append(filters, types.Filter{Name: aws.String("name"), Values: []string{f.name}})
+	}
+	for _, t := range f.tags {
+		filters = append(filters, types.Filter{Name: aws.String("tag:" + aws.ToString(t.Key)), Values: []string{aws.ToString(t.Value)}})
+	}
+	return filters
+}
+
+// created reports whether the image was created between createdAfter and
+// createdBefore, which DescribeImages cannot filter on.
+func (f listFilter) created(image types.Image) bool {
+	if f.createdAfter.IsZero() && f.createdBefore.IsZero() {
+		return true
+	}
+	t, err := time.Parse(time.RFC3339, aws.ToString(image.CreationDate))
+	if err != nil {
+		return false
+	}
+	return (f.createdAfter.IsZero() || t.After(f.createdAfter)) && (f.createdBefore.IsZero() || t.Before(f.createdBefore))
+}
+
+// parseCreationBound parses a -created-after or -created-before value: an
+// RFC 3339 time, or a duration before now such as "30d".
+func parseCreationBound(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := parseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time, neither RFC 3339 nor a duration: %s", s)
+	}
+	return now.Add(-d), nil
+}
+
+// listTarget is an account and region to list the images of.
+type listTarget struct {
+	cfg         aws.Config
+	accountName string
+	region      string
+}
+
+func runList(args []string) {
+	var accountsFrom, createdAfter, createdBefore, sortBy, query string
+	var filter listFilter
+	var regions []string
+	var showCost bool
+	var snapshotPrice float64
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.Var((*list)(&regions), "regions", "regions to list the images of(eg. us-east-1,us-west-2; default: the configured region)")
+	fs.StringVar(&filter.namePrefix, "name-prefix", "", "only list images whose name starts with this prefix")
+	fs.StringVar(&filter.name, "name", "", "only list images whose name matches this glob, with * and ? wildcards(eg. 'web-*-prod')")
+	fs.Var(&filter.tags, "tag", "only list images with these tags; * in a value matches any characters(eg. env=prod,team=*)")
+	filter.scope = addImageScopeFlags(fs, "list")
+	fs.StringVar(&createdAfter, "created-after", "", "only list images created after this RFC 3339 time, or this long ago(eg. 2026-01-01T00:00:00Z or 30d)")
+	fs.StringVar(&createdBefore, "created-before", "", "only list images created before this RFC 3339 time, or this long ago(eg. 90d)")
+	fs.StringVar(&sortBy, "sort", listSortAccount, "order of the images: account (by account, region and newest first), newest, oldest or size (largest first)")
+	fs.BoolVar(&showCost, "show-cost", false, "estimate the monthly cost of storing the snapshots of each image as MonthlyCost, and log the total")
+	fs.Float64Var(&snapshotPrice, "snapshot-price", 0, "with -show-cost, USD per GiB-month of standard snapshot storage(eg. 0.05; default: the list price of the region)")
+	fs.StringVar(&accountsFrom, "accounts-from", "", "assume the role of each account listed in this YAML file and list the images of every account")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. [].ImageId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+
+	now := time.Now()
+	var err error
+	if createdAfter != "" {
+		if filter.createdAfter, err = parseCreationBound(createdAfter, now); err != nil {
+			fatal(err)
+		}
+	}
+	if createdBefore != "" {
+		if filter.createdBefore, err = parseCreationBound(createdBefore, now); err != nil {
+			fatal(err)
+		}
+	}
+	if snapshotPrice < 0 {
+		fatal("snapshot price must not be negative")
+	}
+	var cost *costEstimator
+	if showCost {
+		cost = &costEstimator{price: snapshotPrice}
+	}
+	switch sortBy {
+	case listSortAccount, listSortNewest, listSortOldest, listSortSize:
+	default:
+		fatalf("invalid sort: %s", sortBy)
+	}
+
+	var accounts *accountsFile
+	if accountsFrom != "" {
+		if accounts, err = loadAccountsFile(accountsFrom); err != nil {
+			fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	if len(regions) == 0 {
+		regions = []string{cfg.Region}
+	}
+
+	names := newAccountNames(nil)
+	var targets []listTarget
+	if accounts == nil {
+		for _, region := range regions {
+			targets = append(targets, listTarget{cfg: cfg, region: region})
+		}
+	} else {
+		names = newAccountNames(accounts.Names)
+		for _, a := range accounts.Accounts {
+			acfg := assumeRole(cfg, a)
+			rs := regions
+			if len(a.Regions) > 0 {
+				rs = a.Regions
+			}
+			for _, region := range rs {
+				targets = append(targets, listTarget{cfg: acfg, accountName: a.Name, region: region})
+			}
+		}
+	}
+
+	images, err := listImages(ctx, targets, filter, names, cost)
+	if err != nil {
+		fatal(err)
+	}
+	if cost != nil {
+		var total float64
+		for _, image := range images {
+			total += aws.ToFloat64(image.MonthlyCost)
+		}
+		logf("estimated snapshot storage cost of %d images: $%.2f per month", len(images), total)
+	}
+	sortListedImages(images, sortBy)
+	printResult(images, query)
+}
+
+// listImages lists the images owned by each target concurrently, ordered by
+// account, region and newest first.
+// Images are named after their account: by the name of the target, or else
+// by names. With a cost estimator, the images have their MonthlyCost.
+func listImages(ctx context.Context, targets []listTarget, filter listFilter, names *accountNames, cost *costEstimator) ([]listedImage, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := regionErrors{}
+	result := []listedImage{}
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t listTarget) {
+			defer wg.Done()
+			client := regionalClient(t.cfg, t.region)
+			images, err := filter.scope.describe(ctx, client, filter.filters())
+			var tiers map[string]types.StorageTier
+			if err == nil && cost != nil && len(images) > 0 {
+				tiers, err = snapshotTiers(ctx, client)
+			}
+			accountName := t.accountName
+			if err == nil && accountName == "" && len(images) > 0 {
+				accountName = names.name(ctx, t.cfg, aws.ToString(images[0].OwnerId))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				key := t.region
+				if t.accountName != "" {
+					key = t.accountName + "/" + t.region
+				}
+				errs[key] = err
+				return
+			}
+			for _, image := range images {
+				if !filter.created(image) {
+					continue
+				}
+				l := listedImage{
+					Account:      aws.ToString(image.OwnerId),
+					AccountName:  accountName,
+					Region:       t.region,
+					ImageId:      *image.ImageId,
+					Name:         aws.ToString(image.Name),
+					State:        string(image.State),
+					CreationDate: aws.ToString(image.CreationDate),
+				}
+				for _, bdm := range image.BlockDeviceMappings {
+					if bdm.Ebs != nil {
+						l.SizeGiB += aws.ToInt32(bdm.Ebs.VolumeSize)
+					}
+				}
+				for _, tag := range image.Tags {
+					if aws.ToString(tag.Key) == expireAtTagKey {
+						l.ExpireAt = aws.ToString(tag.Value)
+					}
+				}
+				if cost != nil {
+					l.MonthlyCost = aws.Float64(cost.monthly(t.region, image, tiers))
+				}
+				result = append(result, l)
+			}
+		}(t)
+	}
+	wg.Wait()
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		return a.CreationDate > b.CreationDate
+	})
+	if len(errs) > 0 {
+		return result, errs
+	}
+	return result, nil
+}
+
+// sortListedImages reorders the images, ordered by account, region and newest
+// first, by another order than listSortAccount.
+func sortListedImages(images []listedImage, by string) {
+	switch by {
+	case listSortNewest:
+		// CreationDate is ISO 8601 in UTC, so it sorts lexically.
+		sort.SliceStable(images, func(i, j int) bool { return images[i].CreationDate > images[j].CreationDate })
+	case listSortOldest:
+		sort.SliceStable(images, func(i, j int) bool { return images[i].CreationDate < images[j].CreationDate })
+	case listSortSize:
+		sort.SliceStable(images, func(i, j int) bool { return images[i].SizeGiB > images[j].SizeGiB })
+	}
+}
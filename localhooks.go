@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Events local commands can be hooked to with -hook, besides pre-<stage> and
+// post-<stage> around each stage of a pipeline.
+const (
+	hookPreCreate     = "pre-create"
+	hookPostAvailable = "post-available"
+	hookOnFailure     = "on-failure"
+)
+
+// stageTypes are the types of the stages of a pipeline.
+var stageTypes = []string{stageCreate, stageCopy, stageShare, stageValidate, stageAlias, stagePrune, stageCanary, stageInspect, stageGate, stageVerify, stageLaunchTemplate, stageRefresh}
+
+// localHooks are the local commands hooked to each event, run in order, as
+// in -hook pre-create=./freeze.sh. A nil localHooks runs nothing.
+type localHooks map[string][]string
+
+func (h *localHooks) String() string {
+	var s []string
+	for event, commands := range *h {
+		for _, c := range commands {
+			s = append(s, event+"="+c)
+		}
+	}
+	sort.Strings(s)
+	return strings.Join(s, " ")
+}
+
+func (h *localHooks) Set(value string) error {
+	event, command, ok := strings.Cut(value, "=")
+	if !ok || command == "" {
+		return fmt.Errorf("invalid hook, want event=command: %s", value)
+	}
+	if !validHookEvent(event) {
+		return fmt.Errorf("invalid hook event: %s", event)
+	}
+	if *h == nil {
+		*h = localHooks{}
+	}
+	(*h)[event] = append((*h)[event], command)
+	return nil
+}
+
+func validHookEvent(event string) bool {
+	switch event {
+	case hookPreCreate, hookPostAvailable, hookOnFailure:
+		return true
+	}
+	for _, t := range stageTypes {
+		if event == "pre-"+t || event == "post-"+t {
+			return true
+		}
+	}
+	return false
+}
+
+// stageHooks reports the events of the hooks only a pipeline runs, those
+// around its stages other than pre-create.
+func (h localHooks) stageHooks() []string {
+	var events []string
+	for event := range h {
+		switch event {
+		case hookPreCreate, hookPostAvailable, hookOnFailure:
+		default:
+			events = append(events, event)
+		}
+	}
+	sort.Strings(events)
+	return events
+}
+
+// run runs the commands hooked to the event through the shell, in order,
+// until one fails. They run with AMIMATI_HOOK, AMIMATI_STAGE, AMIMATI_RUN_ID
+// and, once the image exists, AMIMATI_IMAGE_ID, AMIMATI_SNAPSHOT_IDS and
+// AMIMATI_IMAGES (region=image ID of the image and its copies) set, and the
+// result of the image as JSON on stdin. Their output goes to stderr to keep
+// stdout for the result.
+func (h localHooks) run(ctx context.Context, event, stage string, res *result, images map[string]string) error {
+	if len(h[event]) == 0 {
+		return nil
+	}
+	env := append(os.Environ(), "AMIMATI_HOOK="+event, "AMIMATI_STAGE="+stage, "AMIMATI_RUN_ID="+runID)
+	payload := []byte("{}")
+	if res != nil {
+		env = append(env, "AMIMATI_IMAGE_ID="+*res.ImageId, "AMIMATI_SNAPSHOT_IDS="+strings.Join(imageSnapshotIDs(res.Image), ","))
+		var err error
+		if payload, err = json.Marshal(res); err != nil {
+			return fmt.Errorf("error encoding %s hook payload: %w", event, err)
+		}
+	}
+	if len(images) > 0 {
+		regional := make([]string, 0, len(images))
+		for region, id := range images {
+			regional = append(regional, region+"="+id)
+		}
+		sort.Strings(regional)
+		env = append(env, "AMIMATI_IMAGES="+strings.Join(regional, ","))
+	}
+	for _, command := range h[event] {
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Stdin = bytes.NewReader(payload)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		cmd.Env = env
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("error running %s hook %q: %w", event, command, err)
+		}
+	}
+	return nil
+}
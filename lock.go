@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// snapshotLock is the lock applied to the snapshots of the image, parsed from
+// a list such as mode=compliance,duration=365d,cool-off=24h.
+type snapshotLock struct {
+	mode types.LockMode
+	// days is the lock duration in whole days.
+	days int32
+	// coolOffHours is the cool-off period of compliance locks, during which
+	// they can still be removed.
+	coolOffHours int32
+}
+
+func (l *snapshotLock) String() string {
+	if l.mode == "" {
+		return ""
+	}
+	return fmt.Sprintf("mode=%s,duration=%dd", l.mode, l.days)
+}
+
+func (l *snapshotLock) Set(value string) error {
+	for _, kv := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("invalid snapshot lock: %s", kv)
+		}
+		switch k {
+		case "mode":
+			switch m := types.LockMode(v); m {
+			case types.LockModeCompliance, types.LockModeGovernance:
+				l.mode = m
+			default:
+				return fmt.Errorf("invalid lock mode: %s", v)
+			}
+		case "duration":
+			d, err := parseDuration(v)
+			if err != nil {
+				return err
+			}
+			days := (d + 24*time.Hour - 1) / (24 * time.Hour)
+			if days < 1 || days > 36500 {
+				return fmt.Errorf("lock duration must be between 1 and 36500 days: %s", v)
+			}
+			l.days = int32(days)
+		case "cool-off":
+			d, err := parseDuration(v)
+			if err != nil {
+				return err
+			}
+			hours := (d + time.Hour - 1) / time.Hour
+			if hours < 1 || hours > 72 {
+				return fmt.Errorf("cool-off period must be between 1 and 72 hours: %s", v)
+			}
+			l.coolOffHours = int32(hours)
+		default:
+			return fmt.Errorf("invalid snapshot lock: %s", kv)
+		}
+	}
+	if l.mode == "" || l.days == 0 {
+		return fmt.Errorf("snapshot lock requires mode and duration: %s", value)
+	}
+	if l.coolOffHours > 0 && l.mode != types.LockModeCompliance {
+		return fmt.Errorf("cool-off requires the compliance mode: %s", value)
+	}
+	return nil
+}
+
+type lockResult struct {
+	SnapshotId    string
+	LockState     string
+	LockExpiresOn *time.Time `json:",omitempty"`
+}
+
+// lockSnapshots locks each snapshot so it cannot be deleted until the lock
+// expires.
+func lockSnapshots(ctx context.Context, client *ec2.Client, snapshots []deviceSnapshot, lock snapshotLock) ([]lockResult, error) {
+	var result []lockResult
+	for _, ds := range snapshots {
+		in := &ec2.LockSnapshotInput{
+			SnapshotId:   ds.snapshot.SnapshotId,
+			LockMode:     lock.mode,
+			LockDuration: aws.Int32(lock.days),
+		}
+		if lock.coolOffHours > 0 {
+			in.CoolOffPeriod = aws.Int32(lock.coolOffHours)
+		}
+		out, err := client.LockSnapshot(ctx, in)
+		if err != nil {
+			return result, fmt.Errorf("error locking snapshot %s: %w", *ds.snapshot.SnapshotId, err)
+		}
+		trace.state(*ds.snapshot.SnapshotId, "locked "+string(out.LockState), nil)
+		result = append(result, lockResult{SnapshotId: *out.SnapshotId, LockState: string(out.LockState), LockExpiresOn: out.LockExpiresOn})
+	}
+	return result, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logger writes the log lines of the run to stderr, keeping stdout for the
+// result.
+var logger = slog.New(slog.NewTextHandler(logWriter{}, nil))
+
+type logOptions struct {
+	level  string
+	format string
+	// noColor turns the colors of the human format off.
+	noColor bool
+}
+
+// addLogFlags adds the -log-level, -log-format and -no-color flags to the
+// flag set.
+func addLogFlags(fs *flag.FlagSet) *logOptions {
+	o := &logOptions{}
+	fs.StringVar(&o.level, "log-level", "info", "lowest level of the log lines written to stderr(debug, info, warn or error)")
+	fs.StringVar(&o.format, "log-format", "text", "format of the log lines written to stderr(text, json, or human: the phases as headers checked off with their durations, colored on a terminal)")
+	fs.BoolVar(&o.noColor, "no-color", false, "do not color the human log format, as setting NO_COLOR does")
+	return o
+}
+
+// apply sets up the logger from the flags.
+func (o *logOptions) apply() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(o.level)); err != nil {
+		return fmt.Errorf("invalid log level: %s", o.level)
+	}
+	if quiet {
+		level = max(level, slog.LevelError)
+	}
+	handlerOpt := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(o.format) {
+	case "text":
+		logger = slog.New(slog.NewTextHandler(logWriter{}, handlerOpt))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(logWriter{}, handlerOpt))
+	case "human":
+		logger = slog.New(newHumanHandler(logWriter{}, level, useColor(o.noColor)))
+	default:
+		return fmt.Errorf("invalid log format: %s", o.format)
+	}
+	return nil
+}
+
+// logAttrs returns the attributes common to every log line of the run.
+func logAttrs() []any {
+	if runID == "" {
+		return nil
+	}
+	return []any{"run_id", runID}
+}
+
+// fatal logs the error, or message, and exits with its status.
+func fatal(err any) {
+	e, ok := err.(error)
+	if !ok {
+		e = errors.New(fmt.Sprint(err))
+	}
+	exitWith(errorExitCode(e), e)
+}
+
+// fatalf logs the formatted error and exits with the status of the error
+// among the arguments, if any.
+func fatalf(format string, a ...any) {
+	err := &formattedError{msg: fmt.Sprintf(format, a...)}
+	for _, v := range a {
+		if e, ok := v.(error); ok {
+			err.err = e
+			break
+		}
+	}
+	exitWith(errorExitCode(err), err)
+}
+
+// formattedError is the message of fatalf, wrapping the error among its
+// arguments so that its status and AWS details are kept.
+type formattedError struct {
+	msg string
+	err error
+}
+
+func (e *formattedError) Error() string { return e.msg }
+func (e *formattedError) Unwrap() error { return e.err }
+
+// exitWith logs err, answers the -task-token with it, writes the
+// -summary-file and exits with the status. With the json output format, the failure is also written to stdout
+// as a JSON object; see failureOf.
+func exitWith(code int, err error) {
+	logger.Error(err.Error(), logAttrs()...)
+	runCallback.fail(code, err)
+	runRecorder.fail(err)
+	if outputFormat == outputJSON {
+		if o, merr := json.Marshal(map[string]*failure{"Error": failureOf(code, err)}); merr == nil {
+			fmt.Printf("%s\n", o)
+		}
+	}
+	os.Exit(code)
+}
@@ -2,151 +2,807 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
-	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
 )
 
-type tags []types.Tag
+type list []string
 
-func (t *tags) String() string {
-	return fmt.Sprintf("%v", *t)
+func (l *list) String() string {
+	return strings.Join(*l, ",")
 }
 
-func (t *tags) Set(value string) error {
-	for _, tt := range strings.Split(value, ",") {
-		var key, val string
-		v := strings.Split(tt, ":")
-		if len(v) != 2 {
-			return fmt.Errorf("invalid tag: %s", tt)
+func (l *list) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v == "" {
+			return fmt.Errorf("invalid value: %s", value)
 		}
-		key = v[0]
-		val = v[1]
-		*t = append(*t, types.Tag{Key: &key, Value: &val})
+		*l = append(*l, v)
 	}
 	return nil
 }
 
-type options struct {
-	verbose      bool
-	instanceID   string
-	imageName    string
-	imageTags    tags
-	snapshotTags tags
+// commands are the subcommands of amimati, each run with the arguments after
+// its name. Commands starting with __ are internal and not completed. They
+// are set in init, as completion refers back to them.
+var commands map[string]func(args []string)
+
+func init() {
+	commands = map[string]func(args []string){
+		"__complete":   runComplete,
+		"archive":      runArchive,
+		"completion":   runCompletion,
+		"copy":         runCopy,
+		"create":       runCreate,
+		"daemon":       runDaemon,
+		"delete":       runDelete,
+		"deprecate":    runDeprecate,
+		"diff":         runDiff,
+		"export":       runExport,
+		"gc-snapshots": runGCSnapshots,
+		"ibpa":         runIBPA,
+		"import":       runImport,
+		"list":         runList,
+		"pipeline":     runPipeline,
+		"prune":        runPrune,
+		"register":     runRegister,
+		"report":       runReport,
+		"restore":      runRestore,
+		"rollback":     runRollback,
+		"serve":        runServe,
+		"snapshot":     runSnapshot,
+		"sync":         runSync,
+		"tagdiff":      runTagdiff,
+		"undelete":     runUndelete,
+		"volumes":      runVolumes,
+		"wait":         runWait,
+	}
 }
 
+// lambdaMain, set in builds with the lambda tag when running in AWS Lambda,
+// serves the invocations in place of the command line.
+var lambdaMain func()
+
 func main() {
+	if lambdaMain != nil {
+		lambdaMain()
+		return
+	}
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
+		if !strings.HasPrefix(os.Args[1], "-") {
+			fatalf("unknown command: %s", os.Args[1])
+		}
+	}
+	// Without a command, the flags are those of create.
+	runCreate(os.Args[1:])
+}
 
+// runCreate creates an image of an instance, then runs the checks and
+// rollouts given by the flags on it.
+func runCreate(args []string) {
 	var opt options
-	flag.BoolVar(&opt.verbose, "v", false, "verbose output")
-	flag.StringVar(&opt.instanceID, "instance-id", "", "instance ID")
-	flag.StringVar(&opt.imageName, "name", "", "image name")
-	flag.Var(&opt.imageTags, "image-tag", "image tags(eg. key1:val1)")
-	flag.Var(&opt.snapshotTags, "snapshot-tag", "snapshot tags(eg. key1:val1)")
-	flag.Parse()
-
-	if opt.instanceID == "" {
-		fmt.Println("instance ID is required")
-		os.Exit(1)
-	}
+	var query, bootMode, tpmSupport, traceFile, runbookFile, gateCmd, statusFile, runIDFlag, configFile, logGroup, statsdAddr, metricsNamespace string
+	var summary, noProgress bool
+	var profileName, ssmParameter string
+	var ssmParameterJSON bool
+	var updateLT, manifestFile, inventoryTable string
+	var ltDefault bool
+	var refreshASG string
+	var promoteTag tags
+	wait, async := true, false
+	var deprecateSuperseded string
+	var minHealthy int
+	var waitRefresh bool
+	var archive bool
+	var copyRegions, shareWith, shareWithOrg, instanceIDs list
+	var allMatching, dryRun, self bool
+	var concurrency int
+	var inputFile string
+	var hooks localHooks
+	var requiredTags list
+	var jobs []batchJob
+	var timeout time.Duration
+	var profiles []string
+	var accountRoles list
+	var accountsFrom string
+	var accounts *accountsFile
+	var fastLaunch fastLaunchFlag
+	var fsrZones list
+	var waitFSR bool
+	var notifyTo notifyTargets
+	var taskToken, summaryFile string
+	var commonTags tags
+	onError := errorPolicy{mode: onErrorContinue}
+	var canary canaryOptions
+	var inspectorScan bool
+	var inspectorSeverity string
+	inspector := inspectorOptions{}
+	var verify bool
+	verifyOpt := verifyOptions{}
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	timeOpt := addTimeFlags(fs)
+	fs.BoolVar(&opt.verbose, "v", false, "verbose output")
+	fs.BoolVar(&noProgress, "no-progress", false, "log the progress of the snapshots with -v instead of drawing progress bars on an interactive terminal")
+	fs.BoolVar(&self, "self", false, "create an image of the EC2 instance amimati runs on, found with IMDSv2; the region defaults to that of the instance")
+	fs.Var(&instanceIDs, "instance-id", "instance ID, or several to create an image of each concurrently(eg. i-0123456789abcdef0,i-0fedcba9876543210)")
+	fs.StringVar(&opt.instance.name, "instance-name", "", "select the instance by its Name tag instead of -instance-id(eg. web-prod-01)")
+	fs.Var(&opt.instance.filters, "filter", "select the instance by a DescribeInstances filter instead of -instance-id, repeatable(eg. tag:Role=db)")
+	fs.BoolVar(&allMatching, "all-matching", false, "with -instance-name or -filter, create an image of every matching instance instead of requiring exactly one")
+	fs.IntVar(&concurrency, "concurrency", 4, "with several instances or accounts, the number of images created at a time")
+	fs.Var(&requiredTags, "required-tags", "fail before creating the image unless it is tagged with these keys, whose value must match the regular expression after =, if any(eg. owner,cost-center=^CC-[0-9]+$)")
+	fs.Var(&hooks, "hook", "run a local command on an event of the run: pre-create, post-available or on-failure, repeatable(eg. post-available=./notify.sh)")
+	fs.StringVar(&inputFile, "input", "", "create an image for each job of this JSON array or CSV file, or - for stdin, writing a JSON line per job as it ends(eg. jobs.csv)")
+	fs.StringVar(&opt.imageName, "name", "", "image name")
+	fs.StringVar(&opt.description, "description", "", "image description(eg. nightly backup of {{.InstanceName}})")
+	fs.BoolVar(&opt.autoDescribe, "auto-describe", false, "describe the image with the source instance ID, region, creation time and amimati version")
+	fs.Var(&commonTags, "tag", "tags of both the image and its snapshots, repeatable; -image-tag and -snapshot-tag override them by key(eg. env=prod,team=web)")
+	fs.Var(&opt.imageTags, "image-tag", "image tags, repeatable; quote values containing commas(eg. env=prod,url=https://example.com)")
+	fs.Var(snapshotTagFlag{&opt.snapshotTags, &opt.deviceSnapshotTags}, "snapshot-tag", "snapshot tags, repeatable, of the snapshot of a single device when prefixed with its name(eg. env=prod or /dev/xvdb:role=data)")
+	fs.Var((*list)(&opt.excludeDevices), "exclude-device", "leave these volumes of the instance out of the image(eg. /dev/xvdf)")
+	fs.Var(&opt.deviceMappings, "device-mapping", "change the EBS mapping of a device in the image, repeatable(eg. device=/dev/xvdb,type=gp3,size=100,iops=3000,throughput=125,delete-on-termination=false)")
+	fs.StringVar(&opt.expireAfter, "expire-after", "", "tag the image and snapshots with an "+expireAtTagKey+" time after this duration(eg. 30d)")
+	fs.StringVar(&opt.deprecateAfter, "deprecate-after", "", "deprecate the image after this duration, or at this RFC 3339 time(eg. 90d or 2027-01-01T00:00:00Z)")
+	fs.StringVar(&bootMode, "boot-mode", "", "register the image with this boot mode(uefi, legacy-bios or uefi-preferred)")
+	fs.StringVar(&tpmSupport, "tpm-support", "", "register the image with NitroTPM support(v2.0); requires the uefi boot mode")
+	fs.BoolVar(&opt.requireIMDSv2, "require-imdsv2", false, "require IMDSv2 on instances launched from the image")
+	fs.Var((*list)(&opt.targetInstanceTypes), "target-instance-type", "instance types to check the image's enhanced networking against(eg. m7i.large,c4.xlarge)")
+	fs.BoolVar(&opt.allowMarketplace, "allow-marketplace", false, "create the image even if the instance carries AWS Marketplace product codes")
+	fs.BoolVar(&summary, "summary", false, "print a summary table of the devices to stderr")
+	fs.BoolVar(&opt.copyInstanceTags, "copy-instance-tags", false, "copy the tags of the instance to the image and snapshots; -image-tag and -snapshot-tag take precedence")
+	fs.BoolVar(&opt.lineage, "lineage", false, "tag the image and snapshots with the source instance, the image it was launched from, the amimati version and the creation time")
+	fs.StringVar(&opt.copyTagPrefix, "copy-tag-prefix", "", "with -copy-instance-tags, only copy the tags whose key starts with this prefix(eg. team:)")
+	fs.StringVar(&opt.ifExists, "if-exists", ifExistsFail, "what to do when an image of the same name exists: fail, skip (return it), replace (deregister it first) or suffix (append a timestamp to the name)")
+	fs.BoolVar(&opt.purgeReplaced, "delete-replaced-snapshots", false, "with -if-exists replace, also delete the snapshots of the replaced image")
+	fs.BoolVar(&opt.adoptExisting, "adopt-existing", false, "wait for an existing pending image of the same name instead of failing")
+	fs.BoolVar(&opt.changeReport, "change-report", false, "report the blocks and bytes of each snapshot that changed since the previous snapshot of its volume, with the EBS direct APIs")
+	fs.Var(&opt.snapshotLock, "snapshot-lock", "lock the snapshots once completed so they cannot be deleted(eg. mode=compliance,duration=365d,cool-off=24h)")
+	fs.BoolVar(&opt.ssmInventory, "ssm-inventory", false, "include the SSM inventory of the instance in the result")
+	fs.StringVar(&opt.kmsKeyID, "kms-key-id", "", "make the image an encrypted copy, with this KMS key, of the image of the instance named with the -unencrypted suffix(eg. alias/ami-baseline)")
+	fs.BoolVar(&opt.deleteUnencrypted, "delete-unencrypted", false, "with -kms-key-id, delete the unencrypted image and its snapshots once copied")
+	fs.BoolVar(&dryRun, "dry-run", false, "only check that CreateImage is permitted and print the image that would be created")
+	fs.BoolVar(&opt.cleanupOnAbort, "cleanup-on-abort", false, "on SIGINT or SIGTERM before the snapshots have completed, deregister the image and delete its snapshots")
+	fs.StringVar(&opt.preCommand, "pre-command", "", "before creating the image, run this shell command on the instance with SSM Run Command and fail unless it succeeds(eg. \"fsfreeze -f /data\")")
+	fs.StringVar(&opt.postCommand, "post-command", "", "once the snapshots have been created, or creating the image failed, run this shell command on the instance with SSM Run Command(eg. \"fsfreeze -u /data\")")
+	fs.DurationVar(&opt.hookTimeout, "hook-timeout", defaultHookTimeout, "how long -pre-command and -post-command may each run")
+	fs.BoolVar(&opt.vss, "vss", false, "create the image of a Windows instance with the "+vssDocument+" SSM document, for an image consistent for the VSS-aware applications such as SQL Server and Exchange, instead of CreateImage")
+	fs.BoolVar(&wait, "wait", true, "wait for the snapshots to complete and the image to be available; -wait=false prints the pending image and its snapshot IDs once they are created")
+	fs.BoolVar(&async, "async", false, "same as -wait=false")
+	fs.BoolVar(&opt.noReboot, "no-reboot", false, "do not reboot the instance before imaging; the image is only crash-consistent")
+	fs.BoolVar(&opt.mirrorImageTags, "mirror-image-tags-to-snapshots", false, "apply the final tags of the image to each of its snapshots")
+	fs.StringVar(&canary.asg, "canary-asg", "", "once the image is available, replace instances of this auto scaling group with canaries launched from it")
+	fs.IntVar(&canary.count, "canary-count", 1, "number of canary instances")
+	fs.DurationVar(&canary.timeout, "canary-timeout", 15*time.Minute, "how long canary instances may take to become healthy")
+	fs.BoolVar(&verify, "verify", false, "once the image is available, boot an instance from it, health-check it and tag the image "+verifiedTagKey+"=true or false; the steps after it only run if it passes")
+	fs.StringVar(&verifyOpt.instanceType, "verify-instance-type", "t3.micro", "instance type of the verification instance")
+	fs.StringVar(&verifyOpt.subnetID, "verify-subnet-id", "", "subnet of the verification instance(default: the default subnet)")
+	fs.StringVar(&verifyOpt.instanceProfile, "verify-instance-profile", "", "instance profile of the verification instance, needed for -verify-command")
+	fs.StringVar(&verifyOpt.command, "verify-command", "", "health check run on the verification instance with SSM Run Command, passing if it exits 0(eg. \"systemctl is-active nginx\")")
+	fs.StringVar(&verifyOpt.probe, "verify-probe", "", "health check of the private IP address of the verification instance, in place of -verify-command; without either the EC2 status checks must pass(eg. tcp:22 or http://:8080/health)")
+	fs.DurationVar(&verifyOpt.timeout, "verify-timeout", 15*time.Minute, "how long the verification instance may take to pass")
+	fs.BoolVar(&verifyOpt.deregisterOnFailure, "verify-deregister-on-failure", false, "deregister the image and delete its snapshots if the verification fails")
+	fs.BoolVar(&inspectorScan, "inspector-scan", false, "once the image is available, scan an instance launched from it with Amazon Inspector and fail on findings at or above -inspector-severity")
+	fs.StringVar(&inspectorSeverity, "inspector-severity", "HIGH", "lowest finding severity that fails the inspector scan(LOW, MEDIUM, HIGH or CRITICAL)")
+	fs.StringVar(&inspector.instanceType, "inspector-instance-type", "t3.micro", "instance type of the inspector scan instance")
+	fs.StringVar(&inspector.subnetID, "inspector-subnet-id", "", "subnet of the inspector scan instance(default: the default subnet)")
+	fs.StringVar(&inspector.instanceProfile, "inspector-instance-profile", "", "instance profile of the inspector scan instance, needed for agent-based scanning")
+	fs.DurationVar(&inspector.timeout, "inspector-timeout", 30*time.Minute, "how long to wait for the inspector scan")
+	fs.StringVar(&gateCmd, "gate-cmd", "", "once the image is available, run this command with the result on stdin and tag the image "+approvalTagKey+"="+approvalApproved+" only if it exits 0(eg. ./check.sh)")
+	fs.Var(&copyRegions, "copy-to-region", "once the image is available, copy it into these regions concurrently and wait for the copies(eg. us-west-2,eu-west-1)")
+	fs.Var(&shareWith, "share-with", "once the image is available, grant these accounts launch permission on it and its copies, and create volume permission on their snapshots(eg. 111122223333,444455556666)")
+	fs.Var(&shareWithOrg, "share-with-org", "once the image is available, grant these organizations or organizational units launch permission on it and its copies(eg. arn:aws:organizations::111122223333:organization/o-abc123)")
+	fs.Var(&fastLaunch, "fast-launch", "once the image is available, enable Windows fast launch with this many pre-provisioned snapshots(eg. -fast-launch or -fast-launch=10)")
+	fs.Var(&fsrZones, "enable-fsr", "once the image is available, enable Fast Snapshot Restore on its snapshots in these availability zones, billed while enabled(eg. us-east-1a,us-east-1b)")
+	fs.BoolVar(&waitFSR, "wait-fsr", false, "with -enable-fsr, wait until Fast Snapshot Restore is enabled in every zone")
+	fs.Var((*list)(&profiles), "profiles", "create the image once per AWS profile concurrently and print the result of each(eg. prod-a,prod-b)")
+	fs.Var(&accountRoles, "account-role", "assume each of these roles and create the image in its account concurrently, printing the result of each(eg. arn:aws:iam::111111111111:role/amimati,arn:aws:iam::222222222222:role/amimati)")
+	fs.StringVar(&accountsFrom, "accounts-from", "", "assume the role of each account listed in this YAML file and create the image in each of them concurrently")
+	fs.Var(&onError, "on-error", "with several instances, -profiles or several accounts, continue with the other runs on failure, cancel them (fail-fast), or pass while at most a share of runs fail(eg. threshold=20%)")
+	fs.StringVar(&ssmParameter, "ssm-parameter", "", "once the image is available and has passed the checks, write its ID to this SSM parameter, overwriting it(eg. /golden/ami/latest)")
+	fs.BoolVar(&ssmParameterJSON, "ssm-parameter-json", false, "with -ssm-parameter, write the JSON result instead of the image ID")
+	fs.StringVar(&updateLT, "update-launch-template", "", "once the image is available and has passed the checks, create a version of this launch template using it, based on its latest or default version or a version number(eg. lt-0123456789abcdef0:latest)")
+	fs.BoolVar(&ltDefault, "set-default-version", false, "with -update-launch-template, make the new version the default version")
+	fs.Var(&promoteTag, "promote-tag", "once the image and its copies are available and have passed the checks, move this tag to them from the other images carrying it, tagged "+supersededByTagKey+" the new image(eg. role=web-golden)")
+	fs.StringVar(&deprecateSuperseded, "deprecate-superseded", "", "with -promote-tag, deprecate the images the tag was moved from after this duration, or at this RFC 3339 time(eg. 30d)")
+	fs.StringVar(&refreshASG, "refresh-asg", "", "with -update-launch-template, start an instance refresh of this auto scaling group once the launch template is updated(eg. web-asg)")
+	fs.IntVar(&minHealthy, "min-healthy", 0, "minimum healthy percentage during the instance refresh(eg. 90)")
+	fs.BoolVar(&waitRefresh, "wait-refresh", false, "wait for the instance refresh to succeed, failing the run otherwise")
+	fs.BoolVar(&archive, "archive", false, "once the image is available and has passed the verification, move its snapshots to the archive tier for long-term backup; the image must be restored before it can be launched")
+	fs.StringVar(&inventoryTable, "inventory-table", "", "record the image, its source instance, snapshots, tags, duration and operator as an item of this DynamoDB table, keyed by the ImageId string(eg. amimati-inventory)")
+	fs.StringVar(&manifestFile, "manifest", "", "append the image and its copies as a build to this Packer manifest file(eg. manifest.json)")
+	fs.StringVar(&runbookFile, "runbook", "", "write a restore runbook to this file(Markdown, or JSON if it ends in .json)")
+	fs.StringVar(&configFile, "config", "", "config file with the naming convention and profiles(default: amimati.yaml or amimati.json, if either exists)")
+	fs.StringVar(&profileName, "profile-name", "", "apply the defaults of this profile of the config file to the flags not given(eg. prod)")
+	fs.StringVar(&runIDFlag, "run-id", "", "correlation ID of the run, logged and tagged on the created resources as "+runIDTagKey+"(default: generated)")
+	fs.StringVar(&logGroup, "cloudwatch-log-group", "", "ship the log lines of the run to a stream named by the run ID in this CloudWatch Logs group(eg. /amimati/backups)")
+	fs.StringVar(&statsdAddr, "statsd-addr", "", "send run metrics to this StatsD/DogStatsD address(eg. localhost:8125)")
+	fs.StringVar(&metricsNamespace, "metrics-namespace", "", "publish the outcome of the run as CloudWatch metrics in this namespace(eg. Amimati)")
+	fs.Var(&notifyTo, "notify", "when the run finishes, post its outcome to this target, repeatable(eg. sns:arn:aws:sns:us-east-1:111122223333:backups, slack:https://hooks.slack.com/services/..., webhook:https://example.com/hook)")
+	fs.StringVar(&taskToken, "task-token", "", "when the run finishes, answer this Step Functions task token with SendTaskSuccess and the result, or SendTaskFailure and the error(eg. $TASK_TOKEN)")
+	fs.StringVar(&summaryFile, "summary-file", "", "when the run finishes, write the wall time, phase and snapshot durations, AWS call counts and resource IDs of the run to this JSON file(eg. summary.json)")
+	fs.StringVar(&statusFile, "status-file", "", "keep the current phase, snapshot progress and ETA of the run in this JSON file(eg. /tmp/amimati.status.json)")
+	fs.DurationVar(&timeout, "timeout", 0, "give up and exit with status "+strconv.Itoa(exitTimeout)+" if the run has not finished after this long(eg. 2h; default: no limit)")
+	fs.DurationVar(&pollInterval, "poll-interval", pollInterval, "time between two polls of the image and snapshot states")
+	fs.DurationVar(&heartbeat, "heartbeat", 0, "with -v, repeat an unchanged waiting line this often(eg. 5m; default: only print changes)")
+	fs.StringVar(&traceFile, "trace", "", "write a timeline of API calls and state transitions to this file(Chrome trace format)")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. ImageId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
 
-	if opt.imageName == "" {
-		fmt.Println("image name is required")
-		os.Exit(1)
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
 	}
 
-	ctx := context.Background()
-	cfg, err := config.LoadDefaultConfig(ctx)
+	if configFile == "" {
+		configFile = defaultConfigFile()
+	}
+	c, err := loadOptionalConfigFile(configFile)
 	if err != nil {
-		fmt.Printf("error loading config: %v\n", err)
-		os.Exit(1)
+		fatal(err)
+	}
+	if err := applyConfigProfile(fs, c, profileName); err != nil {
+		fatal(err)
 	}
+	opt.imageTags = mergeTags(commonTags, opt.imageTags)
+	opt.snapshotTags = mergeTags(commonTags, opt.snapshotTags)
 
-	client := ec2.NewFromConfig(cfg)
+	if err := timeOpt.apply(); err != nil {
+		fatal(err)
+	}
 
-	ts := make([]types.TagSpecification, 2)
-	if len(opt.imageTags) > 0 {
-		ts = append(ts, types.TagSpecification{ResourceType: types.ResourceTypeImage, Tags: opt.imageTags})
+	if pollInterval <= 0 {
+		fatal("poll interval must be positive")
+	}
+	if err := validateIfExists(opt.ifExists); err != nil {
+		fatal(err)
+	}
+	if opt.adoptExisting && opt.ifExists != ifExistsFail {
+		fatal("-adopt-existing cannot be combined with -if-exists")
+	}
+	if opt.description != "" && opt.autoDescribe {
+		fatal("-description cannot be combined with -auto-describe")
+	}
+	if opt.copyTagPrefix != "" && !opt.copyInstanceTags {
+		fatal("-copy-tag-prefix requires -copy-instance-tags")
+	}
+	if waitFSR && len(fsrZones) == 0 {
+		fatal("-wait-fsr requires -enable-fsr")
+	}
+	if ssmParameterJSON && ssmParameter == "" {
+		fatal("-ssm-parameter-json requires -ssm-parameter")
+	}
+	var ltUpdate launchTemplateUpdate
+	if updateLT != "" {
+		if ltUpdate, err = parseLaunchTemplateUpdate(updateLT); err != nil {
+			fatal(err)
+		}
+	} else if ltDefault || refreshASG != "" {
+		fatal("-set-default-version and -refresh-asg require -update-launch-template")
 	}
-	if len(opt.snapshotTags) > 0 {
-		ts = append(ts, types.TagSpecification{ResourceType: types.ResourceTypeSnapshot, Tags: opt.snapshotTags})
+	if len(promoteTag) > 1 {
+		fatal("-promote-tag takes a single tag")
+	}
+	if deprecateSuperseded != "" {
+		if len(promoteTag) == 0 {
+			fatal("-deprecate-superseded requires -promote-tag")
+		}
+		if _, err := deprecationTime(deprecateSuperseded, time.Now()); err != nil {
+			fatal(err)
+		}
+	}
+	if (minHealthy != 0 || waitRefresh) && refreshASG == "" {
+		fatal("-min-healthy and -wait-refresh require -refresh-asg")
+	}
+	if archive && (updateLT != "" || fastLaunch > 0 || len(fsrZones) > 0) {
+		fatal("-archive cannot be combined with -update-launch-template, -fast-launch or -enable-fsr, which need an image that can be launched")
+	}
+	if minHealthy < 0 || minHealthy > 100 {
+		fatal("minimum healthy percentage must be between 0 and 100")
+	}
+	if events := hooks.stageHooks(); len(events) > 0 {
+		fatalf("-hook %s is only run around the stages of a pipeline", strings.Join(events, ", "))
+	}
+	if opt.hookTimeout <= 0 {
+		fatal("hook timeout must be positive")
+	}
+	if opt.deleteUnencrypted && opt.kmsKeyID == "" {
+		fatal("-delete-unencrypted requires -kms-key-id")
+	}
+	for _, a := range shareWithOrg {
+		if !isOrganizationsARN(a) {
+			fatalf("invalid organization or organizational unit ARN: %s", a)
+		}
 	}
 
-	createdImageOutput, err := client.CreateImage(ctx, &ec2.CreateImageInput{
-		Name:              &opt.imageName,
-		InstanceId:        &opt.instanceID,
-		TagSpecifications: ts,
-	})
-	if err != nil {
-		fmt.Printf("error creating image: %v\n", err)
-		os.Exit(1)
+	if inputFile != "" {
+		if self || len(instanceIDs) > 0 || !opt.instance.empty() || allMatching {
+			fatal("-input cannot be combined with -self, -instance-id, -instance-name, -filter or -all-matching")
+		}
+		if jobs, err = readBatchJobs(inputFile); err != nil {
+			fatal(err)
+		}
+		if err := checkSharedInstances(jobs, opt.noReboot, concurrency); err != nil {
+			fatal(err)
+		}
+	}
+	if self && (len(instanceIDs) > 0 || !opt.instance.empty()) {
+		fatal("-self cannot be combined with -instance-id, -instance-name or -filter")
+	}
+	// Without an instance, one is picked from a list when run from a terminal.
+	pick := len(instanceIDs) == 0 && opt.instance.empty() && !self && inputFile == ""
+	if len(accountRoles) > 0 && accountsFrom != "" {
+		fatal("-account-role cannot be combined with -accounts-from")
+	}
+	if accountsFrom != "" {
+		if accounts, err = loadAccountsFile(accountsFrom); err != nil {
+			fatal(err)
+		}
+	}
+	for _, role := range accountRoles {
+		if accounts == nil {
+			accounts = &accountsFile{}
+		}
+		accounts.Accounts = append(accounts.Accounts, accountRole{RoleArn: role})
+	}
+	if accounts != nil && len(profiles) > 0 {
+		fatal("-account-role and -accounts-from cannot be combined with -profiles")
+	}
+	if accounts != nil && self {
+		fatal("-account-role and -accounts-from cannot be combined with -self")
+	}
+	if pick && (!interactive() || len(profiles) > 0 || accounts != nil) {
+		fatal("instance ID, name or filter, or -self is required")
+	}
+	if len(instanceIDs) > 0 && !opt.instance.empty() {
+		fatal("-instance-id cannot be combined with -instance-name or -filter")
+	}
+	if allMatching && opt.instance.empty() {
+		fatal("-all-matching requires -instance-name or -filter")
+	}
+	batch := len(instanceIDs) > 1 || allMatching || inputFile != ""
+	// Concurrent creations would draw over each other's progress bars.
+	opt.progressBars = !batch && len(profiles) == 0 && accounts == nil && drawProgressBars(noProgress)
+	if len(instanceIDs) == 1 {
+		opt.instanceID = instanceIDs[0]
+	}
+	if concurrency < 1 {
+		fatal("concurrency must be positive")
+	}
+
+	if opt.imageName == "" && inputFile == "" {
+		fatal("image name is required")
+	}
+	if batch && opt.imageName != "" && !strings.Contains(opt.imageName, "{{") {
+		fatal("with several instances, the image name must be a template telling the images apart(eg. backup-{{.InstanceID}})")
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	if inputFile != "" && (outputFormat != outputJSON || query != "") {
+		fatal("-input writes JSON lines and cannot be combined with -o other than json, -query or -quiet")
+	}
+
+	postCreation := canary.asg != "" || verify || inspectorScan || gateCmd != "" || fastLaunch > 0 || len(fsrZones) > 0 || len(copyRegions) > 0 || len(shareWith) > 0 || len(shareWithOrg) > 0 || ssmParameter != "" || updateLT != "" || archive || manifestFile != "" || inventoryTable != "" || runbookFile != "" || statusFile != "" || len(notifyTo) > 0 || summary || len(hooks) > 0 || len(promoteTag) > 0 || taskToken != "" || summaryFile != ""
+	if len(profiles) > 0 && awsOpt.profile != "" {
+		fatal("-profiles cannot be combined with -profile")
+	}
+	if len(profiles) > 0 && postCreation {
+		fatal("-profiles cannot be combined with -canary-asg, -verify, -inspector-scan, -gate-cmd, -fast-launch, -enable-fsr, -copy-to-region, -share-with, -share-with-org, -ssm-parameter, -update-launch-template, -archive, -manifest, -inventory-table, -runbook, -status-file, -notify, -summary, -summary-file, -hook, -promote-tag or -task-token")
+	}
+	if accounts != nil && postCreation {
+		fatal("-account-role and -accounts-from cannot be combined with -canary-asg, -verify, -inspector-scan, -gate-cmd, -fast-launch, -enable-fsr, -copy-to-region, -share-with, -share-with-org, -ssm-parameter, -update-launch-template, -archive, -manifest, -inventory-table, -runbook, -status-file, -notify, -summary, -summary-file, -hook, -promote-tag or -task-token")
+	}
+	if dryRun && (batch || postCreation || len(profiles) > 0 || accounts != nil) {
+		fatal("-dry-run cannot be combined with several instances, -profiles, several accounts or the steps after creation")
+	}
+	if batch && (postCreation || len(profiles) > 0 || accounts != nil) {
+		fatal("several instances cannot be combined with -profiles, -account-role, -accounts-from, -canary-asg, -verify, -inspector-scan, -gate-cmd, -fast-launch, -enable-fsr, -copy-to-region, -share-with, -share-with-org, -ssm-parameter, -update-launch-template, -archive, -manifest, -inventory-table, -runbook, -status-file, -notify, -summary, -summary-file, -hook, -promote-tag or -task-token")
+	}
+
+	if canary.asg != "" && canary.count < 1 {
+		fatal("canary count must be positive")
+	}
+
+	if opt.naming, err = loadNaming(c); err != nil {
+		fatal(err)
+	}
+	if opt.tagPolicy, err = loadTagPolicy(c, requiredTags); err != nil {
+		fatal(err)
 	}
 
-	var snapshotId string
-	var createdImage types.Image
-	for {
-		describeImage, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: []string{*createdImageOutput.ImageId}})
+	if verify && verifyOpt.command != "" && verifyOpt.probe != "" {
+		fatal("-verify-command cannot be combined with -verify-probe")
+	}
+	if verifyOpt.probe != "" {
+		if err := validateProbe(verifyOpt.probe); err != nil {
+			fatal(err)
+		}
+	}
+
+	if inspectorScan {
+		s, err := parseSeverity(inspectorSeverity)
 		if err != nil {
-			fmt.Printf("error describing image: %v\n", err)
-			os.Exit(1)
+			fatal(err)
 		}
-		if len(describeImage.Images) == 0 {
-			fmt.Println("no images found")
-			os.Exit(1)
+		inspector.threshold = s
+	}
+
+	if opt.expireAfter != "" {
+		if _, err := parseDuration(opt.expireAfter); err != nil {
+			fatal(err)
 		}
+	}
 
-		if describeImage.Images[0].BlockDeviceMappings[0].Ebs.SnapshotId != nil {
-			snapshotId = *describeImage.Images[0].BlockDeviceMappings[0].Ebs.SnapshotId
-			createdImage = describeImage.Images[0]
-			break
+	if opt.deprecateAfter != "" {
+		if _, err := deprecationTime(opt.deprecateAfter, time.Now()); err != nil {
+			fatal(err)
 		}
+	}
 
-		if opt.verbose {
-			fmt.Println("waiting for snapshot to be created")
+	opt.noWait = !wait || async
+	if opt.noWait && (postCreation || dryRun || opt.kmsKeyID != "" || bootMode != "" || tpmSupport != "" || opt.requireIMDSv2 || opt.deprecateAfter != "" || opt.mirrorImageTags || opt.snapshotLock.mode != "" || opt.changeReport) {
+		fatal("-wait=false cannot be combined with -dry-run, the steps after creation or the options applied to the available image, such as -kms-key-id, -boot-mode, -tpm-support, -require-imdsv2, -deprecate-after, -mirror-image-tags-to-snapshots, -snapshot-lock or -change-report")
+	}
+
+	if bootMode != "" {
+		m, err := parseBootMode(bootMode)
+		if err != nil {
+			fatal(err)
 		}
-		time.Sleep(5 * time.Second)
+		opt.bootMode = m
 	}
 
-	for {
-		snapshotsOutput, err := client.DescribeSnapshots(ctx, &ec2.DescribeSnapshotsInput{SnapshotIds: []string{snapshotId}})
+	if tpmSupport != "" {
+		v, err := parseTpmSupport(tpmSupport)
 		if err != nil {
-			fmt.Printf("error describing snapshots: %v\n", err)
-			os.Exit(1)
+			fatal(err)
 		}
+		opt.tpmSupport = v
+	}
 
-		if len(snapshotsOutput.Snapshots) == 0 {
-			fmt.Println("no snapshots found")
-			os.Exit(1)
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	if self {
+		if opt.instanceID, err = selfInstanceID(ctx); err != nil {
+			fatal(err)
 		}
+		awsOpt.imdsRegion = true
+	}
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	if summaryFile != "" {
+		runRecorder = newSummaryRecorder(summaryFile, "create")
+		runRecorder.countRequests(&cfg)
+	}
+	if pick {
+		if opt.instanceID, err = pickInstance(ctx, ec2.NewFromConfig(cfg)); err != nil {
+			fatal(err)
+		}
+	}
 
-		snapshot := snapshotsOutput.Snapshots[0]
-		if snapshot.State == types.SnapshotStateCompleted {
-			break
-		} else if snapshot.State == types.SnapshotStateError {
-			fmt.Println("snapshot creation failed")
-			os.Exit(1)
-		} else if snapshot.State != types.SnapshotStatePending {
-			fmt.Printf("snapshot state: %v\n", snapshot.State)
+	if traceFile != "" {
+		trace = newTracer()
+		trace.instrument(&cfg)
+	}
+	setRunID(runIDFlag)
+	if statusFile != "" {
+		runStatus = newStatusWriter(statusFile)
+	}
+	if logGroup != "" {
+		if runLog, err = newCWLogger(ctx, cfg, logGroup); err != nil {
+			fatal(err)
+		}
+	}
+	if statsdAddr != "" {
+		if metrics, err = newStatsdClient(statsdAddr, "command:create"); err != nil {
+			fatal(err)
+		}
+	}
+	if metricsNamespace != "" {
+		cloudMetrics = newCWMetrics(cfg, metricsNamespace, "create")
+	}
+	if len(notifyTo) > 0 {
+		runNotifier = newNotifier(cfg, "create", notifyTo)
+	}
+	if taskToken != "" {
+		runCallback = newTaskCallback(cfg, taskToken)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// res is the image once created, given to the on-failure hooks.
+	var res *result
+	fail := func(err error) {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s: %w", timeout, err)
+		} else if aborted(ctx) {
+			err = fmt.Errorf("aborted: %w", err)
+		}
+		failRun(err)
+		if err := hooks.run(context.Background(), hookOnFailure, lastPhase(), res, nil); err != nil {
+			warnf("%v", err)
+		}
+		exitWith(exitCode(ctx, err), err)
+	}
+
+	if dryRun {
+		opt.dryRun = newDryRunReport()
+		if _, err := createImage(ctx, cfg, opt); err != nil {
+			fail(err)
+		}
+		printResult(opt.dryRun, query)
+		if !opt.dryRun.Permitted {
 			os.Exit(1)
 		}
+		return
+	}
 
+	if inputFile != "" {
+		report := createImageJobs(ctx, cfg, jobs, concurrency, opt, onError)
+		if err := trace.write(traceFile); err != nil {
+			fatal(err)
+		}
+		logf("created %d of %d images (%d failed, %d cancelled)", report.Succeeded, report.Total, report.Failed, report.Cancelled)
+		runLog.close()
+		os.Exit(report.exitStatus())
+	}
+
+	if batch {
+		ids := []string(instanceIDs)
+		if allMatching {
+			if ids, err = opt.instance.resolveAll(ctx, ec2.NewFromConfig(cfg)); err != nil {
+				fail(err)
+			}
+		}
+		results, report := createImageBatch(ctx, cfg, ids, concurrency, opt, onError)
+		if err := trace.write(traceFile); err != nil {
+			fatal(err)
+		}
 		if opt.verbose {
-			fmt.Printf("snapshot state: %v, progress: %s\n", snapshot.State, *snapshot.Progress)
+			logf("created %d of %d images (%d failed, %d cancelled)", report.Succeeded, report.Total, report.Failed, report.Cancelled)
+		}
+		printResult(results, query)
+		runLog.close()
+		os.Exit(report.exitStatus())
+	}
+
+	if len(profiles) > 0 {
+		report := createImageProfiles(ctx, awsOpt, profiles, opt, onError)
+		if err := trace.write(traceFile); err != nil {
+			fatal(err)
+		}
+		printResult(report, query)
+		runLog.close()
+		if !report.Passed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if accounts != nil {
+		report := createImageAccounts(ctx, cfg, accounts, concurrency, opt, onError)
+		if err := trace.write(traceFile); err != nil {
+			fatal(err)
 		}
-		time.Sleep(5 * time.Second)
+		logf("created %d of %d images (%d failed, %d cancelled)", report.Succeeded, report.Total, report.Failed, report.Cancelled)
+		printResult(report, query)
+		runLog.close()
+		os.Exit(report.exitStatus())
 	}
 
-	o, err := json.Marshal(createdImage)
+	if err := hooks.run(ctx, hookPreCreate, "", nil, nil); err != nil {
+		fail(err)
+	}
+	res, err = createImage(ctx, cfg, opt)
+	if err := trace.write(traceFile); err != nil {
+		fatal(err)
+	}
 	if err != nil {
-		fmt.Printf("error marshalling image: %v\n", err)
+		fail(err)
+	}
+	runLog.event("info", "created image "+*res.ImageId)
+	if err := hooks.run(ctx, hookPostAvailable, "", res, map[string]string{cfg.Region: *res.ImageId}); err != nil {
+		fail(err)
+	}
+	metrics.gauge("image.size_gib", float64(imageSizeGiB(res.Image)))
+	cloudMetrics.image(imageSizeGiB(res.Image))
+	if canary.asg != "" || verify || inspectorScan || gateCmd != "" || fastLaunch > 0 || len(fsrZones) > 0 {
+		setPhase("validating image")
+		client := ec2.NewFromConfig(cfg)
+		if err := validateImage(ctx, client, *res.ImageId, opt.verbose); err != nil {
+			fail(err)
+		}
+	}
+	if verify {
+		setPhase("verifying image")
+		res.Verify, err = verifyImage(ctx, cfg, *res.ImageId, verifyOpt, opt.verbose)
+		if err != nil {
+			fail(err)
+		}
+	}
+	verified := res.Verify == nil || res.Verify.Passed
+	if len(copyRegions) > 0 && verified {
+		setPhase("copying image")
+		image, err := waitImageAvailable(ctx, ec2.NewFromConfig(cfg), *res.ImageId, opt.verbose)
+		if err != nil {
+			fail(err)
+		}
+		if res.Copies, err = copyImage(ctx, cfg, image, copyRegions, copyOptions{attrs: imageAttributes{tpmSupport: opt.tpmSupport}, verbose: opt.verbose, bars: opt.progressBars}); err != nil {
+			fail(err)
+		}
+	}
+	if (len(shareWith) > 0 || len(shareWithOrg) > 0) && verified {
+		setPhase("sharing image")
+		targets := append(append([]string{}, shareWith...), shareWithOrg...)
+		images := map[string]string{cfg.Region: *res.ImageId}
+		for region, id := range res.Copies {
+			images[region] = id
+		}
+		for region, id := range images {
+			client := regionalClient(cfg, region)
+			if _, err := waitImageAvailable(ctx, client, id, opt.verbose); err != nil {
+				fail(err)
+			}
+			if err := shareImage(ctx, client, id, targets); err != nil {
+				fail(err)
+			}
+		}
+		res.SharedWith = targets
+	}
+	if fastLaunch > 0 && verified {
+		setPhase("enabling fast launch")
+		res.FastLaunch, err = enableFastLaunch(ctx, ec2.NewFromConfig(cfg), res.Image, int32(fastLaunch), opt.verbose)
+		if err != nil {
+			fail(err)
+		}
+	}
+	if len(fsrZones) > 0 && verified {
+		setPhase("enabling fast snapshot restore")
+		res.FastSnapshotRestore, err = enableFastSnapshotRestores(ctx, ec2.NewFromConfig(cfg), res.Image, fsrZones, waitFSR, opt.verbose)
+		if err != nil {
+			fail(err)
+		}
+	}
+	if inspectorScan && verified {
+		setPhase("inspector scan")
+		res.Inspector, err = inspectImage(ctx, cfg, *res.ImageId, inspector, opt.verbose)
+		if err != nil {
+			fail(err)
+		}
+	}
+	if gateCmd != "" && verified && (res.Inspector == nil || res.Inspector.Passed) {
+		setPhase("approval gate")
+		res.Gate, err = runGate(ctx, gateCmd, *res.ImageId, res)
+		if err != nil {
+			fail(err)
+		}
+		if err := tagApproval(ctx, ec2.NewFromConfig(cfg), []string{*res.ImageId}, res.Gate.Approved); err != nil {
+			fail(err)
+		}
+	}
+	if canary.asg != "" && verified && (res.Inspector == nil || res.Inspector.Passed) && (res.Gate == nil || res.Gate.Approved) {
+		setPhase("canary")
+		res.Canary, err = runCanary(ctx, cfg, *res.ImageId, canary, opt.verbose)
+		if err != nil {
+			fail(err)
+		}
+	}
+	promotable := verified && (res.Inspector == nil || res.Inspector.Passed) && (res.Gate == nil || res.Gate.Approved) && (res.Canary == nil || res.Canary.Promotable)
+	if ssmParameter != "" && promotable {
+		setPhase("writing SSM parameter")
+		client := ssm.NewFromConfig(cfg)
+		put := func() (int64, error) { return putImageParameter(ctx, client, ssmParameter, *res.ImageId) }
+		if ssmParameterJSON {
+			put = func() (int64, error) { return putResultParameter(ctx, client, ssmParameter, res) }
+		}
+		v, err := put()
+		if err != nil {
+			fail(err)
+		}
+		res.SSMParameter = &ssmParameterResult{Name: ssmParameter, Version: v}
+	}
+	if updateLT != "" && promotable {
+		setPhase("updating launch template")
+		res.LaunchTemplate, err = updateLaunchTemplate(ctx, ec2.NewFromConfig(cfg), ltUpdate, *res.ImageId, ltDefault)
+		if err != nil {
+			fail(err)
+		}
+	}
+	if len(promoteTag) > 0 && promotable {
+		setPhase("promoting image")
+		images := map[string]string{cfg.Region: *res.ImageId}
+		for region, id := range res.Copies {
+			images[region] = id
+		}
+		res.Promotion, err = promoteImages(ctx, cfg, promoteTag[0], images, deprecateSuperseded)
+		if err != nil {
+			fail(err)
+		}
+	}
+	if refreshASG != "" && promotable {
+		setPhase("refreshing auto scaling group")
+		client := autoscaling.NewFromConfig(cfg)
+		id, err := startInstanceRefresh(ctx, client, refreshASG, int32(minHealthy))
+		if err != nil {
+			fail(err)
+		}
+		res.InstanceRefresh = &instanceRefreshResult{AutoScalingGroup: refreshASG, InstanceRefreshId: id}
+		if waitRefresh {
+			if err := waitInstanceRefresh(ctx, client, res.InstanceRefresh, opt.verbose); err != nil {
+				fail(err)
+			}
+		}
+	}
+
+	if archive && verified {
+		setPhase("archiving snapshots")
+		res.Archive, err = archiveImage(ctx, ec2.NewFromConfig(cfg), res.Image, false, opt.verbose)
+		if err != nil {
+			fail(err)
+		}
+	}
+
+	images := map[string]string{cfg.Region: *res.ImageId}
+	for region, id := range res.Copies {
+		images[region] = id
+	}
+	if runbookFile != "" {
+		if err := writeRunbook(runbookFile, newRunbook(res, images)); err != nil {
+			fail(err)
+		}
+	}
+	if manifestFile != "" {
+		if err := writeManifest(manifestFile, newPackerBuild(res, images)); err != nil {
+			fail(err)
+		}
+	}
+	if inventoryTable != "" {
+		if err := recordInventory(ctx, cfg, inventoryTable, res, images, promotable); err != nil {
+			fail(err)
+		}
+	}
+	if summary {
+		printSummary(os.Stderr, res)
+	}
+	printResult(res, query)
+	if !promotable {
+		setPhase("rejected")
+		rejected := errors.New("image rejected by the verification, inspector scan, approval gate or canary")
+		runNotifier.finish(res, rejected)
+		runCallback.failWith(taskRejected, 1, rejected)
+		runRecorder.finish(res, images, rejected)
+		if err := hooks.run(context.Background(), hookOnFailure, "rejected", res, nil); err != nil {
+			warnf("%v", err)
+		}
+		metrics.finish(false, time.Since(runStarted))
+		cloudMetrics.finish(false, time.Since(runStarted))
+		runLog.close()
 		os.Exit(1)
 	}
-	fmt.Printf("%s\n", o)
+	setPhase("done")
+	runNotifier.finish(res, nil)
+	runCallback.succeed(res)
+	runRecorder.finish(res, images, nil)
+	metrics.finish(true, time.Since(runStarted))
+	cloudMetrics.finish(true, time.Since(runStarted))
+	runLog.close()
 }
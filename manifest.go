@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// packerManifest is the manifest written by the Packer manifest
+// post-processor, so that tooling consuming it works with amimati unchanged.
+type packerManifest struct {
+	Builds      []packerBuild `json:"builds"`
+	LastRunUUID string        `json:"last_run_uuid"`
+}
+
+type packerBuild struct {
+	Name        string `json:"name"`
+	BuilderType string `json:"builder_type"`
+	// BuildTime is the Unix time the build finished.
+	BuildTime int64    `json:"build_time"`
+	Files     []string `json:"files"`
+	// ArtifactID lists the images as region:image-id, comma separated, as
+	// the amazon-ebs builder does.
+	ArtifactID    string            `json:"artifact_id"`
+	PackerRunUUID string            `json:"packer_run_uuid"`
+	CustomData    map[string]string `json:"custom_data"`
+}
+
+// newPackerBuild describes the image and its copies, by region, as a Packer
+// amazon-ebs build, with the tags of the image as custom data.
+func newPackerBuild(res *result, images map[string]string) packerBuild {
+	var artifacts []string
+	for region, id := range images {
+		artifacts = append(artifacts, region+":"+id)
+	}
+	sort.Strings(artifacts)
+	data := map[string]string{}
+	for _, tag := range res.Tags {
+		data[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return packerBuild{
+		Name:          aws.ToString(res.Name),
+		BuilderType:   "amazon-ebs",
+		ArtifactID:    strings.Join(artifacts, ","),
+		PackerRunUUID: runID,
+		CustomData:    data,
+		BuildTime:     time.Now().Unix(),
+	}
+}
+
+// writeManifest appends the build to the manifest at path, created if it
+// does not exist, as Packer does across runs.
+func writeManifest(path string, b packerBuild) error {
+	var m packerManifest
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("error reading manifest: %w", err)
+	default:
+		if err := json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("error parsing manifest %s: %w", path, err)
+		}
+	}
+	m.Builds = append(m.Builds, b)
+	m.LastRunUUID = b.PackerRunUUID
+	if err := writeFileAtomic(path, m); err != nil {
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	return nil
+}
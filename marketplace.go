@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// checkProductCodes fails when the instance, or the image it was launched
+// from, carries AWS Marketplace product codes. Images inherit the codes, which
+// prevents sharing them, making them public or launching them in other
+// accounts.
+func checkProductCodes(ctx context.Context, client *ec2.Client, instance types.Instance) error {
+	codes := map[string]bool{}
+	for _, pc := range instance.ProductCodes {
+		if pc.ProductCodeType == types.ProductCodeValuesMarketplace {
+			codes[aws.ToString(pc.ProductCodeId)] = true
+		}
+	}
+	// The source image may have been deregistered or not be visible to us, in
+	// which case only the instance's own codes are checked.
+	if instance.ImageId != nil {
+		out, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: []string{*instance.ImageId}})
+		if err == nil && len(out.Images) > 0 {
+			for _, pc := range out.Images[0].ProductCodes {
+				if pc.ProductCodeType == types.ProductCodeValuesMarketplace {
+					codes[aws.ToString(pc.ProductCodeId)] = true
+				}
+			}
+		}
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+
+	var ids []string
+	for id := range codes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return fmt.Errorf("instance %s carries AWS Marketplace product codes (%s) from %s: the image would inherit them and could not be shared, made public or launched in other accounts; use -allow-marketplace to create it for this account anyway",
+		*instance.InstanceId, strings.Join(ids, ", "), aws.ToString(instance.ImageId))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	nameSuffixRegion       = "region"
+	nameSuffixArchitecture = "architecture"
+)
+
+// namingConfig is the naming convention enforced on image names.
+type namingConfig struct {
+	// Prefix is prepended to names that do not start with it.
+	Prefix string `json:"prefix"`
+	// Suffixes are appended to names in order, each separated by a dash:
+	// "region" for the region code and "architecture" for the architecture
+	// of the instance.
+	Suffixes []string `json:"suffixes"`
+}
+
+// loadNaming returns the naming convention of the config file, if any, with
+// AMIMATI_NAME_PREFIX and AMIMATI_NAME_SUFFIXES (comma separated) taking
+// precedence over it.
+func loadNaming(c *fileConfig) (namingConfig, error) {
+	var n namingConfig
+	if c != nil && c.Naming != nil {
+		n = *c.Naming
+	}
+	if v, ok := os.LookupEnv("AMIMATI_NAME_PREFIX"); ok {
+		n.Prefix = v
+	}
+	if v, ok := os.LookupEnv("AMIMATI_NAME_SUFFIXES"); ok {
+		n.Suffixes = nil
+		if v != "" {
+			n.Suffixes = strings.Split(v, ",")
+		}
+	}
+	return n, n.validate()
+}
+
+func (n namingConfig) validate() error {
+	for _, s := range n.Suffixes {
+		switch s {
+		case nameSuffixRegion, nameSuffixArchitecture:
+		default:
+			return fmt.Errorf("invalid name suffix: %s", s)
+		}
+	}
+	return nil
+}
+
+// apply returns name following the convention. Prefixes and suffixes already
+// present are not added again.
+func (n namingConfig) apply(name, region string, arch types.ArchitectureValues) (string, error) {
+	if n.Prefix != "" && !strings.HasPrefix(name, n.Prefix) {
+		name = n.Prefix + name
+	}
+	for _, s := range n.Suffixes {
+		var v string
+		switch s {
+		case nameSuffixRegion:
+			v = region
+		case nameSuffixArchitecture:
+			v = string(arch)
+		}
+		if v == "" {
+			return "", errors.New("cannot resolve name suffix " + s)
+		}
+		if !strings.HasSuffix(name, "-"+v) {
+			name += "-" + v
+		}
+	}
+	return name, nil
+}
+
+// loadOptionalConfigFile loads the config file, returning nil if it does not
+// exist.
+func loadOptionalConfigFile(path string) (*fileConfig, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return loadConfigFile(path)
+}
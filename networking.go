@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// checkNetworking warns when the image lacks the enhanced networking that
+// the source instance had or that the target instance types need.
+func checkNetworking(ctx context.Context, client *ec2.Client, instance types.Instance, image types.Image, targets []string) error {
+	ena := aws.ToBool(image.EnaSupport)
+	sriov := aws.ToString(image.SriovNetSupport) == "simple"
+	if aws.ToBool(instance.EnaSupport) && !ena {
+		warnf("instance %s has ENA support but image %s does not", *instance.InstanceId, *image.ImageId)
+	}
+	if aws.ToString(instance.SriovNetSupport) == "simple" && !sriov {
+		warnf("instance %s has SR-IOV support but image %s does not", *instance.InstanceId, *image.ImageId)
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	in := &ec2.DescribeInstanceTypesInput{}
+	for _, t := range targets {
+		in.InstanceTypes = append(in.InstanceTypes, types.InstanceType(t))
+	}
+	out, err := client.DescribeInstanceTypes(ctx, in)
+	if err != nil {
+		return fmt.Errorf("error describing instance types: %w", err)
+	}
+	for _, it := range out.InstanceTypes {
+		if it.NetworkInfo == nil {
+			continue
+		}
+		switch it.NetworkInfo.EnaSupport {
+		case types.EnaSupportRequired:
+			if !ena {
+				warnf("image %s lacks ENA support required by %s", *image.ImageId, it.InstanceType)
+			}
+		case types.EnaSupportSupported:
+			if !ena && !sriov {
+				warnf("image %s has no enhanced networking on %s", *image.ImageId, it.InstanceType)
+			}
+		case types.EnaSupportUnsupported:
+			if !sriov {
+				warnf("image %s lacks SR-IOV support for enhanced networking on %s", *image.ImageId, it.InstanceType)
+			}
+		}
+	}
+	return nil
+}
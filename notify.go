@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+
+	"github.com/otama-jaccy/amimati/pkg/amimati"
+)
+
+const (
+	notifySNS     = "sns"
+	notifySlack   = "slack"
+	notifyWebhook = "webhook"
+)
+
+// notifyTimeout bounds the delivery of a notification to each target. It is
+// counted from a fresh context so that a run that timed out or was aborted
+// is still reported.
+const notifyTimeout = 30 * time.Second
+
+type notifyTarget struct {
+	kind string
+	// target is the topic ARN of sns, and the URL of slack and webhook.
+	target string
+}
+
+type notifyTargets []notifyTarget
+
+func (t *notifyTargets) String() string {
+	var s []string
+	for _, n := range *t {
+		s = append(s, n.kind+":"+n.target)
+	}
+	return strings.Join(s, ",")
+}
+
+func (t *notifyTargets) Set(value string) error {
+	kind, target, _ := strings.Cut(value, ":")
+	switch kind {
+	case notifySNS:
+		if a, err := arn.Parse(target); err != nil || a.Service != "sns" {
+			return fmt.Errorf("invalid SNS topic ARN: %s", target)
+		}
+	case notifySlack, notifyWebhook:
+		if u, err := url.Parse(target); err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+			return fmt.Errorf("invalid %s URL: %s", kind, target)
+		}
+	default:
+		return fmt.Errorf("invalid notification target, want sns:<topic ARN>, slack:<webhook URL> or webhook:<URL>: %s", value)
+	}
+	*t = append(*t, notifyTarget{kind: kind, target: target})
+	return nil
+}
+
+// notification is the payload posted when a run finishes.
+type notification struct {
+	RunId     string `json:",omitempty"`
+	Command   string
+	Succeeded bool
+	Error     string `json:",omitempty"`
+	Region    string
+	ImageId   string `json:",omitempty"`
+	ImageName string `json:",omitempty"`
+	// Duration is the duration of the run in seconds.
+	Duration    float64
+	SnapshotIds []string `json:",omitempty"`
+}
+
+// text summarizes the notification in a line, for Slack and SNS subjects.
+func (m notification) text() string {
+	image := m.ImageId
+	if m.ImageName != "" {
+		image += " (" + m.ImageName + ")"
+	}
+	d := time.Duration(m.Duration * float64(time.Second)).Round(time.Second)
+	if m.Succeeded {
+		return fmt.Sprintf("amimati %s succeeded after %s: %s in %s", m.Command, d, image, m.Region)
+	}
+	if image == "" {
+		return fmt.Sprintf("amimati %s failed after %s in %s: %s", m.Command, d, m.Region, m.Error)
+	}
+	return fmt.Sprintf("amimati %s failed after %s: %s in %s: %s", m.Command, d, image, m.Region, m.Error)
+}
+
+// notifier sends a notification to its targets when the run finishes. A nil
+// notifier sends nothing.
+type notifier struct {
+	cfg     aws.Config
+	command string
+	targets notifyTargets
+
+	mu      sync.Mutex
+	imageID string
+	sent    bool
+}
+
+var runNotifier *notifier
+
+func newNotifier(cfg aws.Config, command string, targets notifyTargets) *notifier {
+	return &notifier{cfg: cfg, command: command, targets: targets}
+}
+
+// image records the image being created, reported if the run fails.
+func (n *notifier) image(imageID string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.imageID = imageID
+}
+
+// fail notifies that the run failed with err.
+func (n *notifier) fail(err error) {
+	n.finish(nil, err)
+}
+
+// finish notifies that the run finished with the image of res, failed if err
+// is not nil. Only the first call of a run sends.
+func (n *notifier) finish(res *result, err error) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	if n.sent {
+		n.mu.Unlock()
+		return
+	}
+	n.sent = true
+	m := notification{
+		RunId:     runID,
+		Command:   n.command,
+		Succeeded: err == nil,
+		Region:    n.cfg.Region,
+		ImageId:   n.imageID,
+		Duration:  time.Since(runStarted).Seconds(),
+	}
+	n.mu.Unlock()
+	if err != nil {
+		m.Error = err.Error()
+	}
+	if res != nil {
+		m.ImageId = aws.ToString(res.ImageId)
+		m.ImageName = aws.ToString(res.Name)
+		m.SnapshotIds, _ = amimati.MappedSnapshots(res.Image)
+	}
+
+	// Notifications are best effort: a target that cannot be reached is
+	// warned about and does not change the outcome of the run.
+	for _, t := range n.targets {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		if err := n.send(ctx, t, m); err != nil {
+			warnf("error notifying %s: %v", t.kind, err)
+		}
+		cancel()
+	}
+}
+
+func (n *notifier) send(ctx context.Context, t notifyTarget, m notification) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	switch t.kind {
+	case notifySNS:
+		return publishNotification(ctx, n.cfg, t.target, m.text(), string(b))
+	case notifySlack:
+		b, err := json.Marshal(map[string]string{"text": m.text()})
+		if err != nil {
+			return err
+		}
+		return postNotification(ctx, t.target, b)
+	default:
+		return postNotification(ctx, t.target, b)
+	}
+}
+
+// publishNotification publishes the message to the topic, in the region of
+// the topic.
+func publishNotification(ctx context.Context, cfg aws.Config, topicARN, subject, message string) error {
+	a, err := arn.Parse(topicARN)
+	if err != nil {
+		return err
+	}
+	// SNS subjects are a single line of at most 100 characters.
+	subject = strings.Join(strings.Fields(subject), " ")
+	if len(subject) > 100 {
+		subject = subject[:97] + "..."
+	}
+	client := sns.NewFromConfig(cfg, func(o *sns.Options) { o.Region = a.Region })
+	if _, err := client.Publish(ctx, &sns.PublishInput{TopicArn: &topicARN, Subject: &subject, Message: &message}); err != nil {
+		return fmt.Errorf("error publishing to %s: %w", topicARN, err)
+	}
+	return nil
+}
+
+// postNotification posts the JSON body to the URL, failing unless it
+// answers with a 2xx status.
+func postNotification(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error posting notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("error posting notification: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	onErrorContinue  = "continue"
+	onErrorFailFast  = "fail-fast"
+	onErrorThreshold = "threshold"
+)
+
+// errorPolicy decides how failed runs of a batch affect the others and the
+// outcome of the batch. It is a flag taking continue, fail-fast or
+// threshold=N%.
+type errorPolicy struct {
+	mode string
+	// threshold is the fraction of runs allowed to fail with the threshold
+	// mode.
+	threshold float64
+}
+
+func (p *errorPolicy) String() string {
+	if p.mode == onErrorThreshold {
+		return fmt.Sprintf("%s=%s%%", p.mode, strconv.FormatFloat(p.threshold*100, 'f', -1, 64))
+	}
+	return p.mode
+}
+
+func (p *errorPolicy) Set(value string) error {
+	switch value {
+	case onErrorContinue, onErrorFailFast:
+		*p = errorPolicy{mode: value}
+		return nil
+	}
+	v, ok := strings.CutPrefix(value, onErrorThreshold+"=")
+	if !ok {
+		return fmt.Errorf("invalid error policy: %s", value)
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return fmt.Errorf("invalid error threshold: %s", v)
+	}
+	*p = errorPolicy{mode: onErrorThreshold, threshold: percent / 100}
+	return nil
+}
+
+// abort reports whether the remaining runs should be cancelled after failed
+// of the total runs have failed.
+func (p errorPolicy) abort(failed, total int) bool {
+	switch p.mode {
+	case onErrorFailFast:
+		return failed > 0
+	case onErrorThreshold:
+		return float64(failed) > p.threshold*float64(total)
+	}
+	return false
+}
+
+// passed reports whether a batch with failed of the total runs failed
+// succeeds.
+func (p errorPolicy) passed(failed, total int) bool {
+	if p.mode == onErrorThreshold {
+		return float64(failed) <= p.threshold*float64(total)
+	}
+	return failed == 0
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jmespath/go-jmespath"
+)
+
+// printResult writes v to stdout in the output format. When query is set,
+// the JMESPath expression is applied to the document first, and with the json
+// format a string result is printed without quotes so it can be consumed by
+// shell scripts.
+func printResult(v any, query string) {
+	o, err := json.Marshal(v)
+	if err != nil {
+		fatalf("error marshalling result: %v", err)
+	}
+	if outputFormat == outputJSON && query == "" {
+		fmt.Printf("%s\n", o)
+		return
+	}
+	var doc any
+	if err := json.Unmarshal(o, &doc); err != nil {
+		fatalf("error unmarshalling result: %v", err)
+	}
+
+	if query != "" {
+		if doc, err = jmespath.Search(query, doc); err != nil {
+			fatalf("error evaluating query: %v", err)
+		}
+	}
+	if err := writeResult(os.Stdout, doc, outputFormat); err != nil {
+		fatal(err)
+	}
+}
+
+// validateOutput reports a malformed JMESPath expression or an unknown output
+// format before any work is done.
+func validateOutput(query string) error {
+	if err := validateFormat(outputFormat); err != nil {
+		return err
+	}
+	if quiet {
+		if outputFormat != outputJSON && outputFormat != outputID {
+			return fmt.Errorf("-quiet cannot be combined with -o %s", outputFormat)
+		}
+		outputFormat = outputID
+	}
+	if query == "" {
+		return nil
+	}
+	if _, err := jmespath.Compile(query); err != nil {
+		return fmt.Errorf("invalid query: %w", err)
+	}
+	return nil
+}
+
+// warnf logs a warning.
+func warnf(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	logger.Warn(msg, logAttrs()...)
+	runLog.event("warning", msg)
+}
+
+// logf logs a verbose progress line.
+func logf(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	logger.Info(msg, logAttrs()...)
+	runLog.event("info", msg)
+}
+
+// heartbeat is how often waitLog repeats an unchanged line; 0 never repeats
+// it.
+var heartbeat time.Duration
+
+// waitLog logs the lines of a polling loop, skipping those that repeat the
+// previous one until the heartbeat interval has passed.
+type waitLog struct {
+	last   string
+	logged time.Time
+}
+
+func (w *waitLog) logf(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	if msg == w.last && (heartbeat <= 0 || time.Since(w.logged) < heartbeat) {
+		return
+	}
+	logf("%s", msg)
+	w.last, w.logged = msg, time.Now()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+type ssmParameterResult struct {
+	Name    string
+	Version int64
+}
+
+// putImageParameter points the SSM parameter at the image, overwriting the
+// previous value, and returns the version written. The aws:ec2:image data
+// type lets EC2 resolve the parameter as an AMI alias (resolve:ssm:<name>).
+func putImageParameter(ctx context.Context, client *ssm.Client, name, imageID string) (int64, error) {
+	out, err := client.PutParameter(ctx, &ssm.PutParameterInput{
+		Name:      &name,
+		Value:     &imageID,
+		Type:      ssmtypes.ParameterTypeString,
+		DataType:  aws.String("aws:ec2:image"),
+		Overwrite: aws.Bool(true),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error putting parameter %s: %w", name, err)
+	}
+	return out.Version, nil
+}
+
+// putResultParameter writes the result as JSON to the SSM parameter,
+// overwriting the previous value, and returns the version written.
+func putResultParameter(ctx context.Context, client *ssm.Client, name string, res *result) (int64, error) {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return 0, fmt.Errorf("error marshalling result: %w", err)
+	}
+	out, err := client.PutParameter(ctx, &ssm.PutParameterInput{
+		Name:      &name,
+		Value:     aws.String(string(b)),
+		Type:      ssmtypes.ParameterTypeString,
+		Overwrite: aws.Bool(true),
+		// The result of an image with a few devices outgrows the 4 KB of a
+		// standard parameter.
+		Tier: ssmtypes.ParameterTierIntelligentTiering,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error putting parameter %s: %w", name, err)
+	}
+	return out.Version, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// partitions maps region name prefixes to their AWS partition. Regions that
+// match none of them are in the commercial "aws" partition.
+var partitions = []struct {
+	prefix    string
+	partition string
+}{
+	{"us-gov-", "aws-us-gov"},
+	{"cn-", "aws-cn"},
+	{"us-isob-", "aws-iso-b"},
+	{"us-iso-", "aws-iso"},
+	{"eu-isoe-", "aws-iso-e"},
+	{"us-isof-", "aws-iso-f"},
+}
+
+func partitionFor(region string) string {
+	for _, p := range partitions {
+		if strings.HasPrefix(region, p.prefix) {
+			return p.partition
+		}
+	}
+	return "aws"
+}
+
+// ec2ARN returns the ARN of an EC2 resource such as "image/ami-xxx". Images
+// and snapshots have no account ID in their ARN.
+func ec2ARN(region, resource string) string {
+	return fmt.Sprintf("arn:%s:ec2:%s::%s", partitionFor(region), region, resource)
+}
+
+// checkSamePartition fails for regions outside the partition of region, which
+// resources cannot be copied or shared to.
+func checkSamePartition(region string, regions []string) error {
+	p := partitionFor(region)
+	for _, r := range regions {
+		if partitionFor(r) != p {
+			return fmt.Errorf("region %s is in partition %s, not %s of %s", r, partitionFor(r), p, region)
+		}
+	}
+	return nil
+}
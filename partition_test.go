@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPartitionFor(t *testing.T) {
+	for _, tt := range []struct {
+		region, partition string
+	}{
+		{"us-east-1", "aws"},
+		{"eu-west-1", "aws"},
+		{"ap-southeast-5", "aws"},
+		{"us-gov-west-1", "aws-us-gov"},
+		{"us-gov-east-1", "aws-us-gov"},
+		{"cn-north-1", "aws-cn"},
+		{"cn-northwest-1", "aws-cn"},
+		{"us-iso-east-1", "aws-iso"},
+		{"us-iso-west-1", "aws-iso"},
+		{"us-isob-east-1", "aws-iso-b"},
+		{"eu-isoe-west-1", "aws-iso-e"},
+		{"us-isof-south-1", "aws-iso-f"},
+	} {
+		if got := partitionFor(tt.region); got != tt.partition {
+			t.Errorf("partitionFor(%q) = %q, want %q", tt.region, got, tt.partition)
+		}
+	}
+}
+
+func TestEC2ARN(t *testing.T) {
+	for _, tt := range []struct {
+		region, want string
+	}{
+		{"us-east-1", "arn:aws:ec2:us-east-1::image/ami-1"},
+		{"us-gov-west-1", "arn:aws-us-gov:ec2:us-gov-west-1::image/ami-1"},
+		{"cn-north-1", "arn:aws-cn:ec2:cn-north-1::image/ami-1"},
+		{"us-iso-east-1", "arn:aws-iso:ec2:us-iso-east-1::image/ami-1"},
+		{"us-isob-east-1", "arn:aws-iso-b:ec2:us-isob-east-1::image/ami-1"},
+	} {
+		if got := ec2ARN(tt.region, "image/ami-1"); got != tt.want {
+			t.Errorf("ec2ARN(%q) = %q, want %q", tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestCheckSamePartition(t *testing.T) {
+	if err := checkSamePartition("us-east-1", []string{"eu-west-1", "ap-northeast-1"}); err != nil {
+		t.Errorf("commercial regions: %v", err)
+	}
+	if err := checkSamePartition("us-gov-west-1", []string{"us-gov-east-1"}); err != nil {
+		t.Errorf("GovCloud regions: %v", err)
+	}
+	for _, tt := range [][2]string{
+		{"us-east-1", "us-gov-west-1"},
+		{"us-east-1", "cn-north-1"},
+		{"us-iso-east-1", "us-isob-east-1"},
+		{"cn-north-1", "us-east-1"},
+	} {
+		err := checkSamePartition(tt[0], []string{tt[1]})
+		if err == nil || !strings.Contains(err.Error(), tt[1]) {
+			t.Errorf("checkSamePartition(%q, %q) = %v, want an error naming %s", tt[0], tt[1], err, tt[1])
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// pickerRows is the number of instances the picker lists at a time.
+const pickerRows = 20
+
+// interactive reports whether amimati is run from an interactive terminal,
+// where it can ask which instance to image: stdin and stdout are terminals.
+func interactive() bool {
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	for _, f := range []*os.File{os.Stdin, os.Stdout} {
+		if fi, err := f.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// pickerInstance is an instance listed by the picker.
+type pickerInstance struct {
+	id, name, instanceType, zone string
+}
+
+func (p pickerInstance) String() string {
+	return fmt.Sprintf("%-19s  %-30s  %-12s  %s", p.id, p.name, p.instanceType, p.zone)
+}
+
+// pickInstance lists the running instances on stderr and asks on stdin
+// which one to image. A line narrows the list to the instances matching it
+// as a fuzzy search, and a number picks one of those listed.
+func pickInstance(ctx context.Context, client *ec2.Client) (string, error) {
+	var all []pickerInstance
+	p := ec2.NewDescribeInstancesPaginator(client, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{{Name: aws.String("instance-state-name"), Values: []string{"running"}}},
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return "", fmt.Errorf("error describing instances: %w", err)
+		}
+		for _, r := range page.Reservations {
+			for _, i := range r.Instances {
+				pi := pickerInstance{id: aws.ToString(i.InstanceId), name: tagValue(i.Tags, "Name"), instanceType: string(i.InstanceType)}
+				if i.Placement != nil {
+					pi.zone = aws.ToString(i.Placement.AvailabilityZone)
+				}
+				all = append(all, pi)
+			}
+		}
+	}
+	if len(all) == 0 {
+		return "", errors.New("no running instances to pick from")
+	}
+
+	in := bufio.NewScanner(os.Stdin)
+	query := ""
+	for {
+		matches := make([]pickerInstance, 0, len(all))
+		for _, pi := range all {
+			if fuzzyMatch(pi.id+" "+pi.name+" "+pi.instanceType+" "+pi.zone, query) {
+				matches = append(matches, pi)
+			}
+		}
+		shown := matches[:min(len(matches), pickerRows)]
+		fmt.Fprintln(os.Stderr)
+		for n, pi := range shown {
+			fmt.Fprintf(os.Stderr, "%3d) %s\n", n+1, pi)
+		}
+		if len(matches) > len(shown) {
+			fmt.Fprintf(os.Stderr, "     … %d more, type to narrow the list\n", len(matches)-len(shown))
+		}
+		if len(matches) == 0 {
+			fmt.Fprintf(os.Stderr, "no instances match %q\n", query)
+		}
+		fmt.Fprint(os.Stderr, "search, or number of the instance to image: ")
+		if !in.Scan() {
+			if err := in.Err(); err != nil {
+				return "", fmt.Errorf("error reading the instance to image: %w", err)
+			}
+			return "", fmt.Errorf("no instance picked: %w", io.EOF)
+		}
+		line := strings.TrimSpace(in.Text())
+		if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(shown) {
+			return shown[n-1].id, nil
+		}
+		if line == "" && len(matches) == 1 {
+			return matches[0].id, nil
+		}
+		query = line
+	}
+}
+
+// fuzzyMatch reports whether the characters of query appear in s in order,
+// ignoring case.
+func fuzzyMatch(s, query string) bool {
+	s = strings.ToLower(s)
+	for _, r := range strings.ToLower(query) {
+		if r == ' ' {
+			continue
+		}
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+1:]
+	}
+	return true
+}
@@ -0,0 +1,891 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	inspectortypes "github.com/aws/aws-sdk-go-v2/service/inspector2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+const (
+	stageCreate         = "create"
+	stageCopy           = "copy"
+	stageShare          = "share"
+	stageValidate       = "validate"
+	stageAlias          = "alias"
+	stagePrune          = "prune"
+	stageCanary         = "canary"
+	stageInspect        = "inspect"
+	stageGate           = "gate"
+	stageVerify         = "verify"
+	stageLaunchTemplate = "launchTemplate"
+	stageRefresh        = "refresh"
+
+	onFailureAbort    = "abort"
+	onFailureContinue = "continue"
+)
+
+type pipelineConfig struct {
+	InstanceId string `json:"instanceId"`
+	Name       string `json:"name"`
+	// Tags are the tags of both the image and its snapshots, as -tag,
+	// overridden by key by ImageTags and SnapshotTags.
+	Tags         map[string]string `json:"tags"`
+	ImageTags    map[string]string `json:"imageTags"`
+	SnapshotTags map[string]string `json:"snapshotTags"`
+	// DeviceSnapshotTags are the tags of the snapshots of single devices,
+	// by device name.
+	DeviceSnapshotTags  map[string]map[string]string `json:"deviceSnapshotTags"`
+	ExpireAfter         string                       `json:"expireAfter"`
+	BootMode            string                       `json:"bootMode"`
+	RequireIMDSv2       bool                         `json:"requireImdsv2"`
+	TpmSupport          string                       `json:"tpmSupport"`
+	TargetInstanceTypes []string                     `json:"targetInstanceTypes"`
+	AllowMarketplace    bool                         `json:"allowMarketplace"`
+	AdoptExisting       bool                         `json:"adoptExisting"`
+	SSMInventory        bool                         `json:"ssmInventory"`
+	// MirrorImageTagsToSnapshots applies the final tags of the image to its
+	// snapshots.
+	MirrorImageTagsToSnapshots bool `json:"mirrorImageTagsToSnapshots"`
+	NoReboot                   bool `json:"noReboot"`
+	// KmsKeyId and DeleteUnencrypted are -kms-key-id and
+	// -delete-unencrypted.
+	KmsKeyId          string `json:"kmsKeyId"`
+	DeleteUnencrypted bool   `json:"deleteUnencrypted"`
+	// IfExists and DeleteReplacedSnapshots are -if-exists and
+	// -delete-replaced-snapshots.
+	IfExists                string `json:"ifExists"`
+	DeleteReplacedSnapshots bool   `json:"deleteReplacedSnapshots"`
+	// CopyInstanceTags and CopyTagPrefix are -copy-instance-tags and
+	// -copy-tag-prefix.
+	CopyInstanceTags bool   `json:"copyInstanceTags"`
+	CopyTagPrefix    string `json:"copyTagPrefix"`
+	// Lineage is -lineage.
+	Lineage bool `json:"lineage"`
+	// CleanupOnAbort is -cleanup-on-abort.
+	CleanupOnAbort bool `json:"cleanupOnAbort"`
+	// Description and AutoDescribe are -description and -auto-describe.
+	Description  string `json:"description"`
+	AutoDescribe bool   `json:"autoDescribe"`
+	// ExcludeDevices and DeviceMappings are -exclude-device and
+	// -device-mapping values.
+	ExcludeDevices []string `json:"excludeDevices"`
+	DeviceMappings []string `json:"deviceMappings"`
+	// DeprecateAfter is -deprecate-after.
+	DeprecateAfter string `json:"deprecateAfter"`
+	// PreCommand, PostCommand and HookTimeout are -pre-command,
+	// -post-command and -hook-timeout.
+	PreCommand  string `json:"preCommand"`
+	PostCommand string `json:"postCommand"`
+	HookTimeout string `json:"hookTimeout"`
+	// VSS is -vss.
+	VSS bool `json:"vss"`
+	// InstanceName and InstanceFilters select the instance in place of
+	// InstanceId, as -instance-name and -filter do.
+	InstanceName    string              `json:"instanceName"`
+	InstanceFilters map[string][]string `json:"instanceFilters"`
+	// SnapshotLock is a -snapshot-lock value such as
+	// "mode=compliance,duration=365d".
+	SnapshotLock string `json:"snapshotLock"`
+	// ChangeReport reports the blocks changed since the previous snapshots,
+	// as -change-report does.
+	ChangeReport bool `json:"changeReport"`
+	// AccountNames maps account IDs to friendly names shown in the report.
+	AccountNames map[string]string `json:"accountNames"`
+	// Hooks are the local commands hooked to each event, as -hook.
+	Hooks  map[string][]string `json:"hooks"`
+	Stages []pipelineStage     `json:"stages"`
+}
+
+type pipelineStage struct {
+	Type string `json:"type"`
+	// OnFailure is either "abort" (default) to skip the remaining stages or
+	// "continue" to carry on with them.
+	OnFailure string `json:"onFailure"`
+
+	// copy
+	Regions []string `json:"regions"`
+	// TpmSupport re-registers the copies that lack NitroTPM support.
+	TpmSupport string `json:"tpmSupport"`
+	// RestoreArchivedDays temporarily restores snapshots of the image in the
+	// archive tier for this many days before copying.
+	RestoreArchivedDays int32 `json:"restoreArchivedDays"`
+	// MaxParallel bounds the regions copied into at a time.
+	MaxParallel int `json:"maxParallel"`
+	// FailFast cancels the other copies once a region has failed.
+	FailFast bool `json:"failFast"`
+	// share
+	Accounts []string `json:"accounts"`
+	// alias
+	Parameter string `json:"parameter"`
+	// launchTemplate, as -update-launch-template and -set-default-version
+	LaunchTemplate string `json:"launchTemplate"`
+	SetDefault     bool   `json:"setDefault"`
+	// refresh, of autoScalingGroup, as -min-healthy and -wait-refresh
+	MinHealthy int  `json:"minHealthy"`
+	Wait       bool `json:"wait"`
+	// prune
+	NamePrefix string `json:"namePrefix"`
+	KeepLast   int    `json:"keepLast"`
+	// canary
+	AutoScalingGroup string `json:"autoScalingGroup"`
+	Count            int    `json:"count"`
+	Timeout          string `json:"timeout"`
+	// inspect
+	Severity        string `json:"severity"`
+	InstanceType    string `json:"instanceType"`
+	SubnetId        string `json:"subnetId"`
+	InstanceProfile string `json:"instanceProfile"`
+	// gate, and the SSM health check of verify
+	Command string `json:"command"`
+	// verify, which also takes Command, InstanceType, SubnetId,
+	// InstanceProfile and Timeout
+	Probe               string `json:"probe"`
+	DeregisterOnFailure bool   `json:"deregisterOnFailure"`
+}
+
+type pipelineReport struct {
+	RunId     string `json:",omitempty"`
+	Succeeded bool
+	ImageId   string            `json:",omitempty"`
+	Images    map[string]string `json:",omitempty"`
+	// ConsoleUrls holds the console URLs of the images by region.
+	ConsoleUrls map[string]string `json:",omitempty"`
+	Stages      []stageReport
+}
+
+type stageReport struct {
+	Type     string
+	Status   string
+	Error    string  `json:",omitempty"`
+	Duration float64 `json:",omitempty"`
+	Output   any     `json:",omitempty"`
+	// Resumed is whether the stage succeeded in the run resumed with
+	// -resume, and was skipped.
+	Resumed bool `json:",omitempty"`
+}
+
+// pipelineState is shared between the stages of a pipeline run.
+type pipelineState struct {
+	cfg          aws.Config
+	verbose      bool
+	progressBars bool
+	naming       namingConfig
+	tagPolicy    tagPolicy
+	image        *result
+	// images holds the IDs of the created image and its copies by region.
+	images map[string]string
+	// resumed is the state of the run resumed with -resume, if any.
+	resumed *runStateDoc
+	// hooks are the -hook commands, run after those of the pipeline.
+	hooks localHooks
+}
+
+func runPipeline(args []string) {
+	var path, traceFile, runbookFile, manifestFile, inventoryTable, statusFile, runIDFlag, logGroup, statsdAddr, metricsNamespace string
+	var verbose, noProgress, resume bool
+	var query, stateFilePath string
+	var notifyTo notifyTargets
+	var taskToken string
+	var summaryFile string
+	var hooks localHooks
+	var requiredTags list
+	fs := flag.NewFlagSet("pipeline", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	timeOpt := addTimeFlags(fs)
+	fs.StringVar(&path, "config", "amimati.json", "config file")
+	remote := addRemoteConfigFlags(fs)
+	fs.BoolVar(&verbose, "v", false, "verbose output")
+	fs.BoolVar(&noProgress, "no-progress", false, "log the progress of the snapshots with -v instead of drawing progress bars on an interactive terminal")
+	fs.StringVar(&runbookFile, "runbook", "", "write a restore runbook for the image and its copies to this file(Markdown, or JSON if it ends in .json)")
+	fs.StringVar(&manifestFile, "manifest", "", "append the image and its copies as a build to this Packer manifest file(eg. manifest.json)")
+	fs.StringVar(&inventoryTable, "inventory-table", "", "record the image, its copies, source instance, snapshots, tags, duration and operator as an item of this DynamoDB table, keyed by the ImageId string(eg. amimati-inventory)")
+	fs.StringVar(&runIDFlag, "run-id", "", "correlation ID of the run, logged and tagged on the created resources as "+runIDTagKey+"(default: generated)")
+	fs.StringVar(&logGroup, "cloudwatch-log-group", "", "ship the log lines of the run to a stream named by the run ID in this CloudWatch Logs group(eg. /amimati/backups)")
+	fs.StringVar(&statsdAddr, "statsd-addr", "", "send run metrics to this StatsD/DogStatsD address(eg. localhost:8125)")
+	fs.StringVar(&metricsNamespace, "metrics-namespace", "", "publish the outcome of the run as CloudWatch metrics in this namespace(eg. Amimati)")
+	fs.Var(&notifyTo, "notify", "when the run finishes, post its outcome to this target, repeatable(eg. sns:arn:aws:sns:us-east-1:111122223333:backups, slack:https://hooks.slack.com/services/..., webhook:https://example.com/hook)")
+	fs.StringVar(&taskToken, "task-token", "", "when the run finishes, answer this Step Functions task token with SendTaskSuccess and the report, or SendTaskFailure and the error(eg. $TASK_TOKEN)")
+	fs.StringVar(&summaryFile, "summary-file", "", "when the run finishes, write the wall time, phase and snapshot durations, AWS call counts and resource IDs of the run to this JSON file(eg. summary.json)")
+	fs.StringVar(&statusFile, "status-file", "", "keep the current stage, snapshot progress and ETA of the run in this JSON file(eg. /tmp/amimati.status.json)")
+	fs.Var(&hooks, "hook", "run a local command on an event of the run: pre-create, post-available, on-failure, or pre-<stage> and post-<stage> around a stage, repeatable(eg. post-available=./notify.sh)")
+	fs.Var(&requiredTags, "required-tags", "fail before creating the image unless it is tagged with these keys, whose value must match the regular expression after =, if any(eg. owner,cost-center=^CC-[0-9]+$)")
+	fs.BoolVar(&resume, "resume", false, "keep the state of the run in -state-file and, if it exists, resume the interrupted run it describes, skipping the stages that succeeded and waiting for its image instead of creating another")
+	fs.StringVar(&stateFilePath, "state-file", defaultStateFile, "with -resume, the state file of the run, removed once it succeeds")
+	fs.DurationVar(&heartbeat, "heartbeat", 0, "with -v, repeat an unchanged waiting line this often(eg. 5m; default: only print changes)")
+	fs.StringVar(&traceFile, "trace", "", "write a timeline of API calls and state transitions to this file(Chrome trace format)")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. ImageId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := timeOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+
+	if err := remote.validate(); err != nil {
+		fatal(err)
+	}
+
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	if summaryFile != "" {
+		runRecorder = newSummaryRecorder(summaryFile, "pipeline")
+		runRecorder.countRequests(&cfg)
+	}
+
+	c, err := loadPipelineConfig(ctx, cfg, path, remote)
+	if err != nil {
+		fatal(err)
+	}
+	naming, err := loadNaming(c)
+	if err != nil {
+		fatal(err)
+	}
+	policy, err := loadTagPolicy(c, requiredTags)
+	if err != nil {
+		fatal(err)
+	}
+
+	if traceFile != "" {
+		trace = newTracer()
+		trace.instrument(&cfg)
+	}
+	var resumed *runStateDoc
+	if resume {
+		if resumed, err = loadStateFile(stateFilePath); err != nil {
+			fatal(err)
+		}
+		// The resumed run keeps its ID, and so the tags of its resources.
+		if resumed != nil && runIDFlag == "" {
+			runIDFlag = resumed.RunId
+		}
+	}
+	setRunID(runIDFlag)
+	if resume {
+		if runState, err = newStateFile(stateFilePath, c.Pipeline.Stages, resumed); err != nil {
+			fatal(err)
+		}
+		if resumed != nil {
+			logf("resuming run %s from %s", runID, stateFilePath)
+		}
+	}
+	if statusFile != "" {
+		runStatus = newStatusWriter(statusFile)
+	}
+	if logGroup != "" {
+		if runLog, err = newCWLogger(ctx, cfg, logGroup); err != nil {
+			fatal(err)
+		}
+	}
+	if statsdAddr != "" {
+		if metrics, err = newStatsdClient(statsdAddr, "command:pipeline"); err != nil {
+			fatal(err)
+		}
+	}
+	if metricsNamespace != "" {
+		cloudMetrics = newCWMetrics(cfg, metricsNamespace, "pipeline")
+	}
+	if len(notifyTo) > 0 {
+		runNotifier = newNotifier(cfg, "pipeline", notifyTo)
+	}
+	if taskToken != "" {
+		runCallback = newTaskCallback(cfg, taskToken)
+	}
+
+	st := &pipelineState{cfg: cfg, verbose: verbose, progressBars: drawProgressBars(noProgress), naming: naming, tagPolicy: policy, images: map[string]string{}, resumed: resumed, hooks: hooks}
+	if resumed != nil {
+		st.image = resumed.Image
+		for region, id := range resumed.Images {
+			st.images[region] = id
+		}
+	}
+	report := c.Pipeline.run(ctx, st)
+	if err := trace.write(traceFile); err != nil {
+		fatal(err)
+	}
+	if runbookFile != "" && report.Succeeded && st.image != nil {
+		if err := writeRunbook(runbookFile, newRunbook(st.image, st.images)); err != nil {
+			fatal(err)
+		}
+	}
+	if manifestFile != "" && report.Succeeded && st.image != nil {
+		if err := writeManifest(manifestFile, newPackerBuild(st.image, st.images)); err != nil {
+			fatal(err)
+		}
+	}
+	if inventoryTable != "" && st.image != nil {
+		if err := recordInventory(ctx, cfg, inventoryTable, st.image, st.images, report.Succeeded); err != nil {
+			fatal(err)
+		}
+	}
+	printResult(report, query)
+	if st.image != nil {
+		metrics.gauge("image.size_gib", float64(imageSizeGiB(st.image.Image)))
+		cloudMetrics.image(imageSizeGiB(st.image.Image))
+	}
+	if !report.Succeeded {
+		setPhase("failed")
+		runNotifier.finish(st.image, report.err())
+		runCallback.fail(exitCode(ctx, report.err()), report.err())
+		runRecorder.finish(st.image, st.images, report.err())
+		metrics.finish(false, time.Since(runStarted))
+		cloudMetrics.finish(false, time.Since(runStarted))
+		runLog.close()
+		os.Exit(exitCode(ctx, report.err()))
+	}
+	setPhase("done")
+	runNotifier.finish(st.image, nil)
+	runCallback.succeed(report)
+	runRecorder.finish(st.image, st.images, nil)
+	metrics.finish(true, time.Since(runStarted))
+	cloudMetrics.finish(true, time.Since(runStarted))
+	runState.remove()
+	runLog.close()
+}
+
+func (p *pipelineConfig) validate() error {
+	if len(p.Stages) == 0 {
+		return errors.New("pipeline has no stages")
+	}
+	if p.SnapshotLock != "" {
+		if err := new(snapshotLock).Set(p.SnapshotLock); err != nil {
+			return err
+		}
+	}
+	for event := range p.Hooks {
+		if !validHookEvent(event) {
+			return fmt.Errorf("invalid hook event: %s", event)
+		}
+	}
+	for i, s := range p.Stages {
+		switch s.OnFailure {
+		case "", onFailureAbort, onFailureContinue:
+		default:
+			return fmt.Errorf("stage %d: invalid onFailure: %s", i, s.OnFailure)
+		}
+		switch s.Type {
+		case stageCreate:
+			if i != 0 {
+				return fmt.Errorf("stage %d: create must be the first stage", i)
+			}
+			if p.InstanceId == "" && p.InstanceName == "" && len(p.InstanceFilters) == 0 || p.Name == "" {
+				return errors.New("pipeline instanceId, instanceName or instanceFilters, and name are required")
+			}
+			if p.InstanceId != "" && (p.InstanceName != "" || len(p.InstanceFilters) > 0) {
+				return errors.New("pipeline instanceId cannot be combined with instanceName or instanceFilters")
+			}
+			if err := validateIfExists(p.IfExists); err != nil {
+				return err
+			}
+			for _, m := range p.DeviceMappings {
+				if err := new(deviceMappings).Set(m); err != nil {
+					return err
+				}
+			}
+			if p.Description != "" && p.AutoDescribe {
+				return errors.New("pipeline description cannot be combined with autoDescribe")
+			}
+			if p.ExpireAfter != "" {
+				if _, err := parseDuration(p.ExpireAfter); err != nil {
+					return err
+				}
+			}
+			if p.DeprecateAfter != "" {
+				if _, err := deprecationTime(p.DeprecateAfter, time.Now()); err != nil {
+					return err
+				}
+			}
+			if p.HookTimeout != "" {
+				if d, err := parseDuration(p.HookTimeout); err != nil || d == 0 {
+					return fmt.Errorf("invalid pipeline hookTimeout: %s", p.HookTimeout)
+				}
+			}
+			if p.BootMode != "" {
+				if _, err := parseBootMode(p.BootMode); err != nil {
+					return err
+				}
+			}
+			if p.TpmSupport != "" {
+				if _, err := parseTpmSupport(p.TpmSupport); err != nil {
+					return err
+				}
+			}
+		case stageCopy:
+			if len(s.Regions) == 0 {
+				return fmt.Errorf("stage %d: copy requires regions", i)
+			}
+			if s.MaxParallel < 0 {
+				return fmt.Errorf("stage %d: maxParallel must not be negative", i)
+			}
+			if s.TpmSupport != "" {
+				if _, err := parseTpmSupport(s.TpmSupport); err != nil {
+					return fmt.Errorf("stage %d: %w", i, err)
+				}
+			}
+		case stageShare:
+			if len(s.Accounts) == 0 {
+				return fmt.Errorf("stage %d: share requires accounts", i)
+			}
+		case stageValidate:
+		case stageAlias:
+			if s.Parameter == "" {
+				return fmt.Errorf("stage %d: alias requires parameter", i)
+			}
+		case stageLaunchTemplate:
+			if s.LaunchTemplate == "" {
+				return fmt.Errorf("stage %d: launchTemplate requires launchTemplate", i)
+			}
+			if _, err := parseLaunchTemplateUpdate(s.LaunchTemplate); err != nil {
+				return fmt.Errorf("stage %d: %w", i, err)
+			}
+		case stagePrune:
+			if s.NamePrefix == "" || s.KeepLast < 1 {
+				return fmt.Errorf("stage %d: prune requires namePrefix and a positive keepLast", i)
+			}
+		case stageRefresh:
+			if s.AutoScalingGroup == "" {
+				return fmt.Errorf("stage %d: refresh requires autoScalingGroup", i)
+			}
+			if s.MinHealthy < 0 || s.MinHealthy > 100 {
+				return fmt.Errorf("stage %d: minHealthy must be between 0 and 100", i)
+			}
+		case stageCanary:
+			if s.AutoScalingGroup == "" {
+				return fmt.Errorf("stage %d: canary requires autoScalingGroup", i)
+			}
+			if s.Count < 0 {
+				return fmt.Errorf("stage %d: canary count must not be negative", i)
+			}
+			if s.Timeout != "" {
+				if _, err := parseDuration(s.Timeout); err != nil {
+					return fmt.Errorf("stage %d: %w", i, err)
+				}
+			}
+		case stageInspect:
+			if s.Severity != "" {
+				if _, err := parseSeverity(s.Severity); err != nil {
+					return fmt.Errorf("stage %d: %w", i, err)
+				}
+			}
+			if s.Timeout != "" {
+				if _, err := parseDuration(s.Timeout); err != nil {
+					return fmt.Errorf("stage %d: %w", i, err)
+				}
+			}
+		case stageGate:
+			if s.Command == "" {
+				return fmt.Errorf("stage %d: gate requires command", i)
+			}
+		case stageVerify:
+			if s.Command != "" && s.Probe != "" {
+				return fmt.Errorf("stage %d: verify takes command or probe, not both", i)
+			}
+			if s.Probe != "" {
+				if err := validateProbe(s.Probe); err != nil {
+					return fmt.Errorf("stage %d: %w", i, err)
+				}
+			}
+			if s.Timeout != "" {
+				if _, err := parseDuration(s.Timeout); err != nil {
+					return fmt.Errorf("stage %d: %w", i, err)
+				}
+			}
+		default:
+			return fmt.Errorf("stage %d: unknown type: %s", i, s.Type)
+		}
+		if s.Type != stageCreate && s.Type != stagePrune && p.Stages[0].Type != stageCreate {
+			return fmt.Errorf("stage %d: %s requires a preceding create stage", i, s.Type)
+		}
+	}
+	return nil
+}
+
+// err returns the errors of the failed stages, nil if none failed.
+func (r pipelineReport) err() error {
+	var errs []error
+	for _, sr := range r.Stages {
+		if sr.Status == "failed" {
+			errs = append(errs, fmt.Errorf("stage %s: %s", sr.Type, sr.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run executes the stages in order. A failed stage skips the remaining ones
+// unless its failure policy is "continue"; stages that need the image are
+// skipped whenever the image could not be created.
+func (p *pipelineConfig) run(ctx context.Context, st *pipelineState) pipelineReport {
+	report := pipelineReport{RunId: runID, Succeeded: true, Images: st.images}
+	hooks := localHooks{}
+	for event, commands := range p.Hooks {
+		hooks[event] = append(hooks[event], commands...)
+	}
+	for event, commands := range st.hooks {
+		hooks[event] = append(hooks[event], commands...)
+	}
+	aborted := false
+	failed := ""
+	for i, s := range p.Stages {
+		if done, ok := st.resumed.stage(i); ok {
+			done.Resumed = true
+			report.Stages = append(report.Stages, done)
+			continue
+		}
+		sr := stageReport{Type: s.Type}
+		if aborted || (s.Type != stageCreate && s.Type != stagePrune && st.image == nil) {
+			sr.Status = "skipped"
+			report.Stages = append(report.Stages, sr)
+			continue
+		}
+
+		setPhase("stage " + s.Type)
+		start := time.Now()
+		var out any
+		err := hooks.run(ctx, "pre-"+s.Type, s.Type, st.image, st.images)
+		if err == nil {
+			out, err = p.runStage(ctx, st, s)
+		}
+		if err == nil {
+			err = hooks.run(ctx, "post-"+s.Type, s.Type, st.image, st.images)
+		}
+		if err == nil && s.Type == stageCreate {
+			err = hooks.run(ctx, hookPostAvailable, s.Type, st.image, st.images)
+		}
+		sr.Duration = time.Since(start).Seconds()
+		sr.Output = out
+		if err != nil {
+			sr.Status = "failed"
+			sr.Error = err.Error()
+			runLog.event("error", "stage "+s.Type+": "+sr.Error)
+			failed = s.Type
+			if s.OnFailure != onFailureContinue {
+				report.Succeeded = false
+				aborted = true
+			}
+		} else {
+			sr.Status = "succeeded"
+			runState.stage(i, sr, st)
+		}
+		trace.span("stage "+s.Type, start, map[string]any{"status": sr.Status})
+		report.Stages = append(report.Stages, sr)
+	}
+	if st.image != nil {
+		report.ImageId = *st.image.ImageId
+		report.ConsoleUrls = imageConsoleURLs(st.images)
+	}
+	if !report.Succeeded {
+		if err := hooks.run(ctx, hookOnFailure, failed, st.image, st.images); err != nil {
+			warnf("%v", err)
+		}
+	}
+	return report
+}
+
+func (p *pipelineConfig) runStage(ctx context.Context, st *pipelineState, s pipelineStage) (any, error) {
+	switch s.Type {
+	case stageCreate:
+		var lock snapshotLock
+		if p.SnapshotLock != "" {
+			lock.Set(p.SnapshotLock)
+		}
+		var mappings deviceMappings
+		for _, m := range p.DeviceMappings {
+			mappings.Set(m)
+		}
+		hookTimeout := defaultHookTimeout
+		if p.HookTimeout != "" {
+			hookTimeout, _ = parseDuration(p.HookTimeout)
+		}
+		if st.resumed != nil && st.resumed.ImageId != "" {
+			// The interrupted run got as far as creating the image.
+			res, err := attachImage(ctx, st.cfg, st.resumed.ImageId, st.verbose, st.progressBars)
+			if err != nil {
+				return nil, err
+			}
+			st.image = res
+			st.images[st.cfg.Region] = *res.ImageId
+			return res, nil
+		}
+		res, err := createImage(ctx, st.cfg, options{
+			verbose:             st.verbose,
+			progressBars:        st.progressBars,
+			instanceID:          p.InstanceId,
+			instance:            instanceSelector{name: p.InstanceName, filters: filterMap(p.InstanceFilters)},
+			imageName:           p.Name,
+			imageTags:           mergeTags(tagMap(p.Tags), tagMap(p.ImageTags)),
+			snapshotTags:        mergeTags(tagMap(p.Tags), tagMap(p.SnapshotTags)),
+			deviceSnapshotTags:  deviceTagMap(p.DeviceSnapshotTags),
+			expireAfter:         p.ExpireAfter,
+			bootMode:            types.BootModeValues(p.BootMode),
+			requireIMDSv2:       p.RequireIMDSv2,
+			tpmSupport:          types.TpmSupportValues(p.TpmSupport),
+			targetInstanceTypes: p.TargetInstanceTypes,
+			allowMarketplace:    p.AllowMarketplace,
+			adoptExisting:       p.AdoptExisting,
+			ssmInventory:        p.SSMInventory,
+			naming:              st.naming,
+			tagPolicy:           st.tagPolicy,
+			snapshotLock:        lock,
+			changeReport:        p.ChangeReport,
+			mirrorImageTags:     p.MirrorImageTagsToSnapshots,
+			noReboot:            p.NoReboot,
+			kmsKeyID:            p.KmsKeyId,
+			deleteUnencrypted:   p.DeleteUnencrypted,
+			ifExists:            p.IfExists,
+			purgeReplaced:       p.DeleteReplacedSnapshots,
+			copyInstanceTags:    p.CopyInstanceTags,
+			copyTagPrefix:       p.CopyTagPrefix,
+			lineage:             p.Lineage,
+			cleanupOnAbort:      p.CleanupOnAbort,
+			description:         p.Description,
+			autoDescribe:        p.AutoDescribe,
+			excludeDevices:      p.ExcludeDevices,
+			deviceMappings:      mappings,
+			deprecateAfter:      p.DeprecateAfter,
+			preCommand:          p.PreCommand,
+			postCommand:         p.PostCommand,
+			hookTimeout:         hookTimeout,
+			vss:                 p.VSS,
+		})
+		if err != nil {
+			return nil, err
+		}
+		st.image = res
+		st.images[st.cfg.Region] = *res.ImageId
+		return res, nil
+
+	case stageCopy:
+		client := ec2.NewFromConfig(st.cfg)
+		image, err := waitImageAvailable(ctx, client, *st.image.ImageId, st.verbose)
+		if err != nil {
+			return nil, err
+		}
+		if err := restoreArchivedImage(ctx, client, image, s.RestoreArchivedDays, st.verbose); err != nil {
+			return nil, err
+		}
+		copies, err := copyImage(ctx, st.cfg, image, s.Regions, copyOptions{attrs: imageAttributes{tpmSupport: types.TpmSupportValues(s.TpmSupport)}, verbose: st.verbose, maxParallel: s.MaxParallel, failFast: s.FailFast, bars: st.progressBars})
+		for region, id := range copies {
+			st.images[region] = id
+		}
+		return copies, err
+
+	case stageShare:
+		var errs []error
+		for region, id := range st.images {
+			if err := shareImage(ctx, regionalClient(st.cfg, region), id, s.Accounts); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		shared := make([]sharedAccount, 0, len(s.Accounts))
+		for _, a := range s.Accounts {
+			shared = append(shared, sharedAccount{AccountId: a, AccountName: p.AccountNames[a]})
+		}
+		return shared, errors.Join(errs...)
+
+	case stageValidate:
+		var errs []error
+		for region, id := range st.images {
+			if err := validateImage(ctx, regionalClient(st.cfg, region), id, st.verbose); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", region, err))
+			}
+		}
+		return nil, errors.Join(errs...)
+
+	case stageAlias:
+		var errs []error
+		for region, id := range st.images {
+			client := ssm.NewFromConfig(st.cfg, func(o *ssm.Options) { o.Region = region })
+			if _, err := putImageParameter(ctx, client, s.Parameter, id); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", region, err))
+			}
+		}
+		return nil, errors.Join(errs...)
+
+	case stageLaunchTemplate:
+		u, err := parseLaunchTemplateUpdate(s.LaunchTemplate)
+		if err != nil {
+			return nil, err
+		}
+		client := ec2.NewFromConfig(st.cfg)
+		if _, err := waitImageAvailable(ctx, client, *st.image.ImageId, st.verbose); err != nil {
+			return nil, err
+		}
+		return updateLaunchTemplate(ctx, client, u, *st.image.ImageId, s.SetDefault)
+
+	case stageRefresh:
+		client := autoscaling.NewFromConfig(st.cfg)
+		id, err := startInstanceRefresh(ctx, client, s.AutoScalingGroup, int32(s.MinHealthy))
+		if err != nil {
+			return nil, err
+		}
+		res := &instanceRefreshResult{AutoScalingGroup: s.AutoScalingGroup, InstanceRefreshId: id}
+		if s.Wait {
+			err = waitInstanceRefresh(ctx, client, res, st.verbose)
+		}
+		return res, err
+
+	case stagePrune:
+		regions := []string{st.cfg.Region}
+		for region := range st.images {
+			if region != st.cfg.Region {
+				regions = append(regions, region)
+			}
+		}
+		var errs []error
+		pruned := map[string][]pruneResult{}
+		for _, region := range regions {
+			client := regionalClient(st.cfg, region)
+			images, err := retainedImages(ctx, client, s.NamePrefix, s.KeepLast)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", region, err))
+				continue
+			}
+			for _, image := range images {
+				r, err := pruneImage(ctx, client, image)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", region, err))
+					continue
+				}
+				pruned[region] = append(pruned[region], r)
+			}
+		}
+		return pruned, errors.Join(errs...)
+
+	case stageCanary:
+		client := ec2.NewFromConfig(st.cfg)
+		if err := validateImage(ctx, client, *st.image.ImageId, st.verbose); err != nil {
+			return nil, err
+		}
+		opt := canaryOptions{asg: s.AutoScalingGroup, count: s.Count, timeout: 15 * time.Minute}
+		if opt.count == 0 {
+			opt.count = 1
+		}
+		if s.Timeout != "" {
+			opt.timeout, _ = parseDuration(s.Timeout)
+		}
+		res, err := runCanary(ctx, st.cfg, *st.image.ImageId, opt, st.verbose)
+		if err == nil && !res.Promotable {
+			err = fmt.Errorf("image %s is not promotable: %s", *st.image.ImageId, res.Reason)
+		}
+		return res, err
+
+	case stageInspect:
+		client := ec2.NewFromConfig(st.cfg)
+		if err := validateImage(ctx, client, *st.image.ImageId, st.verbose); err != nil {
+			return nil, err
+		}
+		opt := inspectorOptions{
+			throwawayOptions: throwawayOptions{instanceType: "t3.micro", subnetID: s.SubnetId, instanceProfile: s.InstanceProfile},
+			threshold:        inspectortypes.SeverityHigh,
+			timeout:          30 * time.Minute,
+		}
+		if s.InstanceType != "" {
+			opt.instanceType = s.InstanceType
+		}
+		if s.Severity != "" {
+			opt.threshold, _ = parseSeverity(s.Severity)
+		}
+		if s.Timeout != "" {
+			opt.timeout, _ = parseDuration(s.Timeout)
+		}
+		res, err := inspectImage(ctx, st.cfg, *st.image.ImageId, opt, st.verbose)
+		if err == nil && !res.Passed {
+			err = fmt.Errorf("image %s has findings at or above %s", *st.image.ImageId, res.Threshold)
+		}
+		return res, err
+
+	case stageGate:
+		if err := validateImage(ctx, ec2.NewFromConfig(st.cfg), *st.image.ImageId, st.verbose); err != nil {
+			return nil, err
+		}
+		res, err := runGate(ctx, s.Command, *st.image.ImageId, pipelineReport{ImageId: *st.image.ImageId, Images: st.images})
+		if err != nil {
+			return nil, err
+		}
+		var errs []error
+		for region, id := range st.images {
+			if err := tagApproval(ctx, regionalClient(st.cfg, region), []string{id}, res.Approved); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", region, err))
+			}
+		}
+		if !res.Approved {
+			errs = append(errs, fmt.Errorf("image %s is pending approval: gate exited %d", *st.image.ImageId, res.ExitCode))
+		}
+		return res, errors.Join(errs...)
+
+	case stageVerify:
+		if err := validateImage(ctx, ec2.NewFromConfig(st.cfg), *st.image.ImageId, st.verbose); err != nil {
+			return nil, err
+		}
+		opt := verifyOptions{
+			throwawayOptions:    throwawayOptions{instanceType: "t3.micro", subnetID: s.SubnetId, instanceProfile: s.InstanceProfile},
+			command:             s.Command,
+			probe:               s.Probe,
+			timeout:             15 * time.Minute,
+			deregisterOnFailure: s.DeregisterOnFailure,
+		}
+		if s.InstanceType != "" {
+			opt.instanceType = s.InstanceType
+		}
+		if s.Timeout != "" {
+			opt.timeout, _ = parseDuration(s.Timeout)
+		}
+		res, err := verifyImage(ctx, st.cfg, *st.image.ImageId, opt, st.verbose)
+		if err == nil && !res.Passed {
+			err = fmt.Errorf("image %s failed verification: %s", *st.image.ImageId, res.Error)
+		}
+		return res, err
+	}
+	return nil, fmt.Errorf("unknown stage: %s", s.Type)
+}
+
+// validateImage checks that the image is available and every snapshot backing
+// it has completed.
+func validateImage(ctx context.Context, client *ec2.Client, imageID string, verbose bool) error {
+	image, err := waitImageAvailable(ctx, client, imageID, verbose)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for _, bdm := range image.BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+			ids = append(ids, *bdm.Ebs.SnapshotId)
+		}
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("image %s has no EBS snapshots", imageID)
+	}
+	out, err := client.DescribeSnapshots(ctx, &ec2.DescribeSnapshotsInput{SnapshotIds: ids})
+	if err != nil {
+		return fmt.Errorf("error describing snapshots: %w", err)
+	}
+	for _, s := range out.Snapshots {
+		if s.State != types.SnapshotStateCompleted {
+			return fmt.Errorf("snapshot %s state: %v", *s.SnapshotId, s.State)
+		}
+	}
+	return nil
+}
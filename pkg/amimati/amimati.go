@@ -0,0 +1,163 @@
+// Package amimati creates Amazon Machine Images from EC2 instances and waits
+// until the snapshots backing them have completed.
+package amimati
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// DefaultPollInterval is the time between two polls of a Creator without a
+// PollInterval.
+const DefaultPollInterval = 5 * time.Second
+
+// EC2API is the part of the EC2 client used by a Creator. *ec2.Client
+// implements it.
+type EC2API interface {
+	CreateImage(ctx context.Context, params *ec2.CreateImageInput, optFns ...func(*ec2.Options)) (*ec2.CreateImageOutput, error)
+	DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
+	DescribeSnapshots(ctx context.Context, params *ec2.DescribeSnapshotsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSnapshotsOutput, error)
+}
+
+// CreateRequest describes the image to create.
+type CreateRequest struct {
+	InstanceID string
+	Name       string
+	// NoReboot images the instance without shutting it down first, so the
+	// image is only crash-consistent.
+	NoReboot          bool
+	TagSpecifications []types.TagSpecification
+	// Description is the description of the image, if any.
+	Description string
+	// BlockDeviceMappings exclude volumes of the instance from the image, or
+	// change the EBS mappings of the image.
+	BlockDeviceMappings []types.BlockDeviceMapping
+}
+
+// Result is an image whose snapshots have completed.
+type Result struct {
+	// Image is the image as described once its snapshots were created; it
+	// may still be pending.
+	Image types.Image
+	// Snapshots are the completed snapshots of the EBS block device
+	// mappings of the image, in any order.
+	Snapshots []types.Snapshot
+}
+
+// Creator creates images and waits for their snapshots.
+type Creator struct {
+	Client EC2API
+	// PollInterval is the time between two polls of the default waiter,
+	// DefaultPollInterval if zero.
+	PollInterval time.Duration
+	// Progress, if set, is called on every poll of the default waiter until
+	// the snapshots have completed: with no snapshots while they are yet to
+	// be created, and then with the snapshots as last described.
+	Progress func(image types.Image, snapshots []types.Snapshot)
+	// Waiter, if set, replaces the SDKWaiter polling every PollInterval and
+	// reporting to Progress.
+	Waiter Waiter
+}
+
+// Create creates an image of the instance and waits until its snapshots have
+// completed.
+func (c *Creator) Create(ctx context.Context, req CreateRequest) (*Result, error) {
+	imageID, err := c.createImage(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return c.Wait(ctx, imageID)
+}
+
+// Start creates an image of the instance and only waits until the snapshots
+// of its EBS block device mappings have been created, returning the image,
+// still pending, with their IDs. The caller waits for them to complete.
+func (c *Creator) Start(ctx context.Context, req CreateRequest) (types.Image, error) {
+	imageID, err := c.createImage(ctx, req)
+	if err != nil {
+		return types.Image{}, err
+	}
+	return c.waiter(nil).WaitSnapshotsCreated(ctx, imageID)
+}
+
+func (c *Creator) createImage(ctx context.Context, req CreateRequest) (string, error) {
+	out, err := c.Client.CreateImage(ctx, &ec2.CreateImageInput{
+		Name:                aws.String(req.Name),
+		InstanceId:          aws.String(req.InstanceID),
+		NoReboot:            aws.Bool(req.NoReboot),
+		TagSpecifications:   req.TagSpecifications,
+		Description:         optionalString(req.Description),
+		BlockDeviceMappings: req.BlockDeviceMappings,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating image: %w", err)
+	}
+	return *out.ImageId, nil
+}
+
+// Wait waits until the snapshots of every EBS block device mapping of the
+// image have completed, failing if one of them fails.
+func (c *Creator) Wait(ctx context.Context, imageID string) (*Result, error) {
+	var image types.Image
+	w := c.waiter(&image)
+	image, err := w.WaitSnapshotsCreated(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+	snapshotIDs, _ := MappedSnapshots(image)
+	snapshots, err := w.WaitSnapshotsCompleted(ctx, snapshotIDs)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Image: image, Snapshots: snapshots}, nil
+}
+
+// waiter returns the Waiter of c, or else an SDKWaiter reporting to Progress
+// with the snapshots of the image once created.
+func (c *Creator) waiter(image *types.Image) Waiter {
+	if c.Waiter != nil {
+		return c.Waiter
+	}
+	return &SDKWaiter{
+		Client:       c.Client,
+		PollInterval: c.PollInterval,
+		ImageProgress: func(i types.Image) {
+			if c.Progress != nil {
+				c.Progress(i, nil)
+			}
+		},
+		SnapshotProgress: func(snapshots []types.Snapshot) {
+			if c.Progress != nil && image != nil {
+				c.Progress(*image, snapshots)
+			}
+		},
+	}
+}
+
+// MappedSnapshots returns the snapshot IDs of the EBS block device mappings
+// of the image, and whether every one of them has its snapshot yet.
+func MappedSnapshots(image types.Image) ([]string, bool) {
+	var ids []string
+	for _, bdm := range image.BlockDeviceMappings {
+		if bdm.Ebs == nil {
+			continue
+		}
+		if bdm.Ebs.SnapshotId == nil {
+			return nil, false
+		}
+		ids = append(ids, *bdm.Ebs.SnapshotId)
+	}
+	return ids, len(ids) > 0
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
@@ -0,0 +1,78 @@
+package amimati_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/otama-jaccy/amimati/pkg/amimati"
+	"github.com/otama-jaccy/amimati/pkg/amimati/amimatitest"
+)
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestCreatorCreate(t *testing.T) {
+	fake := &amimatitest.FakeEC2{
+		SnapshotPolls: 2,
+		CompletePolls: 3,
+		Devices:       map[string][]string{"i-1": {"/dev/xvda", "/dev/xvdb"}},
+	}
+	var progress int
+	c := &amimati.Creator{Client: fake, PollInterval: time.Millisecond, Progress: func(types.Image, []types.Snapshot) { progress++ }}
+	res, err := c.Create(testContext(t), amimati.CreateRequest{InstanceID: "i-1", Name: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Snapshots) != 2 {
+		t.Fatalf("got %d snapshots, want 2", len(res.Snapshots))
+	}
+	for _, s := range res.Snapshots {
+		if s.State != types.SnapshotStateCompleted {
+			t.Errorf("snapshot %s is %s, want completed", *s.SnapshotId, s.State)
+		}
+	}
+	if ids, ok := amimati.MappedSnapshots(res.Image); !ok || len(ids) != 2 {
+		t.Errorf("image mappings have snapshots %v, want 2", ids)
+	}
+	if progress == 0 {
+		t.Error("Progress was never called")
+	}
+}
+
+func TestCreatorCreateError(t *testing.T) {
+	injected := errors.New("injected")
+	fake := &amimatitest.FakeEC2{Errors: map[string][]error{"CreateImage": {injected}}}
+	c := &amimati.Creator{Client: fake, PollInterval: time.Millisecond}
+	if _, err := c.Create(testContext(t), amimati.CreateRequest{InstanceID: "i-1", Name: "web"}); !errors.Is(err, injected) {
+		t.Fatalf("got error %v, want %v", err, injected)
+	}
+}
+
+func TestCreatorSnapshotFailure(t *testing.T) {
+	fake := &amimatitest.FakeEC2{CompletePolls: 1, FailSnapshots: true}
+	c := &amimati.Creator{Client: fake, PollInterval: time.Millisecond}
+	if _, err := c.Create(testContext(t), amimati.CreateRequest{InstanceID: "i-1", Name: "web"}); err == nil {
+		t.Fatal("got no error for a failed snapshot")
+	}
+}
+
+func TestCreatorStart(t *testing.T) {
+	fake := &amimatitest.FakeEC2{SnapshotPolls: 1, CompletePolls: 5}
+	c := &amimati.Creator{Client: fake, PollInterval: time.Millisecond}
+	image, err := c.Start(testContext(t), amimati.CreateRequest{InstanceID: "i-1", Name: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.State != types.ImageStatePending {
+		t.Errorf("image is %s, want pending", image.State)
+	}
+	if _, ok := amimati.MappedSnapshots(image); !ok {
+		t.Error("image mappings have no snapshots yet")
+	}
+}
@@ -0,0 +1,269 @@
+// Package amimatitest provides an in-memory EC2 for testing code built on
+// package amimati without calling AWS.
+package amimatitest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/otama-jaccy/amimati/pkg/amimati"
+)
+
+var _ amimati.EC2API = (*FakeEC2)(nil)
+
+// FakeEC2 is an in-memory EC2 implementing amimati.EC2API, along with the
+// calls made to copy, tag and deregister images. Its images and snapshots go
+// through the states of the real ones as they are polled: the EBS block
+// device mappings of a new image get their snapshots after SnapshotPolls
+// descriptions of the image, each snapshot completes after CompletePolls
+// descriptions of it or of its image, and the image becomes available once
+// its snapshots have completed. It is safe for concurrent use.
+type FakeEC2 struct {
+	// Devices are the device names of the EBS volumes of each instance;
+	// instances not listed have a single /dev/xvda.
+	Devices map[string][]string
+	// SnapshotPolls is the number of descriptions of a new image before its
+	// mappings have snapshots.
+	SnapshotPolls int
+	// CompletePolls is the number of descriptions of a snapshot, or of its
+	// image, while it is pending, its progress rising evenly.
+	CompletePolls int
+	// FailSnapshots makes snapshots end in the error state, and their images
+	// fail, rather than complete.
+	FailSnapshots bool
+	// Errors are returned by the calls of each operation, such as
+	// "CreateImage", one per call in order; a nil error lets the call
+	// through, and the calls after the last error succeed.
+	Errors map[string][]error
+
+	mu        sync.Mutex
+	images    map[string]*fakeImage
+	snapshots map[string]*fakeSnapshot
+	calls     map[string]int
+	nextID    int
+}
+
+type fakeImage struct {
+	image   types.Image
+	devices []string
+	polls   int
+}
+
+type fakeSnapshot struct {
+	snapshot types.Snapshot
+	polls    int
+}
+
+// Image returns the image as last described, and whether it exists.
+func (f *FakeEC2) Image(imageID string) (types.Image, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i, ok := f.images[imageID]
+	if !ok {
+		return types.Image{}, false
+	}
+	return i.image, true
+}
+
+// Calls returns the number of calls of the operation so far.
+func (f *FakeEC2) Calls(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[op]
+}
+
+// call counts a call of the operation and returns its injected error, if
+// any; f.mu must be held.
+func (f *FakeEC2) call(op string) error {
+	if f.calls == nil {
+		f.calls = map[string]int{}
+		f.images = map[string]*fakeImage{}
+		f.snapshots = map[string]*fakeSnapshot{}
+	}
+	n := f.calls[op]
+	f.calls[op]++
+	if n < len(f.Errors[op]) {
+		return f.Errors[op][n]
+	}
+	return nil
+}
+
+func (f *FakeEC2) id(prefix string) string {
+	f.nextID++
+	return fmt.Sprintf("%s-%017x", prefix, f.nextID)
+}
+
+func (f *FakeEC2) addImage(name, description *string, devices []string, tags []types.Tag) string {
+	id := f.id("ami")
+	image := types.Image{ImageId: aws.String(id), Name: name, Description: description, State: types.ImageStatePending, RootDeviceType: types.DeviceTypeEbs, Tags: tags}
+	for _, d := range devices {
+		image.BlockDeviceMappings = append(image.BlockDeviceMappings, types.BlockDeviceMapping{DeviceName: aws.String(d), Ebs: &types.EbsBlockDevice{VolumeSize: aws.Int32(8)}})
+	}
+	f.images[id] = &fakeImage{image: image, devices: devices}
+	return id
+}
+
+// CreateImage implements amimati.EC2API.
+func (f *FakeEC2) CreateImage(ctx context.Context, params *ec2.CreateImageInput, optFns ...func(*ec2.Options)) (*ec2.CreateImageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CreateImage"); err != nil {
+		return nil, err
+	}
+	devices, ok := f.Devices[aws.ToString(params.InstanceId)]
+	if !ok {
+		devices = []string{"/dev/xvda"}
+	}
+	var tags []types.Tag
+	for _, ts := range params.TagSpecifications {
+		if ts.ResourceType == types.ResourceTypeImage {
+			tags = append(tags, ts.Tags...)
+		}
+	}
+	return &ec2.CreateImageOutput{ImageId: aws.String(f.addImage(params.Name, params.Description, devices, tags))}, nil
+}
+
+// CopyImage copies an image of the fake, whatever the source region.
+func (f *FakeEC2) CopyImage(ctx context.Context, params *ec2.CopyImageInput, optFns ...func(*ec2.Options)) (*ec2.CopyImageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CopyImage"); err != nil {
+		return nil, err
+	}
+	source, ok := f.images[aws.ToString(params.SourceImageId)]
+	if !ok {
+		return nil, fmt.Errorf("image %s not found", aws.ToString(params.SourceImageId))
+	}
+	return &ec2.CopyImageOutput{ImageId: aws.String(f.addImage(params.Name, params.Description, source.devices, nil))}, nil
+}
+
+// DescribeImages implements amimati.EC2API, moving the described images on
+// to their next state. Only ImageIds are supported.
+func (f *FakeEC2) DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DescribeImages"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeImagesOutput{}
+	for _, id := range params.ImageIds {
+		i, ok := f.images[id]
+		if !ok {
+			continue
+		}
+		f.advance(i)
+		out.Images = append(out.Images, i.image)
+	}
+	return out, nil
+}
+
+func (f *FakeEC2) advance(i *fakeImage) {
+	if i.image.State != types.ImageStatePending {
+		return
+	}
+	i.polls++
+	if i.polls <= f.SnapshotPolls {
+		return
+	}
+	done := true
+	for n, bdm := range i.image.BlockDeviceMappings {
+		if bdm.Ebs.SnapshotId == nil {
+			id := f.id("snap")
+			f.snapshots[id] = &fakeSnapshot{snapshot: types.Snapshot{SnapshotId: aws.String(id), State: types.SnapshotStatePending, Progress: aws.String("0%"), VolumeSize: bdm.Ebs.VolumeSize}}
+			ebs := *bdm.Ebs
+			ebs.SnapshotId = aws.String(id)
+			i.image.BlockDeviceMappings[n].Ebs = &ebs
+			done = false
+			continue
+		}
+		// The snapshots of an image progress as it is described too, so
+		// that waiting on the image alone ends.
+		snapshot := f.snapshots[*i.image.BlockDeviceMappings[n].Ebs.SnapshotId]
+		f.tick(snapshot)
+		switch snapshot.snapshot.State {
+		case types.SnapshotStateError:
+			i.image.State = types.ImageStateFailed
+			i.image.StateReason = &types.StateReason{Message: aws.String("snapshot failed")}
+			return
+		case types.SnapshotStateCompleted:
+		default:
+			done = false
+		}
+	}
+	if done {
+		i.image.State = types.ImageStateAvailable
+	}
+}
+
+// tick moves the snapshot on to its next state.
+func (f *FakeEC2) tick(s *fakeSnapshot) {
+	if s.snapshot.State != types.SnapshotStatePending {
+		return
+	}
+	s.polls++
+	switch {
+	case s.polls <= f.CompletePolls:
+		s.snapshot.Progress = aws.String(strconv.Itoa(100*s.polls/(f.CompletePolls+1)) + "%")
+	case f.FailSnapshots:
+		s.snapshot.State = types.SnapshotStateError
+		s.snapshot.StateMessage = aws.String("injected failure")
+	default:
+		s.snapshot.State = types.SnapshotStateCompleted
+		s.snapshot.Progress = aws.String("100%")
+	}
+}
+
+// DescribeSnapshots implements amimati.EC2API, moving the described snapshots
+// on to their next state. Only SnapshotIds are supported.
+func (f *FakeEC2) DescribeSnapshots(ctx context.Context, params *ec2.DescribeSnapshotsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSnapshotsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DescribeSnapshots"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeSnapshotsOutput{}
+	for _, id := range params.SnapshotIds {
+		s, ok := f.snapshots[id]
+		if !ok {
+			return nil, fmt.Errorf("snapshot %s not found", id)
+		}
+		f.tick(s)
+		out.Snapshots = append(out.Snapshots, s.snapshot)
+	}
+	return out, nil
+}
+
+// CreateTags adds the tags to the images of the fake; other resources are
+// ignored.
+func (f *FakeEC2) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CreateTags"); err != nil {
+		return nil, err
+	}
+	for _, id := range params.Resources {
+		if i, ok := f.images[id]; ok {
+			i.image.Tags = append(i.image.Tags, params.Tags...)
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+// DeregisterImage removes the image from the fake.
+func (f *FakeEC2) DeregisterImage(ctx context.Context, params *ec2.DeregisterImageInput, optFns ...func(*ec2.Options)) (*ec2.DeregisterImageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DeregisterImage"); err != nil {
+		return nil, err
+	}
+	if _, ok := f.images[aws.ToString(params.ImageId)]; !ok {
+		return nil, fmt.Errorf("image %s not found", aws.ToString(params.ImageId))
+	}
+	delete(f.images, aws.ToString(params.ImageId))
+	return &ec2.DeregisterImageOutput{}, nil
+}
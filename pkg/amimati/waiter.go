@@ -0,0 +1,134 @@
+package amimati
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Waiter waits for the snapshots of an image. Creators use an SDKWaiter unless
+// given another one, such as one spacing its polls differently or a fake in
+// tests.
+type Waiter interface {
+	// WaitSnapshotsCreated waits until every EBS block device mapping of the
+	// image has its snapshot, and returns the image as then described.
+	WaitSnapshotsCreated(ctx context.Context, imageID string) (types.Image, error)
+	// WaitSnapshotsCompleted waits until the snapshots have completed,
+	// failing if one of them fails, and returns them as last described.
+	WaitSnapshotsCompleted(ctx context.Context, snapshotIDs []string) ([]types.Snapshot, error)
+}
+
+// unlimitedWait is the maximum wait of the SDK waiters, which require one;
+// the context of the wait bounds it instead.
+const unlimitedWait = 100 * 365 * 24 * time.Hour
+
+// SDKWaiter is a Waiter backed by the ImageAvailable and SnapshotCompleted
+// waiters of the EC2 client, polling every PollInterval.
+type SDKWaiter struct {
+	Client EC2API
+	// PollInterval is the time between two polls, DefaultPollInterval if
+	// zero.
+	PollInterval time.Duration
+	// ImageProgress, if set, is called on every poll of the image while its
+	// snapshots are yet to be created.
+	ImageProgress func(image types.Image)
+	// SnapshotProgress, if set, is called on every poll of the snapshots
+	// with the snapshots as described.
+	SnapshotProgress func(snapshots []types.Snapshot)
+}
+
+func (w *SDKWaiter) interval() time.Duration {
+	if w.PollInterval <= 0 {
+		return DefaultPollInterval
+	}
+	return w.PollInterval
+}
+
+// WaitSnapshotsCreated implements Waiter.
+func (w *SDKWaiter) WaitSnapshotsCreated(ctx context.Context, imageID string) (types.Image, error) {
+	var image types.Image
+	waiter := ec2.NewImageAvailableWaiter(w.Client, func(o *ec2.ImageAvailableWaiterOptions) {
+		o.MinDelay = w.interval()
+		o.MaxDelay = w.interval()
+		o.Retryable = func(ctx context.Context, in *ec2.DescribeImagesInput, out *ec2.DescribeImagesOutput, err error) (bool, error) {
+			if err != nil {
+				return false, fmt.Errorf("error describing image: %w", err)
+			}
+			if len(out.Images) == 0 {
+				return false, fmt.Errorf("no images found")
+			}
+			image = out.Images[0]
+			if image.RootDeviceType == types.DeviceTypeInstanceStore {
+				return false, fmt.Errorf("image %s is instance store-backed and has no EBS snapshots", imageID)
+			}
+			if _, ok := MappedSnapshots(image); ok {
+				return false, nil
+			}
+			if image.State == types.ImageStateFailed || image.State == types.ImageStateError {
+				return false, fmt.Errorf("image %s state: %v", imageID, image.State)
+			}
+			if w.ImageProgress != nil {
+				w.ImageProgress(image)
+			}
+			return true, nil
+		}
+	})
+	if err := waiter.Wait(ctx, &ec2.DescribeImagesInput{ImageIds: []string{imageID}}, unlimitedWait); err != nil {
+		return types.Image{}, err
+	}
+	return image, nil
+}
+
+// WaitSnapshotsCompleted implements Waiter.
+func (w *SDKWaiter) WaitSnapshotsCompleted(ctx context.Context, snapshotIDs []string) ([]types.Snapshot, error) {
+	var snapshots []types.Snapshot
+	waiter := ec2.NewSnapshotCompletedWaiter(w.Client, func(o *ec2.SnapshotCompletedWaiterOptions) {
+		o.MinDelay = w.interval()
+		o.MaxDelay = w.interval()
+		o.Retryable = func(ctx context.Context, in *ec2.DescribeSnapshotsInput, out *ec2.DescribeSnapshotsOutput, err error) (bool, error) {
+			if err != nil {
+				return false, fmt.Errorf("error describing snapshots: %w", err)
+			}
+			if len(out.Snapshots) < len(snapshotIDs) {
+				return false, fmt.Errorf("no snapshots found")
+			}
+			snapshots = out.Snapshots
+			if w.SnapshotProgress != nil {
+				w.SnapshotProgress(snapshots)
+			}
+
+			completed := 0
+			for _, s := range snapshots {
+				switch s.State {
+				case types.SnapshotStateCompleted:
+					completed++
+				case types.SnapshotStatePending:
+				default:
+					return false, &SnapshotError{SnapshotID: *s.SnapshotId, State: s.State}
+				}
+			}
+			return completed < len(snapshotIDs), nil
+		}
+	})
+	if err := waiter.Wait(ctx, &ec2.DescribeSnapshotsInput{SnapshotIds: snapshotIDs}, unlimitedWait); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// SnapshotError reports a snapshot of the image that failed, or reached an
+// unexpected state, while being waited for.
+type SnapshotError struct {
+	SnapshotID string
+	State      types.SnapshotState
+}
+
+func (e *SnapshotError) Error() string {
+	if e.State == types.SnapshotStateError {
+		return fmt.Sprintf("snapshot %s creation failed", e.SnapshotID)
+	}
+	return fmt.Sprintf("snapshot %s state: %v", e.SnapshotID, e.State)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// deletionPlan is what prune or delete would deregister and delete, printed
+// by -plan and shown before asking to confirm the deletion.
+type deletionPlan struct {
+	Images []plannedImage
+	// Snapshots and SizeGiB count the snapshots that would be deleted.
+	Snapshots int
+	SizeGiB   int32
+}
+
+// plannedImage is an image in a deletionPlan.
+type plannedImage struct {
+	ImageId      string
+	Name         string
+	CreationDate string
+	Age          string
+	// Rule is why the image was selected.
+	Rule      string
+	Snapshots []plannedSnapshot
+	// KeptSnapshotIds are the snapshots of the image that would be left in
+	// place.
+	KeptSnapshotIds []string `json:",omitempty"`
+}
+
+type plannedSnapshot struct {
+	SnapshotId string
+	DeviceName string
+	SizeGiB    int32
+}
+
+// add plans the deregistration of the image, selected by the rule, and the
+// deletion of its snapshots except those kept.
+func (p *deletionPlan) add(image types.Image, rule string, kept []string, now time.Time) {
+	pi := plannedImage{ImageId: *image.ImageId, Name: aws.ToString(image.Name), CreationDate: aws.ToString(image.CreationDate), Rule: rule, Snapshots: []plannedSnapshot{}, KeptSnapshotIds: kept}
+	if created, err := time.Parse(time.RFC3339, pi.CreationDate); err == nil {
+		pi.Age = humanDuration(now.Sub(created))
+	}
+	for _, bdm := range image.BlockDeviceMappings {
+		if bdm.Ebs == nil || bdm.Ebs.SnapshotId == nil || slices.Contains(kept, *bdm.Ebs.SnapshotId) {
+			continue
+		}
+		s := plannedSnapshot{SnapshotId: *bdm.Ebs.SnapshotId, DeviceName: aws.ToString(bdm.DeviceName), SizeGiB: aws.ToInt32(bdm.Ebs.VolumeSize)}
+		pi.Snapshots = append(pi.Snapshots, s)
+		p.Snapshots++
+		p.SizeGiB += s.SizeGiB
+	}
+	p.Images = append(p.Images, pi)
+}
+
+// write prints the plan for a person to review.
+func (p deletionPlan) write(w io.Writer) {
+	for _, i := range p.Images {
+		fmt.Fprintf(w, "- deregister %s %s, created %s ago: %s\n", i.ImageId, i.Name, i.Age, i.Rule)
+		for _, s := range i.Snapshots {
+			fmt.Fprintf(w, "    delete %s (%s, %d GiB)\n", s.SnapshotId, s.DeviceName, s.SizeGiB)
+		}
+		for _, id := range i.KeptSnapshotIds {
+			fmt.Fprintf(w, "    keep %s\n", id)
+		}
+	}
+	fmt.Fprintf(w, "%d images to deregister and %d snapshots (%d GiB) to delete\n", len(p.Images), p.Snapshots, p.SizeGiB)
+}
+
+// reviewable is a plan of deletions a person is asked to confirm.
+type reviewable interface {
+	write(w io.Writer)
+}
+
+// confirmPlan shows the plan on stderr and asks on stdin to confirm it,
+// failing unless "yes" is answered. Without a terminal to ask on, it fails
+// rather than delete unreviewed.
+func confirmPlan(p reviewable) error {
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return errors.New("refusing to delete without confirmation, stdin is not a terminal: review with -plan, then use -auto-approve")
+	}
+	p.write(os.Stderr)
+	fmt.Fprint(os.Stderr, "Type yes to proceed: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("error reading the confirmation: %w", err)
+	}
+	if strings.TrimSpace(line) != "yes" {
+		return errors.New("deletion not confirmed")
+	}
+	return nil
+}
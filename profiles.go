@@ -0,0 +1,50 @@
+package main
+
+import "context"
+
+type profileResult struct {
+	Profile   string
+	Result    *result `json:",omitempty"`
+	Error     string  `json:",omitempty"`
+	Cancelled bool    `json:",omitempty"`
+}
+
+// batchReport is the aggregate result of a batch of runs under an error
+// policy.
+type batchReport struct {
+	OnError   string
+	Total     int
+	Succeeded int
+	Failed    int
+	Cancelled int
+	// Passed is whether the batch succeeded under the error policy.
+	Passed  bool
+	Results []profileResult
+}
+
+// createImageProfiles runs createImage once per profile concurrently, each
+// with its own config, and returns the results in the order of the profiles.
+// Runs still going are cancelled once the failures abort the batch under the
+// policy.
+func createImageProfiles(ctx context.Context, awsOpt *awsOptions, profiles []string, opt options, policy errorPolicy) batchReport {
+	results := make([]profileResult, len(profiles))
+	outcomes := runBatch(ctx, len(profiles), 0, policy, func(ctx context.Context, i int) error {
+		cfg, err := awsOpt.loadProfile(ctx, profiles[i])
+		if err != nil {
+			return err
+		}
+		trace.instrument(&cfg)
+		results[i].Result, err = createImage(ctx, cfg, opt)
+		return err
+	})
+	for i, o := range outcomes {
+		results[i].Profile = profiles[i]
+		if o.err != nil {
+			results[i].Error = o.err.Error()
+		}
+		results[i].Cancelled = o.cancelled
+	}
+	report := newBatchReport(policy, outcomes)
+	report.Results = results
+	return report
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const progressBarWidth = 30
+
+// stderrIsTerminal reports whether stderr, where the progress bars are drawn,
+// is an interactive terminal.
+func stderrIsTerminal() bool {
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := os.Stderr.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// drawProgressBars reports whether the progress bars are drawn: unless
+// disabled by -no-progress or -quiet, when stderr is a terminal.
+func drawProgressBars(noProgress bool) bool {
+	return !noProgress && !quiet && stderrIsTerminal()
+}
+
+// snapshotETA estimates the time left until a snapshot of the progress,
+// such as "45%", started at started completes.
+func snapshotETA(progress string, started time.Time) (time.Duration, bool) {
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(progress, "%"), 64)
+	if err != nil || pct <= 0 || pct >= 100 || started.IsZero() {
+		return 0, false
+	}
+	elapsed := time.Since(started)
+	return time.Duration(float64(elapsed) * (100 - pct) / pct), true
+}
+
+// progressBars draws a live progress bar per snapshot on stderr, redrawn in
+// place on every poll. Log lines are written above the bars while they are
+// drawn.
+type progressBars struct {
+	mu    sync.Mutex
+	order []string
+	lines map[string]string
+	drawn int
+}
+
+// activeBars is set while progress bars are drawn, for the log writer.
+var (
+	activeBarsMu sync.Mutex
+	activeBars   *progressBars
+)
+
+func newProgressBars() *progressBars {
+	return &progressBars{lines: map[string]string{}}
+}
+
+// update redraws the bars with the snapshots, and stops drawing them once
+// every snapshot has finished.
+func (b *progressBars) update(snapshots []types.Snapshot) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	done := true
+	for _, s := range snapshots {
+		b.set(aws.ToString(s.SnapshotId), progressLine(s))
+		if s.State == types.SnapshotStatePending {
+			done = false
+		}
+	}
+	b.redraw(done)
+}
+
+// setLine sets the line drawn for key, below those already drawn if it is
+// new, and redraws the bars, which stop being drawn once done.
+func (b *progressBars) setLine(key, line string, done bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.set(key, line)
+	b.redraw(done)
+}
+
+func (b *progressBars) set(key, line string) {
+	if _, ok := b.lines[key]; !ok {
+		b.order = append(b.order, key)
+	}
+	b.lines[key] = line
+}
+
+// redraw draws the bars again; b.mu must be held.
+func (b *progressBars) redraw(done bool) {
+	activeBarsMu.Lock()
+	if done {
+		activeBars = nil
+	} else {
+		activeBars = b
+	}
+	activeBarsMu.Unlock()
+	b.clear()
+	b.draw()
+	if done {
+		b.drawn = 0
+	}
+}
+
+// clear erases the bars drawn, leaving the cursor where they started.
+func (b *progressBars) clear() {
+	if b.drawn > 0 {
+		fmt.Fprintf(os.Stderr, "\x1b[%dF\x1b[J", b.drawn)
+	}
+	b.drawn = 0
+}
+
+func (b *progressBars) draw() {
+	var sb strings.Builder
+	for _, id := range b.order {
+		sb.WriteString(b.lines[id])
+		sb.WriteByte('\n')
+	}
+	os.Stderr.WriteString(sb.String())
+	b.drawn = len(b.order)
+}
+
+// progressLine renders the bar of a snapshot with its elapsed time and ETA.
+func progressLine(s types.Snapshot) string {
+	progress := aws.ToString(s.Progress)
+	pct, _ := strconv.ParseFloat(strings.TrimSuffix(progress, "%"), 64)
+	if s.State == types.SnapshotStateCompleted {
+		pct = 100
+	}
+	line := fmt.Sprintf("%s %s", aws.ToString(s.SnapshotId), progressBar(pct))
+	started := aws.ToTime(s.StartTime)
+	if !started.IsZero() {
+		line += fmt.Sprintf("  %s elapsed", time.Since(started).Round(time.Second))
+	}
+	switch {
+	case s.State != types.SnapshotStatePending:
+		line += "  " + string(s.State)
+	default:
+		if eta, ok := snapshotETA(progress, started); ok {
+			line += fmt.Sprintf("  ETA %s", eta.Round(time.Second))
+		}
+	}
+	return line
+}
+
+// progressBar renders a bar filled to the percentage, followed by it.
+func progressBar(pct float64) string {
+	filled := int(pct / 100 * progressBarWidth)
+	return fmt.Sprintf("[%s%s] %3.0f%%", strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled), pct)
+}
+
+// logWriter is the stderr of the logger: while progress bars are drawn, it
+// erases them, writes the log line and draws them again below it.
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	activeBarsMu.Lock()
+	b := activeBars
+	activeBarsMu.Unlock()
+	if b == nil {
+		return os.Stderr.Write(p)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	n, err := os.Stderr.Write(p)
+	b.draw()
+	return n, err
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the buckets of the run
+// duration histogram: from a minute to four hours.
+var durationBuckets = []float64{60, 300, 600, 1200, 1800, 3600, 7200, 14400}
+
+// promMetrics counts the runs of the daemon by target and serves them in the
+// Prometheus text format. A nil promMetrics records nothing.
+type promMetrics struct {
+	mu      sync.Mutex
+	targets map[string]*targetMetrics
+}
+
+type targetMetrics struct {
+	runs, failures uint64
+	// buckets counts the runs of each duration bucket, not cumulatively.
+	buckets     []uint64
+	durationSum float64
+	lastSuccess time.Time
+}
+
+func newPromMetrics() *promMetrics {
+	return &promMetrics{targets: map[string]*targetMetrics{}}
+}
+
+// serve serves the metrics on /metrics at addr in the background, failing
+// right away if addr cannot be listened on.
+func (m *promMetrics) serve(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening for metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.serveHTTP)
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			warnf("error serving metrics: %v", err)
+		}
+	}()
+	return nil
+}
+
+// run records a run of the target.
+func (m *promMetrics) run(target string, succeeded bool, elapsed time.Duration, finished time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.targets[target]
+	if t == nil {
+		t = &targetMetrics{buckets: make([]uint64, len(durationBuckets))}
+		m.targets[target] = t
+	}
+	t.runs++
+	if succeeded {
+		t.lastSuccess = finished
+	} else {
+		t.failures++
+	}
+	seconds := elapsed.Seconds()
+	t.durationSum += seconds
+	for i, b := range durationBuckets {
+		if seconds <= b {
+			t.buckets[i]++
+			break
+		}
+	}
+}
+
+func (m *promMetrics) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.targets))
+	for name := range m.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	family := func(name, typ, help string, line func(target string, t *targetMetrics)) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, target := range names {
+			line(target, m.targets[target])
+		}
+	}
+	family("amimati_runs_total", "counter", "Runs of the pipeline.", func(target string, t *targetMetrics) {
+		fmt.Fprintf(&b, "amimati_runs_total{target=%s} %d\n", labelValue(target), t.runs)
+	})
+	family("amimati_failures_total", "counter", "Runs of the pipeline that failed.", func(target string, t *targetMetrics) {
+		fmt.Fprintf(&b, "amimati_failures_total{target=%s} %d\n", labelValue(target), t.failures)
+	})
+	family("amimati_duration_seconds", "histogram", "Duration of the runs of the pipeline.", func(target string, t *targetMetrics) {
+		var cumulative uint64
+		for i, bound := range durationBuckets {
+			cumulative += t.buckets[i]
+			fmt.Fprintf(&b, "amimati_duration_seconds_bucket{target=%s,le=\"%s\"} %d\n", labelValue(target), strconv.FormatFloat(bound, 'f', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&b, "amimati_duration_seconds_bucket{target=%s,le=\"+Inf\"} %d\n", labelValue(target), t.runs)
+		fmt.Fprintf(&b, "amimati_duration_seconds_sum{target=%s} %s\n", labelValue(target), strconv.FormatFloat(t.durationSum, 'f', -1, 64))
+		fmt.Fprintf(&b, "amimati_duration_seconds_count{target=%s} %d\n", labelValue(target), t.runs)
+	})
+	family("amimati_last_success_timestamp_seconds", "gauge", "Unix time of the last run of the pipeline that succeeded.", func(target string, t *targetMetrics) {
+		if !t.lastSuccess.IsZero() {
+			fmt.Fprintf(&b, "amimati_last_success_timestamp_seconds{target=%s} %d\n", labelValue(target), t.lastSuccess.Unix())
+		}
+	})
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
+
+// labelValue quotes a label value as the Prometheus text format does,
+// escaping only backslashes, double quotes and newlines.
+func labelValue(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+// target is the target label of the metrics of the runs of the pipeline: the
+// instance it images, by ID or name, else the name of its images.
+func (p *pipelineConfig) target() string {
+	switch {
+	case p.InstanceId != "":
+		return p.InstanceId
+	case p.InstanceName != "":
+		return p.InstanceName
+	}
+	return p.Name
+}
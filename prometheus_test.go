@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLabelValue(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"i-0123456789abcdef0", `"i-0123456789abcdef0"`},
+		{`web "blue"`, `"web \"blue\""`},
+		{`C:\images`, `"C:\\images"`},
+		{"two\nlines", `"two\nlines"`},
+		{"café-ゴールデン", `"café-ゴールデン"`},
+		{"tab\there", "\"tab\there\""},
+	} {
+		if got := labelValue(tt.in); got != tt.want {
+			t.Errorf("labelValue(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPromMetrics(t *testing.T) {
+	m := newPromMetrics()
+	m.run(`web "café"`, true, 90*time.Second, time.Unix(1700000000, 0))
+	m.run(`web "café"`, false, 5*time.Hour, time.Unix(1700003600, 0))
+	m.run("i-1", false, 30*time.Second, time.Unix(1700000000, 0))
+	w := httptest.NewRecorder()
+	m.serveHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`amimati_runs_total{target="web \"café\""} 2`,
+		`amimati_failures_total{target="web \"café\""} 1`,
+		`amimati_runs_total{target="i-1"} 1`,
+		`amimati_duration_seconds_bucket{target="web \"café\"",le="60"} 0`,
+		`amimati_duration_seconds_bucket{target="web \"café\"",le="300"} 1`,
+		`amimati_duration_seconds_bucket{target="web \"café\"",le="14400"} 1`,
+		`amimati_duration_seconds_bucket{target="web \"café\"",le="+Inf"} 2`,
+		`amimati_duration_seconds_sum{target="web \"café\""} 18090`,
+		`amimati_duration_seconds_count{target="web \"café\""} 2`,
+		`amimati_last_success_timestamp_seconds{target="web \"café\""} 1700000000`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+	// A target that never succeeded has no last success.
+	if strings.Contains(body, `amimati_last_success_timestamp_seconds{target="i-1"}`) {
+		t.Error("got a last success for a target that never succeeded")
+	}
+	if strings.Contains(body, `\u`) || strings.Contains(body, `\x`) {
+		t.Error("metrics contain escapes the text format does not allow")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// supersededByTagKey names the image that took the promotion tag over from
+// the image tagged with it.
+const supersededByTagKey = "amimati:superseded-by"
+
+// promotionResult reports the promotion of the image and its copies with
+// -promote-tag.
+type promotionResult struct {
+	Tag string
+	// Superseded are the images the tag was moved from, in every region.
+	Superseded []supersededImage `json:",omitempty"`
+}
+
+type supersededImage struct {
+	Region          string
+	ImageId         string
+	DeprecationTime *time.Time `json:",omitempty"`
+}
+
+// promoteImages moves the tag to the images, keyed by region, from the other
+// images of the account carrying it in their region, which are tagged as
+// superseded by the new image of the region and, with deprecateAfter, have
+// their deprecation scheduled. The new image is tagged first so that the tag
+// always marks an image.
+func promoteImages(ctx context.Context, cfg aws.Config, tag types.Tag, images map[string]string, deprecateAfter string) (*promotionResult, error) {
+	res := &promotionResult{Tag: formatTags(tags{tag})}
+	regions := make([]string, 0, len(images))
+	for region := range images {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		superseded, err := promoteImage(ctx, regionalClient(cfg, region), tag, images[region], deprecateAfter)
+		for _, s := range superseded {
+			s.Region = region
+			res.Superseded = append(res.Superseded, s)
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+func promoteImage(ctx context.Context, client *ec2.Client, tag types.Tag, imageID, deprecateAfter string) ([]supersededImage, error) {
+	if _, err := client.CreateTags(ctx, &ec2.CreateTagsInput{Resources: []string{imageID}, Tags: []types.Tag{tag}}); err != nil {
+		return nil, fmt.Errorf("error tagging image %s: %w", imageID, err)
+	}
+	out, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		Owners:  []string{"self"},
+		Filters: []types.Filter{{Name: aws.String("tag:" + aws.ToString(tag.Key)), Values: []string{aws.ToString(tag.Value)}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing images: %w", err)
+	}
+	var superseded []supersededImage
+	for _, image := range out.Images {
+		id := aws.ToString(image.ImageId)
+		if id == imageID {
+			continue
+		}
+		if _, err := client.DeleteTags(ctx, &ec2.DeleteTagsInput{Resources: []string{id}, Tags: []types.Tag{tag}}); err != nil {
+			return superseded, fmt.Errorf("error untagging image %s: %w", id, err)
+		}
+		if _, err := client.CreateTags(ctx, &ec2.CreateTagsInput{Resources: []string{id}, Tags: []types.Tag{{Key: aws.String(supersededByTagKey), Value: &imageID}}}); err != nil {
+			return superseded, fmt.Errorf("error tagging image %s: %w", id, err)
+		}
+		s := supersededImage{ImageId: id}
+		if deprecateAfter != "" {
+			at, err := deprecationTime(deprecateAfter, time.Now())
+			if err != nil {
+				return superseded, err
+			}
+			at = at.UTC().Truncate(time.Minute)
+			if err := deprecateImage(ctx, client, id, at); err != nil {
+				return superseded, err
+			}
+			s.DeprecationTime = &at
+		}
+		superseded = append(superseded, s)
+	}
+	return superseded, nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const expireAtTagKey = "amimati:expire-at"
+
+type pruneResult struct {
+	ImageId     string
+	Name        string
+	SnapshotIds []string
+	RecycleBin  *recycled `json:",omitempty"`
+}
+
+// pruneReport summarizes a prune run.
+type pruneReport struct {
+	DryRun bool `json:",omitempty"`
+	// Matched is the number of images matching the filters, of which Kept
+	// were retained.
+	Matched   int
+	Kept      int
+	Deleted   int
+	Snapshots int
+	Images    []pruneResult
+}
+
+func runPrune(args []string) {
+	var expired, dryRun, plan, autoApprove bool
+	var namePrefix, olderThan, query, configFile, profileName string
+	var keepLast int
+	var tagFilters filterTags
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.BoolVar(&expired, "expired", false, "delete images whose "+expireAtTagKey+" tag has passed")
+	fs.StringVar(&namePrefix, "name-prefix", "", "only prune images whose name starts with this prefix")
+	fs.Var(&tagFilters, "tag", "only prune images with these tags(eg. env=prod)")
+	scope := addImageScopeFlags(fs, "consider")
+	fs.IntVar(&keepLast, "keep-last", 0, "keep this many of the most recently created matching images")
+	fs.StringVar(&olderThan, "older-than", "", "only delete matching images created longer ago than this(eg. 30d)")
+	fs.BoolVar(&dryRun, "dry-run", false, "report the images and snapshots that would be deleted without deleting them")
+	fs.BoolVar(&plan, "plan", false, "print the plan of the images and snapshots that would be deleted, with their sizes, ages and the rule matching them, without deleting them")
+	fs.BoolVar(&autoApprove, "auto-approve", false, "delete without showing the plan and asking to confirm it on the terminal")
+	fs.StringVar(&configFile, "config", "", "config file with the profiles(default: amimati.yaml or amimati.json, if either exists)")
+	fs.StringVar(&profileName, "profile-name", "", "apply the retention policy of this profile of the config file to the flags not given(eg. prod)")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. Images[].ImageId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+
+	if profileName != "" {
+		if configFile == "" {
+			configFile = defaultConfigFile()
+		}
+		var c *fileConfig
+		if configFile != "" {
+			var err error
+			if c, err = loadConfigFile(configFile); err != nil {
+				fatal(err)
+			}
+		}
+		if err := applyConfigProfile(fs, c, profileName); err != nil {
+			fatal(err)
+		}
+	}
+
+	retention := keepLast > 0 || olderThan != ""
+	if expired == retention {
+		fatal("either -expired, or -keep-last or -older-than is required")
+	}
+	if keepLast < 0 {
+		fatal("keep-last must not be negative")
+	}
+	if retention && namePrefix == "" && len(tagFilters) == 0 {
+		fatal("-keep-last and -older-than require -name-prefix or -tag")
+	}
+	if plan && dryRun {
+		fatal("-plan cannot be combined with -dry-run")
+	}
+	var cutoff time.Time
+	if olderThan != "" {
+		d, err := parseDuration(olderThan)
+		if err != nil {
+			fatal(err)
+		}
+		cutoff = time.Now().Add(-d)
+	}
+
+	ctx := context.Background()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	client := ec2.NewFromConfig(cfg)
+
+	report := pruneReport{DryRun: dryRun, Images: []pruneResult{}}
+	var images []types.Image
+	if expired {
+		images, err = expiredImages(ctx, client, scope, time.Now())
+	} else {
+		images, err = matchingImages(ctx, client, scope, namePrefix, tagFilters)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	report.Matched = len(images)
+	// The images of other owners are left out before -keep-last and
+	// -older-than are applied, so that they do not take up the slots of the
+	// images kept.
+	if !scope.ownOnly() {
+		if images, err = ownedImages(ctx, cfg, images); err != nil {
+			fatal(err)
+		}
+	}
+	if !expired {
+		images = prunable(images, keepLast, cutoff)
+	}
+	report.Kept = report.Matched - len(images)
+
+	if plan || (!dryRun && !autoApprove && len(images) > 0) {
+		var p deletionPlan
+		now := time.Now()
+		for _, image := range images {
+			p.add(image, pruneRule(image, expired, namePrefix, tagFilters, keepLast, olderThan), nil, now)
+		}
+		if plan {
+			printResult(p, query)
+			return
+		}
+		if err := confirmPlan(p); err != nil {
+			fatal(err)
+		}
+	}
+
+	for _, image := range images {
+		var r pruneResult
+		if dryRun {
+			r = pruneResult{ImageId: *image.ImageId, Name: aws.ToString(image.Name), SnapshotIds: imageSnapshotIDs(image)}
+		} else if r, err = pruneImage(ctx, client, image); err != nil {
+			fatal(err)
+		}
+		report.Deleted++
+		report.Snapshots += len(r.SnapshotIds)
+		report.Images = append(report.Images, r)
+	}
+
+	printResult(report, query)
+}
+
+// ownedImages returns the images owned by the caller, warning about the
+// others, which only their owner can deregister.
+func ownedImages(ctx context.Context, cfg aws.Config, images []types.Image) ([]types.Image, error) {
+	caller, err := lookupCaller(ctx, cfg)()
+	if err != nil {
+		return nil, err
+	}
+	var owned []types.Image
+	for _, image := range images {
+		if aws.ToString(image.OwnerId) != caller.Account {
+			warnf("keeping %s: it is owned by %s, which alone can deregister it", *image.ImageId, aws.ToString(image.OwnerId))
+			continue
+		}
+		owned = append(owned, image)
+	}
+	return owned, nil
+}
+
+// pruneRule describes why prune selected the image.
+func pruneRule(image types.Image, expired bool, namePrefix string, tagFilters filterTags, keepLast int, olderThan string) string {
+	if expired {
+		return "expired at " + tagValue(image.Tags, expireAtTagKey)
+	}
+	var match, policy []string
+	if namePrefix != "" {
+		match = append(match, "name prefix "+namePrefix)
+	}
+	if len(tagFilters) > 0 {
+		match = append(match, "tags "+tagFilters.String())
+	}
+	if keepLast > 0 {
+		policy = append(policy, fmt.Sprintf("not among the %d newest", keepLast))
+	}
+	if olderThan != "" {
+		policy = append(policy, "older than "+olderThan)
+	}
+	return "matches " + strings.Join(match, " and ") + ", " + strings.Join(policy, " and ")
+}
+
+// matchingImages returns the images owned by the caller whose name starts with
+// prefix and which carry all the tags, newest first.
+func matchingImages(ctx context.Context, client *ec2.Client, scope *imageScope, prefix string, tagFilters filterTags) ([]types.Image, error) {
+	var f []types.Filter
+	if prefix != "" {
+		f = append(f, types.Filter{Name: aws.String("name"), Values: []string{prefix + "*"}})
+	}
+	for _, t := range tagFilters {
+		f = append(f, types.Filter{Name: aws.String("tag:" + aws.ToString(t.Key)), Values: []string{aws.ToString(t.Value)}})
+	}
+	images, err := scope.describe(ctx, client, f)
+	if err != nil {
+		return nil, err
+	}
+
+	// CreationDate is ISO 8601 in UTC, so it sorts lexically.
+	sort.Slice(images, func(i, j int) bool {
+		return aws.ToString(images[i].CreationDate) > aws.ToString(images[j].CreationDate)
+	})
+	return images, nil
+}
+
+// prunable returns the images, newest first, that are not among the keepLast
+// newest and, unless cutoff is zero, were created before cutoff.
+func prunable(images []types.Image, keepLast int, cutoff time.Time) []types.Image {
+	if len(images) <= keepLast {
+		return nil
+	}
+	var r []types.Image
+	for _, image := range images[keepLast:] {
+		if !cutoff.IsZero() {
+			created, err := time.Parse(time.RFC3339, aws.ToString(image.CreationDate))
+			if err != nil || !created.Before(cutoff) {
+				continue
+			}
+		}
+		r = append(r, image)
+	}
+	return r
+}
+
+// imageSnapshotIDs returns the IDs of the EBS snapshots backing the image.
+func imageSnapshotIDs(image types.Image) []string {
+	var ids []string
+	for _, bdm := range image.BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+			ids = append(ids, *bdm.Ebs.SnapshotId)
+		}
+	}
+	return ids
+}
+
+// expiredImages returns the images of the scope whose expire-at tag is before
+// now.
+func expiredImages(ctx context.Context, client *ec2.Client, scope *imageScope, now time.Time) ([]types.Image, error) {
+	scoped, err := scope.describe(ctx, client, []types.Filter{{Name: aws.String("tag-key"), Values: []string{expireAtTagKey}}})
+	if err != nil {
+		return nil, err
+	}
+	var images []types.Image
+	for _, image := range scoped {
+		for _, tag := range image.Tags {
+			if aws.ToString(tag.Key) != expireAtTagKey {
+				continue
+			}
+			at, err := time.Parse(time.RFC3339, aws.ToString(tag.Value))
+			if err != nil {
+				warnf("ignoring %s: invalid %s tag: %s", *image.ImageId, expireAtTagKey, aws.ToString(tag.Value))
+				continue
+			}
+			if at.Before(now) {
+				images = append(images, image)
+			}
+		}
+	}
+	return images, nil
+}
+
+// deleteImage deregisters the image and deletes the EBS snapshots backing it.
+func deleteImage(ctx context.Context, client *ec2.Client, image types.Image) ([]string, error) {
+	if _, err := client.DeregisterImage(ctx, &ec2.DeregisterImageInput{ImageId: image.ImageId}); err != nil {
+		return nil, fmt.Errorf("error deregistering image %s: %w", *image.ImageId, err)
+	}
+	var deleted []string
+	for _, bdm := range image.BlockDeviceMappings {
+		if bdm.Ebs == nil || bdm.Ebs.SnapshotId == nil {
+			continue
+		}
+		if _, err := client.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{SnapshotId: bdm.Ebs.SnapshotId}); err != nil {
+			return deleted, fmt.Errorf("error deleting snapshot %s: %w", *bdm.Ebs.SnapshotId, err)
+		}
+		deleted = append(deleted, *bdm.Ebs.SnapshotId)
+	}
+	return deleted, nil
+}
+
+// retainedImages returns the images owned by the caller whose name starts with
+// prefix, except the keepLast most recently created ones.
+func retainedImages(ctx context.Context, client *ec2.Client, prefix string, keepLast int) ([]types.Image, error) {
+	images, err := matchingImages(ctx, client, nil, prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	return prunable(images, keepLast, time.Time{}), nil
+}
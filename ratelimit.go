@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// API families share an EC2 request token bucket per account and region.
+const (
+	apiFamilyDescribe = "describe"
+	apiFamilyImage    = "image"
+	apiFamilyMutating = "mutating"
+)
+
+// apiFamily returns the family an EC2 operation is throttled under.
+func apiFamily(operation string) string {
+	for _, p := range []string{"Describe", "Get", "List", "Search"} {
+		if strings.HasPrefix(operation, p) {
+			return apiFamilyDescribe
+		}
+	}
+	switch operation {
+	case "CreateImage", "CopyImage", "RegisterImage":
+		return apiFamilyImage
+	}
+	return apiFamilyMutating
+}
+
+// apiRates are requests per second per API family; zero disables limiting.
+// They stay well below the EC2 refill rates so that other clients of the
+// account keep some headroom.
+type apiRates map[string]float64
+
+func defaultAPIRates() apiRates {
+	return apiRates{apiFamilyDescribe: 10, apiFamilyImage: 2, apiFamilyMutating: 5}
+}
+
+func (r apiRates) String() string {
+	var s []string
+	for f, v := range r {
+		s = append(s, f+"="+strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (r apiRates) Set(value string) error {
+	for _, kv := range strings.Split(value, ",") {
+		f, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("invalid API rate: %s", kv)
+		}
+		switch f {
+		case apiFamilyDescribe, apiFamilyImage, apiFamilyMutating:
+		default:
+			return fmt.Errorf("unknown API family: %s", f)
+		}
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 {
+			return fmt.Errorf("invalid API rate: %s", kv)
+		}
+		r[f] = rate
+	}
+	return nil
+}
+
+// tokenBucket allows rate requests per second with bursts of burst requests,
+// at least one so that rates below one request in five seconds still let
+// requests through.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := max(1, 5*rate)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		d := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
+// apiFamilyTotal is the bucket of -api-rps, taken by every EC2 request on
+// top of the bucket of its family.
+const apiFamilyTotal = "total"
+
+// rateLimiter holds a token bucket per region and API family, shared by all
+// the clients created from the same config and so by all their goroutines.
+type rateLimiter struct {
+	rates apiRates
+	// total caps the EC2 requests per second of a region across families;
+	// zero disables it.
+	total   float64
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func (l *rateLimiter) bucket(region, family string) *tokenBucket {
+	rate := l.rates[family]
+	if family == apiFamilyTotal {
+		rate = l.total
+	}
+	if rate <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := region + "/" + family
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newTokenBucket(rate)
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// instrument adds a middleware that waits for a token before each EC2 call.
+func (l *rateLimiter) instrument(cfg *aws.Config) {
+	cfg.APIOptions = append(cfg.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("amimatiRateLimit", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			if awsmiddleware.GetServiceID(ctx) == "EC2" {
+				region := awsmiddleware.GetRegion(ctx)
+				for _, family := range []string{apiFamilyTotal, apiFamily(awsmiddleware.GetOperationName(ctx))} {
+					if b := l.bucket(region, family); b != nil {
+						if err := b.wait(ctx); err != nil {
+							return middleware.InitializeOutput{}, middleware.Metadata{}, err
+						}
+					}
+				}
+			}
+			return next.HandleInitialize(ctx, in)
+		}), middleware.After)
+	})
+}
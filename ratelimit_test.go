@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketSlowRates(t *testing.T) {
+	for _, rate := range []float64{0.01, 0.1, 0.19, 0.2, 1} {
+		b := newTokenBucket(rate)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := b.wait(ctx); err != nil {
+			t.Errorf("rate %v: first request: %v", rate, err)
+		}
+		// Time enough for a token to refill.
+		b.mu.Lock()
+		b.last = b.last.Add(-time.Duration(float64(time.Second) / rate))
+		b.mu.Unlock()
+		if err := b.wait(ctx); err != nil {
+			t.Errorf("rate %v: request after refill: %v", rate, err)
+		}
+		cancel()
+	}
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	b := newTokenBucket(2)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	for i := 0; i < 10; i++ {
+		if err := b.wait(ctx); err != nil {
+			t.Fatalf("request %d of the burst: %v", i+1, err)
+		}
+	}
+	if err := b.wait(ctx); err == nil {
+		t.Fatal("request beyond the burst was not delayed")
+	}
+}
+
+func TestRateLimiterSlowTotal(t *testing.T) {
+	l := &rateLimiter{rates: apiRates{}, total: 0.1, buckets: map[string]*tokenBucket{}}
+	b := l.bucket("us-east-1", apiFamilyTotal)
+	if b == nil {
+		t.Fatal("no bucket for -api-rps")
+	}
+	if l.bucket("us-east-1", apiFamilyTotal) != b {
+		t.Error("the region does not share its -api-rps bucket")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b.wait(ctx); err != nil {
+		t.Fatalf("-api-rps 0.1: first request: %v", err)
+	}
+	if l.bucket("us-east-1", apiFamilyImage) != nil {
+		t.Error("got a bucket for a family without a rate")
+	}
+}
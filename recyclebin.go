@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// recycled describes deleted resources retained by a Recycle Bin rule.
+type recycled struct {
+	// ImageExitTime is when the deregistered image leaves the Recycle Bin
+	// for good, if a rule retains it.
+	ImageExitTime *time.Time         `json:",omitempty"`
+	Snapshots     []recycledSnapshot `json:",omitempty"`
+}
+
+type recycledSnapshot struct {
+	SnapshotId string
+	ExitTime   *time.Time
+}
+
+// pruneImage deletes the image and its snapshots and reports which of them
+// went to the Recycle Bin.
+func pruneImage(ctx context.Context, client *ec2.Client, image types.Image) (pruneResult, error) {
+	r := pruneResult{ImageId: *image.ImageId, Name: aws.ToString(image.Name)}
+	snapshotIds, err := deleteImage(ctx, client, image)
+	r.SnapshotIds = snapshotIds
+	if err != nil {
+		return r, err
+	}
+	r.RecycleBin = recycleBinStatus(ctx, client, *image.ImageId, snapshotIds)
+	return r, nil
+}
+
+// recycleBinStatus looks the deleted image and snapshots up in the Recycle
+// Bin. It returns nil when none of them are retained or the caller is not
+// allowed to list the Recycle Bin.
+func recycleBinStatus(ctx context.Context, client *ec2.Client, imageID string, snapshotIds []string) *recycled {
+	var r recycled
+	if out, err := client.ListImagesInRecycleBin(ctx, &ec2.ListImagesInRecycleBinInput{ImageIds: []string{imageID}}); err == nil && len(out.Images) > 0 {
+		r.ImageExitTime = out.Images[0].RecycleBinExitTime
+	}
+	if len(snapshotIds) > 0 {
+		if out, err := client.ListSnapshotsInRecycleBin(ctx, &ec2.ListSnapshotsInRecycleBinInput{SnapshotIds: snapshotIds}); err == nil {
+			for _, s := range out.Snapshots {
+				r.Snapshots = append(r.Snapshots, recycledSnapshot{SnapshotId: *s.SnapshotId, ExitTime: s.RecycleBinExitTime})
+			}
+		}
+	}
+	if r.ImageExitTime == nil && len(r.Snapshots) == 0 {
+		return nil
+	}
+	return &r
+}
+
+type undeleteResult struct {
+	ImageId             string
+	RestoredSnapshotIds []string
+}
+
+func runUndelete(args []string) {
+	var imageID, query string
+	fs := flag.NewFlagSet("undelete", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.StringVar(&imageID, "image-id", "", "ID of the image to restore from the Recycle Bin")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. ImageId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	if imageID == "" {
+		fatal("image ID is required")
+	}
+
+	ctx := context.Background()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+
+	res, err := undeleteImage(ctx, ec2.NewFromConfig(cfg), imageID)
+	if err != nil {
+		fatal(err)
+	}
+	printResult(res, query)
+}
+
+// undeleteImage restores the image from the Recycle Bin along with those of
+// its snapshots that are still in it.
+func undeleteImage(ctx context.Context, client *ec2.Client, imageID string) (*undeleteResult, error) {
+	if _, err := client.RestoreImageFromRecycleBin(ctx, &ec2.RestoreImageFromRecycleBinInput{ImageId: &imageID}); err != nil {
+		return nil, fmt.Errorf("error restoring image %s: %w", imageID, err)
+	}
+	out, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: []string{imageID}})
+	if err != nil {
+		return nil, fmt.Errorf("error describing image %s: %w", imageID, err)
+	}
+	if len(out.Images) == 0 {
+		return nil, fmt.Errorf("image %s not found", imageID)
+	}
+
+	var ids []string
+	for _, bdm := range out.Images[0].BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+			ids = append(ids, *bdm.Ebs.SnapshotId)
+		}
+	}
+	res := &undeleteResult{ImageId: imageID, RestoredSnapshotIds: []string{}}
+	if len(ids) == 0 {
+		return res, nil
+	}
+	bin, err := client.ListSnapshotsInRecycleBin(ctx, &ec2.ListSnapshotsInRecycleBinInput{SnapshotIds: ids})
+	if err != nil {
+		return res, fmt.Errorf("error listing snapshots in the recycle bin: %w", err)
+	}
+	for _, s := range bin.Snapshots {
+		if _, err := client.RestoreSnapshotFromRecycleBin(ctx, &ec2.RestoreSnapshotFromRecycleBinInput{SnapshotId: s.SnapshotId}); err != nil {
+			return res, fmt.Errorf("error restoring snapshot %s: %w", *s.SnapshotId, err)
+		}
+		res.RestoredSnapshotIds = append(res.RestoredSnapshotIds, *s.SnapshotId)
+	}
+	return res, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// imageAttributes are the attributes that can only be chosen when an image is
+// registered, or set once with ModifyImageAttribute. Empty values keep those
+// of the original image.
+type imageAttributes struct {
+	bootMode    types.BootModeValues
+	imdsSupport types.ImdsSupportValues
+	tpmSupport  types.TpmSupportValues
+	// enaSupport is only ever set to true.
+	enaSupport *bool
+}
+
+// imageAttributesOf returns the attributes of the image, for its copies to
+// have them too.
+func imageAttributesOf(image types.Image) imageAttributes {
+	a := imageAttributes{bootMode: image.BootMode, imdsSupport: image.ImdsSupport, tpmSupport: image.TpmSupport}
+	if aws.ToBool(image.EnaSupport) {
+		a.enaSupport = aws.Bool(true)
+	}
+	return a
+}
+
+// withDefaults returns a with its empty attributes set to those of d.
+func (a imageAttributes) withDefaults(d imageAttributes) imageAttributes {
+	if a.bootMode == "" {
+		a.bootMode = d.bootMode
+	}
+	if a.imdsSupport == "" {
+		a.imdsSupport = d.imdsSupport
+	}
+	if a.tpmSupport == "" {
+		a.tpmSupport = d.tpmSupport
+	}
+	if a.enaSupport == nil {
+		a.enaSupport = d.enaSupport
+	}
+	return a
+}
+
+// missingFrom returns the attributes the image does not already have.
+func (a imageAttributes) missingFrom(image types.Image) imageAttributes {
+	var m imageAttributes
+	if a.bootMode != "" && a.bootMode != image.BootMode {
+		m.bootMode = a.bootMode
+	}
+	if a.imdsSupport != "" && a.imdsSupport != image.ImdsSupport {
+		m.imdsSupport = a.imdsSupport
+	}
+	if a.tpmSupport != "" && a.tpmSupport != image.TpmSupport {
+		m.tpmSupport = a.tpmSupport
+	}
+	if a.enaSupport != nil && *a.enaSupport != aws.ToBool(image.EnaSupport) {
+		m.enaSupport = a.enaSupport
+	}
+	return m
+}
+
+// applyImageAttributes gives the image the attributes it lacks: IMDS support
+// with ModifyImageAttribute, or by re-registering it when its boot mode, TPM
+// or ENA support differ, as those cannot be modified. It returns the
+// image as it is afterwards, which may have another ID.
+func applyImageAttributes(ctx context.Context, client *ec2.Client, image types.Image, attrs imageAttributes, verbose bool) (types.Image, error) {
+	missing := attrs.missingFrom(image)
+	if missing == (imageAttributes{}) {
+		return image, nil
+	}
+	if missing.bootMode != "" || missing.tpmSupport != "" || missing.enaSupport != nil {
+		return reregisterImage(ctx, client, image, missing, verbose)
+	}
+	if verbose {
+		logf("setting the IMDS support of image %s to %s", *image.ImageId, missing.imdsSupport)
+	}
+	if _, err := client.ModifyImageAttribute(ctx, &ec2.ModifyImageAttributeInput{
+		ImageId:     image.ImageId,
+		ImdsSupport: &types.AttributeValue{Value: aws.String(string(missing.imdsSupport))},
+	}); err != nil {
+		return types.Image{}, fmt.Errorf("error setting IMDS support of image %s: %w", *image.ImageId, err)
+	}
+	return waitImageAvailable(ctx, client, *image.ImageId, verbose)
+}
+
+// reregisterImage replaces the image with one registered from the same
+// snapshots, applying the given attributes. The original image is
+// deregistered but its snapshots are kept; tags are carried over.
+func reregisterImage(ctx context.Context, client *ec2.Client, image types.Image, attrs imageAttributes, verbose bool) (types.Image, error) {
+	image, err := waitImageAvailable(ctx, client, *image.ImageId, verbose)
+	if err != nil {
+		return types.Image{}, err
+	}
+
+	var bdms []types.BlockDeviceMapping
+	for _, bdm := range image.BlockDeviceMappings {
+		m := types.BlockDeviceMapping{DeviceName: bdm.DeviceName, VirtualName: bdm.VirtualName, NoDevice: bdm.NoDevice}
+		if bdm.Ebs != nil {
+			m.Ebs = &types.EbsBlockDevice{
+				SnapshotId:          bdm.Ebs.SnapshotId,
+				VolumeSize:          bdm.Ebs.VolumeSize,
+				VolumeType:          bdm.Ebs.VolumeType,
+				Iops:                bdm.Ebs.Iops,
+				Throughput:          bdm.Ebs.Throughput,
+				DeleteOnTermination: bdm.Ebs.DeleteOnTermination,
+			}
+		}
+		bdms = append(bdms, m)
+	}
+
+	in := &ec2.RegisterImageInput{
+		Name:                image.Name,
+		Description:         image.Description,
+		Architecture:        image.Architecture,
+		BlockDeviceMappings: bdms,
+		RootDeviceName:      image.RootDeviceName,
+		VirtualizationType:  aws.String(string(image.VirtualizationType)),
+		EnaSupport:          image.EnaSupport,
+		SriovNetSupport:     image.SriovNetSupport,
+		BootMode:            image.BootMode,
+		TpmSupport:          image.TpmSupport,
+		ImdsSupport:         image.ImdsSupport,
+	}
+	if len(image.Tags) > 0 {
+		in.TagSpecifications = []types.TagSpecification{{ResourceType: types.ResourceTypeImage, Tags: image.Tags}}
+	}
+	if attrs.bootMode != "" {
+		in.BootMode = attrs.bootMode
+	}
+	if attrs.imdsSupport != "" {
+		in.ImdsSupport = attrs.imdsSupport
+	}
+	if attrs.tpmSupport != "" {
+		in.TpmSupport = attrs.tpmSupport
+	}
+	if attrs.enaSupport != nil {
+		in.EnaSupport = attrs.enaSupport
+	}
+	if in.TpmSupport != "" && in.BootMode != types.BootModeValuesUefi {
+		return types.Image{}, fmt.Errorf("TPM support requires the uefi boot mode, image %s has %q", *image.ImageId, in.BootMode)
+	}
+
+	if _, err := client.DeregisterImage(ctx, &ec2.DeregisterImageInput{ImageId: image.ImageId}); err != nil {
+		return types.Image{}, fmt.Errorf("error deregistering image %s: %w", *image.ImageId, err)
+	}
+	out, err := client.RegisterImage(ctx, in)
+	if err != nil {
+		return types.Image{}, fmt.Errorf("error registering image: %w", err)
+	}
+	if verbose {
+		logf("re-registered image %s as %s", *image.ImageId, *out.ImageId)
+	}
+	return waitImageAvailable(ctx, client, *out.ImageId, verbose)
+}
+
+// setIMDSv2Required sets the ImdsSupport attribute of the image to v2.0 once it
+// is available. The attribute cannot be unset afterwards.
+func setIMDSv2Required(ctx context.Context, client *ec2.Client, imageID string, verbose bool) (types.Image, error) {
+	if _, err := waitImageAvailable(ctx, client, imageID, verbose); err != nil {
+		return types.Image{}, err
+	}
+	if _, err := client.ModifyImageAttribute(ctx, &ec2.ModifyImageAttributeInput{
+		ImageId:     &imageID,
+		ImdsSupport: &types.AttributeValue{Value: aws.String(string(types.ImdsSupportValuesV20))},
+	}); err != nil {
+		return types.Image{}, fmt.Errorf("error setting IMDS support of image %s: %w", imageID, err)
+	}
+	return waitImageAvailable(ctx, client, imageID, verbose)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// snapshotMapping maps a device of the image to register to an existing
+// snapshot.
+type snapshotMapping struct {
+	device     string
+	snapshotID string
+}
+
+// snapshotMappings is the repeatable -snapshot flag of register, each value a
+// comma separated list of device=snapshot ID pairs.
+type snapshotMappings []snapshotMapping
+
+func (m *snapshotMappings) String() string {
+	var s []string
+	for _, v := range *m {
+		s = append(s, v.device+"="+v.snapshotID)
+	}
+	return strings.Join(s, ",")
+}
+
+func (m *snapshotMappings) Set(value string) error {
+	for _, kv := range strings.Split(value, ",") {
+		device, id, ok := strings.Cut(kv, "=")
+		if !ok || device == "" || !strings.HasPrefix(id, "snap-") {
+			return fmt.Errorf("invalid snapshot mapping, want device=snap-id: %s", kv)
+		}
+		for _, v := range *m {
+			if v.device == device {
+				return fmt.Errorf("device %s is mapped twice", device)
+			}
+		}
+		*m = append(*m, snapshotMapping{device: device, snapshotID: id})
+	}
+	return nil
+}
+
+// registerRequest is the image register builds from existing snapshots.
+type registerRequest struct {
+	name, description string
+	mappings          snapshotMappings
+	// rootDevice is the device of the root volume, the first mapping if
+	// empty.
+	rootDevice          string
+	architecture        types.ArchitectureValues
+	attrs               imageAttributes
+	volumeType          types.VolumeType
+	deleteOnTermination bool
+	imageTags           tags
+}
+
+// registerInput builds the RegisterImage input of the request, given the
+// snapshots it maps by ID. The snapshots must have completed, and each volume
+// is the size of its snapshot.
+func registerInput(req registerRequest, snapshots map[string]types.Snapshot) (*ec2.RegisterImageInput, error) {
+	root := req.rootDevice
+	if root == "" {
+		root = req.mappings[0].device
+	}
+	in := &ec2.RegisterImageInput{
+		Name:               aws.String(req.name),
+		Architecture:       req.architecture,
+		RootDeviceName:     aws.String(root),
+		VirtualizationType: aws.String("hvm"),
+		EnaSupport:         req.attrs.enaSupport,
+		BootMode:           req.attrs.bootMode,
+		TpmSupport:         req.attrs.tpmSupport,
+		ImdsSupport:        req.attrs.imdsSupport,
+	}
+	if req.description != "" {
+		in.Description = aws.String(req.description)
+	}
+	rootMapped := false
+	for _, m := range req.mappings {
+		s, ok := snapshots[m.snapshotID]
+		if !ok {
+			return nil, fmt.Errorf("snapshot %s of %s not found", m.snapshotID, m.device)
+		}
+		if s.State != types.SnapshotStateCompleted {
+			return nil, fmt.Errorf("snapshot %s of %s is %s, not completed", m.snapshotID, m.device, s.State)
+		}
+		rootMapped = rootMapped || m.device == root
+		in.BlockDeviceMappings = append(in.BlockDeviceMappings, types.BlockDeviceMapping{
+			DeviceName: aws.String(m.device),
+			Ebs: &types.EbsBlockDevice{
+				SnapshotId:          aws.String(m.snapshotID),
+				VolumeSize:          s.VolumeSize,
+				VolumeType:          req.volumeType,
+				DeleteOnTermination: aws.Bool(req.deleteOnTermination),
+			},
+		})
+	}
+	if !rootMapped {
+		return nil, fmt.Errorf("root device %s is not among the -snapshot devices", root)
+	}
+	if in.TpmSupport != "" && in.BootMode != types.BootModeValuesUefi {
+		return nil, fmt.Errorf("TPM support requires the uefi boot mode")
+	}
+	// The image is tagged with the run ID as every resource amimati creates.
+	// RegisterImage creates no snapshots, the image uses the given ones, and
+	// only takes a TagSpecification of the image.
+	if imageTags := append(append(tags{}, req.imageTags...), runIDTags()...); len(imageTags) > 0 {
+		in.TagSpecifications = []types.TagSpecification{{ResourceType: types.ResourceTypeImage, Tags: imageTags}}
+	}
+	return in, nil
+}
+
+// registerImage registers the image of the request and waits for it to be
+// available.
+func registerImage(ctx context.Context, client *ec2.Client, req registerRequest, verbose bool) (types.Image, error) {
+	ids := make([]string, 0, len(req.mappings))
+	for _, m := range req.mappings {
+		ids = append(ids, m.snapshotID)
+	}
+	out, err := client.DescribeSnapshots(ctx, &ec2.DescribeSnapshotsInput{SnapshotIds: ids})
+	if err != nil {
+		return types.Image{}, fmt.Errorf("error describing snapshots: %w", err)
+	}
+	snapshots := map[string]types.Snapshot{}
+	for _, s := range out.Snapshots {
+		snapshots[*s.SnapshotId] = s
+	}
+	in, err := registerInput(req, snapshots)
+	if err != nil {
+		return types.Image{}, err
+	}
+	setPhase("registering image")
+	reg, err := client.RegisterImage(ctx, in)
+	if err != nil {
+		return types.Image{}, fmt.Errorf("error registering image: %w", err)
+	}
+	if verbose {
+		logf("registered image %s from %s", *reg.ImageId, req.mappings.String())
+	}
+	setPhase("waiting for image")
+	return waitImageAvailable(ctx, client, *reg.ImageId, verbose)
+}
+
+// runRegister registers an image from existing snapshots, such as those of
+// DLM or of the EBS direct APIs.
+func runRegister(args []string) {
+	var req registerRequest
+	var query, architecture, bootMode, tpmSupport, volumeType, runIDFlag string
+	var imdsV2, noENA, verbose bool
+	var timeout time.Duration
+	fs := flag.NewFlagSet("register", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.Var(&req.mappings, "snapshot", "device and completed snapshot of a volume of the image, repeatable(eg. /dev/xvda=snap-0123456789abcdef0)")
+	fs.StringVar(&req.rootDevice, "root-device", "", "device of the root volume(default: the device of the first -snapshot)")
+	fs.StringVar(&req.name, "name", "", "image name")
+	fs.StringVar(&req.description, "description", "", "image description")
+	fs.StringVar(&architecture, "architecture", string(types.ArchitectureValuesX8664), "architecture of the image(x86_64, arm64, i386, x86_64_mac or arm64_mac)")
+	fs.StringVar(&bootMode, "boot-mode", "", "boot mode of the image(uefi, legacy-bios or uefi-preferred; default: that of the architecture)")
+	fs.StringVar(&tpmSupport, "tpm-support", "", "set to v2.0 to give the instances of the image a NitroTPM; requires -boot-mode uefi")
+	fs.BoolVar(&imdsV2, "require-imdsv2", false, "require IMDSv2 on the instances launched from the image")
+	fs.BoolVar(&noENA, "no-ena", false, "register the image without ENA support")
+	fs.StringVar(&volumeType, "volume-type", "", "EBS volume type of the volumes of the image(eg. gp3; default: that of the region)")
+	fs.BoolVar(&req.deleteOnTermination, "delete-on-termination", true, "delete the volumes of the instances of the image when they terminate")
+	fs.Var(&req.imageTags, "image-tag", "image tags, repeatable; quote values containing commas(eg. env=prod,url=https://example.com)")
+	fs.StringVar(&runIDFlag, "run-id", "", "correlation ID of the run, logged and tagged on the image as "+runIDTagKey+"(default: generated)")
+	fs.BoolVar(&verbose, "v", false, "verbose output")
+	fs.DurationVar(&timeout, "timeout", 0, "give up if the image is not available after this long(eg. 30m; default: no limit)")
+	fs.DurationVar(&pollInterval, "poll-interval", pollInterval, "time between two polls of the image state")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. ImageId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	setRunID(runIDFlag)
+	if req.name == "" {
+		fatal("name is required")
+	}
+	if len(req.mappings) == 0 {
+		fatal("at least one -snapshot is required")
+	}
+	req.architecture = types.ArchitectureValues(architecture)
+	valid := false
+	for _, v := range req.architecture.Values() {
+		valid = valid || v == req.architecture
+	}
+	if !valid {
+		fatalf("invalid architecture: %s", architecture)
+	}
+	if bootMode != "" {
+		mode, err := parseBootMode(bootMode)
+		if err != nil {
+			fatal(err)
+		}
+		req.attrs.bootMode = mode
+	}
+	if tpmSupport != "" {
+		tpm, err := parseTpmSupport(tpmSupport)
+		if err != nil {
+			fatal(err)
+		}
+		req.attrs.tpmSupport = tpm
+	}
+	if imdsV2 {
+		req.attrs.imdsSupport = types.ImdsSupportValuesV20
+	}
+	if !noENA {
+		req.attrs.enaSupport = aws.Bool(true)
+	}
+	req.volumeType = types.VolumeType(volumeType)
+	if pollInterval <= 0 {
+		fatal("poll interval must be positive")
+	}
+
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	started := time.Now()
+	client := ec2.NewFromConfig(cfg)
+	image, err := registerImage(ctx, client, req, verbose)
+	if err != nil {
+		exitWith(exitCode(ctx, err), err)
+	}
+	snapshots, err := describeImageSnapshots(ctx, client, image)
+	if err != nil {
+		fatal(err)
+	}
+	printResult(newResult(ctx, cfg, image, snapshots, started), query)
+}
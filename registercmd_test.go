@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestSnapshotMappingsSet(t *testing.T) {
+	var m snapshotMappings
+	if err := m.Set("/dev/xvda=snap-1,/dev/xvdb=snap-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("/dev/xvdc=snap-3"); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.String(); got != "/dev/xvda=snap-1,/dev/xvdb=snap-2,/dev/xvdc=snap-3" {
+		t.Errorf("got %s", got)
+	}
+	for _, bad := range []string{"/dev/xvda", "=snap-1", "/dev/xvdd=vol-1", "/dev/xvda=snap-4"} {
+		if err := m.Set(bad); err == nil {
+			t.Errorf("Set(%q) did not fail", bad)
+		}
+	}
+}
+
+func TestRegisterInput(t *testing.T) {
+	snapshots := map[string]types.Snapshot{
+		"snap-1": {SnapshotId: aws.String("snap-1"), State: types.SnapshotStateCompleted, VolumeSize: aws.Int32(8)},
+		"snap-2": {SnapshotId: aws.String("snap-2"), State: types.SnapshotStateCompleted, VolumeSize: aws.Int32(100)},
+		"snap-3": {SnapshotId: aws.String("snap-3"), State: types.SnapshotStatePending, VolumeSize: aws.Int32(8)},
+	}
+	req := registerRequest{
+		name:                "web",
+		mappings:            snapshotMappings{{"/dev/xvda", "snap-1"}, {"/dev/xvdb", "snap-2"}},
+		architecture:        types.ArchitectureValuesArm64,
+		attrs:               imageAttributes{bootMode: types.BootModeValuesUefi, enaSupport: aws.Bool(true)},
+		volumeType:          types.VolumeTypeGp3,
+		deleteOnTermination: true,
+		imageTags:           tags{{Key: aws.String("env"), Value: aws.String("prod")}},
+	}
+	defer func(id string) { runID = id }(runID)
+	runID = "run-1"
+	in, err := registerInput(req, snapshots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToString(in.RootDeviceName) != "/dev/xvda" || in.Architecture != types.ArchitectureValuesArm64 || in.BootMode != types.BootModeValuesUefi {
+		t.Errorf("got root %s, architecture %s, boot mode %s", aws.ToString(in.RootDeviceName), in.Architecture, in.BootMode)
+	}
+	if len(in.BlockDeviceMappings) != 2 || aws.ToInt32(in.BlockDeviceMappings[1].Ebs.VolumeSize) != 100 || in.BlockDeviceMappings[1].Ebs.VolumeType != types.VolumeTypeGp3 {
+		t.Errorf("got mappings %+v", in.BlockDeviceMappings)
+	}
+	if len(in.TagSpecifications) != 1 || in.TagSpecifications[0].ResourceType != types.ResourceTypeImage || formatTags(in.TagSpecifications[0].Tags) != "env=prod,"+runIDTagKey+"=run-1" {
+		t.Errorf("got tag specifications %+v", in.TagSpecifications)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		change func(*registerRequest)
+		want   string
+	}{
+		{"pending snapshot", func(r *registerRequest) { r.mappings = snapshotMappings{{"/dev/xvda", "snap-3"}} }, "not completed"},
+		{"missing snapshot", func(r *registerRequest) { r.mappings = snapshotMappings{{"/dev/xvda", "snap-9"}} }, "not found"},
+		{"unmapped root", func(r *registerRequest) { r.rootDevice = "/dev/sda1" }, "root device"},
+		{"TPM without UEFI", func(r *registerRequest) { r.attrs = imageAttributes{tpmSupport: types.TpmSupportValuesV20} }, "TPM"},
+	} {
+		r := req
+		tt.change(&r)
+		if _, err := registerInput(r, snapshots); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
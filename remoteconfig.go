@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/appconfigdata"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// remoteConfig names a configuration kept in SSM Parameter Store or AppConfig
+// in place of a local config file, so that a central team can change it for
+// every runner at once.
+type remoteConfig struct {
+	ssmParameter string
+	// appConfig is application/environment/profile.
+	appConfig string
+}
+
+func addRemoteConfigFlags(fs *flag.FlagSet) *remoteConfig {
+	r := &remoteConfig{}
+	fs.StringVar(&r.ssmParameter, "config-ssm", "", "fetch the config from this SSM parameter instead of -config(eg. /amimati/config)")
+	fs.StringVar(&r.appConfig, "config-appconfig", "", "fetch the config from this AppConfig configuration profile instead of -config(eg. amimati/prod/backup-policy)")
+	return r
+}
+
+func (r *remoteConfig) set() bool {
+	return r.ssmParameter != "" || r.appConfig != ""
+}
+
+func (r *remoteConfig) validate() error {
+	if r.ssmParameter != "" && r.appConfig != "" {
+		return errors.New("-config-ssm and -config-appconfig cannot be combined")
+	}
+	if r.appConfig != "" && len(strings.Split(r.appConfig, "/")) != 3 {
+		return fmt.Errorf("invalid AppConfig profile, want application/environment/profile: %s", r.appConfig)
+	}
+	return nil
+}
+
+// String names the source of the configuration in messages.
+func (r *remoteConfig) String() string {
+	if r.ssmParameter != "" {
+		return "SSM parameter " + r.ssmParameter
+	}
+	return "AppConfig profile " + r.appConfig
+}
+
+// fetch retrieves and parses the configuration.
+func (r *remoteConfig) fetch(ctx context.Context, cfg aws.Config) (*fileConfig, error) {
+	var data []byte
+	if r.ssmParameter != "" {
+		out, err := ssm.NewFromConfig(cfg).GetParameter(ctx, &ssm.GetParameterInput{
+			Name:           aws.String(r.ssmParameter),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error getting config parameter %s: %w", r.ssmParameter, err)
+		}
+		data = []byte(aws.ToString(out.Parameter.Value))
+	} else {
+		// A new session is started on each fetch: session tokens expire
+		// after 24 hours, and the first configuration of a session is
+		// always returned in full.
+		ids := strings.Split(r.appConfig, "/")
+		client := appconfigdata.NewFromConfig(cfg)
+		session, err := client.StartConfigurationSession(ctx, &appconfigdata.StartConfigurationSessionInput{
+			ApplicationIdentifier:          aws.String(ids[0]),
+			EnvironmentIdentifier:          aws.String(ids[1]),
+			ConfigurationProfileIdentifier: aws.String(ids[2]),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error starting AppConfig session for %s: %w", r.appConfig, err)
+		}
+		out, err := client.GetLatestConfiguration(ctx, &appconfigdata.GetLatestConfigurationInput{
+			ConfigurationToken: session.InitialConfigurationToken,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error getting AppConfig configuration %s: %w", r.appConfig, err)
+		}
+		data = out.Configuration
+	}
+	return decodeConfig(bytes.NewReader(data), r.String())
+}
+
+// loadPipelineConfig loads the config from remote if set, or else from the
+// file at path, and checks that it defines a valid pipeline.
+func loadPipelineConfig(ctx context.Context, cfg aws.Config, path string, remote *remoteConfig) (*fileConfig, error) {
+	var c *fileConfig
+	var err error
+	source := path
+	if remote.set() {
+		c, err = remote.fetch(ctx, cfg)
+		source = remote.String()
+	} else {
+		c, err = loadConfigFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.Pipeline == nil {
+		return nil, fmt.Errorf("no pipeline defined in %s", source)
+	}
+	if err := c.Pipeline.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// coverage is the backup coverage of an instance in the report.
+type coverage struct {
+	InstanceId string
+	Name       string
+	// LatestImageId is the newest available image created from the instance,
+	// empty if there is none.
+	LatestImageId      string     `json:",omitempty"`
+	LatestImageCreated *time.Time `json:",omitempty"`
+	Age                string     `json:",omitempty"`
+	Covered            bool
+	// MonthlyCost is the estimated monthly cost in USD of storing the
+	// snapshots of every image created from the instance, with -show-cost.
+	MonthlyCost *float64 `json:",omitempty"`
+}
+
+func runReport(args []string) {
+	var query, maxAgeFlag string
+	var instanceFilters filters
+	var tagFilters filterTags
+	var gapsOnly, showCost bool
+	var snapshotPrice float64
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.Var(&instanceFilters, "filter", "only report the instances matching this DescribeInstances filter, repeatable(eg. tag:Backup=daily)")
+	fs.Var(&tagFilters, "tag", "only report the instances with these tags; * in a value matches any characters(eg. env=prod)")
+	fs.StringVar(&maxAgeFlag, "max-age", "7d", "an instance is covered if an image created from it is at most this old(eg. 24h or 7d)")
+	scope := addImageScopeFlags(fs, "count")
+	fs.BoolVar(&gapsOnly, "gaps-only", false, "only report the instances that are not covered")
+	fs.BoolVar(&showCost, "show-cost", false, "estimate the monthly cost of storing the snapshots of the images of each instance as MonthlyCost, and log the total")
+	fs.Float64Var(&snapshotPrice, "snapshot-price", 0, "with -show-cost, USD per GiB-month of standard snapshot storage(eg. 0.05; default: the list price of the region)")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. [?!Covered].InstanceId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	maxAge, err := parseDuration(maxAgeFlag)
+	if err != nil {
+		fatal(err)
+	}
+	if maxAge <= 0 {
+		fatal("maximum age must be positive")
+	}
+	if snapshotPrice < 0 {
+		fatal("snapshot price must not be negative")
+	}
+	var cost *costEstimator
+	if showCost {
+		cost = &costEstimator{price: snapshotPrice}
+	}
+
+	ctx := context.Background()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	client := ec2.NewFromConfig(cfg)
+
+	f := append(filters{}, instanceFilters...)
+	for _, t := range tagFilters {
+		f = append(f, types.Filter{Name: aws.String("tag:" + aws.ToString(t.Key)), Values: []string{aws.ToString(t.Value)}})
+	}
+	report, err := coverageReport(ctx, client, scope, f, maxAge, time.Now(), cost)
+	if err != nil {
+		fatal(err)
+	}
+	if cost != nil {
+		var total float64
+		for _, c := range report {
+			total += aws.ToFloat64(c.MonthlyCost)
+		}
+		logf("estimated snapshot storage cost of the images of %d instances: $%.2f per month", len(report), total)
+	}
+
+	gaps := 0
+	shown := []coverage{}
+	for _, c := range report {
+		if !c.Covered {
+			gaps++
+		}
+		if !gapsOnly || !c.Covered {
+			shown = append(shown, c)
+		}
+	}
+	printResult(shown, query)
+	if gaps > 0 {
+		warnf("%d of %d instances have no image newer than %s", gaps, len(report), maxAgeFlag)
+		os.Exit(1)
+	}
+}
+
+// coverageReport returns, for each running instance matching the filters,
+// the newest available image of the scope that was created from it,
+// and whether it is at most maxAge old at now. Uncovered instances come
+// first. With a cost estimator, the instances have the MonthlyCost of all
+// their images.
+func coverageReport(ctx context.Context, client *ec2.Client, scope *imageScope, f filters, maxAge time.Duration, now time.Time, cost *costEstimator) ([]coverage, error) {
+	stateFiltered := false
+	for _, ff := range f {
+		stateFiltered = stateFiltered || aws.ToString(ff.Name) == "instance-state-name"
+	}
+	if !stateFiltered {
+		f = append(f, types.Filter{Name: aws.String("instance-state-name"), Values: []string{"running"}})
+	}
+	var report []coverage
+	p := ec2.NewDescribeInstancesPaginator(client, &ec2.DescribeInstancesInput{Filters: f})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error describing instances: %w", err)
+		}
+		for _, r := range page.Reservations {
+			for _, i := range r.Instances {
+				report = append(report, coverage{InstanceId: *i.InstanceId, Name: tagValue(i.Tags, "Name")})
+			}
+		}
+	}
+	if len(report) == 0 {
+		return report, nil
+	}
+
+	images, err := scope.describe(ctx, client, []types.Filter{{Name: aws.String("state"), Values: []string{string(types.ImageStateAvailable)}}})
+	if err != nil {
+		return nil, err
+	}
+	var tiers map[string]types.StorageTier
+	if cost != nil {
+		if tiers, err = snapshotTiers(ctx, client); err != nil {
+			return nil, err
+		}
+	}
+	latest := map[string]types.Image{}
+	created := map[string]time.Time{}
+	costs := map[string]float64{}
+	for _, image := range images {
+		source := imageSourceInstance(image)
+		if source != "" && cost != nil {
+			costs[source] += cost.monthly(client.Options().Region, image, tiers)
+		}
+		t, err := time.Parse(time.RFC3339, aws.ToString(image.CreationDate))
+		if source == "" || err != nil {
+			continue
+		}
+		if t.After(created[source]) {
+			latest[source], created[source] = image, t
+		}
+	}
+
+	for i := range report {
+		c := &report[i]
+		if cost != nil {
+			c.MonthlyCost = aws.Float64(math.Round(costs[c.InstanceId]*100) / 100)
+		}
+		image, ok := latest[c.InstanceId]
+		if !ok {
+			continue
+		}
+		t := created[c.InstanceId]
+		c.LatestImageId = *image.ImageId
+		c.LatestImageCreated = &t
+		c.Age = humanDuration(now.Sub(t))
+		c.Covered = now.Sub(t) <= maxAge
+	}
+	sort.SliceStable(report, func(i, j int) bool {
+		if report[i].Covered != report[j].Covered {
+			return !report[i].Covered
+		}
+		return report[i].InstanceId < report[j].InstanceId
+	})
+	return report, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// logRequests adds a middleware logging every AWS call made with cfg at the
+// debug level, once its attempts are over, with its request ID so that a run
+// can be matched with CloudTrail and AWS support cases.
+func logRequests(cfg *aws.Config) {
+	cfg.APIOptions = append(cfg.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("amimatiRequestLog", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			if !logger.Enabled(ctx, slog.LevelDebug) {
+				return next.HandleInitialize(ctx, in)
+			}
+			start := time.Now()
+			out, md, err := next.HandleInitialize(ctx, in)
+			attrs := append(logAttrs(), "service", awsmiddleware.GetServiceID(ctx), "operation", awsmiddleware.GetOperationName(ctx), "region", awsmiddleware.GetRegion(ctx), "duration", time.Since(start).Round(time.Millisecond))
+			id, ok := awsmiddleware.GetRequestIDMetadata(md)
+			if !ok {
+				if ids := requestIDs(err); len(ids) > 0 {
+					id, ok = ids[0], true
+				}
+			}
+			if ok {
+				attrs = append(attrs, "requestId", id)
+			}
+			if err != nil {
+				attrs = append(attrs, "error", err)
+			}
+			logger.Debug("aws request", attrs...)
+			return out, md, err
+		}), middleware.After)
+	})
+}
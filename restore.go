@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// restoreOptions describe the instance launched by restore.
+type restoreOptions struct {
+	instanceType    string
+	subnetID        string
+	securityGroups  list
+	keyName         string
+	instanceProfile string
+	name            string
+	tags            tags
+	copyImageTags   bool
+	statusCheck     bool
+	timeout         time.Duration
+}
+
+// restoredInstance is the result of restore.
+type restoredInstance struct {
+	InstanceId       string
+	ImageId          string
+	Name             string `json:",omitempty"`
+	InstanceType     string
+	State            string
+	StatusOk         bool
+	AvailabilityZone string     `json:",omitempty"`
+	SubnetId         string     `json:",omitempty"`
+	PrivateIpAddress string     `json:",omitempty"`
+	PublicIpAddress  string     `json:",omitempty"`
+	LaunchTime       *time.Time `json:",omitempty"`
+	Tags             map[string]string
+}
+
+func runRestore(args []string) {
+	var opt restoreOptions
+	var imageID, query string
+	var verbose bool
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.StringVar(&imageID, "image-id", "", "ID of the image to launch the instance from")
+	fs.StringVar(&opt.instanceType, "instance-type", "", "instance type(eg. m6i.large)")
+	fs.StringVar(&opt.subnetID, "subnet-id", "", "subnet to launch the instance in(default: a default subnet)")
+	fs.Var(&opt.securityGroups, "security-group-ids", "security groups of the instance(eg. sg-0123456789abcdef0; default: the default group)")
+	fs.StringVar(&opt.keyName, "key-name", "", "name of the key pair of the instance")
+	fs.StringVar(&opt.instanceProfile, "instance-profile", "", "name of the IAM instance profile of the instance")
+	fs.StringVar(&opt.name, "name", "", "Name tag of the instance(default: the Name tag of the image, if any)")
+	fs.Var(&opt.tags, "tag", "tags of the instance and its volumes, repeatable(eg. env=prod)")
+	fs.BoolVar(&opt.copyImageTags, "copy-image-tags", false, "also apply the tags of the image to the instance and its volumes")
+	fs.BoolVar(&opt.statusCheck, "status-check", true, "once the instance is running, wait until its status checks pass")
+	fs.BoolVar(&verbose, "v", false, "verbose output")
+	fs.DurationVar(&opt.timeout, "timeout", 30*time.Minute, "give up and exit with status "+strconv.Itoa(exitTimeout)+" if the instance is not ready after this long, leaving it running")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. InstanceId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	if imageID == "" {
+		fatal("image ID is required")
+	}
+	if opt.instanceType == "" {
+		fatal("instance type is required")
+	}
+	if opt.timeout <= 0 {
+		fatal("timeout must be positive")
+	}
+
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, opt.timeout)
+	defer cancel()
+
+	res, err := restoreInstance(ctx, ec2.NewFromConfig(cfg), imageID, opt, verbose)
+	if err != nil {
+		exitWith(exitCode(ctx, err), err)
+	}
+	printResult(res, query)
+}
+
+// restoreInstance launches an instance from the image and waits until it is
+// running and, with statusCheck, until its status checks pass. The instance
+// is left running if the wait fails, so it can be looked into.
+func restoreInstance(ctx context.Context, client *ec2.Client, imageID string, opt restoreOptions, verbose bool) (*restoredInstance, error) {
+	image, err := describeImage(ctx, client, imageID)
+	if err != nil {
+		return nil, err
+	}
+	if image.State != types.ImageStateAvailable {
+		return nil, fmt.Errorf("image %s is %s", imageID, image.State)
+	}
+
+	var t tags
+	if opt.copyImageTags {
+		t = userTags(image.Tags)
+	}
+	name := opt.name
+	if name == "" {
+		name = tagValue(image.Tags, "Name")
+	}
+	if name != "" {
+		t = mergeTags(t, tags{{Key: aws.String("Name"), Value: &name}})
+	}
+	t = mergeTags(t, append(tags{{Key: aws.String(sourceImageTagKey), Value: &imageID}}, runIDTags()...))
+	t = mergeTags(t, opt.tags)
+
+	in := &ec2.RunInstancesInput{
+		ImageId:      &imageID,
+		InstanceType: types.InstanceType(opt.instanceType),
+		MinCount:     aws.Int32(1),
+		MaxCount:     aws.Int32(1),
+		TagSpecifications: []types.TagSpecification{
+			{ResourceType: types.ResourceTypeInstance, Tags: t},
+			{ResourceType: types.ResourceTypeVolume, Tags: t},
+		},
+	}
+	if opt.subnetID != "" {
+		in.SubnetId = &opt.subnetID
+	}
+	if len(opt.securityGroups) > 0 {
+		in.SecurityGroupIds = opt.securityGroups
+	}
+	if opt.keyName != "" {
+		in.KeyName = &opt.keyName
+	}
+	if opt.instanceProfile != "" {
+		in.IamInstanceProfile = &types.IamInstanceProfileSpecification{Name: &opt.instanceProfile}
+	}
+	out, err := client.RunInstances(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("error launching instance from image %s: %w", imageID, err)
+	}
+	id := *out.Instances[0].InstanceId
+	if verbose {
+		logf("launched instance %s from image %s", id, imageID)
+	}
+
+	setPhase("waiting for instance")
+	if err := ec2.NewInstanceRunningWaiter(client).Wait(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{id}}, opt.timeout); err != nil {
+		return nil, fmt.Errorf("error waiting for instance %s to run: %w", id, err)
+	}
+	statusOk := false
+	if opt.statusCheck {
+		setPhase("waiting for status checks")
+		if verbose {
+			logf("waiting for the status checks of instance %s", id)
+		}
+		if err := ec2.NewInstanceStatusOkWaiter(client).Wait(ctx, &ec2.DescribeInstanceStatusInput{InstanceIds: []string{id}}, opt.timeout); err != nil {
+			return nil, fmt.Errorf("error waiting for the status checks of instance %s: %w", id, err)
+		}
+		statusOk = true
+	}
+
+	instance, err := describeInstance(ctx, client, id)
+	if err != nil {
+		return nil, err
+	}
+	res := &restoredInstance{
+		InstanceId:       id,
+		ImageId:          imageID,
+		Name:             tagValue(instance.Tags, "Name"),
+		InstanceType:     string(instance.InstanceType),
+		StatusOk:         statusOk,
+		SubnetId:         aws.ToString(instance.SubnetId),
+		PrivateIpAddress: aws.ToString(instance.PrivateIpAddress),
+		PublicIpAddress:  aws.ToString(instance.PublicIpAddress),
+		LaunchTime:       instance.LaunchTime,
+		Tags:             map[string]string{},
+	}
+	if instance.State != nil {
+		res.State = string(instance.State.Name)
+	}
+	if instance.Placement != nil {
+		res.AvailabilityZone = aws.ToString(instance.Placement.AvailabilityZone)
+	}
+	for _, tag := range instance.Tags {
+		res.Tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return res, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// defaultStateFile is where pipeline -resume keeps the state of the run.
+const defaultStateFile = ".amimati-state.json"
+
+// stateFile keeps the state of a pipeline run, rewritten atomically as it
+// progresses: the image being created as soon as its ID is known, and each
+// stage once it has succeeded. A run resumed from it skips the stages that
+// succeeded and waits for the image instead of creating another one. A nil
+// stateFile keeps nothing.
+type stateFile struct {
+	path string
+
+	mu     sync.Mutex
+	doc    runStateDoc
+	failed bool
+}
+
+type runStateDoc struct {
+	RunId string
+	// StageTypes are the types of the stages of the pipeline, checked on
+	// resuming.
+	StageTypes []string
+	// ImageId is the image being created, and Image the result of the create
+	// stage once it has succeeded.
+	ImageId string  `json:",omitempty"`
+	Image   *result `json:",omitempty"`
+	// Images are the image and its copies by region.
+	Images map[string]string `json:",omitempty"`
+	// Stages are the reports of the stages that succeeded, by index.
+	Stages map[int]stageReport `json:",omitempty"`
+}
+
+var runState *stateFile
+
+// stage returns the report of the stage at index i if it succeeded in the
+// resumed run.
+func (d *runStateDoc) stage(i int) (stageReport, bool) {
+	if d == nil {
+		return stageReport{}, false
+	}
+	sr, ok := d.Stages[i]
+	return sr, ok
+}
+
+// loadStateFile reads the state file at path, if it exists.
+func loadStateFile(path string) (*runStateDoc, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file: %w", err)
+	}
+	var doc runStateDoc
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing state file %s: %w", path, err)
+	}
+	return &doc, nil
+}
+
+// newStateFile starts the state file at path for the stages of the pipeline,
+// carrying over the state of the resumed run, if any.
+func newStateFile(path string, stages []pipelineStage, resumed *runStateDoc) (*stateFile, error) {
+	s := &stateFile{path: path}
+	if resumed != nil {
+		s.doc = *resumed
+	}
+	types := make([]string, 0, len(stages))
+	for _, st := range stages {
+		types = append(types, st.Type)
+	}
+	if resumed != nil && fmt.Sprint(resumed.StageTypes) != fmt.Sprint(types) {
+		return nil, fmt.Errorf("state file %s is of a pipeline with the stages %v, not %v: remove it to start over", path, resumed.StageTypes, types)
+	}
+	s.doc.RunId = runID
+	s.doc.StageTypes = types
+	if s.doc.Stages == nil {
+		s.doc.Stages = map[int]stageReport{}
+	}
+	return s, nil
+}
+
+// image records the ID of the image being created.
+func (s *stateFile) image(imageID string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.doc.ImageId = imageID
+	s.write()
+}
+
+// stage records the stage at index i as succeeded, along with the image and
+// its copies so far.
+func (s *stateFile) stage(i int, sr stageReport, st *pipelineState) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.doc.Stages[i] = sr
+	s.doc.Image = st.image
+	s.doc.Images = st.images
+	s.write()
+}
+
+// remove deletes the state file once the run has succeeded, so that the next
+// run starts over.
+func (s *stateFile) remove() {
+	if s == nil {
+		return
+	}
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		warnf("error removing state file: %v", err)
+	}
+}
+
+// write replaces the state file with the current state; s.mu must be held.
+// Failures are warned about once and do not stop the run.
+func (s *stateFile) write() {
+	if err := writeFileAtomic(s.path, s.doc); err != nil && !s.failed {
+		warnf("error writing state file: %v", err)
+		s.failed = true
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+// defaultMaxRetries is how many times a throttled or transiently failed
+// request is retried by default. Accounts with heavy EC2 API usage throttle
+// for longer than the three attempts of the SDK.
+const defaultMaxRetries = 10
+
+// maxRetryBackoff caps the exponential backoff between two attempts.
+const maxRetryBackoff = 30 * time.Second
+
+// newRetryer returns a retryer retrying throttling and transient errors up to
+// maxRetries times, with exponential backoff and full jitter. Other errors,
+// such as a missing instance or a denied call, fail on the first attempt.
+// Unlike the SDK default, retries are not limited by a client-side quota,
+// which a long run against a throttled account would exhaust. The adaptive
+// mode also delays requests once throttled, as the SDK does.
+func newRetryer(maxRetries int, mode aws.RetryMode) aws.Retryer {
+	standard := func(o *retry.StandardOptions) {
+		o.MaxAttempts = maxRetries + 1
+		o.MaxBackoff = maxRetryBackoff
+		o.RateLimiter = ratelimit.None
+	}
+	if mode == aws.RetryModeAdaptive {
+		return &loggingRetryer{Retryer: retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+			o.StandardOptions = append(o.StandardOptions, standard)
+		})}
+	}
+	return &loggingRetryer{Retryer: retry.NewStandard(standard)}
+}
+
+// loggingRetryer logs each retry at the debug level, with the request ID of
+// the failed attempt.
+type loggingRetryer struct {
+	aws.Retryer
+}
+
+func (r *loggingRetryer) RetryDelay(attempt int, err error) (time.Duration, error) {
+	d, derr := r.Retryer.RetryDelay(attempt, err)
+	if derr == nil {
+		attrs := append(logAttrs(), "attempt", attempt, "delay", d.Round(time.Millisecond), "error", err)
+		if ids := requestIDs(err); len(ids) > 0 {
+			attrs = append(attrs, "requestId", ids[0])
+		}
+		logger.Debug("retrying request", attrs...)
+	}
+	return d, derr
+}
+
+// GetAttemptToken implements aws.RetryerV2 for the adaptive mode, which
+// delays the attempts once throttled.
+func (r *loggingRetryer) GetAttemptToken(ctx context.Context) (func(error) error, error) {
+	if v2, ok := r.Retryer.(aws.RetryerV2); ok {
+		return v2.GetAttemptToken(ctx)
+	}
+	return r.Retryer.GetInitialToken(), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func TestRetrySettings(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		opt        awsOptions
+		cfg        aws.Config
+		maxRetries int
+		mode       aws.RetryMode
+	}{
+		{"defaults", awsOptions{maxRetries: defaultMaxRetries}, aws.Config{}, defaultMaxRetries, aws.RetryModeStandard},
+		{"environment", awsOptions{maxRetries: defaultMaxRetries}, aws.Config{RetryMaxAttempts: 4, RetryMode: aws.RetryModeAdaptive}, 3, aws.RetryModeAdaptive},
+		{"flags", awsOptions{maxRetries: 0, maxRetriesSet: true, retryMode: "standard"}, aws.Config{RetryMaxAttempts: 4, RetryMode: aws.RetryModeAdaptive}, 0, aws.RetryModeStandard},
+		{"adaptive flag", awsOptions{maxRetries: defaultMaxRetries, retryMode: "adaptive"}, aws.Config{}, defaultMaxRetries, aws.RetryModeAdaptive},
+	} {
+		maxRetries, mode := tt.opt.retrySettings(tt.cfg)
+		if maxRetries != tt.maxRetries || mode != tt.mode {
+			t.Errorf("%s: got %d retries in mode %s, want %d in mode %s", tt.name, maxRetries, mode, tt.maxRetries, tt.mode)
+		}
+	}
+}
+
+func TestNewRetryer(t *testing.T) {
+	for _, mode := range []aws.RetryMode{aws.RetryModeStandard, aws.RetryModeAdaptive} {
+		r := newRetryer(4, mode)
+		if got := r.MaxAttempts(); got != 5 {
+			t.Errorf("%s: got %d attempts, want 5", mode, got)
+		}
+		if _, ok := r.(aws.RetryerV2); !ok {
+			t.Errorf("%s: retryer does not implement aws.RetryerV2", mode)
+		}
+	}
+	if _, ok := newRetryer(1, aws.RetryModeAdaptive).(*loggingRetryer).Retryer.(*retry.AdaptiveMode); !ok {
+		t.Error("adaptive mode is not backed by the adaptive retryer of the SDK")
+	}
+}
+
+func TestRetriesFlag(t *testing.T) {
+	var n int
+	var set bool
+	f := &retriesFlag{&n, &set}
+	if err := f.Set("3"); err != nil || n != 3 || !set {
+		t.Errorf("Set(3) = %v, got %d, set %v", err, n, set)
+	}
+	if err := f.Set("x"); err == nil {
+		t.Error("Set(x) did not fail")
+	}
+}
+
+func TestRequestIDs(t *testing.T) {
+	respErr := func(id string) error {
+		return &awshttp.ResponseError{RequestID: id, ResponseError: &smithyhttp.ResponseError{Response: &smithyhttp.Response{Response: &http.Response{StatusCode: 400}}, Err: errors.New("denied")}}
+	}
+	err := errors.Join(fmt.Errorf("error creating image: %w", respErr("req-1")), respErr("req-2"), respErr("req-1"))
+	got := requestIDs(err)
+	if len(got) != 2 || got[0] != "req-1" || got[1] != "req-2" {
+		t.Errorf("requestIDs = %v, want [req-1 req-2]", got)
+	}
+	if got := requestIDs(errors.New("plain")); got != nil {
+		t.Errorf("requestIDs of a plain error = %v", got)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+type rollbackResult struct {
+	LaunchTemplateId  string
+	FromVersion       int64
+	FromImageId       string
+	ToImageId         string
+	NewVersion        int64
+	InstanceRefreshId string `json:",omitempty"`
+}
+
+func runRollback(args []string) {
+	var lt, refreshASG, query string
+	var useLatest bool
+	var minHealthy int
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.StringVar(&lt, "launch-template", "", "launch template ID or name")
+	fs.BoolVar(&useLatest, "latest", false, "roll back the latest version instead of the default one")
+	fs.StringVar(&refreshASG, "refresh-asg", "", "start an instance refresh of this auto scaling group after the rollback")
+	fs.IntVar(&minHealthy, "min-healthy", 0, "minimum healthy percentage during the instance refresh")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. ToImageId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	if lt == "" {
+		fatal("launch template is required")
+	}
+
+	ctx := context.Background()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	client := ec2.NewFromConfig(cfg)
+
+	versions, err := launchTemplateVersions(ctx, client, launchTemplateRef(lt))
+	if err != nil {
+		fatal(err)
+	}
+	current, previous, err := rollbackVersions(versions, useLatest)
+	if err != nil {
+		fatal(err)
+	}
+
+	from, to := launchTemplateImage(current), launchTemplateImage(previous)
+	v, err := createLaunchTemplateVersion(ctx, client, launchTemplateRef(lt), *current.VersionNumber, to,
+		fmt.Sprintf("amimati rollback from %s to %s", from, to), aws.ToBool(current.DefaultVersion))
+	if err != nil {
+		fatal(err)
+	}
+	res := rollbackResult{
+		LaunchTemplateId: aws.ToString(v.LaunchTemplateId),
+		FromVersion:      *current.VersionNumber,
+		FromImageId:      from,
+		ToImageId:        to,
+		NewVersion:       *v.VersionNumber,
+	}
+
+	if refreshASG != "" {
+		id, err := startInstanceRefresh(ctx, autoscaling.NewFromConfig(cfg), refreshASG, int32(minHealthy))
+		if err != nil {
+			fatal(err)
+		}
+		res.InstanceRefreshId = id
+	}
+	printResult(res, query)
+}
+
+// rollbackVersions returns the default (or latest) version of the launch
+// template, and the most recent older version using a different image.
+func rollbackVersions(versions []types.LaunchTemplateVersion, useLatest bool) (current, previous types.LaunchTemplateVersion, err error) {
+	if len(versions) == 0 {
+		return current, previous, fmt.Errorf("launch template has no versions")
+	}
+	i := 0
+	if !useLatest {
+		i = -1
+		for j, v := range versions {
+			if aws.ToBool(v.DefaultVersion) {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return current, previous, fmt.Errorf("launch template has no default version")
+		}
+	}
+
+	current = versions[i]
+	image := launchTemplateImage(current)
+	for _, v := range versions[i+1:] {
+		if p := launchTemplateImage(v); p != "" && p != image {
+			return current, v, nil
+		}
+	}
+	return current, previous, fmt.Errorf("no version older than %d uses an image other than %s", *current.VersionNumber, image)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Placeholders the operator fills in when restoring, since the network of
+// the recovery site is not known at bake time.
+const (
+	runbookSubnetPlaceholder        = "<SUBNET_ID>"
+	runbookSecurityGroupPlaceholder = "<SECURITY_GROUP_IDS>"
+)
+
+type runbook struct {
+	ImageName        string
+	SourceInstanceId string
+	InstanceType     string
+	GeneratedAt      time.Time
+	Regions          []runbookRegion
+}
+
+type runbookRegion struct {
+	Region     string
+	ImageId    string
+	ConsoleUrl string `json:",omitempty"`
+	Command    string
+}
+
+func newRunbook(r *result, images map[string]string) runbook {
+	rb := runbook{ImageName: aws.ToString(r.Name), GeneratedAt: time.Now().UTC()}
+	if r.SourceInstance != nil {
+		rb.SourceInstanceId = r.SourceInstance.InstanceId
+		rb.InstanceType = r.SourceInstance.InstanceType
+	}
+
+	regions := make([]string, 0, len(images))
+	for region := range images {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		args := []string{
+			"aws ec2 run-instances",
+			"--region " + region,
+			"--image-id " + images[region],
+		}
+		if rb.InstanceType != "" {
+			args = append(args, "--instance-type "+rb.InstanceType)
+		}
+		args = append(args,
+			"--subnet-id "+runbookSubnetPlaceholder,
+			"--security-group-ids "+runbookSecurityGroupPlaceholder,
+			fmt.Sprintf("--tag-specifications 'ResourceType=instance,Tags=[{Key=Name,Value=%s-restore}]'", rb.ImageName),
+		)
+		rb.Regions = append(rb.Regions, runbookRegion{Region: region, ImageId: images[region], ConsoleUrl: imageConsoleURL(region, images[region]), Command: strings.Join(args, " \\\n  ")})
+	}
+	return rb
+}
+
+var runbookTemplate = template.Must(template.New("runbook").Funcs(template.FuncMap{"formatTime": formatTime}).Parse(`# Restore runbook: {{.ImageName}}
+
+Generated {{formatTime .GeneratedAt}} by amimati{{if .SourceInstanceId}} from {{.SourceInstanceId}}{{end}}.
+
+Replace ` + "`" + runbookSubnetPlaceholder + "`" + ` and ` + "`" + runbookSecurityGroupPlaceholder + "`" + ` with the subnet and security groups of the recovery site before running a command.
+{{range .Regions}}
+## {{.Region}}
+
+Image: ` + "`{{.ImageId}}`" + `{{if .ConsoleUrl}} ([console]({{.ConsoleUrl}})){{end}}
+
+` + "```sh" + `
+{{.Command}}
+` + "```" + `
+{{end}}`))
+
+// writeRunbook writes the restore runbook as JSON when path ends in .json
+// and as Markdown otherwise.
+func writeRunbook(path string, rb runbook) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating runbook: %w", err)
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rb)
+	} else {
+		err = runbookTemplate.Execute(f, rb)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing runbook: %w", err)
+	}
+	return f.Close()
+}
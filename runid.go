@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// runIDTagKey records the run that created a resource.
+const runIDTagKey = "amimati:run-id"
+
+// runID correlates the log lines, resources and results of a run. It is empty
+// for commands that do not create resources.
+var runID string
+
+// newRunID returns a run ID made of the start time and a random suffix, such
+// as 20240501T100000Z-1a2b3c4d.
+func newRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+// setRunID sets the run ID of the process to id, or to a new one if id is
+// empty.
+func setRunID(id string) {
+	if id == "" {
+		id = newRunID()
+	}
+	runID = id
+}
+
+// runIDTags returns the tag recording the run ID, if any.
+func runIDTags() []types.Tag {
+	if runID == "" {
+		return nil
+	}
+	return []types.Tag{{Key: aws.String(runIDTagKey), Value: aws.String(runID)}}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+
+	"github.com/otama-jaccy/amimati/pkg/amimati"
+)
+
+// runSummary is the -summary-file of a run: how long each of its phases and
+// snapshots took, the AWS calls it made and the resources it created, kept to
+// follow the performance of backups over time.
+type runSummary struct {
+	RunId     string `json:",omitempty"`
+	Command   string
+	Succeeded bool
+	Error     string `json:",omitempty"`
+	Region    string
+	Started   time.Time
+	Finished  time.Time
+	// Duration is the wall time of the run in seconds.
+	Duration  float64
+	Phases    []phaseSummary
+	Snapshots []snapshotSummary `json:",omitempty"`
+	// ApiCalls are the AWS calls of the run by operation, each counted once
+	// however many attempts it took.
+	ApiCalls      []apiCallSummary
+	TotalApiCalls int
+	ImageId       string `json:",omitempty"`
+	// Images are the image and its copies by region.
+	Images      map[string]string `json:",omitempty"`
+	SnapshotIds []string          `json:",omitempty"`
+}
+
+type phaseSummary struct {
+	Name    string
+	Started time.Time
+	// Duration is in seconds, until the next phase or the end of the run.
+	Duration float64
+}
+
+type snapshotSummary struct {
+	DeviceName string
+	SnapshotId string
+	SizeGiB    int32
+	// Duration is in seconds, unset when the snapshot did not complete.
+	Duration float64 `json:",omitempty"`
+}
+
+type apiCallSummary struct {
+	Service   string
+	Operation string
+	Calls     int
+	Errors    int `json:",omitempty"`
+	// Duration is the time spent in the calls in seconds, retries included.
+	Duration float64
+}
+
+// terminalPhases end the run rather than start a phase of it.
+var terminalPhases = map[string]bool{"done": true, "failed": true, "rejected": true}
+
+// summaryRecorder records the phases and AWS calls of the run and writes the
+// summary file once it finishes. A nil summaryRecorder records nothing.
+type summaryRecorder struct {
+	path    string
+	command string
+	region  string
+
+	mu      sync.Mutex
+	phases  []phaseSummary
+	calls   map[[2]string]*apiCallSummary
+	written bool
+}
+
+var runRecorder *summaryRecorder
+
+func newSummaryRecorder(path, command string) *summaryRecorder {
+	return &summaryRecorder{path: path, command: command, calls: map[[2]string]*apiCallSummary{}}
+}
+
+// phase records the start of the phase.
+func (s *summaryRecorder) phase(name string) {
+	if s == nil || terminalPhases[name] {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phases = append(s.phases, phaseSummary{Name: name, Started: time.Now()})
+}
+
+// countRequests adds a middleware counting the AWS calls made with cfg.
+func (s *summaryRecorder) countRequests(cfg *aws.Config) {
+	if s == nil {
+		return
+	}
+	s.region = cfg.Region
+	cfg.APIOptions = append(cfg.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("amimatiRunSummary", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			start := time.Now()
+			out, md, err := next.HandleInitialize(ctx, in)
+			s.call(awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx), time.Since(start), err)
+			return out, md, err
+		}), middleware.After)
+	})
+}
+
+func (s *summaryRecorder) call(service, operation string, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.calls[[2]string{service, operation}]
+	if !ok {
+		c = &apiCallSummary{Service: service, Operation: operation}
+		s.calls[[2]string{service, operation}] = c
+	}
+	c.Calls++
+	if err != nil {
+		c.Errors++
+	}
+	c.Duration += d.Seconds()
+}
+
+// fail writes the summary of the run that failed with err.
+func (s *summaryRecorder) fail(err error) {
+	s.finish(nil, nil, err)
+}
+
+// finish writes the summary of the run that created the image of res and
+// its images by region, failed if err is not nil. Only the first call of a
+// run writes; a summary that cannot be written is warned about.
+func (s *summaryRecorder) finish(res *result, images map[string]string, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.written {
+		s.mu.Unlock()
+		return
+	}
+	s.written = true
+	sum := s.summary(time.Now())
+	s.mu.Unlock()
+
+	sum.Succeeded = err == nil
+	if err != nil {
+		sum.Error = err.Error()
+	}
+	if res != nil {
+		sum.ImageId = aws.ToString(res.ImageId)
+		sum.Images = images
+		sum.SnapshotIds, _ = amimati.MappedSnapshots(res.Image)
+		for _, t := range res.timings {
+			sum.Snapshots = append(sum.Snapshots, snapshotSummary{DeviceName: t.deviceName, SnapshotId: t.snapshotID, SizeGiB: t.size, Duration: t.duration.Seconds()})
+		}
+	}
+	if err := writeFileAtomic(s.path, sum); err != nil {
+		warnf("error writing summary file: %v", err)
+	}
+}
+
+// summary returns the summary of the phases and calls recorded by finished.
+func (s *summaryRecorder) summary(finished time.Time) runSummary {
+	sum := runSummary{
+		RunId:    runID,
+		Command:  s.command,
+		Region:   s.region,
+		Started:  runStarted,
+		Finished: finished,
+		Duration: finished.Sub(runStarted).Seconds(),
+		Phases:   []phaseSummary{},
+		ApiCalls: []apiCallSummary{},
+	}
+	for i, p := range s.phases {
+		end := finished
+		if i+1 < len(s.phases) {
+			end = s.phases[i+1].Started
+		}
+		p.Duration = end.Sub(p.Started).Seconds()
+		sum.Phases = append(sum.Phases, p)
+	}
+	for _, c := range s.calls {
+		sum.ApiCalls = append(sum.ApiCalls, *c)
+		sum.TotalApiCalls += c.Calls
+	}
+	sort.Slice(sum.ApiCalls, func(i, j int) bool {
+		a, b := sum.ApiCalls[i], sum.ApiCalls[j]
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.Operation < b.Operation
+	})
+	return sum
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestSummaryPhases(t *testing.T) {
+	s := newSummaryRecorder("", "create")
+	start := time.Now()
+	s.phases = []phaseSummary{{Name: "describing instance", Started: start}, {Name: "waiting for snapshots", Started: start.Add(2 * time.Second)}}
+	s.phase("done")
+	sum := s.summary(start.Add(10 * time.Second))
+	if len(sum.Phases) != 2 || sum.Phases[0].Duration != 2 || sum.Phases[1].Duration != 8 {
+		t.Errorf("got phases %+v, want 2s then 8s", sum.Phases)
+	}
+}
+
+func TestSummaryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.json")
+	s := newSummaryRecorder(path, "create")
+	s.phase("describing instance")
+	s.call("EC2", "DescribeSnapshots", time.Second, nil)
+	s.call("EC2", "DescribeSnapshots", time.Second, errors.New("throttled"))
+	s.call("EC2", "CreateImage", time.Second, nil)
+	res := &result{
+		Image:   types.Image{ImageId: aws.String("ami-1"), BlockDeviceMappings: []types.BlockDeviceMapping{{DeviceName: aws.String("/dev/xvda"), Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-1")}}}},
+		timings: []deviceTiming{{deviceName: "/dev/xvda", snapshotID: "snap-1", size: 8, duration: time.Minute}},
+	}
+	s.finish(res, map[string]string{"us-east-1": "ami-1", "eu-west-1": "ami-2"}, nil)
+	s.fail(errors.New("late"))
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got runSummary
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Succeeded || got.ImageId != "ami-1" || got.Images["eu-west-1"] != "ami-2" || len(got.SnapshotIds) != 1 {
+		t.Errorf("got summary %s", b)
+	}
+	if got.TotalApiCalls != 3 || len(got.ApiCalls) != 2 || got.ApiCalls[1].Operation != "DescribeSnapshots" || got.ApiCalls[1].Errors != 1 {
+		t.Errorf("got calls %+v", got.ApiCalls)
+	}
+	if len(got.Snapshots) != 1 || got.Snapshots[0].Duration != 60 {
+		t.Errorf("got snapshots %+v", got.Snapshots)
+	}
+}
+
+func TestSummaryCountRequests(t *testing.T) {
+	var calls atomic.Int32
+	srv := snapshotServer(t, &calls)
+	cfg := aws.Config{Region: "us-east-1", BaseEndpoint: aws.String(srv.URL), Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", "")}
+	s := newSummaryRecorder("", "create")
+	s.countRequests(&cfg)
+	if _, err := ec2.NewFromConfig(cfg).DescribeSnapshots(testContext(t), &ec2.DescribeSnapshotsInput{SnapshotIds: []string{"snap-1"}}); err != nil {
+		t.Fatal(err)
+	}
+	sum := s.summary(time.Now())
+	if sum.TotalApiCalls != 1 || sum.ApiCalls[0].Service != "EC2" || sum.ApiCalls[0].Operation != "DescribeSnapshots" || sum.Region != "us-east-1" {
+		t.Errorf("got %+v", sum)
+	}
+}
+
+func TestSummaryRecorderNil(t *testing.T) {
+	var s *summaryRecorder
+	s.phase("describing instance")
+	cfg := aws.Config{}
+	s.countRequests(&cfg)
+	s.finish(nil, nil, nil)
+	if len(cfg.APIOptions) != 0 {
+		t.Error("a nil recorder added a middleware")
+	}
+}
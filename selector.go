@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// filters is a repeatable flag of DescribeInstances filters, as in
+// -filter tag:Role=db -filter vpc-id=vpc-123,vpc-456.
+type filters []types.Filter
+
+func (f *filters) String() string {
+	s := make([]string, 0, len(*f))
+	for _, ff := range *f {
+		s = append(s, aws.ToString(ff.Name)+"="+strings.Join(ff.Values, ","))
+	}
+	return strings.Join(s, " ")
+}
+
+func (f *filters) Set(value string) error {
+	name, values, ok := strings.Cut(value, "=")
+	if !ok || name == "" || values == "" {
+		return fmt.Errorf("invalid filter: %s", value)
+	}
+	*f = append(*f, types.Filter{Name: aws.String(name), Values: strings.Split(values, ",")})
+	return nil
+}
+
+// instanceSelector selects the instance to image by its Name tag or by
+// filters, in place of its ID.
+type instanceSelector struct {
+	name    string
+	filters filters
+}
+
+func (s instanceSelector) empty() bool {
+	return s.name == "" && len(s.filters) == 0
+}
+
+// resolve returns the ID of the only instance matching the selector.
+func (s instanceSelector) resolve(ctx context.Context, client *ec2.Client) (string, error) {
+	ids, err := s.resolveAll(ctx, client)
+	if err != nil {
+		return "", err
+	}
+	if len(ids) > 1 {
+		return "", fmt.Errorf("%d instances match %s: %s", len(ids), s, strings.Join(ids, ", "))
+	}
+	return ids[0], nil
+}
+
+// resolveAll returns the IDs of the instances matching the selector, failing
+// if there are none. Terminated instances are ignored unless a filter on the
+// instance state is given.
+func (s instanceSelector) resolveAll(ctx context.Context, client *ec2.Client) ([]string, error) {
+	f := append(filters{}, s.filters...)
+	if s.name != "" {
+		f = append(f, types.Filter{Name: aws.String("tag:Name"), Values: []string{s.name}})
+	}
+	stateFiltered := false
+	for _, ff := range f {
+		stateFiltered = stateFiltered || aws.ToString(ff.Name) == "instance-state-name"
+	}
+	if !stateFiltered {
+		f = append(f, types.Filter{Name: aws.String("instance-state-name"), Values: []string{"pending", "running", "stopping", "stopped"}})
+	}
+
+	var ids []string
+	p := ec2.NewDescribeInstancesPaginator(client, &ec2.DescribeInstancesInput{Filters: f})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error describing instances: %w", err)
+		}
+		for _, r := range page.Reservations {
+			for _, i := range r.Instances {
+				ids = append(ids, *i.InstanceId)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no instance matches %s", s)
+	}
+	return ids, nil
+}
+
+// String describes the selector in messages.
+func (s instanceSelector) String() string {
+	var parts []string
+	if s.name != "" {
+		parts = append(parts, "name "+s.name)
+	}
+	if len(s.filters) > 0 {
+		parts = append(parts, "filters "+s.filters.String())
+	}
+	return strings.Join(parts, " and ")
+}
+
+// filterMap converts filters from a config file to filters, ordered by name.
+func filterMap(m map[string][]string) filters {
+	names := make([]string, 0, len(m))
+	for n := range m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	f := make(filters, 0, len(m))
+	for _, n := range names {
+		f = append(f, types.Filter{Name: aws.String(n), Values: m[n]})
+	}
+	return f
+}
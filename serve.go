@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"google.golang.org/grpc"
+
+	amimativ1 "github.com/otama-jaccy/amimati/api/amimati/v1"
+)
+
+// inventoryImage is an image of the dashboard along with its regional copies.
+type inventoryImage struct {
+	ImageId      string
+	Name         string
+	Region       string
+	State        string
+	CreationDate time.Time
+	Age          string
+	SizeGiB      int32
+	// Copies holds the IDs of the copies of the image by region.
+	Copies    map[string]string `json:",omitempty"`
+	ExpireAt  *time.Time        `json:",omitempty"`
+	ExpiresIn string            `json:",omitempty"`
+}
+
+type dashboard struct {
+	cfg        aws.Config
+	regions    []string
+	namePrefix string
+}
+
+func runServe(args []string) {
+	var addr, grpcAddr, namePrefix string
+	var regions []string
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	timeOpt := addTimeFlags(fs)
+	fs.StringVar(&addr, "addr", "localhost:8080", "address to listen on")
+	fs.StringVar(&grpcAddr, "grpc-addr", "", "also serve the gRPC job API on this address(eg. localhost:9090)")
+	fs.Var((*list)(&regions), "regions", "other regions to look for copies in(eg. us-west-2,eu-west-1)")
+	fs.StringVar(&namePrefix, "name-prefix", "", "only list images whose name starts with this prefix")
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := timeOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	ctx := context.Background()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+
+	if grpcAddr != "" {
+		lis, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			fatal(err)
+		}
+		srv := grpc.NewServer()
+		amimativ1.RegisterJobServiceServer(srv, newJobServer(cfg))
+		logger.Info("serving the job API", "addr", grpcAddr)
+		go func() {
+			if err := srv.Serve(lis); err != nil {
+				fatal(err)
+			}
+		}()
+	}
+
+	d := &dashboard{cfg: cfg, regions: regions, namePrefix: namePrefix}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.serveHTML)
+	mux.HandleFunc("/images.json", d.serveJSON)
+	logger.Info("serving the dashboard", "url", "http://"+addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fatal(err)
+	}
+}
+
+func (d *dashboard) serveJSON(w http.ResponseWriter, r *http.Request) {
+	images, err := d.images(r.Context(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(images)
+}
+
+func (d *dashboard) serveHTML(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	now := time.Now()
+	images, err := d.images(r.Context(), now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	var expiring []inventoryImage
+	for _, image := range images {
+		if image.ExpireAt != nil {
+			expiring = append(expiring, image)
+		}
+	}
+	sort.Slice(expiring, func(i, j int) bool { return expiring[i].ExpireAt.Before(*expiring[j].ExpireAt) })
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, map[string]any{
+		"Region":   d.cfg.Region,
+		"Regions":  d.regions,
+		"Images":   images,
+		"Expiring": expiring,
+		"Updated":  formatTime(now),
+	}); err != nil {
+		warnf("error rendering dashboard: %v", err)
+	}
+}
+
+// images lists the images owned by the caller in the home region, newest
+// first, along with their copies in the other regions.
+func (d *dashboard) images(ctx context.Context, now time.Time) ([]inventoryImage, error) {
+	home, err := ownImages(ctx, ec2.NewFromConfig(d.cfg), d.namePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", d.cfg.Region, err)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := regionErrors{}
+	copies := map[string]map[string]string{}
+	for _, region := range d.regions {
+		wg.Add(1)
+		go func(region string) {
+			defer wg.Done()
+			images, err := ownImages(ctx, regionalClient(d.cfg, region), d.namePrefix)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[region] = err
+				return
+			}
+			for _, image := range images {
+				for _, t := range image.Tags {
+					if aws.ToString(t.Key) == sourceImageTagKey {
+						if copies[*t.Value] == nil {
+							copies[*t.Value] = map[string]string{}
+						}
+						copies[*t.Value][region] = *image.ImageId
+					}
+				}
+			}
+		}(region)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	var result []inventoryImage
+	for _, image := range home {
+		result = append(result, newInventoryImage(image, d.cfg.Region, copies[*image.ImageId], now))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreationDate.After(result[j].CreationDate) })
+	return result, nil
+}
+
+func ownImages(ctx context.Context, client *ec2.Client, namePrefix string) ([]types.Image, error) {
+	var filters []types.Filter
+	if namePrefix != "" {
+		filters = []types.Filter{{Name: aws.String("name"), Values: []string{namePrefix + "*"}}}
+	}
+	return describeOwnImages(ctx, client, filters)
+}
+
+// describeOwnImages pages through the images owned by the caller that match
+// the filters.
+func describeOwnImages(ctx context.Context, client *ec2.Client, filters []types.Filter) ([]types.Image, error) {
+	in := &ec2.DescribeImagesInput{Owners: []string{"self"}, Filters: filters}
+	var images []types.Image
+	p := ec2.NewDescribeImagesPaginator(client, in)
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error describing images: %w", err)
+		}
+		images = append(images, out.Images...)
+	}
+	return images, nil
+}
+
+func newInventoryImage(image types.Image, region string, copies map[string]string, now time.Time) inventoryImage {
+	i := inventoryImage{
+		ImageId: *image.ImageId,
+		Name:    aws.ToString(image.Name),
+		Region:  region,
+		State:   string(image.State),
+		Copies:  copies,
+	}
+	if t, err := time.Parse(time.RFC3339, aws.ToString(image.CreationDate)); err == nil {
+		i.CreationDate = t
+		i.Age = humanDuration(now.Sub(t))
+	}
+	for _, bdm := range image.BlockDeviceMappings {
+		if bdm.Ebs != nil {
+			i.SizeGiB += aws.ToInt32(bdm.Ebs.VolumeSize)
+		}
+	}
+	for _, t := range image.Tags {
+		if aws.ToString(t.Key) != expireAtTagKey {
+			continue
+		}
+		if at, err := time.Parse(time.RFC3339, aws.ToString(t.Value)); err == nil {
+			i.ExpireAt = &at
+			if at.After(now) {
+				i.ExpiresIn = humanDuration(at.Sub(now))
+			} else {
+				i.ExpiresIn = "expired"
+			}
+		}
+	}
+	return i
+}
+
+// humanDuration formats d in the largest whole unit of days, hours or minutes.
+func humanDuration(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"join":       strings.Join,
+	"formatTime": func(t *time.Time) string { return formatTime(*t) },
+	"copyRegions": func(copies map[string]string) string {
+		regions := make([]string, 0, len(copies))
+		for r := range copies {
+			regions = append(regions, r)
+		}
+		sort.Strings(regions)
+		return strings.Join(regions, ", ")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>amimati images</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #eee; }
+.expired { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Images in {{.Region}}</h1>
+<p>Copies looked up in: {{if .Regions}}{{join .Regions ", "}}{{else}}none{{end}}. Updated {{.Updated}}.</p>
+
+<h2>Upcoming expirations</h2>
+{{if .Expiring}}
+<table>
+<tr><th>Image</th><th>Name</th><th>Expires at</th><th>Expires in</th></tr>
+{{range .Expiring}}<tr><td>{{.ImageId}}</td><td>{{.Name}}</td><td>{{formatTime .ExpireAt}}</td><td{{if eq .ExpiresIn "expired"}} class="expired"{{end}}>{{.ExpiresIn}}</td></tr>
+{{end}}</table>
+{{else}}<p>No image has an expiry.</p>{{end}}
+
+<h2>All images</h2>
+<table>
+<tr><th>Image</th><th>Name</th><th>State</th><th>Age</th><th>Size (GiB)</th><th>Copies</th></tr>
+{{range .Images}}<tr><td>{{.ImageId}}</td><td>{{.Name}}</td><td>{{.State}}</td><td>{{.Age}}</td><td>{{.SizeGiB}}</td><td>{{copyRegions .Copies}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+type sharedAccount struct {
+	AccountId   string
+	AccountName string `json:",omitempty"`
+}
+
+// publicShare is the share target making an image public.
+const publicShare = "all"
+
+// isOrganizationsARN reports whether the share target is the ARN of an
+// organization or organizational unit rather than an account ID.
+func isOrganizationsARN(s string) bool {
+	return strings.HasPrefix(s, "arn:") && strings.Contains(s, ":organizations::")
+}
+
+// shareImage grants the accounts launch permission on the image and create
+// volume permission on its snapshots. The publicShare target makes the image
+// public, unless image block public access is enabled in the region; its
+// snapshots stay private, which public images do not need. Organization and
+// organizational unit ARNs are granted launch permission on the image only, as
+// snapshots cannot be shared with them.
+func shareImage(ctx context.Context, client *ec2.Client, imageID string, accounts []string) error {
+	var perms []types.LaunchPermission
+	var userIDs []string
+	for i := range accounts {
+		switch {
+		case accounts[i] == publicShare:
+			if err := checkPublicSharing(ctx, client); err != nil {
+				return err
+			}
+			perms = append(perms, types.LaunchPermission{Group: types.PermissionGroupAll})
+		case isOrganizationsARN(accounts[i]) && strings.Contains(accounts[i], ":ou/"):
+			perms = append(perms, types.LaunchPermission{OrganizationalUnitArn: &accounts[i]})
+		case isOrganizationsARN(accounts[i]):
+			perms = append(perms, types.LaunchPermission{OrganizationArn: &accounts[i]})
+		default:
+			perms = append(perms, types.LaunchPermission{UserId: &accounts[i]})
+			userIDs = append(userIDs, accounts[i])
+		}
+	}
+	if _, err := client.ModifyImageAttribute(ctx, &ec2.ModifyImageAttributeInput{
+		ImageId:          &imageID,
+		LaunchPermission: &types.LaunchPermissionModifications{Add: perms},
+	}); err != nil {
+		return fmt.Errorf("error sharing image %s: %w", imageID, err)
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	out, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: []string{imageID}})
+	if err != nil {
+		return fmt.Errorf("error describing image %s: %w", imageID, err)
+	}
+	if len(out.Images) == 0 {
+		return fmt.Errorf("image %s not found", imageID)
+	}
+	for _, bdm := range out.Images[0].BlockDeviceMappings {
+		if bdm.Ebs == nil || bdm.Ebs.SnapshotId == nil {
+			continue
+		}
+		if _, err := client.ModifySnapshotAttribute(ctx, &ec2.ModifySnapshotAttributeInput{
+			SnapshotId:    bdm.Ebs.SnapshotId,
+			Attribute:     types.SnapshotAttributeNameCreateVolumePermission,
+			OperationType: types.OperationTypeAdd,
+			UserIds:       userIDs,
+		}); err != nil {
+			return fmt.Errorf("error sharing snapshot %s: %w", *bdm.Ebs.SnapshotId, err)
+		}
+	}
+	return nil
+}
+
+// imageSharing is who an image is shared with: the launch permissions of the
+// image, and the create volume permissions of its snapshots by device name.
+type imageSharing struct {
+	launch  []types.LaunchPermission
+	volumes map[string][]types.CreateVolumePermission
+}
+
+// describeSharing describes the launch permissions of the image and the
+// create volume permissions of its snapshots.
+func describeSharing(ctx context.Context, client *ec2.Client, imageID string, snapshots []deviceSnapshot) (imageSharing, error) {
+	out, err := client.DescribeImageAttribute(ctx, &ec2.DescribeImageAttributeInput{
+		ImageId:   &imageID,
+		Attribute: types.ImageAttributeNameLaunchPermission,
+	})
+	if err != nil {
+		return imageSharing{}, fmt.Errorf("error describing launch permissions of image %s: %w", imageID, err)
+	}
+	s := imageSharing{launch: out.LaunchPermissions, volumes: map[string][]types.CreateVolumePermission{}}
+	for _, ds := range snapshots {
+		out, err := client.DescribeSnapshotAttribute(ctx, &ec2.DescribeSnapshotAttributeInput{
+			SnapshotId: ds.snapshot.SnapshotId,
+			Attribute:  types.SnapshotAttributeNameCreateVolumePermission,
+		})
+		if err != nil {
+			return imageSharing{}, fmt.Errorf("error describing create volume permissions of snapshot %s: %w", aws.ToString(ds.snapshot.SnapshotId), err)
+		}
+		if len(out.CreateVolumePermissions) > 0 {
+			s.volumes[ds.deviceName] = out.CreateVolumePermissions
+		}
+	}
+	return s, nil
+}
+
+// apply grants the permissions to the image, a copy in the client's region,
+// and to its snapshots of the same devices.
+func (s imageSharing) apply(ctx context.Context, client *ec2.Client, image types.Image) error {
+	if len(s.launch) > 0 {
+		for _, p := range s.launch {
+			if p.Group == types.PermissionGroupAll {
+				if err := checkPublicSharing(ctx, client); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := client.ModifyImageAttribute(ctx, &ec2.ModifyImageAttributeInput{
+			ImageId:          image.ImageId,
+			LaunchPermission: &types.LaunchPermissionModifications{Add: s.launch},
+		}); err != nil {
+			return fmt.Errorf("error sharing image %s: %w", *image.ImageId, err)
+		}
+	}
+	for _, bdm := range image.BlockDeviceMappings {
+		perms := s.volumes[aws.ToString(bdm.DeviceName)]
+		if bdm.Ebs == nil || bdm.Ebs.SnapshotId == nil || len(perms) == 0 {
+			continue
+		}
+		if _, err := client.ModifySnapshotAttribute(ctx, &ec2.ModifySnapshotAttributeInput{
+			SnapshotId:             bdm.Ebs.SnapshotId,
+			Attribute:              types.SnapshotAttributeNameCreateVolumePermission,
+			CreateVolumePermission: &types.CreateVolumePermissionModifications{Add: perms},
+		}); err != nil {
+			return fmt.Errorf("error sharing snapshot %s: %w", *bdm.Ebs.SnapshotId, err)
+		}
+	}
+	return nil
+}
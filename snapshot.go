@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// deviceSnapshot is the snapshot backing a block device of an image.
+type deviceSnapshot struct {
+	deviceName string
+	snapshot   types.Snapshot
+}
+
+// describeImageSnapshots describes the EBS snapshots of the image in the order
+// of its block device mappings.
+func describeImageSnapshots(ctx context.Context, client *ec2.Client, image types.Image) ([]deviceSnapshot, error) {
+	var ids []string
+	devices := map[string]string{}
+	for _, bdm := range image.BlockDeviceMappings {
+		if bdm.Ebs == nil || bdm.Ebs.SnapshotId == nil {
+			continue
+		}
+		ids = append(ids, *bdm.Ebs.SnapshotId)
+		devices[*bdm.Ebs.SnapshotId] = aws.ToString(bdm.DeviceName)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	out, err := client.DescribeSnapshots(ctx, &ec2.DescribeSnapshotsInput{SnapshotIds: ids})
+	if err != nil {
+		return nil, fmt.Errorf("error describing snapshots: %w", err)
+	}
+	snapshots := map[string]types.Snapshot{}
+	for _, s := range out.Snapshots {
+		snapshots[*s.SnapshotId] = s
+	}
+
+	var ds []deviceSnapshot
+	for _, id := range ids {
+		s, ok := snapshots[id]
+		if !ok {
+			s = types.Snapshot{SnapshotId: aws.String(id)}
+		}
+		ds = append(ds, deviceSnapshot{deviceName: devices[id], snapshot: s})
+	}
+	return ds, nil
+}
+
+// imageSizeGiB returns the total size of the EBS volumes of the image.
+func imageSizeGiB(image types.Image) int32 {
+	var size int32
+	for _, bdm := range image.BlockDeviceMappings {
+		if bdm.Ebs != nil {
+			size += aws.ToInt32(bdm.Ebs.VolumeSize)
+		}
+	}
+	return size
+}
+
+// mirrorImageTags applies the tags of the image to each of its snapshots.
+func mirrorImageTags(ctx context.Context, client *ec2.Client, image types.Image, snapshots []deviceSnapshot) error {
+	t := userTags(image.Tags)
+	if len(t) == 0 || len(snapshots) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(snapshots))
+	for _, ds := range snapshots {
+		ids = append(ids, *ds.snapshot.SnapshotId)
+	}
+	if _, err := client.CreateTags(ctx, &ec2.CreateTagsInput{Resources: ids, Tags: t}); err != nil {
+		return fmt.Errorf("error mirroring image tags to snapshots: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/otama-jaccy/amimati/pkg/amimati"
+)
+
+// snapshotSet is the result of the snapshot command: crash-consistent
+// snapshots of the volumes of an instance, taken at the same point in time.
+type snapshotSet struct {
+	InstanceId string
+	Name       string `json:",omitempty"`
+	Snapshots  []setSnapshot
+	Started    time.Time
+	Finished   time.Time
+	Duration   string
+}
+
+type setSnapshot struct {
+	SnapshotId string
+	VolumeId   string
+	DeviceName string `json:",omitempty"`
+	SizeGiB    int32
+	State      string
+	ConsoleUrl string
+}
+
+func runSnapshot(args []string) {
+	var instanceID, description, query string
+	var snapshotTags tags
+	var excludeBoot, copyVolumeTags, verbose, noWait bool
+	var timeout time.Duration
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.StringVar(&instanceID, "instance-id", "", "ID of the instance whose volumes are snapshotted")
+	fs.StringVar(&description, "description", "", "description of the snapshots(default: one naming the instance and the time)")
+	fs.Var(&snapshotTags, "tag", "tags to apply to the snapshots, repeatable(eg. env=prod,team=web)")
+	fs.BoolVar(&copyVolumeTags, "copy-volume-tags", false, "also apply the tags of each volume to its snapshot")
+	fs.BoolVar(&excludeBoot, "exclude-boot-volume", false, "snapshot the data volumes only")
+	fs.BoolVar(&noWait, "no-wait", false, "print the snapshots once they are started instead of waiting for them to complete")
+	fs.BoolVar(&verbose, "v", false, "verbose output")
+	fs.DurationVar(&timeout, "timeout", 0, "give up and exit with status "+strconv.Itoa(exitTimeout)+" if the snapshots have not completed after this long(eg. 1h; default: no limit)")
+	fs.DurationVar(&pollInterval, "poll-interval", pollInterval, "time between two polls of the snapshot states")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. Snapshots[].SnapshotId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	if instanceID == "" {
+		fatal("instance ID is required")
+	}
+	if pollInterval <= 0 {
+		fatal("poll interval must be positive")
+	}
+
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	started := time.Now()
+	if description == "" {
+		description = autoDescription(instanceID, cfg.Region, started)
+	}
+	set, err := snapshotInstance(ctx, ec2.NewFromConfig(cfg), instanceID, description, snapshotTags, excludeBoot, copyVolumeTags, !noWait, verbose)
+	if err != nil {
+		exitWith(exitCode(ctx, err), err)
+	}
+	set.Started = started
+	set.Finished = time.Now()
+	set.Duration = humanDuration(set.Finished.Sub(started))
+	for i, s := range set.Snapshots {
+		set.Snapshots[i].ConsoleUrl = snapshotConsoleURL(cfg.Region, s.SnapshotId)
+	}
+	printResult(set, query)
+}
+
+// snapshotInstance snapshots the EBS volumes of the instance with a single
+// CreateSnapshots call, so the snapshots are crash-consistent with each
+// other, and if wait, waits until they have completed. The snapshots are
+// tagged with the tags and the ID of the instance.
+func snapshotInstance(ctx context.Context, client *ec2.Client, instanceID, description string, t tags, excludeBoot, copyVolumeTags, wait, verbose bool) (*snapshotSet, error) {
+	instance, err := describeInstance(ctx, client, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	devices := map[string]string{}
+	for _, bdm := range instance.BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.VolumeId != nil {
+			devices[*bdm.Ebs.VolumeId] = aws.ToString(bdm.DeviceName)
+		}
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("instance %s has no EBS volumes to snapshot", instanceID)
+	}
+	// The root device of an instance store-backed instance is no EBS volume.
+	if instance.RootDeviceType == types.DeviceTypeInstanceStore {
+		excludeBoot = true
+	}
+
+	in := &ec2.CreateSnapshotsInput{
+		InstanceSpecification: &types.InstanceSpecification{
+			InstanceId:        &instanceID,
+			ExcludeBootVolume: aws.Bool(excludeBoot),
+		},
+		Description: &description,
+		TagSpecifications: []types.TagSpecification{{
+			ResourceType: types.ResourceTypeSnapshot,
+			Tags:         mergeTags(tags{{Key: aws.String(sourceInstanceTagKey), Value: &instanceID}}, t),
+		}},
+	}
+	if copyVolumeTags {
+		in.CopyTagsFromSource = types.CopyTagsFromSourceVolume
+	}
+	out, err := client.CreateSnapshots(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("error creating snapshots of instance %s: %w", instanceID, err)
+	}
+	if len(out.Snapshots) == 0 {
+		return nil, fmt.Errorf("instance %s has no volumes to snapshot", instanceID)
+	}
+
+	set := &snapshotSet{InstanceId: instanceID, Name: tagValue(instance.Tags, "Name")}
+	ids := make([]string, 0, len(out.Snapshots))
+	for _, s := range out.Snapshots {
+		ids = append(ids, *s.SnapshotId)
+		set.Snapshots = append(set.Snapshots, setSnapshot{
+			SnapshotId: *s.SnapshotId,
+			VolumeId:   aws.ToString(s.VolumeId),
+			DeviceName: devices[aws.ToString(s.VolumeId)],
+			SizeGiB:    aws.ToInt32(s.VolumeSize),
+			State:      string(s.State),
+		})
+	}
+	if verbose {
+		logf("snapshotting instance %s: %v", instanceID, ids)
+	}
+	if !wait {
+		return set, nil
+	}
+
+	waits := map[string]*waitLog{}
+	waiter := &amimati.SDKWaiter{Client: client, PollInterval: pollInterval, SnapshotProgress: func(snapshots []types.Snapshot) {
+		for _, s := range snapshots {
+			if !verbose || s.State != types.SnapshotStatePending {
+				continue
+			}
+			if waits[*s.SnapshotId] == nil {
+				waits[*s.SnapshotId] = &waitLog{}
+			}
+			waits[*s.SnapshotId].logf("snapshot %s state: %v, progress: %s", *s.SnapshotId, s.State, aws.ToString(s.Progress))
+		}
+	}}
+	snapshots, err := waiter.WaitSnapshotsCompleted(ctx, ids)
+	if err != nil {
+		return set, err
+	}
+	states := map[string]string{}
+	for _, s := range snapshots {
+		states[*s.SnapshotId] = string(s.State)
+	}
+	for i := range set.Snapshots {
+		set.Snapshots[i].State = states[set.Snapshots[i].SnapshotId]
+	}
+	return set, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const defaultHookTimeout = 5 * time.Minute
+
+// hookResult is a command run on the instance with -pre-command or
+// -post-command, or the SSM document run by -vss, which is its Command.
+type hookResult struct {
+	Hook      string
+	Command   string
+	CommandId string
+	Status    string
+	ExitCode  int32
+	Output    string `json:",omitempty"`
+}
+
+// runInstanceCommand runs the shell command on the instance with SSM Run
+// Command and waits until it finishes, failing unless it succeeds within the
+// timeout. Windows instances run it with PowerShell.
+func runInstanceCommand(ctx context.Context, client *ssm.Client, instance types.Instance, hook, command string, timeout time.Duration) (*hookResult, error) {
+	document := "AWS-RunShellScript"
+	if instance.Platform == types.PlatformValuesWindows {
+		document = "AWS-RunPowerShellScript"
+	}
+	res, err := runDocument(ctx, client, aws.ToString(instance.InstanceId), hook, document, map[string][]string{
+		"commands":         {command},
+		"executionTimeout": {strconv.Itoa(int(timeout.Seconds()))},
+	}, timeout)
+	if res != nil {
+		res.Command = command
+	}
+	return res, err
+}
+
+// runDocument runs the SSM command document with the parameters on the
+// instance and waits until it finishes, failing unless it succeeds within the
+// timeout.
+func runDocument(ctx context.Context, client *ssm.Client, instanceID, hook, document string, parameters map[string][]string, timeout time.Duration) (*hookResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	out, err := client.SendCommand(ctx, &ssm.SendCommandInput{
+		DocumentName: aws.String(document),
+		InstanceIds:  []string{instanceID},
+		Comment:      aws.String("amimati " + hook + " " + runID),
+		Parameters:   parameters,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error sending %s: %w", hook, err)
+	}
+	commandID := aws.ToString(out.Command.CommandId)
+	res := &hookResult{Hook: hook, Command: document, CommandId: commandID}
+
+	for {
+		inv, err := client.GetCommandInvocation(ctx, &ssm.GetCommandInvocationInput{CommandId: &commandID, InstanceId: &instanceID})
+		var notYet *ssmtypes.InvocationDoesNotExist
+		switch {
+		case errors.As(err, &notYet):
+			// The invocation is listed shortly after the command is sent.
+		case err != nil && ctx.Err() != nil:
+			cancelInstanceCommand(client, commandID)
+			return res, fmt.Errorf("%s %s did not finish within %s", hook, commandID, timeout)
+		case err != nil:
+			return res, fmt.Errorf("error getting %s invocation: %w", hook, err)
+		default:
+			res.Status = string(inv.Status)
+			res.ExitCode = inv.ResponseCode
+			res.Output = strings.TrimSpace(aws.ToString(inv.StandardOutputContent) + aws.ToString(inv.StandardErrorContent))
+			switch inv.Status {
+			case ssmtypes.CommandInvocationStatusSuccess:
+				return res, nil
+			case ssmtypes.CommandInvocationStatusFailed, ssmtypes.CommandInvocationStatusCancelled, ssmtypes.CommandInvocationStatusTimedOut:
+				return res, fmt.Errorf("%s %s %s with exit code %d: %s", hook, commandID, strings.ToLower(string(inv.Status)), inv.ResponseCode, aws.ToString(inv.StandardErrorContent))
+			}
+		}
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			cancelInstanceCommand(client, commandID)
+			return res, fmt.Errorf("%s %s did not finish within %s", hook, commandID, timeout)
+		}
+	}
+}
+
+// cancelInstanceCommand cancels a command that outlived its timeout, with a
+// fresh context as the one of the command is done.
+func cancelInstanceCommand(client *ssm.Client, commandID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := client.CancelCommand(ctx, &ssm.CancelCommandInput{CommandId: &commandID}); err != nil {
+		warnf("error cancelling command %s: %v", commandID, err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsdClient sends run metrics over UDP in the StatsD format, with DogStatsD
+// tags. A nil statsdClient sends nothing.
+type statsdClient struct {
+	conn net.Conn
+	tags []string
+
+	mu         sync.Mutex
+	current    string
+	phaseStart time.Time
+	failed     bool
+}
+
+var metrics *statsdClient
+
+// newStatsdClient returns a client sending to addr with the tags added to
+// every metric.
+func newStatsdClient(addr string, tags ...string) (*statsdClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to statsd: %w", err)
+	}
+	return &statsdClient{conn: conn, tags: tags}, nil
+}
+
+func (c *statsdClient) send(name, value, typ string, tags []string) {
+	if c == nil {
+		return
+	}
+	line := "amimati." + name + ":" + value + "|" + typ
+	if all := append(append([]string{}, c.tags...), tags...); len(all) > 0 {
+		line += "|#" + strings.Join(all, ",")
+	}
+	// StatsD is fire and forget: a lost packet is not worth failing the run.
+	if _, err := c.conn.Write([]byte(line)); err != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if !c.failed {
+			c.failed = true
+			warnf("error sending metrics to statsd: %v", err)
+		}
+	}
+}
+
+func (c *statsdClient) count(name string, n int, tags ...string) {
+	c.send(name, fmt.Sprint(n), "c", tags)
+}
+
+func (c *statsdClient) gauge(name string, v float64, tags ...string) {
+	c.send(name, fmt.Sprint(v), "g", tags)
+}
+
+func (c *statsdClient) timing(name string, d time.Duration, tags ...string) {
+	c.send(name, fmt.Sprint(d.Milliseconds()), "ms", tags)
+}
+
+// phase times the phase the run leaves when it enters the next one.
+func (c *statsdClient) phase(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	prev, start := c.current, c.phaseStart
+	c.current, c.phaseStart = name, time.Now()
+	c.mu.Unlock()
+	if prev != "" {
+		c.timing("phase.duration", time.Since(start), "phase:"+strings.ReplaceAll(prev, " ", "_"))
+	}
+}
+
+// finish counts the run as a success or failure.
+func (c *statsdClient) finish(succeeded bool, elapsed time.Duration) {
+	if c == nil {
+		return
+	}
+	if succeeded {
+		c.count("run.success", 1)
+	} else {
+		c.count("run.failure", 1)
+	}
+	c.timing("run.duration", elapsed)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// statusWriter keeps a status file describing the current phase of the run
+// and the progress of its snapshots, rewritten atomically on every change so
+// it can be polled from outside. A nil statusWriter writes nothing.
+type statusWriter struct {
+	path string
+
+	mu        sync.Mutex
+	doc       statusDoc
+	snapshots map[string]*snapshotStatus
+	failed    bool
+}
+
+type statusDoc struct {
+	RunId     string
+	Started   time.Time
+	Updated   time.Time
+	Phase     string
+	ImageId   string           `json:",omitempty"`
+	Snapshots []snapshotStatus `json:",omitempty"`
+	// Eta is the estimated completion time of the slowest snapshot.
+	Eta   *time.Time `json:",omitempty"`
+	Error string     `json:",omitempty"`
+}
+
+type snapshotStatus struct {
+	SnapshotId string
+	State      string
+	Progress   string
+	Eta        *time.Time `json:",omitempty"`
+}
+
+var runStatus *statusWriter
+
+// currentPhase is the phase the run last entered, reported with its failure.
+// Runs of a batch set it concurrently, so it is only accessed under phaseMu.
+var (
+	phaseMu      sync.Mutex
+	currentPhase string
+)
+
+// lastPhase returns the phase the run last entered.
+func lastPhase() string {
+	phaseMu.Lock()
+	defer phaseMu.Unlock()
+	return currentPhase
+}
+
+// runStarted is when the run started, for its metrics.
+var runStarted = time.Now()
+
+func newStatusWriter(path string) *statusWriter {
+	now := time.Now().UTC()
+	return &statusWriter{path: path, doc: statusDoc{RunId: runID, Started: now}, snapshots: map[string]*snapshotStatus{}}
+}
+
+// phase records the phase the run entered.
+func (s *statusWriter) phase(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.doc.Phase = name
+	s.write()
+}
+
+func (s *statusWriter) image(imageID string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.doc.ImageId = imageID
+	s.write()
+}
+
+// snapshot records the state and progress of a snapshot, such as "45%", and
+// estimates when it completes from its progress since it started.
+func (s *statusWriter) snapshot(snapshotID, state, progress string, started time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.snapshots[snapshotID]
+	if !ok {
+		ss = &snapshotStatus{SnapshotId: snapshotID}
+		s.snapshots[snapshotID] = ss
+	}
+	if ss.State == state && ss.Progress == progress {
+		return
+	}
+	ss.State, ss.Progress, ss.Eta = state, progress, nil
+	if left, ok := snapshotETA(progress, started); ok {
+		eta := time.Now().Add(left).UTC().Truncate(time.Second)
+		ss.Eta = &eta
+	}
+	s.write()
+}
+
+// fail records the error that ended the run.
+func (s *statusWriter) fail(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.doc.Phase = "failed"
+	s.doc.Error = err.Error()
+	s.write()
+}
+
+// write replaces the status file with the current status; s.mu must be held.
+// Failures are warned about once and do not stop the run.
+func (s *statusWriter) write() {
+	s.doc.Updated = time.Now().UTC()
+	s.doc.Snapshots = s.doc.Snapshots[:0]
+	s.doc.Eta = nil
+	for _, ss := range s.snapshots {
+		s.doc.Snapshots = append(s.doc.Snapshots, *ss)
+		if ss.Eta != nil && (s.doc.Eta == nil || ss.Eta.After(*s.doc.Eta)) {
+			s.doc.Eta = ss.Eta
+		}
+	}
+	sort.Slice(s.doc.Snapshots, func(i, j int) bool { return s.doc.Snapshots[i].SnapshotId < s.doc.Snapshots[j].SnapshotId })
+
+	if err := writeFileAtomic(s.path, s.doc); err != nil && !s.failed {
+		warnf("error writing status file: %v", err)
+		s.failed = true
+	}
+}
+
+// writeFileAtomic writes v as JSON to a temporary file next to path and
+// renames it over path, so readers never see a partial file.
+func writeFileAtomic(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// setPhase records the phase the run entered in the status file, the run
+// log, the metrics and the summary file.
+func setPhase(name string) {
+	phaseMu.Lock()
+	currentPhase = name
+	phaseMu.Unlock()
+	runStatus.phase(name)
+	runLog.event("info", "phase: "+name)
+	metrics.phase(name)
+	runRecorder.phase(name)
+	humanPhase(name)
+}
+
+// failRun records the error that ended the run in the status file, the run
+// log and the metrics, sends the remaining log events and notifies the
+// failure.
+func failRun(err error) {
+	runStatus.fail(err)
+	runNotifier.fail(err)
+	metrics.finish(false, time.Since(runStarted))
+	cloudMetrics.finish(false, time.Since(runStarted))
+	runLog.event("error", err.Error())
+	runLog.close()
+}
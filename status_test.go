@@ -0,0 +1,27 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestSetPhaseConcurrent is meant for -race: the runs of a batch set their
+// phases concurrently.
+func TestSetPhaseConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for _, name := range []string{"creating image", "waiting for snapshots", "copying"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				setPhase(name)
+				_ = lastPhase()
+			}
+		}(name)
+	}
+	wg.Wait()
+	setPhase("done")
+	if got := lastPhase(); got != "done" {
+		t.Errorf("got phase %q, want done", got)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+type deviceTiming struct {
+	deviceName string
+	snapshotID string
+	size       int32
+	state      types.SnapshotState
+	duration   time.Duration
+}
+
+// deviceTimings derives how long each snapshot took from its start time and
+// its completion time, or the time it was last seen completed when EC2 does
+// not report one.
+func deviceTimings(snapshots []deviceSnapshot, observed time.Time) []deviceTiming {
+	var ts []deviceTiming
+	for _, ds := range snapshots {
+		s := ds.snapshot
+		t := deviceTiming{deviceName: ds.deviceName, snapshotID: *s.SnapshotId, size: aws.ToInt32(s.VolumeSize), state: s.State}
+		if s.StartTime != nil && s.State == types.SnapshotStateCompleted {
+			end := observed
+			if s.CompletionTime != nil {
+				end = *s.CompletionTime
+			}
+			t.duration = end.Sub(*s.StartTime)
+		}
+		ts = append(ts, t)
+	}
+	return ts
+}
+
+// printSummary writes a table of the devices of the image with their
+// snapshot durations and throughput, marking the slowest device.
+func printSummary(w io.Writer, r *result) {
+	slowest := -1
+	for i, t := range r.timings {
+		if slowest < 0 || t.duration > r.timings[slowest].duration {
+			slowest = i
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tSNAPSHOT\tSIZE\tDURATION\tTHROUGHPUT\t")
+	for i, t := range r.timings {
+		duration, throughput := string(t.state), "-"
+		if t.duration > 0 {
+			duration = t.duration.Round(time.Second).String()
+			throughput = fmt.Sprintf("%.1f MiB/s", float64(t.size)*1024/t.duration.Seconds())
+		}
+		mark := ""
+		if i == slowest && len(r.timings) > 1 {
+			mark = "<- slowest"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d GiB\t%s\t%s\t%s\n", t.deviceName, t.snapshotID, t.size, duration, throughput, mark)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "started: %s, finished: %s\n", formatTime(r.started), formatTime(r.finished))
+	fmt.Fprintf(w, "total wall time: %s\n", r.finished.Sub(r.started).Round(time.Second))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+const (
+	syncStatusExisting = "existing"
+	syncStatusCopied   = "copied"
+	syncStatusFailed   = "failed"
+)
+
+type syncRegion struct {
+	Region     string
+	ImageId    string `json:",omitempty"`
+	ConsoleUrl string `json:",omitempty"`
+	Status     string
+	Error      string `json:",omitempty"`
+}
+
+type syncReport struct {
+	ImageId string
+	Regions []syncRegion
+}
+
+func runSync(args []string) {
+	var imageID, query string
+	var regions list
+	var verbose bool
+	var restoreDays restoreArchivedFlag
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.StringVar(&imageID, "image-id", "", "source image ID")
+	fs.Var(&regions, "regions", "regions the image must exist in(eg. us-west-2,eu-west-1)")
+	fs.Var(&restoreDays, "restore-archived", "temporarily restore snapshots of the image in the archive tier before copying, for 1 day or the given number of days(eg. -restore-archived=7)")
+	fs.BoolVar(&verbose, "v", false, "verbose output")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. Regions[].ImageId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	if imageID == "" {
+		fatal("image ID is required")
+	}
+	if len(regions) == 0 {
+		fatal("regions are required")
+	}
+
+	ctx := context.Background()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	if err := checkSamePartition(cfg.Region, regions); err != nil {
+		fatal(err)
+	}
+
+	client := ec2.NewFromConfig(cfg)
+	source, err := waitImageAvailable(ctx, client, imageID, verbose)
+	if err != nil {
+		fatal(err)
+	}
+	sourceSnapshots, err := describeImageSnapshots(ctx, client, source)
+	if err != nil {
+		fatal(err)
+	}
+
+	report := syncReport{ImageId: imageID}
+	var missing []string
+	for _, region := range regions {
+		if region == cfg.Region {
+			report.Regions = append(report.Regions, syncRegion{Region: region, ImageId: imageID, Status: syncStatusExisting})
+			continue
+		}
+		existing, err := findCopy(ctx, regionalClient(cfg, region), source)
+		switch {
+		case err != nil:
+			report.Regions = append(report.Regions, syncRegion{Region: region, Status: syncStatusFailed, Error: err.Error()})
+		case existing != nil:
+			report.Regions = append(report.Regions, syncRegion{Region: region, ImageId: *existing.ImageId, Status: syncStatusExisting})
+		default:
+			missing = append(missing, region)
+		}
+	}
+
+	if len(missing) > 0 {
+		if verbose {
+			logf("copying %s to %v", imageID, missing)
+		}
+		if err := restoreArchivedImage(ctx, client, source, int32(restoreDays), verbose); err != nil {
+			fatal(err)
+		}
+		copies, err := copyImage(ctx, cfg, source, missing, copyOptions{verbose: verbose})
+		var errs regionErrors
+		if err != nil && !errors.As(err, &errs) {
+			fatal(err)
+		}
+		for _, region := range missing {
+			if id, ok := copies[region]; ok {
+				report.Regions = append(report.Regions, syncRegion{Region: region, ImageId: id, Status: syncStatusCopied})
+			} else {
+				report.Regions = append(report.Regions, syncRegion{Region: region, Status: syncStatusFailed, Error: errs[region].Error()})
+			}
+		}
+	}
+
+	failed := false
+	for i, r := range report.Regions {
+		if r.ImageId != "" {
+			report.Regions[i].ConsoleUrl = imageConsoleURL(r.Region, r.ImageId)
+		}
+		if r.Status == syncStatusFailed || r.Region == cfg.Region {
+			failed = failed || r.Status == syncStatusFailed
+			continue
+		}
+		if err := retagCopy(ctx, regionalClient(cfg, r.Region), source, sourceSnapshots, r.ImageId); err != nil {
+			report.Regions[i].Status = syncStatusFailed
+			report.Regions[i].Error = err.Error()
+			failed = true
+		}
+	}
+
+	printResult(report, query)
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// sourceInstanceTagKey records the instance an image was created from.
+const sourceInstanceTagKey = "amimati:source-instance-id"
+
+// Lineage tags of -lineage, besides sourceInstanceTagKey.
+const (
+	// baseImageTagKey records the image the source instance was launched
+	// from.
+	baseImageTagKey = "amimati:base-image-id"
+	versionTagKey   = "amimati:version"
+	createdAtTagKey = "amimati:created-at"
+)
+
+// lineageTags are the tags tracing an image of the instance created at t
+// back to what it was built from.
+func lineageTags(instance types.Instance, t time.Time) tags {
+	lt := tags{
+		{Key: aws.String(sourceInstanceTagKey), Value: instance.InstanceId},
+		{Key: aws.String(versionTagKey), Value: aws.String(toolVersion())},
+		{Key: aws.String(createdAtTagKey), Value: aws.String(t.UTC().Format(time.RFC3339))},
+	}
+	if instance.ImageId != nil {
+		lt = append(lt, types.Tag{Key: aws.String(baseImageTagKey), Value: instance.ImageId})
+	}
+	return lt
+}
+
+type tagChange struct {
+	Instance string
+	Image    string
+}
+
+type tagDiff struct {
+	ImageId    string
+	InstanceId string
+	Added      map[string]string
+	Dropped    map[string]string
+	Changed    map[string]tagChange
+}
+
+func runTagdiff(args []string) {
+	var imageID, instanceID, query string
+	fs := flag.NewFlagSet("tagdiff", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.StringVar(&imageID, "image-id", "", "image ID")
+	fs.StringVar(&instanceID, "instance-id", "", "source instance ID(default: resolved from the image)")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. Dropped)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	if imageID == "" {
+		fatal("image ID is required")
+	}
+
+	ctx := context.Background()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	client := ec2.NewFromConfig(cfg)
+
+	out, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: []string{imageID}})
+	if err != nil {
+		fatalf("error describing image: %v", err)
+	}
+	if len(out.Images) == 0 {
+		fatalf("image %s not found", imageID)
+	}
+	image := out.Images[0]
+
+	if instanceID == "" {
+		instanceID = imageSourceInstance(image)
+	}
+	if instanceID == "" {
+		fatalf("cannot resolve the source instance of %s, use -instance-id", imageID)
+	}
+	instance, err := describeInstance(ctx, client, instanceID)
+	if err != nil {
+		fatal(err)
+	}
+
+	printResult(diffTags(imageID, instanceID, instance.Tags, image.Tags), query)
+}
+
+// imageSourceInstance returns the ID of the instance the image was created
+// from, as reported by EC2 or recorded in its lineage tag.
+func imageSourceInstance(image types.Image) string {
+	if image.SourceInstanceId != nil {
+		return *image.SourceInstanceId
+	}
+	for _, t := range image.Tags {
+		if aws.ToString(t.Key) == sourceInstanceTagKey {
+			return aws.ToString(t.Value)
+		}
+	}
+	return ""
+}
+
+// diffTags compares the tags of an instance and its image. Tags with the
+// reserved aws: prefix cannot be propagated and are ignored.
+func diffTags(imageID, instanceID string, instanceTags, imageTags []types.Tag) tagDiff {
+	toMap := func(ts []types.Tag) map[string]string {
+		m := map[string]string{}
+		for _, t := range ts {
+			if k := aws.ToString(t.Key); !strings.HasPrefix(k, "aws:") {
+				m[k] = aws.ToString(t.Value)
+			}
+		}
+		return m
+	}
+	from, to := toMap(instanceTags), toMap(imageTags)
+
+	d := tagDiff{ImageId: imageID, InstanceId: instanceID, Added: map[string]string{}, Dropped: map[string]string{}, Changed: map[string]tagChange{}}
+	for k, v := range from {
+		w, ok := to[k]
+		switch {
+		case !ok:
+			d.Dropped[k] = v
+		case v != w:
+			d.Changed[k] = tagChange{Instance: v, Image: w}
+		}
+	}
+	for k, v := range to {
+		if _, ok := from[k]; !ok {
+			d.Added[k] = v
+		}
+	}
+	return d
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// tagPolicyConfig is the tag policy images must comply with, checked before
+// they are created.
+type tagPolicyConfig struct {
+	// Required are the keys every image must be tagged with.
+	Required []string `json:"required"`
+	// Patterns are regular expressions the values of the tags must match,
+	// by key. A key with a pattern is required too.
+	Patterns map[string]string `json:"patterns"`
+}
+
+// tagPolicy is a compiled tagPolicyConfig. The zero tagPolicy requires
+// nothing.
+type tagPolicy struct {
+	required []string
+	patterns map[string]*regexp.Regexp
+}
+
+// loadTagPolicy returns the tag policy of the config file, if any, with the
+// keys of -required-tags added. A flag value of key=regex also sets the
+// pattern of the key, taking precedence over the config file.
+func loadTagPolicy(c *fileConfig, flag []string) (tagPolicy, error) {
+	var pc tagPolicyConfig
+	if c != nil && c.TagPolicy != nil {
+		pc = *c.TagPolicy
+	}
+	patterns := map[string]string{}
+	for k, v := range pc.Patterns {
+		patterns[k] = v
+	}
+	required := append([]string{}, pc.Required...)
+	for _, f := range flag {
+		key, pattern, ok := strings.Cut(f, "=")
+		if key == "" {
+			return tagPolicy{}, fmt.Errorf("invalid required tag: %q", f)
+		}
+		required = append(required, key)
+		if ok {
+			patterns[key] = pattern
+		}
+	}
+
+	p := tagPolicy{patterns: map[string]*regexp.Regexp{}}
+	seen := map[string]bool{}
+	for _, k := range required {
+		if !seen[k] {
+			seen[k] = true
+			p.required = append(p.required, k)
+		}
+	}
+	for k, v := range patterns {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return tagPolicy{}, fmt.Errorf("invalid pattern of required tag %s: %w", k, err)
+		}
+		p.patterns[k] = re
+		if !seen[k] {
+			seen[k] = true
+			p.required = append(p.required, k)
+		}
+	}
+	sort.Strings(p.required)
+	return p, nil
+}
+
+// check fails listing every required tag t lacks and every value not
+// matching its pattern.
+func (p tagPolicy) check(t tags) error {
+	if len(p.required) == 0 {
+		return nil
+	}
+	values := map[string]string{}
+	for _, tt := range t {
+		values[aws.ToString(tt.Key)] = aws.ToString(tt.Value)
+	}
+	var missing, invalid []string
+	for _, k := range p.required {
+		v, ok := values[k]
+		if !ok {
+			missing = append(missing, k)
+			continue
+		}
+		if re := p.patterns[k]; re != nil && !re.MatchString(v) {
+			invalid = append(invalid, fmt.Sprintf("%s=%q does not match %s", k, v, re))
+		}
+	}
+	var errs []string
+	if len(missing) > 0 {
+		errs = append(errs, "missing "+strings.Join(missing, ", "))
+	}
+	errs = append(errs, invalid...)
+	if len(errs) > 0 {
+		return errors.New("image tags violate the tag policy: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// AWS tag constraints, checked before any request is made.
+const (
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+	maxTagsPerImage   = 50
+	reservedTagPrefix = "aws:"
+)
+
+// tags is a repeatable flag of tags to apply to resources. Each value is a
+// comma separated list of key=value pairs; see parseTags.
+type tags []types.Tag
+
+func (t *tags) String() string {
+	return formatTags(*t)
+}
+
+func (t *tags) Set(value string) error {
+	parsed, err := parseTags(value)
+	if err != nil {
+		return err
+	}
+	for _, tag := range parsed {
+		if err := validateTag(tag); err != nil {
+			return err
+		}
+		if strings.HasPrefix(strings.ToLower(aws.ToString(tag.Key)), reservedTagPrefix) {
+			return fmt.Errorf("invalid tag %s: the %s prefix is reserved for AWS", aws.ToString(tag.Key), reservedTagPrefix)
+		}
+		// A tag given again, in the same or a later flag, replaces the
+		// earlier one.
+		*t = mergeTags(*t, tags{tag})
+	}
+	if len(*t) > maxTagsPerImage {
+		return fmt.Errorf("too many tags: %d, at most %d are allowed", len(*t), maxTagsPerImage)
+	}
+	return nil
+}
+
+// filterTags is a repeatable flag of tags that images must carry. It is
+// parsed as tags, but may name the aws: tags set by AWS, and * in a value
+// matches any characters.
+type filterTags []types.Tag
+
+func (t *filterTags) String() string {
+	return formatTags(*t)
+}
+
+func (t *filterTags) Set(value string) error {
+	parsed, err := parseTags(value)
+	if err != nil {
+		return err
+	}
+	for _, tag := range parsed {
+		if err := validateTag(tag); err != nil {
+			return err
+		}
+		*t = filterTags(mergeTags(tags(*t), tags{tag}))
+	}
+	return nil
+}
+
+// parseTags parses a comma separated list of tags, each key=value. A value
+// may be empty (key=) and contain colons, so ARNs and URLs need no quoting.
+// Keys or values containing commas or equal signs are quoted with double or
+// single quotes, or the character is escaped with a backslash, as in
+// team:owner=alice,role=arn:aws:iam::111122223333:role/x,"note"="a, b".
+// Quotes are only special at the start of a key or value.
+// The key:value form of earlier versions is still accepted when a pair has
+// no unquoted =; the value then starts after the first colon, where a quote
+// is special too.
+func parseTags(value string) ([]types.Tag, error) {
+	var parsed []types.Tag
+	rest := value
+	for {
+		key, colon, n, err := scanTagField(rest, true)
+		pair := rest[:n]
+		if pair == "" {
+			return nil, fmt.Errorf("invalid tag, want key=value: empty tag in %q", value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag key %s: %w", pair, err)
+		}
+		var val string
+		if n < len(rest) && rest[n] == '=' {
+			var m int
+			val, _, m, err = scanTagField(rest[n+1:], false)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tag value %s: %w", rest[n+1:n+1+m], err)
+			}
+			n += 1 + m
+		} else if colon < 0 {
+			return nil, fmt.Errorf("invalid tag, want key=value: %s", pair)
+		} else {
+			key, val = key[:colon], key[colon+1:]
+		}
+		parsed = append(parsed, types.Tag{Key: aws.String(key), Value: aws.String(val)})
+		if n == len(rest) {
+			return parsed, nil
+		}
+		rest = rest[n+1:]
+	}
+}
+
+// scanTagField reads a key, up to the first unquoted comma or equal sign, or a
+// value, up to the first unquoted comma, removing its quotes and escapes. It
+// returns the field, the offset in it of the first unquoted colon of a key,
+// or -1, and the length of s read. A quote is only special at the start of
+// the field, or after that colon, which may start the value of the key:value
+// form.
+func scanTagField(s string, key bool) (string, int, int, error) {
+	var b strings.Builder
+	var quote rune
+	colon := -1
+	escaped, quotable := false, true
+	for i, r := range s {
+		canQuote := quotable
+		quotable = false
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				b.WriteRune(r)
+			}
+		case canQuote && (r == '"' || r == '\''):
+			quote = r
+		case r == ',' || key && r == '=':
+			return b.String(), colon, i, nil
+		case key && r == ':' && colon < 0:
+			colon = b.Len()
+			b.WriteRune(r)
+			quotable = true
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if escaped {
+		return "", colon, len(s), fmt.Errorf("trailing backslash")
+	}
+	if quote != 0 {
+		return "", colon, len(s), fmt.Errorf("unterminated %c quote", quote)
+	}
+	return b.String(), colon, len(s), nil
+}
+
+// validateTag checks the tag against the AWS constraints on tag lengths and
+// encoding.
+func validateTag(tag types.Tag) error {
+	key, val := aws.ToString(tag.Key), aws.ToString(tag.Value)
+	switch {
+	case key == "":
+		return fmt.Errorf("invalid tag: empty key")
+	case !utf8.ValidString(key) || !utf8.ValidString(val):
+		return fmt.Errorf("invalid tag %s: not valid UTF-8", key)
+	case utf8.RuneCountInString(key) > maxTagKeyLength:
+		return fmt.Errorf("invalid tag %s: keys are at most %d characters", key, maxTagKeyLength)
+	case utf8.RuneCountInString(val) > maxTagValueLength:
+		return fmt.Errorf("invalid tag %s: values are at most %d characters", key, maxTagValueLength)
+	}
+	return nil
+}
+
+// formatTags formats the tags as parseTags reads them.
+func formatTags(t []types.Tag) string {
+	var s []string
+	for _, tag := range t {
+		s = append(s, quoteTag(aws.ToString(tag.Key))+"="+quoteTag(aws.ToString(tag.Value)))
+	}
+	return strings.Join(s, ",")
+}
+
+func quoteTag(s string) string {
+	_, afterColon, _ := strings.Cut(s, ":")
+	if !strings.ContainsAny(s, `,=\`) && !strings.HasPrefix(s, `"`) && !strings.HasPrefix(s, "'") && !strings.HasPrefix(afterColon, `"`) && !strings.HasPrefix(afterColon, "'") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
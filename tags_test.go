@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestParseTags(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []string // key, value, key, value...
+	}{
+		{`a=b`, []string{"a", "b"}},
+		{`a=b,c=d`, []string{"a", "b", "c", "d"}},
+		{`owner=`, []string{"owner", ""}},
+		{`team:owner=alice`, []string{"team:owner", "alice"}},
+		{`role=arn:aws:iam::111122223333:role/x`, []string{"role", "arn:aws:iam::111122223333:role/x"}},
+		{`url=https://example.com/a?b=c`, []string{"url", "https://example.com/a?b=c"}},
+		{`note="a, b"`, []string{"note", "a, b"}},
+		{`"note"="a, b",c=d`, []string{"note", "a, b", "c", "d"}},
+		{`note='a, b'`, []string{"note", "a, b"}},
+		{`"k=1"=v`, []string{"k=1", "v"}},
+		{`k="x:y"`, []string{"k", "x:y"}},
+		{`k="say \"hi\""`, []string{"k", `say "hi"`}},
+		{`k='it"s'`, []string{"k", `it"s`}},
+		{`k=a\,b`, []string{"k", "a,b"}},
+		{`k\=1=v`, []string{"k=1", "v"}},
+		{`k=a\\b`, []string{"k", `a\b`}},
+		// Quotes are only special at the start of a key or value.
+		{`a=b:'c,d=e`, []string{"a", "b:'c", "d", "e"}},
+		{`a=it's,b=c`, []string{"a", "it's", "b", "c"}},
+		// The key:value form of earlier versions.
+		{`env:prod`, []string{"env", "prod"}},
+		{`env:'a,b',c=d`, []string{"env", "a,b", "c", "d"}},
+		{`env\:x:prod`, []string{"env:x", "prod"}},
+	} {
+		got, err := parseTags(tt.in)
+		if err != nil {
+			t.Errorf("parseTags(%q): %v", tt.in, err)
+			continue
+		}
+		var kv []string
+		for _, tag := range got {
+			kv = append(kv, aws.ToString(tag.Key), aws.ToString(tag.Value))
+		}
+		if !reflect.DeepEqual(kv, tt.want) {
+			t.Errorf("parseTags(%q) = %q, want %q", tt.in, kv, tt.want)
+		}
+	}
+}
+
+func TestParseTagsErrors(t *testing.T) {
+	for _, in := range []string{
+		``,
+		`a=b,`,
+		`a=b,,c=d`,
+		`novalue`,
+		`k="unterminated`,
+		`"k=v`,
+		`k='a,b`,
+		`env:'a,b`,
+		// The quote is not at the start of the value.
+		`a=b="c,d"`,
+		`k=v\`,
+	} {
+		if got, err := parseTags(in); err == nil {
+			t.Errorf("parseTags(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestFormatTagsRoundTrip(t *testing.T) {
+	want := []types.Tag{
+		{Key: aws.String("plain"), Value: aws.String("v")},
+		{Key: aws.String("a,b"), Value: aws.String("c=d")},
+		{Key: aws.String(`"quoted"`), Value: aws.String(`back\slash`)},
+		{Key: aws.String("team:'x"), Value: aws.String("arn:aws:s3:::'bucket")},
+		{Key: aws.String("empty"), Value: aws.String("")},
+	}
+	got, err := parseTags(formatTags(want))
+	if err != nil {
+		t.Fatalf("parseTags(%q): %v", formatTags(want), err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTags(formatTags(%v)) = %v", want, got)
+	}
+}
+
+func TestCommonTags(t *testing.T) {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	var common, image tags
+	fs.Var(&common, "tag", "")
+	fs.Var(&image, "image-tag", "")
+	if err := fs.Parse([]string{"-tag", "env=prod,team=web", "-image-tag", "team=db,role=primary"}); err != nil {
+		t.Fatal(err)
+	}
+	got := formatTags(mergeTags(common, image))
+	if want := "env=prod,team=db,role=primary"; got != want {
+		t.Errorf("image tags are %s, want %s", got, want)
+	}
+	if got, want := formatTags(mergeTags(common, nil)), "env=prod,team=web"; got != want {
+		t.Errorf("snapshot tags are %s, want %s", got, want)
+	}
+}
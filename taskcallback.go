@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Errors reported to Step Functions with SendTaskFailure, by exit status,
+// which a Catch or Retry of the state can match.
+var taskErrors = map[int]string{
+	exitFailure:    "amimati.Failed",
+	exitValidation: "amimati.InvalidArguments",
+	exitAWS:        "amimati.AWSError",
+	exitTimeout:    "amimati.Timeout",
+	exitSnapshot:   "amimati.SnapshotFailed",
+	exitPartial:    "amimati.PartialFailure",
+	exitAborted:    "amimati.Aborted",
+}
+
+// taskRejected is the error of a run whose image was created but rejected
+// by a check after creation.
+const taskRejected = "amimati.Rejected"
+
+// taskCallback answers the task token of a Step Functions "wait for
+// callback" state when the run finishes, with SendTaskSuccess and the result
+// of the run or SendTaskFailure and its error. A nil taskCallback answers
+// nothing.
+type taskCallback struct {
+	cfg   aws.Config
+	token string
+
+	mu   sync.Mutex
+	sent bool
+}
+
+var runCallback *taskCallback
+
+func newTaskCallback(cfg aws.Config, token string) *taskCallback {
+	return &taskCallback{cfg: cfg, token: token}
+}
+
+// succeed sends the output, marshalled as JSON, as the output of the task.
+func (c *taskCallback) succeed(output any) {
+	if !c.first() {
+		return
+	}
+	b, err := json.Marshal(output)
+	if err != nil {
+		warnf("error marshalling the task output: %v", err)
+		return
+	}
+	c.send("SendTaskSuccess", map[string]string{"taskToken": c.token, "output": string(b)})
+}
+
+// fail sends the failure of the run with the exit status code and err.
+func (c *taskCallback) fail(code int, err error) {
+	name, ok := taskErrors[code]
+	if !ok {
+		name = taskErrors[exitFailure]
+	}
+	c.failWith(name, code, err)
+}
+
+// failWith sends the failure named name, with the failure of the json
+// output format as its cause.
+func (c *taskCallback) failWith(name string, code int, err error) {
+	if !c.first() {
+		return
+	}
+	cause, merr := json.Marshal(failureOf(code, err))
+	if merr != nil {
+		cause = []byte(err.Error())
+	}
+	// The cause of a task failure is at most 32768 characters.
+	if len(cause) > 32768 {
+		cause = cause[:32768]
+	}
+	c.send("SendTaskFailure", map[string]string{"taskToken": c.token, "error": name, "cause": string(cause)})
+}
+
+// first reports whether this is the first answer of the run, the only one
+// sent.
+func (c *taskCallback) first() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sent {
+		return false
+	}
+	c.sent = true
+	return true
+}
+
+// send calls the Step Functions action. The answer is best effort, counted
+// from a fresh context as notifications are: a run whose answer cannot be sent
+// warns about it, and the state times out as it would without it.
+func (c *taskCallback) send(action string, body map[string]string) {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if err := callStates(ctx, c.cfg, action, body); err != nil {
+		warnf("error answering the task token: %v", err)
+	}
+}
+
+// callStates calls the Step Functions action, as SendTaskSuccess.
+func callStates(ctx context.Context, cfg aws.Config, action string, body any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	_, err = invokeAPI(ctx, cfg, apiCall{
+		serviceID:   "SFN",
+		signingName: "states",
+		operation:   action,
+		method:      http.MethodPost,
+		path:        "/",
+		header:      http.Header{"Content-Type": {"application/x-amz-json-1.0"}, "X-Amz-Target": {"AWSStepFunctions." + action}},
+		body:        b,
+	})
+	if err != nil {
+		return fmt.Errorf("error calling %s: %w", action, err)
+	}
+	return nil
+}
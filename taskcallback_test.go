@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// statesCall is a request received by the fake Step Functions endpoint.
+type statesCall struct {
+	target string
+	body   map[string]string
+}
+
+func statesServer(t *testing.T, status int) (*httptest.Server, *[]statesCall) {
+	var calls []statesCall
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "/states/aws4_request") {
+			t.Errorf("request is not signed for states: %q", r.Header.Get("Authorization"))
+		}
+		c := statesCall{target: r.Header.Get("X-Amz-Target")}
+		if err := json.NewDecoder(r.Body).Decode(&c.body); err != nil {
+			t.Error(err)
+		}
+		calls = append(calls, c)
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			w.Write([]byte(`{"__type":"TaskTimedOut","message":"Task Timed Out"}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func statesConfig(url string) aws.Config {
+	return aws.Config{Region: "us-east-1", BaseEndpoint: aws.String(url), Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", "")}
+}
+
+func TestTaskCallbackSucceed(t *testing.T) {
+	srv, calls := statesServer(t, http.StatusOK)
+	c := newTaskCallback(statesConfig(srv.URL), "token-1")
+	c.succeed(map[string]string{"ImageId": "ami-1"})
+	c.fail(exitFailure, errors.New("late"))
+	if len(*calls) != 1 {
+		t.Fatalf("got %d calls, want only the first answer", len(*calls))
+	}
+	got := (*calls)[0]
+	if got.target != "AWSStepFunctions.SendTaskSuccess" || got.body["taskToken"] != "token-1" || got.body["output"] != `{"ImageId":"ami-1"}` {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestTaskCallbackFail(t *testing.T) {
+	srv, calls := statesServer(t, http.StatusOK)
+	c := newTaskCallback(statesConfig(srv.URL), "token-1")
+	c.fail(exitTimeout, context.DeadlineExceeded)
+	if len(*calls) != 1 {
+		t.Fatalf("got %d calls, want 1", len(*calls))
+	}
+	got := (*calls)[0]
+	if got.target != "AWSStepFunctions.SendTaskFailure" || got.body["error"] != "amimati.Timeout" {
+		t.Errorf("got %+v", got)
+	}
+	var cause failure
+	if err := json.Unmarshal([]byte(got.body["cause"]), &cause); err != nil || cause.ExitStatus != exitTimeout || cause.Message != context.DeadlineExceeded.Error() {
+		t.Errorf("got cause %s (%v)", got.body["cause"], err)
+	}
+}
+
+func TestTaskCallbackNil(t *testing.T) {
+	var c *taskCallback
+	c.succeed("ignored")
+	c.fail(exitFailure, errors.New("ignored"))
+}
+
+func TestCallStatesError(t *testing.T) {
+	srv, _ := statesServer(t, http.StatusBadRequest)
+	err := callStates(context.Background(), statesConfig(srv.URL), "SendTaskSuccess", map[string]string{"taskToken": "t", "output": "{}"})
+	if err == nil || !strings.Contains(err.Error(), "TaskTimedOut") {
+		t.Fatalf("got error %v, want the TaskTimedOut error", err)
+	}
+}
+
+func TestCallStatesRetried(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"__type":"ServiceUnavailable","message":"try again"}`))
+		}
+	}))
+	defer srv.Close()
+
+	cfg := statesConfig(srv.URL)
+	cfg.Retryer = func() aws.Retryer {
+		return retry.NewStandard(func(o *retry.StandardOptions) {
+			o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+		})
+	}
+	if err := callStates(context.Background(), cfg, "SendTaskSuccess", map[string]string{"taskToken": "t", "output": "{}"}); err != nil || requests != 2 {
+		t.Fatalf("got %v after %d requests, want success after a retry", err, requests)
+	}
+}
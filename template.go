@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// templateData is what image names and tag values can refer to, as in
+// {{.Date "2006-01-02"}}-{{.InstanceName}}.
+type templateData struct {
+	InstanceID   string
+	InstanceName string
+	Region       string
+
+	now time.Time
+	// caller looks up the identity the @{aws:...} tokens of tag values
+	// resolve to.
+	caller func() (callerIdentity, error)
+}
+
+func newTemplateData(instance types.Instance, region string, now time.Time) templateData {
+	return templateData{
+		InstanceID:   aws.ToString(instance.InstanceId),
+		InstanceName: tagValue(instance.Tags, "Name"),
+		Region:       region,
+		now:          now,
+	}
+}
+
+// Date formats the time of the run with the layout in the -timezone.
+func (d templateData) Date(layout string) string {
+	return d.now.In(timeZone).Format(layout)
+}
+
+// Env returns the value of the environment variable, failing the expansion
+// when it is not set.
+func (d templateData) Env(key string) (string, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", key)
+	}
+	return v, nil
+}
+
+// expand expands the template s with the data.
+func (d templateData) expand(s string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	t, err := template.New("").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid template %q: %w", s, err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, d); err != nil {
+		return "", fmt.Errorf("error expanding %q: %w", s, err)
+	}
+	return b.String(), nil
+}
+
+// expandTags expands the values of the tags into new tags: their $VAR and
+// ${VAR} environment variables and @{aws:...} tokens, then their template.
+func (d templateData) expandTags(t tags) (tags, error) {
+	expanded := make(tags, 0, len(t))
+	for _, tt := range t {
+		v, err := d.expandTokens(aws.ToString(tt.Value))
+		if err == nil {
+			v, err = d.expand(v)
+		}
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, types.Tag{Key: tt.Key, Value: aws.String(v)})
+	}
+	return expanded, nil
+}
+
+// tokenPattern matches $$, $VAR, ${VAR} and @{aws:key} in tag values.
+var tokenPattern = regexp.MustCompile(`\$\$|\$([A-Za-z_][A-Za-z0-9_]*)|\$\{([A-Za-z_][A-Za-z0-9_]*)\}|@\{aws:([a-z]+)\}`)
+
+// expandTokens replaces the environment variables and @{aws:...} tokens of the
+// tag value s, failing when a variable is not set or a token is unknown. $$
+// stands for a literal $.
+func (d templateData) expandTokens(s string) (string, error) {
+	if !strings.ContainsAny(s, "$@") {
+		return s, nil
+	}
+	var err error
+	v := tokenPattern.ReplaceAllStringFunc(s, func(m string) string {
+		if err != nil {
+			return m
+		}
+		if m == "$$" {
+			return "$"
+		}
+		sub := tokenPattern.FindStringSubmatch(m)
+		if key := sub[1] + sub[2]; key != "" {
+			var v string
+			v, err = d.Env(key)
+			return v
+		}
+		if d.caller == nil {
+			err = fmt.Errorf("@{aws:%s} cannot be resolved here", sub[3])
+			return m
+		}
+		var id callerIdentity
+		if id, err = d.caller(); err != nil {
+			return m
+		}
+		var v string
+		v, err = id.token(sub[3])
+		return v
+	})
+	if err != nil {
+		return "", fmt.Errorf("error expanding %q: %w", s, err)
+	}
+	return v, nil
+}
+
+// callerIdentity is who amimati runs as, according to STS.
+type callerIdentity struct {
+	Account string
+	Arn     string
+	UserId  string
+}
+
+// token returns the value of the @{aws:key} token.
+func (c callerIdentity) token(key string) (string, error) {
+	switch key {
+	case "account":
+		return c.Account, nil
+	case "arn":
+		return c.Arn, nil
+	case "userid":
+		return c.UserId, nil
+	case "username":
+		return c.username(), nil
+	}
+	return "", fmt.Errorf("unknown token @{aws:%s}, want account, arn, userid or username", key)
+}
+
+// username is the name of the IAM user, the session name of an assumed role,
+// or root.
+func (c callerIdentity) username() string {
+	parsed, err := arn.Parse(c.Arn)
+	if err != nil {
+		return c.UserId
+	}
+	// user/path/name, assumed-role/role/session, federated-user/name or root
+	return parsed.Resource[strings.LastIndex(parsed.Resource, "/")+1:]
+}
+
+// lookupCaller returns a function looking up the caller identity with
+// GetCallerIdentity once, on first use.
+func lookupCaller(ctx context.Context, cfg aws.Config) func() (callerIdentity, error) {
+	var once sync.Once
+	var id callerIdentity
+	var err error
+	return func() (callerIdentity, error) {
+		once.Do(func() {
+			out, e := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+			if e != nil {
+				err = fmt.Errorf("error getting caller identity: %w", e)
+				return
+			}
+			id = callerIdentity{Account: aws.ToString(out.Account), Arn: aws.ToString(out.Arn), UserId: aws.ToString(out.UserId)}
+		})
+		return id, err
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// throwawayOptions describe a short-lived instance launched from an image to
+// check it.
+type throwawayOptions struct {
+	instanceType    string
+	subnetID        string
+	instanceProfile string
+}
+
+// launchThrowaway launches an instance from the image, tagged with purpose,
+// and waits until it is running.
+func launchThrowaway(ctx context.Context, client *ec2.Client, imageID, purpose string, opt throwawayOptions, timeout time.Duration) (string, error) {
+	in := &ec2.RunInstancesInput{
+		ImageId:      &imageID,
+		InstanceType: types.InstanceType(opt.instanceType),
+		MinCount:     aws.Int32(1),
+		MaxCount:     aws.Int32(1),
+		TagSpecifications: []types.TagSpecification{{
+			ResourceType: types.ResourceTypeInstance,
+			Tags: append([]types.Tag{
+				{Key: aws.String("Name"), Value: aws.String("amimati-" + purpose + "-" + imageID)},
+				{Key: aws.String("amimati:purpose"), Value: aws.String(purpose)},
+			}, runIDTags()...),
+		}},
+	}
+	if opt.subnetID != "" {
+		in.SubnetId = &opt.subnetID
+	}
+	if opt.instanceProfile != "" {
+		in.IamInstanceProfile = &types.IamInstanceProfileSpecification{Name: &opt.instanceProfile}
+	}
+	out, err := client.RunInstances(ctx, in)
+	if err != nil {
+		return "", fmt.Errorf("error launching %s instance: %w", purpose, err)
+	}
+	id := *out.Instances[0].InstanceId
+
+	if err := ec2.NewInstanceRunningWaiter(client).Wait(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{id}}, timeout); err != nil {
+		return id, fmt.Errorf("error waiting for %s instance %s: %w", purpose, id, err)
+	}
+	return id, nil
+}
+
+func terminateThrowaway(ctx context.Context, client *ec2.Client, instanceID string) error {
+	if _, err := client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{InstanceIds: []string{instanceID}}); err != nil {
+		return fmt.Errorf("error terminating instance %s: %w", instanceID, err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	timeFormatRFC3339 = "rfc3339"
+	timeFormatUnix    = "unix"
+	timeFormatLocal   = "local"
+)
+
+// timeFormat and timeZone control how formatTime shows timestamps in human
+// output. JSON results keep RFC 3339 in UTC.
+var (
+	timeFormat = timeFormatRFC3339
+	timeZone   = time.UTC
+)
+
+// timeOptions are the flags controlling formatTime.
+type timeOptions struct {
+	format   string
+	timezone string
+}
+
+func addTimeFlags(fs *flag.FlagSet) *timeOptions {
+	o := &timeOptions{}
+	fs.StringVar(&o.format, "time-format", timeFormatRFC3339, "format of timestamps in human output(rfc3339, unix or local)")
+	fs.StringVar(&o.timezone, "timezone", "", "time zone of timestamps in human output(eg. Asia/Tokyo; default: UTC, or the local zone with -time-format local)")
+	return o
+}
+
+// apply validates the flags and sets the time format and zone of the process.
+func (o *timeOptions) apply() error {
+	switch o.format {
+	case timeFormatRFC3339, timeFormatUnix:
+		timeZone = time.UTC
+	case timeFormatLocal:
+		timeZone = time.Local
+	default:
+		return fmt.Errorf("invalid time format: %s", o.format)
+	}
+	timeFormat = o.format
+	if o.timezone != "" {
+		loc, err := time.LoadLocation(o.timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone: %w", err)
+		}
+		timeZone = loc
+	}
+	return nil
+}
+
+// formatTime formats t for human output.
+func formatTime(t time.Time) string {
+	switch timeFormat {
+	case timeFormatUnix:
+		return strconv.FormatInt(t.Unix(), 10)
+	case timeFormatLocal:
+		return t.In(timeZone).Format("2006-01-02 15:04:05 MST")
+	}
+	return t.In(timeZone).Format(time.RFC3339)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// tracer records API calls and state transitions as a Chrome trace
+// (chrome://tracing, Perfetto). A nil tracer records nothing.
+type tracer struct {
+	mu     sync.Mutex
+	start  time.Time
+	events []traceEvent
+}
+
+type traceEvent struct {
+	Name     string         `json:"name"`
+	Category string         `json:"cat"`
+	Phase    string         `json:"ph"`
+	Time     int64          `json:"ts"`
+	Duration int64          `json:"dur,omitempty"`
+	Scope    string         `json:"s,omitempty"`
+	Pid      int            `json:"pid"`
+	Tid      int            `json:"tid"`
+	Args     map[string]any `json:"args,omitempty"`
+}
+
+const (
+	traceTidAPI = iota + 1
+	traceTidState
+)
+
+var trace *tracer
+
+func newTracer() *tracer {
+	return &tracer{start: time.Now()}
+}
+
+// instrument adds a middleware recording every API call made with cfg.
+func (t *tracer) instrument(cfg *aws.Config) {
+	if t == nil {
+		return
+	}
+	cfg.APIOptions = append(cfg.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("amimatiTrace", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			start := time.Now()
+			out, md, err := next.HandleInitialize(ctx, in)
+			args := map[string]any{"region": awsmiddleware.GetRegion(ctx)}
+			if id, ok := awsmiddleware.GetRequestIDMetadata(md); ok {
+				args["requestId"] = id
+			}
+			if err != nil {
+				args["error"] = err.Error()
+			}
+			t.add(traceEvent{
+				Name:     awsmiddleware.GetServiceID(ctx) + "." + awsmiddleware.GetOperationName(ctx),
+				Category: "api",
+				Phase:    "X",
+				Time:     start.Sub(t.start).Microseconds(),
+				Duration: time.Since(start).Microseconds(),
+				Tid:      traceTidAPI,
+				Args:     args,
+			})
+			return out, md, err
+		}), middleware.After)
+	})
+}
+
+// state records a state transition of a resource.
+func (t *tracer) state(resource, state string, args map[string]any) {
+	if t == nil {
+		return
+	}
+	t.add(traceEvent{
+		Name:     resource + " " + state,
+		Category: "state",
+		Phase:    "i",
+		Scope:    "g",
+		Time:     time.Since(t.start).Microseconds(),
+		Tid:      traceTidState,
+		Args:     args,
+	})
+}
+
+// span records a unit of work that started at start and ends now.
+func (t *tracer) span(name string, start time.Time, args map[string]any) {
+	if t == nil {
+		return
+	}
+	t.add(traceEvent{
+		Name:     name,
+		Category: "stage",
+		Phase:    "X",
+		Time:     start.Sub(t.start).Microseconds(),
+		Duration: time.Since(start).Microseconds(),
+		Tid:      traceTidState,
+		Args:     args,
+	})
+}
+
+func (t *tracer) add(e traceEvent) {
+	e.Pid = 1
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, e)
+}
+
+func (t *tracer) write(path string) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	o, err := json.MarshalIndent(map[string]any{"traceEvents": t.events, "displayTimeUnit": "ms"}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling trace: %w", err)
+	}
+	if err := os.WriteFile(path, o, 0o644); err != nil {
+		return fmt.Errorf("error writing trace: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const verifiedTagKey = "verified"
+
+// verifyOptions describe how an image is verified by booting an instance
+// from it.
+type verifyOptions struct {
+	throwawayOptions
+	// command is run on the instance with SSM Run Command; probe is a
+	// tcp:<port> or http(s)://:<port>/<path> health check of the instance.
+	// Without either, the EC2 status checks of the instance must pass.
+	command string
+	probe   string
+	timeout time.Duration
+	// deregisterOnFailure deregisters the image and deletes its snapshots
+	// when the verification fails.
+	deregisterOnFailure bool
+}
+
+type verifyResult struct {
+	InstanceId string
+	Check      string
+	Passed     bool
+	Output     string `json:",omitempty"`
+	Error      string `json:",omitempty"`
+	// Deregistered is set when the image failed and was deregistered.
+	Deregistered bool `json:",omitempty"`
+}
+
+// validateProbe checks a -verify-probe value.
+func validateProbe(probe string) error {
+	if port, ok := strings.CutPrefix(probe, "tcp:"); ok {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid probe port: %s", probe)
+		}
+		return nil
+	}
+	u, err := url.Parse(probe)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid probe, want tcp:<port> or http(s)://:<port>/<path>: %s", probe)
+	}
+	return nil
+}
+
+// verifyImage launches a short-lived instance from the image, checks it and
+// terminates it. The image is tagged verified=true or verified=false with the
+// outcome, and deregistered on failure if the options say so. A failed check
+// is reported in the result rather than as an error.
+func verifyImage(ctx context.Context, cfg aws.Config, imageID string, opt verifyOptions, verbose bool) (*verifyResult, error) {
+	client := ec2.NewFromConfig(cfg)
+	deadline := time.Now().Add(opt.timeout)
+	instanceID, err := launchThrowaway(ctx, client, imageID, "verify", opt.throwawayOptions, opt.timeout)
+	if instanceID != "" {
+		defer func() {
+			if terr := terminateThrowaway(context.WithoutCancel(ctx), client, instanceID); terr != nil {
+				warnf("%v", terr)
+			}
+		}()
+	}
+	if err != nil {
+		return nil, err
+	}
+	instance, err := describeInstance(ctx, client, instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &verifyResult{InstanceId: instanceID}
+	checkCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	switch {
+	case opt.command != "":
+		res.Check = "command"
+		err = verifyCommand(checkCtx, ssm.NewFromConfig(cfg), instance, opt.command, res, verbose)
+	case opt.probe != "":
+		res.Check = opt.probe
+		err = verifyProbe(checkCtx, instance, opt.probe, verbose)
+	default:
+		res.Check = "status checks"
+		err = ec2.NewInstanceStatusOkWaiter(client).Wait(checkCtx, &ec2.DescribeInstanceStatusInput{InstanceIds: []string{instanceID}}, time.Until(deadline))
+	}
+	if ctx.Err() != nil {
+		return nil, err
+	}
+	res.Passed = err == nil
+	if err != nil {
+		res.Error = err.Error()
+	}
+	if verbose {
+		logf("verification of image %s on instance %s: passed %t", imageID, instanceID, res.Passed)
+	}
+
+	if _, err := client.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{imageID},
+		Tags:      []types.Tag{{Key: aws.String(verifiedTagKey), Value: aws.String(strconv.FormatBool(res.Passed))}},
+	}); err != nil {
+		return res, fmt.Errorf("error tagging image %s: %w", imageID, err)
+	}
+	if !res.Passed && opt.deregisterOnFailure {
+		image, err := describeImage(ctx, client, imageID)
+		if err != nil {
+			return res, err
+		}
+		if _, err := pruneImage(ctx, client, image); err != nil {
+			return res, err
+		}
+		res.Deregistered = true
+	}
+	return res, nil
+}
+
+// verifyCommand waits until the instance is an online SSM managed node and
+// runs the command on it.
+func verifyCommand(ctx context.Context, client *ssm.Client, instance types.Instance, command string, res *verifyResult, verbose bool) error {
+	instanceID := aws.ToString(instance.InstanceId)
+	var wait waitLog
+	for {
+		out, err := client.DescribeInstanceInformation(ctx, &ssm.DescribeInstanceInformationInput{
+			Filters: []ssmtypes.InstanceInformationStringFilter{{Key: aws.String("InstanceIds"), Values: []string{instanceID}}},
+		})
+		if err != nil {
+			return fmt.Errorf("error describing instance information: %w", err)
+		}
+		if len(out.InstanceInformationList) > 0 && out.InstanceInformationList[0].PingStatus == ssmtypes.PingStatusOnline {
+			break
+		}
+		if verbose {
+			wait.logf("waiting for instance %s to be an online SSM managed node", instanceID)
+		}
+		if err := sleepPoll(ctx); err != nil {
+			return fmt.Errorf("instance %s is not an online SSM managed node: %w", instanceID, err)
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	h, err := runInstanceCommand(ctx, client, instance, "verify command", command, time.Until(deadline))
+	if h != nil {
+		res.Output = h.Output
+	}
+	return err
+}
+
+// verifyProbe polls the private IP address of the instance until the probe
+// succeeds.
+func verifyProbe(ctx context.Context, instance types.Instance, probe string, verbose bool) error {
+	ip := aws.ToString(instance.PrivateIpAddress)
+	var check func() error
+	if port, ok := strings.CutPrefix(probe, "tcp:"); ok {
+		addr := net.JoinHostPort(ip, port)
+		check = func() error {
+			conn, err := (&net.Dialer{Timeout: 5 * time.Second}).DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}
+	} else {
+		u, _ := url.Parse(probe)
+		u.Host = net.JoinHostPort(ip, u.Port())
+		if u.Port() == "" {
+			u.Host = ip
+		}
+		check = func() error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+			if err != nil {
+				return err
+			}
+			resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				return fmt.Errorf("%s answered %s", u, resp.Status)
+			}
+			return nil
+		}
+	}
+	var wait waitLog
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if verbose {
+			wait.logf("waiting for probe %s of instance %s: %v", probe, aws.ToString(instance.InstanceId), err)
+		}
+		if serr := sleepPoll(ctx); serr != nil {
+			return fmt.Errorf("probe %s failed: %w", probe, err)
+		}
+	}
+}
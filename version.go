@@ -0,0 +1,19 @@
+package main
+
+import "runtime/debug"
+
+// version is the version of amimati, set at build time with
+// -ldflags "-X main.version=v1.2.3".
+var version string
+
+// toolVersion returns the version of amimati: the one set at build time, or
+// else the module version recorded by go install.
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// checkEBSBacked fails unless the instance is EBS-backed: CreateImage cannot
+// image an instance whose root device is in the instance store.
+func checkEBSBacked(instance types.Instance) error {
+	if instance.RootDeviceType == types.DeviceTypeInstanceStore {
+		return fmt.Errorf("instance %s is instance store-backed: only EBS-backed instances can be imaged with CreateImage", *instance.InstanceId)
+	}
+	for _, bdm := range instance.BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.VolumeId != nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("instance %s has no EBS volumes attached", *instance.InstanceId)
+}
+
+// checkVolumes fails when a volume attached to the instance cannot be
+// captured intact: it is in the error state, the snapshot it was created from
+// is still being restored from the archive tier, or its EBS status checks
+// report it impaired.
+func checkVolumes(ctx context.Context, client *ec2.Client, instance types.Instance) error {
+	devices := map[string]string{}
+	var ids []string
+	for _, bdm := range instance.BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.VolumeId != nil {
+			devices[*bdm.Ebs.VolumeId] = aws.ToString(bdm.DeviceName)
+			ids = append(ids, *bdm.Ebs.VolumeId)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	out, err := client.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{VolumeIds: ids})
+	if err != nil {
+		return fmt.Errorf("error describing volumes: %w", err)
+	}
+	var snapshotIDs []string
+	sources := map[string]string{}
+	for _, v := range out.Volumes {
+		if v.State == types.VolumeStateError {
+			return fmt.Errorf("volume %s (%s) of %s is in the error state", *v.VolumeId, devices[*v.VolumeId], *instance.InstanceId)
+		}
+		if id := aws.ToString(v.SnapshotId); id != "" {
+			snapshotIDs = append(snapshotIDs, id)
+			sources[id] = *v.VolumeId
+		}
+	}
+
+	if len(snapshotIDs) > 0 {
+		p := ec2.NewDescribeSnapshotTierStatusPaginator(client, &ec2.DescribeSnapshotTierStatusInput{
+			Filters: []types.Filter{{Name: aws.String("snapshot-id"), Values: snapshotIDs}},
+		})
+		for p.HasMorePages() {
+			page, err := p.NextPage(ctx)
+			if err != nil {
+				// Snapshots of other accounts, such as those of public
+				// images, have no tier status visible to the caller.
+				warnf("error describing snapshot tier status, not checking for archived snapshots: %v", err)
+				break
+			}
+			for _, s := range page.SnapshotTierStatuses {
+				switch s.LastTieringOperationStatus {
+				case types.TieringOperationStatusTemporaryRestoreInProgress, types.TieringOperationStatusPermanentRestoreInProgress:
+					volumeID := sources[aws.ToString(s.SnapshotId)]
+					return fmt.Errorf("volume %s (%s) of %s is created from snapshot %s, which is still being restored from the archive tier", volumeID, devices[volumeID], *instance.InstanceId, *s.SnapshotId)
+				}
+			}
+		}
+	}
+
+	p := ec2.NewDescribeVolumeStatusPaginator(client, &ec2.DescribeVolumeStatusInput{VolumeIds: ids})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("error describing volume status: %w", err)
+		}
+		for _, s := range page.VolumeStatuses {
+			if s.VolumeStatus == nil || s.VolumeStatus.Status != types.VolumeStatusInfoStatusImpaired {
+				continue
+			}
+			var checks []string
+			for _, d := range s.VolumeStatus.Details {
+				checks = append(checks, fmt.Sprintf("%s: %s", d.Name, aws.ToString(d.Status)))
+			}
+			return fmt.Errorf("volume %s (%s) of %s is impaired (%s)", *s.VolumeId, devices[*s.VolumeId], *instance.InstanceId, strings.Join(checks, ", "))
+		}
+	}
+	return nil
+}
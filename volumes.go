@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+type instanceVolume struct {
+	DeviceName          string
+	VolumeId            string
+	Root                bool
+	SizeGiB             int32
+	VolumeType          string
+	Iops                int32 `json:",omitempty"`
+	Throughput          int32 `json:",omitempty"`
+	Encrypted           bool
+	KmsKeyId            string `json:",omitempty"`
+	DeleteOnTermination bool
+}
+
+func runVolumes(args []string) {
+	var instanceID, query string
+	fs := flag.NewFlagSet("volumes", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.StringVar(&instanceID, "instance-id", "", "instance ID")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. [].DeviceName)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	if instanceID == "" {
+		fatal("instance ID is required")
+	}
+
+	ctx := context.Background()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	client := ec2.NewFromConfig(cfg)
+
+	instance, err := describeInstance(ctx, client, instanceID)
+	if err != nil {
+		fatal(err)
+	}
+	volumes, err := instanceVolumes(ctx, client, instance)
+	if err != nil {
+		fatal(err)
+	}
+	printResult(volumes, query)
+}
+
+// instanceVolumes describes the EBS volumes attached to the instance in the
+// order of its block device mappings, which is the order they are captured in
+// the image.
+func instanceVolumes(ctx context.Context, client *ec2.Client, instance types.Instance) ([]instanceVolume, error) {
+	var ids []string
+	for _, bdm := range instance.BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.VolumeId != nil {
+			ids = append(ids, *bdm.Ebs.VolumeId)
+		}
+	}
+	result := []instanceVolume{}
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	out, err := client.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{VolumeIds: ids})
+	if err != nil {
+		return nil, fmt.Errorf("error describing volumes: %w", err)
+	}
+	volumes := map[string]types.Volume{}
+	for _, v := range out.Volumes {
+		volumes[*v.VolumeId] = v
+	}
+
+	for _, bdm := range instance.BlockDeviceMappings {
+		if bdm.Ebs == nil || bdm.Ebs.VolumeId == nil {
+			continue
+		}
+		iv := instanceVolume{
+			DeviceName:          aws.ToString(bdm.DeviceName),
+			VolumeId:            *bdm.Ebs.VolumeId,
+			Root:                aws.ToString(bdm.DeviceName) == aws.ToString(instance.RootDeviceName),
+			DeleteOnTermination: aws.ToBool(bdm.Ebs.DeleteOnTermination),
+		}
+		if v, ok := volumes[iv.VolumeId]; ok {
+			iv.SizeGiB = aws.ToInt32(v.Size)
+			iv.VolumeType = string(v.VolumeType)
+			iv.Iops = aws.ToInt32(v.Iops)
+			iv.Throughput = aws.ToInt32(v.Throughput)
+			iv.Encrypted = aws.ToBool(v.Encrypted)
+			iv.KmsKeyId = aws.ToString(v.KmsKeyId)
+		}
+		result = append(result, iv)
+	}
+	return result, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+
+	"github.com/otama-jaccy/amimati/pkg/amimati"
+)
+
+// vssDocument is the SSM document that creates an image of a Windows
+// instance from VSS snapshots, with the writers of SQL Server, Exchange and
+// the other VSS-aware applications frozen while its snapshots are taken.
+const vssDocument = "AWSEC2-CreateVssSnapshot"
+
+// defaultVSSTimeout bounds the run of vssDocument, from freezing the writers
+// to the image being created; the snapshots complete after it.
+const defaultVSSTimeout = 15 * time.Minute
+
+// checkVSS checks that the image of the instance can be created with -vss.
+// The document images every volume of the instance, without rebooting it,
+// and returns its image once created, for the run to wait for.
+func checkVSS(instance types.Instance, opt options) error {
+	switch {
+	case instance.Platform != types.PlatformValuesWindows:
+		return fmt.Errorf("-vss needs a Windows instance, %s is not one", aws.ToString(instance.InstanceId))
+	case opt.noWait:
+		return errors.New("-vss cannot be combined with -wait=false")
+	case len(opt.excludeDevices) > 0 || len(opt.deviceMappings) > 0:
+		return errors.New("-vss cannot be combined with -exclude-device or -device-mapping, the document images every volume")
+	}
+	return nil
+}
+
+// createVSSImage creates the image named name of the Windows instance with
+// vssDocument, and returns its ID along with the run of the document. The
+// image tags, with the run ID, are passed to the document, which tags what it
+// creates with them, so that a run failing before the image is found still
+// leaves them tagged. They are applied again to the image once it is found,
+// with those the tags parameter of the document cannot carry.
+func createVSSImage(ctx context.Context, cfg aws.Config, client *ec2.Client, instanceID, name, description string, imageTags tags) (string, *hookResult, error) {
+	params := map[string][]string{
+		"CreateAmi":         {"True"},
+		"AmiName":           {name},
+		"ExcludeBootVolume": {"False"},
+	}
+	if description != "" {
+		params["description"] = []string{description}
+	}
+	p, skipped := vssTagsParameter(imageTags)
+	if p != "" {
+		params["tags"] = []string{p}
+	}
+	for _, t := range skipped {
+		warnf("tag %s holds a comma, semicolon or equal sign, which %s cannot take, it is applied once the image is created", aws.ToString(t.Key), vssDocument)
+	}
+	h, err := runDocument(ctx, ssm.NewFromConfig(cfg), instanceID, "vss", vssDocument, params, defaultVSSTimeout)
+	if err != nil {
+		return "", h, err
+	}
+	image, err := findImageByName(ctx, client, name)
+	if err != nil {
+		return "", h, err
+	}
+	if image == nil {
+		return "", h, fmt.Errorf("%s %s succeeded but no image named %s was created", vssDocument, h.CommandId, name)
+	}
+	imageID := aws.ToString(image.ImageId)
+	if len(imageTags) > 0 {
+		if _, err := client.CreateTags(ctx, &ec2.CreateTagsInput{Resources: []string{imageID}, Tags: imageTags}); err != nil {
+			return imageID, h, fmt.Errorf("error tagging image %s: %w", imageID, err)
+		}
+	}
+	return imageID, h, nil
+}
+
+// vssTagsParameter encodes the tags in the Key=key,Value=value;... form of
+// the tags parameter of vssDocument. The form has no quoting, so the tags
+// holding a comma, semicolon or equal sign are left out and returned.
+func vssTagsParameter(t tags) (string, tags) {
+	var pairs []string
+	var skipped tags
+	for _, tag := range t {
+		key, value := aws.ToString(tag.Key), aws.ToString(tag.Value)
+		if strings.ContainsAny(key+value, ",;=") {
+			skipped = append(skipped, tag)
+			continue
+		}
+		pairs = append(pairs, "Key="+key+",Value="+value)
+	}
+	return strings.Join(pairs, ";"), skipped
+}
+
+// tagVSSSnapshots applies the snapshot tags to the snapshots of the image
+// created by vssDocument, once they are known.
+func tagVSSSnapshots(ctx context.Context, client *ec2.Client, image types.Image, snapshotTags tags) error {
+	ids, _ := amimati.MappedSnapshots(image)
+	if len(ids) == 0 || len(snapshotTags) == 0 {
+		return nil
+	}
+	if _, err := client.CreateTags(ctx, &ec2.CreateTagsInput{Resources: ids, Tags: snapshotTags}); err != nil {
+		return fmt.Errorf("error tagging snapshots %v: %w", ids, err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestCheckVSS(t *testing.T) {
+	windows := types.Instance{InstanceId: aws.String("i-1"), Platform: types.PlatformValuesWindows}
+	for _, tc := range []struct {
+		name     string
+		instance types.Instance
+		opt      options
+		ok       bool
+	}{
+		{"windows", windows, options{vss: true}, true},
+		{"linux", types.Instance{InstanceId: aws.String("i-1")}, options{vss: true}, false},
+		{"no wait", windows, options{vss: true, noWait: true}, false},
+		{"exclude device", windows, options{vss: true, excludeDevices: []string{"xvdf"}}, false},
+	} {
+		if err := checkVSS(tc.instance, tc.opt); (err == nil) != tc.ok {
+			t.Errorf("%s: got error %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestVSSTagsParameter(t *testing.T) {
+	in := tags{
+		{Key: aws.String("env"), Value: aws.String("prod")},
+		{Key: aws.String(runIDTagKey), Value: aws.String("20240501T100000Z-1a2b3c4d")},
+		{Key: aws.String("note"), Value: aws.String("a, b")},
+		{Key: aws.String("url"), Value: aws.String("https://example.com/?a=b")},
+	}
+	p, skipped := vssTagsParameter(in)
+	if want := "Key=env,Value=prod;Key=" + runIDTagKey + ",Value=20240501T100000Z-1a2b3c4d"; p != want {
+		t.Errorf("got %q, want %q", p, want)
+	}
+	if formatTags(skipped) != `note="a, b",url="https://example.com/?a=b"` {
+		t.Errorf("got skipped %s", formatTags(skipped))
+	}
+}
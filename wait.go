@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/otama-jaccy/amimati/pkg/amimati"
+)
+
+func runWait(args []string) {
+	var imageID, query string
+	var verbose, noProgress bool
+	var timeout time.Duration
+	fs := flag.NewFlagSet("wait", flag.ExitOnError)
+	awsOpt := addAWSFlags(fs)
+	logOpt := addLogFlags(fs)
+	fs.StringVar(&imageID, "image-id", "", "ID of the image to wait for")
+	fs.BoolVar(&verbose, "v", false, "verbose output")
+	fs.BoolVar(&noProgress, "no-progress", false, "log the progress of the snapshots with -v instead of drawing progress bars on an interactive terminal")
+	fs.DurationVar(&timeout, "timeout", 0, "give up and exit with status "+strconv.Itoa(exitTimeout)+" if the snapshots have not completed and the image is not available after this long(eg. 1h; default: no limit)")
+	fs.DurationVar(&pollInterval, "poll-interval", pollInterval, "time between two polls of the image and snapshot states")
+	fs.StringVar(&query, "query", "", "JMESPath query applied to the result(eg. ImageId)")
+	addOutputFlag(fs)
+	fs.Parse(args)
+
+	if err := logOpt.apply(); err != nil {
+		fatal(err)
+	}
+
+	if err := validateOutput(query); err != nil {
+		fatal(err)
+	}
+	if imageID == "" {
+		fatal("image ID is required")
+	}
+	if pollInterval <= 0 {
+		fatal("poll interval must be positive")
+	}
+
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	cfg, err := awsOpt.load(ctx)
+	if err != nil {
+		fatal(err)
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	res, err := attachImage(ctx, cfg, imageID, verbose, drawProgressBars(noProgress))
+	if err != nil {
+		exitWith(exitCode(ctx, err), err)
+	}
+	printResult(res, query)
+}
+
+// attachImage waits for an image created elsewhere, or by a run that was
+// killed, as createImage does: until its snapshots have completed and it is
+// available. The result lacks what only the run creating the image knows,
+// such as its source instance. With bars, the progress of the snapshots is
+// drawn as progress bars.
+func attachImage(ctx context.Context, cfg aws.Config, imageID string, verbose, bars bool) (*result, error) {
+	started := time.Now()
+	client := ec2.NewFromConfig(cfg)
+	runStatus.image(imageID)
+	runState.image(imageID)
+	creator := &amimati.Creator{Client: client, PollInterval: pollInterval, Progress: newSnapshotProgress(verbose, bars).report}
+
+	setPhase("waiting for snapshots")
+	created, err := creator.Wait(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+	setPhase("waiting for image")
+	image := created.Image
+	if image.State != types.ImageStateAvailable {
+		if image, err = waitImageAvailable(ctx, client, imageID, verbose); err != nil {
+			return nil, err
+		}
+	}
+	snapshots, err := describeImageSnapshots(ctx, client, image)
+	if err != nil {
+		return nil, err
+	}
+	return newResult(ctx, cfg, image, snapshots, started), nil
+}
+
+// pollInterval is the time between two polls of image and snapshot states.
+var pollInterval = amimati.DefaultPollInterval
+
+// unlimitedWait is the maximum wait of the SDK waiters, which require one;
+// the context of the wait bounds it instead.
+const unlimitedWait = 100 * 365 * 24 * time.Hour
+
+// sleepPoll waits for the poll interval, or returns the error of ctx once it
+// is done.
+func sleepPoll(ctx context.Context) error {
+	t := time.NewTimer(pollInterval)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// exitCode is the exit status of a run with ctx failed with err:
+// exitTimeout if its deadline has passed, exitAborted if a signal aborted it,
+// and otherwise that of the error.
+func exitCode(ctx context.Context, err error) int {
+	if ctx.Err() == context.DeadlineExceeded {
+		return exitTimeout
+	}
+	if aborted(ctx) {
+		return exitAborted
+	}
+	return errorExitCode(err)
+}
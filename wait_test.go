@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/otama-jaccy/amimati/pkg/amimati/amimatitest"
+)
+
+func TestWaitImageAvailableReturnsFailedImage(t *testing.T) {
+	ctx := testContext(t)
+	fake := &amimatitest.FakeEC2{SnapshotPolls: 1, FailSnapshots: true}
+	out, err := fake.CreateImage(ctx, &ec2.CreateImageInput{InstanceId: aws.String("i-1"), Name: aws.String("web")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := waitImageAvailable(ctx, fake, *out.ImageId, false)
+	if err == nil {
+		t.Fatal("got no error for a failed image")
+	}
+	if image.State != types.ImageStateFailed {
+		t.Errorf("got image in state %q, want failed", image.State)
+	}
+}
+
+func TestWaitImageAvailableDescribeError(t *testing.T) {
+	ctx := testContext(t)
+	injected := errors.New("injected")
+	fake := &amimatitest.FakeEC2{Errors: map[string][]error{"DescribeImages": {injected}}}
+	out, err := fake.CreateImage(ctx, &ec2.CreateImageInput{InstanceId: aws.String("i-1"), Name: aws.String("web")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := waitImageAvailable(ctx, fake, *out.ImageId, false); !errors.Is(err, injected) {
+		t.Fatalf("got error %v, want %v", err, injected)
+	}
+}
+
+func TestWaitImageAvailableNotFound(t *testing.T) {
+	ctx := testContext(t)
+	if _, err := waitImageAvailable(ctx, &amimatitest.FakeEC2{}, "ami-missing", false); err == nil {
+		t.Fatal("got no error for a missing image")
+	}
+}
+
+func TestWaitImageAvailableContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(testContext(t), 50*time.Millisecond)
+	defer cancel()
+	// The snapshots never get created within the timeout.
+	fake := &amimatitest.FakeEC2{SnapshotPolls: 1 << 30}
+	out, err := fake.CreateImage(ctx, &ec2.CreateImageInput{InstanceId: aws.String("i-1"), Name: aws.String("web")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := waitImageAvailable(ctx, fake, *out.ImageId, false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if fake.Calls("DescribeImages") < 2 {
+		t.Errorf("got %d polls, want the image polled until the timeout", fake.Calls("DescribeImages"))
+	}
+}
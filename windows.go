@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// scheduleConfig restricts when scheduled runs may start.
+type scheduleConfig struct {
+	// Windows are the weekly periods runs may start in. Runs may start at any
+	// time when there are none.
+	Windows []maintenanceWindow `json:"windows"`
+	// Blackouts are periods no run may start in, such as change freezes.
+	Blackouts []blackoutPeriod `json:"blackouts"`
+	// Timezone is the IANA time zone of the windows, UTC by default.
+	Timezone string `json:"timezone"`
+
+	loc *time.Location
+}
+
+type maintenanceWindow struct {
+	// Days are the weekdays the window opens on, such as "Sat", every day by
+	// default.
+	Days []string `json:"days"`
+	// Start and End are times of day such as "01:00". A window ending before
+	// it starts closes the next day.
+	Start string `json:"start"`
+	End   string `json:"end"`
+
+	days       map[time.Weekday]bool
+	start, end time.Duration
+}
+
+type blackoutPeriod struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// validate checks the config and prepares it for use.
+func (c *scheduleConfig) validate() error {
+	c.loc = time.UTC
+	if c.Timezone != "" {
+		loc, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone: %w", err)
+		}
+		c.loc = loc
+	}
+	for i := range c.Windows {
+		w := &c.Windows[i]
+		w.days = map[time.Weekday]bool{}
+		for _, d := range w.Days {
+			wd, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return fmt.Errorf("window %d: invalid day: %s", i, d)
+			}
+			w.days[wd] = true
+		}
+		var err error
+		if w.start, err = parseTimeOfDay(w.Start); err != nil {
+			return fmt.Errorf("window %d: %w", i, err)
+		}
+		if w.end, err = parseTimeOfDay(w.End); err != nil {
+			return fmt.Errorf("window %d: %w", i, err)
+		}
+		if w.start == w.end {
+			return fmt.Errorf("window %d: start and end are the same", i)
+		}
+	}
+	for i, b := range c.Blackouts {
+		if !b.End.After(b.Start) {
+			return fmt.Errorf("blackout %d: end is not after start", i)
+		}
+	}
+	return nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day: %s", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// nextAllowed returns the earliest time from t on that a run may start, along
+// with why it is later than t, if it is.
+func (c *scheduleConfig) nextAllowed(t time.Time) (time.Time, string) {
+	var reason string
+	// Each step moves past a blackout or to the next window opening, so a
+	// bounded number of them settles unless the config rules out every time.
+	for i := 0; i < 1000; i++ {
+		if b := c.blackoutAt(t); b != nil {
+			t, reason = b.End, "blackout"
+			if b.Reason != "" {
+				reason += ": " + b.Reason
+			}
+			continue
+		}
+		if len(c.Windows) == 0 || c.inWindow(t) {
+			return t, reason
+		}
+		if reason == "" {
+			reason = "outside the maintenance windows"
+		}
+		t = c.nextWindowStart(t)
+	}
+	return time.Time{}, "no allowed time found"
+}
+
+func (c *scheduleConfig) blackoutAt(t time.Time) *blackoutPeriod {
+	for i, b := range c.Blackouts {
+		if !t.Before(b.Start) && t.Before(b.End) {
+			return &c.Blackouts[i]
+		}
+	}
+	return nil
+}
+
+// opening returns when the window opens and closes starting on the day of d.
+func (w maintenanceWindow) opening(d time.Time) (time.Time, time.Time) {
+	start := d.Add(w.start)
+	end := d.Add(w.end)
+	if w.end < w.start {
+		end = end.AddDate(0, 0, 1)
+	}
+	return start, end
+}
+
+func (w maintenanceWindow) opensOn(d time.Weekday) bool {
+	return len(w.days) == 0 || w.days[d]
+}
+
+func (c *scheduleConfig) inWindow(t time.Time) bool {
+	t = t.In(c.loc)
+	// A window opened the day before may still be open.
+	for _, offset := range []int{-1, 0} {
+		day := midnight(t).AddDate(0, 0, offset)
+		for _, w := range c.Windows {
+			if !w.opensOn(day.Weekday()) {
+				continue
+			}
+			if start, end := w.opening(day); !t.Before(start) && t.Before(end) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *scheduleConfig) nextWindowStart(t time.Time) time.Time {
+	t = t.In(c.loc)
+	var next time.Time
+	for offset := 0; offset <= 7; offset++ {
+		day := midnight(t).AddDate(0, 0, offset)
+		for _, w := range c.Windows {
+			if !w.opensOn(day.Weekday()) {
+				continue
+			}
+			if start, _ := w.opening(day); start.After(t) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+	}
+	return next
+}
+
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}